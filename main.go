@@ -33,6 +33,7 @@ Server Options:
     -c, --config <file>              Configuration file
     -sl,--signal <signal>[=<pid>]    Send signal to gnatsd process (stop, quit, reopen, reload)
         --client_advertise <string>  Client URL to advertise to other servers
+        --unix_socket <path>         Listen for clients on a unix socket
     -t                               Test configuration and exit
 
 Logging Options:
@@ -14,9 +14,15 @@
 package server
 
 import (
+	"container/list"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
@@ -24,6 +30,7 @@ import (
 	"time"
 
 	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
 )
 
 // For backwards compatibility, users who are not explicitly defined into an
@@ -40,6 +47,12 @@ type rme struct {
 // Account are subject namespace definitions. By default no messages are shared between accounts.
 // You can share via exports and imports of streams and services.
 type Account struct {
+	// Here first because of use of atomics, and memory alignment.
+	inMsgs   int64
+	outMsgs  int64
+	inBytes  int64
+	outBytes int64
+
 	Name     string
 	Nkey     string
 	Issuer   string
@@ -50,12 +63,14 @@ type Account struct {
 	etmr     *time.Timer
 	clients  map[*client]*client
 	rm       map[string]*rme
+	lleafs   []*client
 	imports  importMap
 	exports  exportMap
 	limits
-	nae     int
-	pruning bool
-	expired bool
+	nae          int
+	pruning      bool
+	expired      bool
+	usersRevoked map[string]int64
 }
 
 // Account based limits.
@@ -156,6 +171,36 @@ func (a *Account) removeClient(c *client) int {
 	return n
 }
 
+// NumLeafNodes returns the number of leaf node connections bound to this account.
+func (a *Account) NumLeafNodes() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.lleafs)
+}
+
+// addLeafNode will register a leaf node connection to receive
+// interest updates for this account.
+func (a *Account) addLeafNode(c *client) {
+	a.mu.Lock()
+	a.lleafs = append(a.lleafs, c)
+	a.mu.Unlock()
+}
+
+// removeLeafNode will remove the leaf node connection from this account.
+func (a *Account) removeLeafNode(c *client) {
+	a.mu.Lock()
+	for i, l := range a.lleafs {
+		if l == c {
+			ll := len(a.lleafs)
+			a.lleafs[i] = a.lleafs[ll-1]
+			a.lleafs[ll-1] = nil
+			a.lleafs = a.lleafs[:ll-1]
+			break
+		}
+	}
+	a.mu.Unlock()
+}
+
 // AddServiceExport will configure the account with the defined export.
 func (a *Account) AddServiceExport(subject string, accounts []*Account) error {
 	a.mu.Lock()
@@ -468,19 +513,36 @@ func (a *Account) checkStreamImportAuthorizedNoLock(account *Account, subject st
 // Will fetch the activation token for an import.
 func fetchActivation(url string) string {
 	// FIXME(dlc) - Make configurable.
-	c := &http.Client{Timeout: 2 * time.Second}
-	resp, err := c.Get(url)
-	if err != nil || resp == nil {
-		return ""
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := httpGet(url, 2*time.Second)
 	if err != nil {
 		return ""
 	}
 	return string(body)
 }
 
+// httpGetError is returned by httpGet when the response is not a success.
+type httpGetError struct {
+	status int
+}
+
+func (e *httpGetError) Error() string {
+	return fmt.Sprintf("unexpected response status %d", e.status)
+}
+
+// httpGet will return the body of the response to a GET request.
+func httpGet(url string, timeout time.Duration) ([]byte, error) {
+	c := &http.Client{Timeout: timeout}
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpGetError{resp.StatusCode}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 // Fires for expired activation tokens. We could track this with timers etc.
 // Instead we just re-analyze where we are and if we need to act.
 func (a *Account) activationExpired(subject string) {
@@ -629,6 +691,17 @@ func (a *Account) IsExpired() bool {
 	return a.expired
 }
 
+// checkUserRevoked returns true if the user JWT for the given nkey
+// was issued at or before the time the user has been revoked.
+func (a *Account) checkUserRevoked(nkey string, issuedAt int64) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if t, ok := a.usersRevoked[nkey]; ok && issuedAt <= t {
+		return true
+	}
+	return false
+}
+
 // Called when an account has expired.
 func (a *Account) expiredTimeout() {
 	// Collect the clients.
@@ -781,24 +854,83 @@ func (s *Server) updateAccountClaims(a *Account, ac *jwt.AccountClaims) {
 		}
 	}
 
+	// The revocations are not part of the jwt claims, so grab them
+	// from the JWT these claims were decoded from, if we have it.
+	var revocations map[string]int64
+	if a.claimJWT != "" {
+		revocations = decodeAccountRevocations(a.claimJWT, ac.ID)
+	}
+
 	// Now do limits if they are present.
+	a.mu.Lock()
 	a.msubs = int(ac.Limits.Subs)
 	a.mpay = int32(ac.Limits.Payload)
 	a.mconns = int(ac.Limits.Conn)
+	checkRevoked := len(revocations) > 0 && !reflect.DeepEqual(a.usersRevoked, revocations)
+	a.usersRevoked = revocations
+	a.mu.Unlock()
+
+	var exceeded, revoked []*client
 	for i, c := range gatherClients() {
 		if a.mconns > 0 && i >= a.mconns {
-			c.maxAccountConnExceeded()
+			exceeded = append(exceeded, c)
 			continue
 		}
 		c.mu.Lock()
 		c.applyAccountLimits()
+		userJWT := c.opts.JWT
 		c.mu.Unlock()
+		if checkRevoked && userJWT != "" {
+			if juc, err := jwt.DecodeUserClaims(userJWT); err == nil && a.checkUserRevoked(juc.Subject, juc.IssuedAt) {
+				revoked = append(revoked, c)
+			}
+		}
+	}
+	if len(exceeded) > 0 || len(revoked) > 0 {
+		// The server lock may be held here, so close them in a go routine.
+		go func() {
+			for _, c := range exceeded {
+				c.maxAccountConnExceeded()
+			}
+			for _, c := range revoked {
+				c.userRevoked()
+			}
+		}()
+	}
+}
+
+// accountRevocations is used to decode the revoked users of an account
+// JWT, which the claims of the jwt package do not have.
+type accountRevocations struct {
+	ID   string `json:"jti"`
+	Nats struct {
+		Revocations map[string]int64 `json:"revocations,omitempty"`
+	} `json:"nats"`
+}
+
+// decodeAccountRevocations returns the revocations of the account JWT,
+// keyed by user public nkey, if the JWT is the one of the claims with id.
+// The JWT is expected to have been verified already.
+func decodeAccountRevocations(claimJWT, id string) map[string]int64 {
+	chunks := strings.Split(claimJWT, ".")
+	if len(chunks) != 3 {
+		return nil
+	}
+	data, err := base64.RawStdEncoding.DecodeString(chunks[1])
+	if err != nil {
+		return nil
+	}
+	ar := &accountRevocations{}
+	if err := json.Unmarshal(data, ar); err != nil || ar.ID != id {
+		return nil
 	}
+	return ar.Nats.Revocations
 }
 
-// Helper to build an internal account structure from a jwt.AccountClaims.
-func (s *Server) buildInternalAccount(ac *jwt.AccountClaims) *Account {
-	acc := &Account{Name: ac.Subject, Issuer: ac.Issuer}
+// Helper to build an internal account structure from a jwt.AccountClaims
+// and the JWT they were decoded from.
+func (s *Server) buildInternalAccount(ac *jwt.AccountClaims, claimJWT string) *Account {
+	acc := &Account{Name: ac.Subject, Issuer: ac.Issuer, claimJWT: claimJWT}
 	s.updateAccountClaims(acc, ac)
 	return acc
 }
@@ -846,3 +978,299 @@ func (m *MemAccResolver) Fetch(pub string) (string, error) {
 	}
 	return "", ErrMissingAccount
 }
+
+// File extension of the account JWTs in the directory of a DirAccResolver.
+const dirAccResolverExt = ".jwt"
+
+// Interval at which the directory of a DirAccResolver is checked for
+// changes. This is a var so that tests can lower it.
+var dirAccResolverPollInterval = 2 * time.Second
+
+// DirAccResolver is an AccountResolver that loads the account JWTs from
+// the <pubkey>.jwt files of a directory. When the server is running, the
+// directory is watched and the accounts are updated when their JWT changes.
+type DirAccResolver struct {
+	dir string
+}
+
+// NewDirAccResolver returns an account resolver for the given directory.
+func NewDirAccResolver(dir string) (*DirAccResolver, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", dir)
+	}
+	return &DirAccResolver{dir: dir}, nil
+}
+
+// Fetch will return the content of the JWT file of the account.
+func (dr *DirAccResolver) Fetch(pub string) (string, error) {
+	// This also makes sure that we never read outside of the directory.
+	if !nkeys.IsValidPublicAccountKey(pub) {
+		return "", ErrMissingAccount
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dr.dir, pub+dirAccResolverExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrMissingAccount
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// modTimes returns the modification time of the JWT files, keyed by account.
+func (dr *DirAccResolver) modTimes() (map[string]time.Time, error) {
+	files, err := ioutil.ReadDir(dr.dir)
+	if err != nil {
+		return nil, err
+	}
+	mods := make(map[string]time.Time, len(files))
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, dirAccResolverExt) {
+			continue
+		}
+		mods[strings.TrimSuffix(name, dirAccResolverExt)] = fi.ModTime()
+	}
+	return mods, nil
+}
+
+// watchAccountDir will check the directory of the resolver for JWT
+// files that changed and update the corresponding accounts.
+func (s *Server) watchAccountDir(dr *DirAccResolver) {
+	defer s.grWG.Done()
+
+	mods, err := dr.modTimes()
+	if err != nil {
+		s.Errorf("Error reading account resolver directory: %v", err)
+	}
+	t := time.NewTicker(dirAccResolverPollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			nmods, err := dr.modTimes()
+			if err != nil {
+				s.Errorf("Error reading account resolver directory: %v", err)
+				continue
+			}
+			for pub, mt := range nmods {
+				if omt, ok := mods[pub]; !ok || !omt.Equal(mt) {
+					s.updateAccountFromResolver(pub)
+				}
+			}
+			mods = nmods
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// URLAccResolver is an AccountResolver that fetches the account JWTs with
+// GET requests to <url>/<pubkey>. The JWTs are kept in a bounded LRU cache
+// for a configurable time. If the JWT of a cached account can not be fetched,
+// the cached entry is returned, even if expired. The JWTs of the registered
+// accounts are fetched again once that time has passed, so that updates
+// are applied.
+type URLAccResolver struct {
+	url     string
+	timeout time.Duration
+	ttl     time.Duration
+	max     int
+
+	mu    sync.Mutex
+	cache map[string]*list.Element
+	lru   *list.List
+	stats ResolverStats
+}
+
+// urlAccEntry is an account JWT cached by the URLAccResolver.
+type urlAccEntry struct {
+	pub     string
+	jwt     string
+	fetched time.Time
+}
+
+// NewURLAccResolver returns an account resolver for the given base url.
+// Zero values for the timeout, ttl and cache size select the defaults.
+func NewURLAccResolver(baseURL string, timeout, ttl time.Duration, cacheSize int) (*URLAccResolver, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	if timeout <= 0 {
+		timeout = DEFAULT_URL_RESOLVER_TIMEOUT
+	}
+	if ttl <= 0 {
+		ttl = DEFAULT_URL_RESOLVER_TTL
+	}
+	if cacheSize <= 0 {
+		cacheSize = DEFAULT_URL_RESOLVER_CACHE_SIZE
+	}
+	return &URLAccResolver{
+		url:     baseURL,
+		timeout: timeout,
+		ttl:     ttl,
+		max:     cacheSize,
+		cache:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// Fetch will return the JWT of the account, from the cache if not expired.
+func (ur *URLAccResolver) Fetch(pub string) (string, error) {
+	// The key is part of the url, so make sure this is really a key.
+	if !nkeys.IsValidPublicAccountKey(pub) {
+		return "", ErrMissingAccount
+	}
+
+	ur.mu.Lock()
+	ur.stats.Lookups++
+	if el := ur.cache[pub]; el != nil {
+		if e := el.Value.(*urlAccEntry); time.Since(e.fetched) < ur.ttl {
+			ur.lru.MoveToFront(el)
+			ur.stats.CacheHits++
+			ur.mu.Unlock()
+			return e.jwt, nil
+		}
+	}
+	ur.mu.Unlock()
+
+	// Do the request without the lock.
+	start := time.Now()
+	body, err := httpGet(ur.url+pub, ur.timeout)
+	rtt := time.Since(start)
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.stats.Fetches++
+	ur.stats.TotalLatency += rtt
+	if rtt > ur.stats.MaxLatency {
+		ur.stats.MaxLatency = rtt
+	}
+	if err != nil {
+		ur.stats.Failures++
+		// The account is not known by the service anymore.
+		if he, ok := err.(*httpGetError); ok && he.status == http.StatusNotFound {
+			if el := ur.cache[pub]; el != nil {
+				ur.removeEntry(el)
+			}
+			return "", ErrMissingAccount
+		}
+		// Keep serving what we have if the service can not be reached.
+		if el := ur.cache[pub]; el != nil {
+			ur.stats.StaleHits++
+			return el.Value.(*urlAccEntry).jwt, nil
+		}
+		return "", err
+	}
+
+	jwt := strings.TrimSpace(string(body))
+	if el := ur.cache[pub]; el != nil {
+		e := el.Value.(*urlAccEntry)
+		e.jwt, e.fetched = jwt, time.Now()
+		ur.lru.MoveToFront(el)
+	} else {
+		ur.cache[pub] = ur.lru.PushFront(&urlAccEntry{pub: pub, jwt: jwt, fetched: time.Now()})
+		if ur.lru.Len() > ur.max {
+			ur.removeEntry(ur.lru.Back())
+		}
+	}
+	return jwt, nil
+}
+
+// removeEntry will remove the entry from the cache.
+// Lock should be held.
+func (ur *URLAccResolver) removeEntry(el *list.Element) {
+	ur.lru.Remove(el)
+	delete(ur.cache, el.Value.(*urlAccEntry).pub)
+}
+
+// Stats returns the statistics of the lookups done by the resolver.
+func (ur *URLAccResolver) Stats() *ResolverStats {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+	rs := ur.stats
+	rs.CacheSize = ur.lru.Len()
+	if rs.Fetches > 0 {
+		rs.AvgLatency = rs.TotalLatency / time.Duration(rs.Fetches)
+	}
+	return &rs
+}
+
+// sameConfig returns true if both resolvers are configured the same way.
+func (ur *URLAccResolver) sameConfig(o *URLAccResolver) bool {
+	return ur.url == o.url && ur.timeout == o.timeout && ur.ttl == o.ttl && ur.max == o.max
+}
+
+// Interval at which the registered accounts are checked for claims to fetch
+// again from a URLAccResolver. This is a var so that tests can lower it.
+var urlAccResolverRefreshInterval = time.Second
+
+// refreshURLAccounts will fetch again the claims of the registered accounts
+// once the ttl of the resolver has passed and update the accounts that changed.
+func (s *Server) refreshURLAccounts(ur *URLAccResolver) {
+	defer s.grWG.Done()
+
+	t := time.NewTicker(urlAccResolverRefreshInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			now := time.Now()
+			var names []string
+			s.mu.Lock()
+			for name, acc := range s.accounts {
+				// Accounts not from the resolver have no JWT.
+				if acc.claimJWT != "" && now.Sub(acc.updated) >= ur.ttl {
+					acc.updated = now
+					names = append(names, name)
+				}
+			}
+			s.mu.Unlock()
+			for _, name := range names {
+				s.updateAccountFromResolver(name)
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// updateAccountFromResolver will update the account, if already registered,
+// with the claims from the resolver.
+func (s *Server) updateAccountFromResolver(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc := s.accounts[name]
+	if acc == nil {
+		// Will be fetched when first needed.
+		return
+	}
+	claimJWT, err := s.fetchRawAccountClaims(name)
+	if err != nil {
+		s.Errorf("Error fetching claims for account [%s]: %v", name, err)
+		return
+	}
+	if acc.claimJWT == claimJWT {
+		return
+	}
+	if err := s.updateAccountWithClaimJWT(acc, claimJWT); err != nil {
+		s.Errorf("Error updating account [%s]: %v", name, err)
+		return
+	}
+	s.Noticef("Updated account [%s] from resolver", name)
+}
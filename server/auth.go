@@ -15,6 +15,7 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"strings"
 
@@ -206,6 +207,9 @@ func (s *Server) configureAuthorization() {
 		s.users = nil
 		s.info.AuthRequired = false
 	}
+	if opts.AuthCallout != nil {
+		s.info.AuthRequired = true
+	}
 }
 
 // checkAuthentication will check based on client type and
@@ -216,6 +220,10 @@ func (s *Server) checkAuthentication(c *client) bool {
 		return s.isClientAuthorized(c)
 	case ROUTER:
 		return s.isRouterAuthorized(c)
+	case LEAF:
+		return s.isLeafNodeAuthorized(c)
+	case GATEWAY:
+		return s.isGatewayAuthorized(c)
 	default:
 		return false
 	}
@@ -230,12 +238,17 @@ func (s *Server) isClientAuthorized(c *client) bool {
 	authorization := s.opts.Authorization
 	username := s.opts.Username
 	password := s.opts.Password
+	tlsMap := s.opts.TLSMap
+	callout := s.opts.AuthCallout
 	s.optsMu.RUnlock()
 
-	// Check custom auth first, then jwts, then nkeys, then multiple users, then token, then single user/pass.
+	// Check custom auth first, then the callout, then jwts, then nkeys, then multiple users, then token, then single user/pass.
 	if customClientAuthentication != nil {
 		return customClientAuthentication.Check(c)
 	}
+	if callout != nil && !callout.isAuthUser(c) {
+		return s.processClientAuthCallout(c, callout)
+	}
 
 	// Grab under lock but process after.
 	var (
@@ -278,6 +291,28 @@ func (s *Server) isClientAuthorized(c *client) bool {
 		}
 	}
 
+	// With verify_and_map the user is derived from the verified client
+	// certificate instead of the credentials in CONNECT.
+	if tlsMap && s.trustedNkeys == nil {
+		if state := c.GetTLSConnectionState(); state != nil && len(state.PeerCertificates) > 0 {
+			id, user, nkey := s.lookupTLSMappedUser(state.PeerCertificates[0])
+			s.mu.Unlock()
+			if id == "" {
+				c.Debugf("No user mapped to client certificate %q", state.PeerCertificates[0].Subject)
+				return false
+			}
+			c.mu.Lock()
+			c.opts.Username = id
+			c.mu.Unlock()
+			if nkey != nil {
+				c.RegisterNkeyUser(nkey)
+			} else {
+				c.RegisterUser(user)
+			}
+			return true
+		}
+	}
+
 	// Check if we have nkeys or users for client.
 	hasNkeys := s.nkeys != nil
 	hasUsers := s.users != nil
@@ -311,6 +346,10 @@ func (s *Server) isClientAuthorized(c *client) bool {
 			c.Debugf("Account JWT has expired")
 			return false
 		}
+		if acc.checkUserRevoked(juc.Subject, juc.IssuedAt) {
+			c.Debugf("User JWT has been revoked")
+			return false
+		}
 		// Verify the signature against the nonce.
 		if c.opts.Sig == "" {
 			c.Debugf("Signature missing")
@@ -379,6 +418,31 @@ func (s *Server) isClientAuthorized(c *client) bool {
 	return false
 }
 
+// tlsMapIdentities returns the identities a client certificate can map to,
+// in order of precedence: the subject DN, the email addresses and the DNS
+// names.
+func tlsMapIdentities(cert *x509.Certificate) []string {
+	ids := make([]string, 0, 1+len(cert.EmailAddresses)+len(cert.DNSNames))
+	ids = append(ids, cert.Subject.String())
+	ids = append(ids, cert.EmailAddresses...)
+	return append(ids, cert.DNSNames...)
+}
+
+// lookupTLSMappedUser returns the first identity of the certificate that is
+// a configured user or nkey, along with that user or nkey.
+// Lock should be held.
+func (s *Server) lookupTLSMappedUser(cert *x509.Certificate) (string, *User, *NkeyUser) {
+	for _, id := range tlsMapIdentities(cert) {
+		if user, ok := s.users[id]; ok {
+			return id, user, nil
+		}
+		if nkey, ok := s.nkeys[id]; ok {
+			return id, nil, nkey
+		}
+	}
+	return "", nil, nil
+}
+
 // checkRouterAuth checks optional router authorization which can be nil or username/password.
 func (s *Server) isRouterAuthorized(c *client) bool {
 	// Snapshot server options.
@@ -401,6 +465,48 @@ func (s *Server) isRouterAuthorized(c *client) bool {
 	return true
 }
 
+// isLeafNodeAuthorized checks optional leafnode authorization which can be nil
+// or username/password. On success the leaf node is bound to its account.
+func (s *Server) isLeafNodeAuthorized(c *client) bool {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	if opts.LeafNode.Username != "" {
+		if opts.LeafNode.Username != c.opts.Username {
+			return false
+		}
+		if !comparePasswords(opts.LeafNode.Password, c.opts.Password) {
+			return false
+		}
+	}
+
+	acc := s.gacc
+	if opts.LeafNode.Account != "" {
+		if acc = s.LookupAccount(opts.LeafNode.Account); acc == nil {
+			c.Debugf("Leafnode account %q can not be found", opts.LeafNode.Account)
+			return false
+		}
+	}
+	c.mu.Lock()
+	c.acc = acc
+	c.mu.Unlock()
+	return true
+}
+
+// isGatewayAuthorized will check the inbound gateway against the
+// gateway authorization, if any.
+func (s *Server) isGatewayAuthorized(c *client) bool {
+	// Snapshot server options.
+	opts := s.getOpts()
+	if opts.Gateway.Username == "" {
+		return true
+	}
+	if opts.Gateway.Username != c.opts.Username {
+		return false
+	}
+	return comparePasswords(opts.Gateway.Password, c.opts.Password)
+}
+
 // Support for bcrypt stored passwords and tokens.
 const bcryptPrefix = "$2a$"
 
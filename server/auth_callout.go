@@ -0,0 +1,444 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
+)
+
+// AuthCallout delegates the authentication of clients to an external
+// service, which receives an AuthCalloutRequest for each CONNECT and
+// replies with a user JWT signed by Issuer, whose subject is the UserNkey
+// of the request. The audience of the JWT is the account the client is
+// bound to, the global account if empty, and its permissions and
+// expiration apply to the client. Any other response denies the client,
+// its content being the reason.
+type AuthCallout struct {
+	// Subject the requests are sent to.
+	Subject string
+	// Account of the service, the system account if not set.
+	Account string
+	// Public account nkey that signs the responses.
+	Issuer string
+	// Users and nkeys authenticated by the server itself, such as the
+	// ones of the service.
+	AuthUsers []string
+	// How long to wait for a response before denying the client.
+	Timeout time.Duration
+	// How long a response is reused for the same credentials.
+	CacheTTL time.Duration
+}
+
+func (ac *AuthCallout) clone() *AuthCallout {
+	if ac == nil {
+		return nil
+	}
+	clone := &AuthCallout{}
+	*clone = *ac
+	if ac.AuthUsers != nil {
+		clone.AuthUsers = make([]string, len(ac.AuthUsers))
+		copy(clone.AuthUsers, ac.AuthUsers)
+	}
+	return clone
+}
+
+// isAuthUser returns true if the client is authenticated by the server
+// instead of the service.
+func (ac *AuthCallout) isAuthUser(c *client) bool {
+	for _, u := range ac.AuthUsers {
+		if (c.opts.Username != _EMPTY_ && u == c.opts.Username) || (c.opts.Nkey != _EMPTY_ && u == c.opts.Nkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthCalloutRequest is sent to the authorization service for each client
+// that needs to be authenticated. UserNkey is a public user nkey made for
+// this request only, which the user JWT of the response must be issued to.
+type AuthCalloutRequest struct {
+	Server      ServerInfo      `json:"server"`
+	Client      ConnInfo        `json:"client"`
+	UserNkey    string          `json:"user_nkey"`
+	User        string          `json:"user,omitempty"`
+	Pass        string          `json:"pass,omitempty"`
+	Token       string          `json:"auth_token,omitempty"`
+	Nkey        string          `json:"nkey,omitempty"`
+	JWT         string          `json:"jwt,omitempty"`
+	Nonce       string          `json:"nonce,omitempty"`
+	SigVerified bool            `json:"sig_verified"`
+	TLS         *AuthCalloutTLS `json:"tls,omitempty"`
+}
+
+// AuthCalloutTLS describes the TLS connection of the client.
+type AuthCalloutTLS struct {
+	Verified bool     `json:"verified"`
+	Certs    []string `json:"certs,omitempty"`
+}
+
+var errAuthCalloutTimeout = errors.New("authorization request timed out")
+
+// authCallout sends the authorization requests of a callout configuration,
+// with an internal client of the service account, and caches the responses.
+type authCallout struct {
+	cfg    AuthCallout
+	srv    *Server
+	acc    *Account
+	client *client
+	sub    *subscription
+	inbox  string
+
+	// Serializes the use of the internal client.
+	pmu sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[string]chan []byte
+	cache   map[string]*list.Element
+	lru     *list.List
+}
+
+// authCalloutEntry is a response cached for some credentials.
+type authCalloutEntry struct {
+	key     string
+	claims  *jwt.UserClaims // nil when the client was denied.
+	reason  string
+	expires time.Time
+}
+
+// getAuthCallout returns the callout of the configuration, which replaces
+// the previous one if the configuration changed.
+func (s *Server) getAuthCallout(cfg *AuthCallout) (*authCallout, error) {
+	s.calloutMu.Lock()
+	defer s.calloutMu.Unlock()
+
+	if ac := s.callout; ac != nil && reflect.DeepEqual(ac.cfg, *cfg) {
+		return ac, nil
+	}
+	if s.callout != nil {
+		s.callout.close()
+		s.callout = nil
+	}
+	ac, err := s.newAuthCallout(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.callout = ac
+	return ac, nil
+}
+
+func (s *Server) newAuthCallout(cfg *AuthCallout) (*authCallout, error) {
+	var acc *Account
+	if cfg.Account == _EMPTY_ {
+		acc = s.SystemAccount()
+	} else {
+		acc = s.LookupAccount(cfg.Account)
+	}
+	if acc == nil {
+		return nil, ErrMissingAccount
+	}
+
+	c := &client{srv: s, typ: SYSTEM, acc: acc}
+	c.initClient()
+
+	ac := &authCallout{
+		cfg:     *cfg.clone(),
+		srv:     s,
+		acc:     acc,
+		client:  c,
+		inbox:   "_INBOX." + nuid.Next() + ".",
+		pending: make(map[string]chan []byte),
+		cache:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	sub := &subscription{client: c, subject: []byte(ac.inbox + "*"), sid: []byte("1"), icb: ac.processReply}
+	c.mu.Lock()
+	c.subs[string(sub.sid)] = sub
+	c.mu.Unlock()
+	if err := acc.sl.Insert(sub); err != nil {
+		return nil, err
+	}
+	// The service may be connected to another server of the cluster.
+	s.updateRouteSubscriptionMap(acc, sub, 1)
+	ac.sub = sub
+	return ac, nil
+}
+
+// close removes the interest in the replies.
+func (ac *authCallout) close() {
+	ac.acc.sl.Remove(ac.sub)
+	ac.srv.updateRouteSubscriptionMap(ac.acc, ac.sub, -1)
+}
+
+// processClientAuthCallout authenticates the client with the response of
+// the authorization service. No response in time denies the client.
+func (s *Server) processClientAuthCallout(c *client, cfg *AuthCallout) bool {
+	ac, err := s.getAuthCallout(cfg)
+	if err != nil {
+		c.Errorf("Authorization callout account %q not available: %v", cfg.Account, err)
+		return false
+	}
+	req := s.authCalloutRequest(c)
+	key := authCalloutKey(req)
+
+	e := ac.lookup(key)
+	if e == nil {
+		// The response is bound to this request by its user nkey, so
+		// that no other JWT of the issuer can be used for this client.
+		ukp, err := nkeys.CreateUser()
+		if err != nil {
+			c.Errorf("Error creating authorization callout nkey: %v", err)
+			return false
+		}
+		req.UserNkey, _ = ukp.PublicKey()
+		resp, err := ac.request(req)
+		if err != nil {
+			c.Debugf("Authorization callout failed: %v", err)
+			return false
+		}
+		if e, err = ac.processResponse(key, req.UserNkey, resp); err != nil {
+			c.Errorf("Invalid authorization callout response: %v", err)
+			return false
+		}
+	}
+	if e.claims == nil {
+		c.Debugf("Authorization denied by callout: %s", e.reason)
+		return false
+	}
+
+	name := e.claims.Audience
+	if name == _EMPTY_ {
+		name = globalAccountName
+	}
+	acc := s.LookupAccount(name)
+	if acc == nil {
+		c.Debugf("Account %q of authorization callout response not found", name)
+		return false
+	}
+	c.RegisterNkeyUser(buildInternalNkeyUser(e.claims, acc))
+	c.checkExpiration(e.claims.Claims())
+	return true
+}
+
+// authCalloutRequest returns the request describing the client.
+func (s *Server) authCalloutRequest(c *client) *AuthCalloutRequest {
+	req := &AuthCalloutRequest{}
+	c.mu.Lock()
+	req.Client.fillNoRTT(c, c.nc, time.Now())
+	req.User = c.opts.Username
+	req.Pass = c.opts.Password
+	req.Token = c.opts.Authorization
+	req.Nkey = c.opts.Nkey
+	req.JWT = c.opts.JWT
+	req.Nonce = string(c.nonce)
+	sig := c.opts.Sig
+	c.mu.Unlock()
+
+	req.SigVerified = verifyNonceSig(req.Nkey, req.JWT, sig, req.Nonce)
+	if cs := c.GetTLSConnectionState(); cs != nil {
+		req.TLS = &AuthCalloutTLS{Verified: len(cs.VerifiedChains) > 0}
+		for _, cert := range cs.PeerCertificates {
+			req.TLS.Certs = append(req.TLS.Certs, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+		}
+	}
+
+	s.mu.Lock()
+	req.Server = s.eventServerInfo()
+	s.mu.Unlock()
+	return req
+}
+
+// verifyNonceSig returns true if the signature of the nonce was made with
+// the nkey, or the key of the user JWT if there is no nkey.
+func verifyNonceSig(nkey, ujwt, sig, nonce string) bool {
+	if sig == _EMPTY_ || nonce == _EMPTY_ {
+		return false
+	}
+	if nkey == _EMPTY_ && ujwt != _EMPTY_ {
+		juc, err := jwt.DecodeUserClaims(ujwt)
+		if err != nil {
+			return false
+		}
+		nkey = juc.Subject
+	}
+	pub, err := nkeys.FromPublicKey(nkey)
+	if err != nil {
+		return false
+	}
+	// Accept the raw URL encoding of newer clients as well.
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		if raw, err = base64.RawURLEncoding.DecodeString(sig); err != nil {
+			return false
+		}
+	}
+	return pub.Verify([]byte(nonce), raw) == nil
+}
+
+// authCalloutKey returns the cache key of the credentials of the request.
+// The nonce is not part of it, only the result of the signature check.
+func authCalloutKey(req *AuthCalloutRequest) string {
+	h := sha256.New()
+	for _, f := range []string{req.User, req.Pass, req.Token, req.Nkey, req.JWT, strconv.FormatBool(req.SigVerified)} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	if req.TLS != nil && len(req.TLS.Certs) > 0 {
+		h.Write([]byte(req.TLS.Certs[0]))
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// request sends the request to the service and waits for the response.
+func (ac *authCallout) request(req *AuthCalloutRequest) ([]byte, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []byte, 1)
+	ac.mu.Lock()
+	ac.seq++
+	reply := ac.inbox + strconv.FormatUint(ac.seq, 10)
+	ac.pending[reply] = ch
+	ac.mu.Unlock()
+
+	defer func() {
+		ac.mu.Lock()
+		delete(ac.pending, reply)
+		ac.mu.Unlock()
+	}()
+
+	ac.publish(ac.cfg.Subject, reply, b)
+
+	t := time.NewTimer(ac.cfg.Timeout)
+	defer t.Stop()
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.C:
+		return nil, errAuthCalloutTimeout
+	}
+}
+
+// publish processes the message as if it was a PUB from a client of the
+// service account.
+func (ac *authCallout) publish(subject, reply string, msg []byte) {
+	ac.pmu.Lock()
+	defer ac.pmu.Unlock()
+
+	c := ac.client
+	c.pa.subject = []byte(subject)
+	c.pa.reply = []byte(reply)
+	c.pa.size = len(msg)
+	c.pa.szb = []byte(strconv.Itoa(len(msg)))
+	c.processInboundClientMsg(append(msg, _CRLF_...))
+	c.flushClients()
+	c.pa.subject, c.pa.reply, c.pa.szb = nil, nil, nil
+}
+
+// processReply hands the response to the request waiting for it.
+func (ac *authCallout) processReply(sub *subscription, subject, reply string, msg []byte) {
+	ac.mu.Lock()
+	ch := ac.pending[subject]
+	ac.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	resp := make([]byte, len(msg))
+	copy(resp, msg)
+	select {
+	case ch <- resp:
+	default:
+	}
+}
+
+// processResponse checks the response of the service to the request made
+// with the user nkey, and caches it.
+func (ac *authCallout) processResponse(key, unkey string, resp []byte) (*authCalloutEntry, error) {
+	now := time.Now()
+	e := &authCalloutEntry{key: key, expires: now.Add(ac.cfg.CacheTTL)}
+
+	juc, err := jwt.DecodeUserClaims(string(resp))
+	if err != nil {
+		// Anything but a JWT is a denial.
+		e.reason = string(resp)
+		if len(e.reason) > MAX_CONTROL_LINE_SIZE {
+			e.reason = e.reason[:MAX_CONTROL_LINE_SIZE]
+		}
+	} else {
+		if juc.Issuer != ac.cfg.Issuer {
+			return nil, fmt.Errorf("issuer %q is not trusted", juc.Issuer)
+		}
+		if juc.Subject != unkey {
+			return nil, fmt.Errorf("user JWT subject %q is not the nkey of the request", juc.Subject)
+		}
+		vr := jwt.CreateValidationResults()
+		juc.Validate(vr)
+		if vr.IsBlocking(true) {
+			return nil, fmt.Errorf("user JWT not valid: %+v", vr.Issues)
+		}
+		e.claims = juc
+		if juc.Expires > 0 {
+			if exp := time.Unix(juc.Expires, 0); exp.Before(e.expires) {
+				e.expires = exp
+			}
+		}
+	}
+
+	ac.mu.Lock()
+	if el := ac.cache[key]; el != nil {
+		ac.lru.Remove(el)
+	}
+	ac.cache[key] = ac.lru.PushFront(e)
+	if ac.lru.Len() > DEFAULT_AUTH_CALLOUT_CACHE_SIZE {
+		el := ac.lru.Back()
+		ac.lru.Remove(el)
+		delete(ac.cache, el.Value.(*authCalloutEntry).key)
+	}
+	ac.mu.Unlock()
+	return e, nil
+}
+
+// lookup returns the cached response for the credentials, if not expired.
+func (ac *authCallout) lookup(key string) *authCalloutEntry {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	el := ac.cache[key]
+	if el == nil {
+		return nil
+	}
+	e := el.Value.(*authCalloutEntry)
+	if !time.Now().Before(e.expires) {
+		ac.lru.Remove(el)
+		delete(ac.cache, key)
+		return nil
+	}
+	ac.lru.MoveToFront(el)
+	return e
+}
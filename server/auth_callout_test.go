@@ -0,0 +1,251 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
+)
+
+func TestAuthCallout(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	issuer, _ := akp.PublicKey()
+	otherAkp, _ := nkeys.CreateAccount()
+	ukp, _ := nkeys.CreateUser()
+	upub, _ := ukp.PublicKey()
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		system_account: SYS
+		accounts {
+			SYS { users [{user: auth, password: pwd}] }
+			A {}
+		}
+		authorization {
+			auth_callout {
+				subject: "auth.req"
+				issuer: %q
+				auth_users: [auth]
+				timeout: "250ms"
+				cache_ttl: "500ms"
+			}
+		}
+	`, issuer)))
+	defer os.Remove(conf)
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+	svc, err := nats.Connect(url, nats.UserInfo("auth", "pwd"), nats.Name("service"))
+	if err != nil {
+		t.Fatalf("Error connecting the service: %v", err)
+	}
+	defer svc.Close()
+
+	grant := func(kp nkeys.KeyPair, req *AuthCalloutRequest) []byte {
+		uc := jwt.NewUserClaims(req.UserNkey)
+		uc.Audience = "A"
+		uc.Pub.Allow.Add("foo")
+		ujwt, _ := uc.Encode(kp)
+		return []byte(ujwt)
+	}
+	var requests int32
+	var aliceJWT atomic.Value
+	reqs := make(chan *AuthCalloutRequest, 10)
+	svc.Subscribe("auth.req", func(m *nats.Msg) {
+		atomic.AddInt32(&requests, 1)
+		var req AuthCalloutRequest
+		if err := json.Unmarshal(m.Data, &req); err != nil {
+			return
+		}
+		reqs <- &req
+		switch {
+		case req.User == "alice" && req.Pass == "secret":
+			ujwt := grant(akp, &req)
+			aliceJWT.Store(ujwt)
+			m.Respond(ujwt)
+		case req.Nkey == upub && req.SigVerified:
+			m.Respond(grant(akp, &req))
+		case req.User == "bob":
+			m.Respond([]byte("bob is not welcome"))
+		case req.User == "mallory":
+			m.Respond(grant(otherAkp, &req))
+		case req.User == "eve":
+			// Replays a valid JWT issued for another request.
+			m.Respond(aliceJWT.Load().([]byte))
+		}
+	})
+	svc.Flush()
+
+	connect := func(opts ...nats.Option) (*nats.Conn, error) {
+		return nats.Connect(url, append(opts, nats.MaxReconnects(0))...)
+	}
+	clientAccount := func(name string) string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, c := range s.clients {
+			c.mu.Lock()
+			n, acc := c.opts.Name, c.acc
+			c.mu.Unlock()
+			if n == name && acc != nil {
+				return acc.Name
+			}
+		}
+		return ""
+	}
+
+	// The client is bound to the account and permissions of the response.
+	nc, err := connect(nats.UserInfo("alice", "secret"), nats.Name("alice"))
+	if err != nil {
+		t.Fatalf("Expected alice to connect, got %v", err)
+	}
+	defer nc.Close()
+	req := <-reqs
+	if req.Client.Name != "alice" || req.Server.ID != s.ID() || req.SigVerified || req.Nonce == "" || req.UserNkey == "" {
+		t.Fatalf("Unexpected request: %+v", req)
+	}
+	if acc := clientAccount("alice"); acc != "A" {
+		t.Fatalf("Expected alice in account A, got %q", acc)
+	}
+	errCh := make(chan error, 1)
+	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) { errCh <- err })
+	nc.Publish("bar", []byte("hello"))
+	select {
+	case err := <-errCh:
+		if !strings.Contains(err.Error(), "Permissions Violation") {
+			t.Fatalf("Expected permissions violation, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected permissions violation")
+	}
+
+	// The response is cached for the same credentials.
+	nc2, err := connect(nats.UserInfo("alice", "secret"))
+	if err != nil {
+		t.Fatalf("Expected alice to connect, got %v", err)
+	}
+	nc2.Close()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected cached response, got %d requests", n)
+	}
+
+	// The service itself is authenticated by the server.
+	if acc := clientAccount("service"); acc != "SYS" {
+		t.Fatalf("Expected service in account SYS, got %q", acc)
+	}
+
+	// The nonce signature is verified before sending the request.
+	if nc, err := connect(nats.Nkey(upub, func(nonce []byte) ([]byte, error) {
+		return ukp.Sign(nonce)
+	})); err != nil {
+		t.Fatalf("Expected nkey user to connect, got %v", err)
+	} else {
+		nc.Close()
+	}
+	<-reqs
+
+	// Denials, wrong issuers, replayed responses and timeouts.
+	for _, user := range []string{"bob", "mallory", "eve", "slow"} {
+		if nc, err := connect(nats.UserInfo(user, "pwd")); err == nil {
+			nc.Close()
+			t.Fatalf("Expected %s to be denied", user)
+		}
+		<-reqs
+	}
+
+	// Explicit denials are cached, invalid responses and timeouts are not.
+	n := atomic.LoadInt32(&requests)
+	for _, user := range []string{"bob", "mallory", "eve", "slow"} {
+		if nc, err := connect(nats.UserInfo(user, "pwd")); err == nil {
+			nc.Close()
+			t.Fatalf("Expected %s to be denied", user)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != n+3 {
+		t.Fatalf("Expected %d requests, got %d", n+3, got)
+	}
+
+	// Once expired, the service is asked again.
+	time.Sleep(600 * time.Millisecond)
+	nc2, err = connect(nats.UserInfo("alice", "secret"))
+	if err != nil {
+		t.Fatalf("Expected alice to connect, got %v", err)
+	}
+	nc2.Close()
+	if got := atomic.LoadInt32(&requests); got != n+4 {
+		t.Fatalf("Expected %d requests, got %d", n+4, got)
+	}
+}
+
+func TestAuthCalloutConfig(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	issuer, _ := akp.PublicKey()
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		authorization {
+			auth_callout {
+				subject: "auth.req"
+				account: AUTH
+				issuer: %q
+				auth_users: svc
+				timeout: 2
+			}
+		}
+	`, issuer)))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	processOptions(opts)
+	expected := &AuthCallout{
+		Subject:   "auth.req",
+		Account:   "AUTH",
+		Issuer:    issuer,
+		AuthUsers: []string{"svc"},
+		Timeout:   2 * time.Second,
+		CacheTTL:  DEFAULT_AUTH_CALLOUT_CACHE_TTL,
+	}
+	if !reflect.DeepEqual(opts.AuthCallout, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, opts.AuthCallout)
+	}
+
+	for _, test := range []struct {
+		conf string
+		err  string
+	}{
+		{`issuer: "` + issuer + `"`, "requires a subject"},
+		{`subject: foo, issuer: "UABC"`, "not a valid public account key"},
+		{`subject: foo, issuer: "` + issuer + `", timeout: "never"`, "Invalid auth_callout timeout"},
+		{`subject: foo, issuer: "` + issuer + `", bad: 1`, "unknown field"},
+	} {
+		conf := createConfFile(t, []byte(`authorization { auth_callout { `+test.conf+` } }`))
+		_, err := ProcessConfigFile(conf)
+		os.Remove(conf)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expected error %q, got %v", test.err, err)
+		}
+	}
+}
@@ -14,8 +14,21 @@
 package server
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
 )
 
 func TestUserCloneNilPermissions(t *testing.T) {
@@ -101,3 +114,134 @@ func TestUserCloneNil(t *testing.T) {
 		t.Fatalf("Expected nil, got: %+v", clone)
 	}
 }
+
+// testGenCert creates a certificate from the template, signed by parent, or
+// self-signed if parent is nil.
+func testGenCert(t *testing.T, tmpl *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSVerifyAndMap(t *testing.T) {
+	ca := testGenCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	srvCert := testGenCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCert := func(tmpl *x509.Certificate) tls.Certificate {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		return testGenCert(t, tmpl, &ca)
+	}
+
+	opts := DefaultOptions()
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	opts.TLSMap = true
+	accB := &Account{Name: "B"}
+	opts.Accounts = []*Account{accB}
+	opts.Users = []*User{
+		{Username: "CN=alice,O=Acme", Permissions: &Permissions{Publish: &SubjectPermission{Allow: []string{"foo"}}}},
+		{Username: "bob@example.com", Account: accB},
+		{Username: "svc.example.com", Password: "pwd"},
+	}
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("tls://127.0.0.1:%d", opts.Port)
+	connect := func(cert tls.Certificate, name string, extra ...nats.Option) (*nats.Conn, error) {
+		tc := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}
+		return nats.Connect(url, append(extra, nats.Name(name), nats.Secure(tc))...)
+	}
+
+	// The subject DN maps to alice, whose permissions apply.
+	errCh := make(chan error, 1)
+	alice, err := connect(clientCert(&x509.Certificate{Subject: pkix.Name{CommonName: "alice", Organization: []string{"Acme"}}}),
+		"alice", nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) { errCh <- err }))
+	if err != nil {
+		t.Fatalf("Error connecting alice: %v", err)
+	}
+	defer alice.Close()
+	alice.Publish("bar", nil)
+	select {
+	case err := <-errCh:
+		if !strings.Contains(err.Error(), "Permissions Violation") {
+			t.Fatalf("Expected permissions violation, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected permissions violation for alice")
+	}
+
+	// The email maps to bob, bound to account B. Credentials are not needed,
+	// even for a user that has a password.
+	bob, err := connect(clientCert(&x509.Certificate{
+		Subject:        pkix.Name{CommonName: "bob"},
+		EmailAddresses: []string{"bob@example.com"},
+	}), "bob")
+	if err != nil {
+		t.Fatalf("Error connecting bob: %v", err)
+	}
+	defer bob.Close()
+	svc, err := connect(clientCert(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "service"},
+		DNSNames: []string{"svc.example.com"},
+	}), "svc")
+	if err != nil {
+		t.Fatalf("Error connecting svc: %v", err)
+	}
+	defer svc.Close()
+
+	// A certificate that maps to no user is rejected.
+	if nc, err := connect(clientCert(&x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}), "mallory"); err == nil {
+		nc.Close()
+		t.Fatalf("Expected unmapped certificate to be rejected")
+	}
+
+	users := map[string]string{
+		"alice": "CN=alice,O=Acme",
+		"bob":   "bob@example.com",
+		"svc":   "svc.example.com",
+	}
+	cz, _ := s.Connz(&ConnzOptions{Username: true})
+	if len(cz.Conns) != len(users) {
+		t.Fatalf("Expected %d connections, got %d", len(users), len(cz.Conns))
+	}
+	for _, ci := range cz.Conns {
+		if ci.AuthorizedUser != users[ci.Name] {
+			t.Fatalf("Expected %q to be mapped to %q, got %q", ci.Name, users[ci.Name], ci.AuthorizedUser)
+		}
+	}
+	s.mu.Lock()
+	for _, c := range s.clients {
+		if c.opts.Name == "bob" && c.acc.Name != "B" {
+			t.Fatalf("Expected bob to be bound to account B")
+		}
+	}
+	s.mu.Unlock()
+}
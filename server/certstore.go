@@ -0,0 +1,312 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// How often the certificate files are checked for changes.
+	certStorePollInterval = 2 * time.Second
+	// How long to wait before fetching an OCSP response again after a
+	// failure, or when the responder does not tell when to.
+	ocspRetryInterval   = time.Minute
+	ocspDefaultInterval = time.Hour
+)
+
+const (
+	// Timeout of requests to the OCSP responder.
+	ocspRequestTimeout = 5 * time.Second
+	// Maximum size of the OCSP response that is read.
+	ocspMaxResponseSize = 64 * 1024
+)
+
+// CertInfo describes a certificate of the chain served to TLS clients.
+type CertInfo struct {
+	Subject        string     `json:"subject"`
+	Expires        time.Time  `json:"expires"`
+	OCSPNextUpdate *time.Time `json:"ocsp_next_update,omitempty"`
+}
+
+// certStore serves the client TLS certificate through GetCertificate and
+// reloads it when its files change. Since the certificate is picked at
+// handshake time, established connections are not affected by a rotation.
+type certStore struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	issuer   *x509.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+	rotated  time.Time
+	ocspNext time.Time
+	ocspUpd  time.Time
+}
+
+func newCertStore(certFile, keyFile string) (*certStore, error) {
+	cs := &certStore{certFile: certFile, keyFile: keyFile}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// load reads the certificate and key files.
+func (cs *certStore) load() error {
+	certMod, keyMod, err := cs.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cs.certFile, cs.keyFile)
+	if err != nil {
+		return fmt.Errorf("error parsing X509 certificate/key pair: %v", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("error parsing certificate: %v", err)
+	}
+	// The issuer, needed for OCSP, is the next certificate of the chain.
+	var issuer *x509.Certificate
+	if len(cert.Certificate) > 1 {
+		issuer, _ = x509.ParseCertificate(cert.Certificate[1])
+	}
+
+	cs.mu.Lock()
+	if cs.cert != nil {
+		cs.rotated = time.Now()
+	}
+	cs.cert = &cert
+	cs.issuer = issuer
+	cs.certMod, cs.keyMod = certMod, keyMod
+	cs.ocspNext, cs.ocspUpd = time.Time{}, time.Time{}
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *certStore) modTimes() (time.Time, time.Time, error) {
+	cfi, err := os.Stat(cs.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	kfi, err := os.Stat(cs.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return cfi.ModTime(), kfi.ModTime(), nil
+}
+
+// changed returns true if the certificate or key file was modified since
+// they were last loaded.
+func (cs *certStore) changed() bool {
+	certMod, keyMod, err := cs.modTimes()
+	if err != nil {
+		// Possibly in the middle of a replacement, check again later.
+		return false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return !certMod.Equal(cs.certMod) || !keyMod.Equal(cs.keyMod)
+}
+
+// getCertificate is the tls.Config GetCertificate callback.
+func (cs *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cert, nil
+}
+
+// ocspDue returns true if the certificate supports OCSP and its staple
+// needs to be fetched.
+func (cs *certStore) ocspDue() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.issuer != nil && len(cs.cert.Leaf.OCSPServer) > 0 && !time.Now().Before(cs.ocspNext)
+}
+
+// refreshOCSP fetches the OCSP response of the certificate and staples it.
+func (cs *certStore) refreshOCSP() error {
+	cs.mu.RLock()
+	cert, issuer := cs.cert, cs.issuer
+	cs.mu.RUnlock()
+
+	staple, next, err := fetchOCSPResponse(cert.Leaf, issuer)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	// The certificate may have been rotated while fetching.
+	if cs.cert != cert {
+		return nil
+	}
+	now := time.Now()
+	if err != nil {
+		cs.ocspNext = now.Add(ocspRetryInterval)
+		return err
+	}
+	// Refresh half way to the next update.
+	cs.ocspNext = now.Add(ocspDefaultInterval)
+	if !next.IsZero() {
+		cs.ocspNext = now.Add(next.Sub(now) / 2)
+		if cs.ocspNext.Sub(now) < ocspRetryInterval {
+			cs.ocspNext = now.Add(ocspRetryInterval)
+		}
+	}
+	cs.ocspUpd = next
+	stapled := *cert
+	stapled.OCSPStaple = staple
+	cs.cert = &stapled
+	return nil
+}
+
+// fetchOCSPResponse returns the OCSP response for cert from its responder,
+// provided the certificate is in good standing.
+func fetchOCSPResponse(cert, issuer *x509.Certificate) ([]byte, time.Time, error) {
+	req, err := createOCSPRequest(cert, issuer)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	hc := &http.Client{Timeout: ocspRequestTimeout}
+	resp, err := hc.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("OCSP responder returned %v", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	status, next, err := parseOCSPResponse(body, cert)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	switch status {
+	case ocspRevoked:
+		return nil, time.Time{}, fmt.Errorf("certificate is revoked")
+	case ocspUnknown:
+		return nil, time.Time{}, fmt.Errorf("certificate is unknown to the OCSP responder")
+	}
+	return body, next, nil
+}
+
+// info returns the description of the served chain and the time of the
+// last rotation, which is zero if the certificate was never rotated.
+func (cs *certStore) info() ([]CertInfo, time.Time) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	certs := certChainInfo(cs.cert)
+	if len(certs) > 0 && !cs.ocspUpd.IsZero() {
+		upd := cs.ocspUpd
+		certs[0].OCSPNextUpdate = &upd
+	}
+	return certs, cs.rotated
+}
+
+// certChainInfo returns the description of the chain of cert.
+func certChainInfo(cert *tls.Certificate) []CertInfo {
+	if cert == nil {
+		return nil
+	}
+	certs := make([]CertInfo, 0, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		c := cert.Leaf
+		if i > 0 || c == nil {
+			var err error
+			if c, err = x509.ParseCertificate(der); err != nil {
+				continue
+			}
+		}
+		certs = append(certs, CertInfo{Subject: c.Subject.String(), Expires: c.NotAfter})
+	}
+	return certs
+}
+
+// configureCertStore makes the client TLS config serve its certificate
+// from the given files, which are then watched for changes. The current
+// store is kept if it serves the same files, so that a config reload does
+// not count as a rotation, and removed if TLS is disabled or the files are
+// not known.
+// Lock should be held.
+func (s *Server) configureCertStore(config *tls.Config, certFile, keyFile string) error {
+	if config == nil || certFile == _EMPTY_ || keyFile == _EMPTY_ {
+		s.certs = nil
+		return nil
+	}
+	cs := s.certs
+	if cs == nil || cs.certFile != certFile || cs.keyFile != keyFile {
+		var err error
+		if cs, err = newCertStore(certFile, keyFile); err != nil {
+			s.certs = nil
+			return err
+		}
+	}
+	// GetCertificate is only used without static certificates.
+	config.Certificates = nil
+	config.GetCertificate = cs.getCertificate
+	s.certs = cs
+	if !s.certsWatched {
+		s.certsWatched = true
+		s.startGoRoutine(s.watchCertStore)
+	}
+	return nil
+}
+
+// watchCertStore rotates the client certificate when its files change and
+// keeps its OCSP staple up to date.
+func (s *Server) watchCertStore() {
+	defer s.grWG.Done()
+
+	t := time.NewTicker(certStorePollInterval)
+	defer t.Stop()
+
+	for {
+		s.mu.Lock()
+		cs := s.certs
+		s.mu.Unlock()
+
+		if cs != nil {
+			if cs.changed() {
+				if err := cs.load(); err != nil {
+					s.Errorf("Error rotating TLS certificate: %v", err)
+				} else {
+					certs, _ := cs.info()
+					s.Noticef("Rotated TLS certificate %q, expires %v", certs[0].Subject, certs[0].Expires)
+				}
+			}
+			if cs.ocspDue() {
+				if err := cs.refreshOCSP(); err != nil {
+					s.Warnf("Error stapling OCSP response: %v", err)
+				}
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-s.quitCh:
+			return
+		}
+	}
+}
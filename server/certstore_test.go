@@ -0,0 +1,279 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Response structures for the test OCSP responder, which only needs to
+// answer that certificates are good.
+type testOCSPSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.RawValue
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type testOCSPResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []testOCSPSingleResponse
+}
+
+type testOCSPBasicResponse struct {
+	TBSResponseData    testOCSPResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+func testOCSPResponder(t *testing.T, nextUpdate time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req ocspRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		keyHash, _ := asn1.Marshal([]byte("responder"))
+		basic, _ := asn1.Marshal(testOCSPBasicResponse{
+			TBSResponseData: testOCSPResponseData{
+				ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+				ProducedAt:  now,
+				Responses: []testOCSPSingleResponse{{
+					CertID:     req.TBSRequest.RequestList[0].Cert,
+					Good:       asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0},
+					ThisUpdate: now,
+					NextUpdate: nextUpdate.UTC().Truncate(time.Second),
+				}},
+			},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1},
+			Signature:          asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+		})
+		resp, _ := asn1.Marshal(ocspResponse{
+			Response: ocspResponseBytes{ResponseType: oidOCSPBasicResponse, Response: basic},
+		})
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+}
+
+// testWriteCertFiles writes the certificate, followed by the chain, and
+// its key in PEM format.
+func testWriteCertFiles(t *testing.T, certFile, keyFile string, cert tls.Certificate, chain ...*x509.Certificate) {
+	t.Helper()
+	var certPEM []byte
+	certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})...)
+	for _, c := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+}
+
+// testTLSHandshake connects to the server and returns the TLS connection.
+func testTLSHandshake(t *testing.T, s *Server, pool *x509.CertPool) *tls.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	// The INFO is sent before the handshake. Read it one byte at a time
+	// so that no TLS data is buffered.
+	var info []byte
+	for !strings.HasSuffix(string(info), "\r\n") {
+		b := make([]byte, 1)
+		if _, err := conn.Read(b); err != nil {
+			t.Fatalf("Error reading INFO: %v", err)
+		}
+		info = append(info, b[0])
+	}
+	tc := tls.Client(conn, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+	if err := tc.Handshake(); err != nil {
+		t.Fatalf("Error during handshake: %v", err)
+	}
+	return tc
+}
+
+func TestCertStoreRotationAndOCSP(t *testing.T) {
+	oldInterval := certStorePollInterval
+	certStorePollInterval = 20 * time.Millisecond
+	defer func() { certStorePollInterval = oldInterval }()
+
+	nextUpdate := time.Now().Add(time.Hour)
+	ocsp := testOCSPResponder(t, nextUpdate)
+	defer ocsp.Close()
+
+	dir, err := ioutil.TempDir("", "gnatsd")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	ca := testGenCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	genLeaf := func(cn string) tls.Certificate {
+		return testGenCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: cn},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			OCSPServer:  []string{ocsp.URL},
+		}, &ca)
+	}
+	leaf1 := genLeaf("server-1")
+	testWriteCertFiles(t, certFile, keyFile, leaf1, ca.Leaf)
+
+	opts := DefaultOptions()
+	tc := &TLSConfigOpts{
+		CertFile:         certFile,
+		KeyFile:          keyFile,
+		Ciphers:          defaultCipherSuites(),
+		CurvePreferences: defaultCurvePreferences(),
+	}
+	if opts.TLSConfig, err = GenTLSConfig(tc); err != nil {
+		t.Fatalf("Error generating TLS config: %v", err)
+	}
+	opts.TLSCert, opts.TLSKey = certFile, keyFile
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	// The OCSP response is stapled once fetched.
+	var conn *tls.Conn
+	checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+		c := testTLSHandshake(t, s, pool)
+		if len(c.ConnectionState().OCSPResponse) == 0 {
+			c.Close()
+			return fmt.Errorf("OCSP response not stapled")
+		}
+		conn = c
+		return nil
+	})
+	defer conn.Close()
+	cs := conn.ConnectionState()
+	if cs.PeerCertificates[0].SerialNumber.Cmp(leaf1.Leaf.SerialNumber) != 0 {
+		t.Fatalf("Unexpected certificate %v", cs.PeerCertificates[0].Subject)
+	}
+	if status, _, err := parseOCSPResponse(cs.OCSPResponse, leaf1.Leaf); err != nil || status != ocspGood {
+		t.Fatalf("Unexpected stapled response: %v, %v", status, err)
+	}
+
+	v, _ := s.Varz(nil)
+	if len(v.TLSCerts) != 2 || v.TLSCertRotated != nil {
+		t.Fatalf("Unexpected certificates in varz: %+v, rotated %v", v.TLSCerts, v.TLSCertRotated)
+	}
+	if !v.TLSCerts[0].Expires.Equal(leaf1.Leaf.NotAfter) || v.TLSCerts[1].Subject != "CN=Test CA" {
+		t.Fatalf("Unexpected certificates in varz: %+v", v.TLSCerts)
+	}
+	if upd := v.TLSCerts[0].OCSPNextUpdate; upd == nil || !upd.Equal(nextUpdate.UTC().Truncate(time.Second)) {
+		t.Fatalf("Unexpected OCSP next update: %v", upd)
+	}
+
+	// Replace the files. New connections get the new certificate.
+	leaf2 := genLeaf("server-2")
+	testWriteCertFiles(t, certFile, keyFile, leaf2, ca.Leaf)
+	future := time.Now().Add(time.Second)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+		c := testTLSHandshake(t, s, pool)
+		defer c.Close()
+		cs := c.ConnectionState()
+		if cs.PeerCertificates[0].SerialNumber.Cmp(leaf2.Leaf.SerialNumber) != 0 {
+			return fmt.Errorf("Certificate not rotated yet")
+		}
+		if status, _, err := parseOCSPResponse(cs.OCSPResponse, leaf2.Leaf); err != nil || status != ocspGood {
+			return fmt.Errorf("OCSP response of new certificate not stapled: %v", err)
+		}
+		return nil
+	})
+	v, _ = s.Varz(nil)
+	if v.TLSCertRotated == nil || !v.TLSCerts[0].Expires.Equal(leaf2.Leaf.NotAfter) {
+		t.Fatalf("Expected rotation in varz, got %+v, rotated %v", v.TLSCerts, v.TLSCertRotated)
+	}
+
+	// The connection established before the rotation is not affected.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("CONNECT {\"verbose\":false}\r\nPING\r\n"))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q, %v", line, err)
+	}
+}
+
+func TestCertStoreKeepsCertificateOnBadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnatsd")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	cert := testGenCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "server"}}, nil)
+	testWriteCertFiles(t, certFile, keyFile, cert)
+	cs, err := newCertStore(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+
+	// A partially written pair fails to load and the previous certificate
+	// is still served.
+	ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	future := time.Now().Add(time.Second)
+	os.Chtimes(keyFile, future, future)
+	if !cs.changed() {
+		t.Fatalf("Expected change to be detected")
+	}
+	if err := cs.load(); err == nil {
+		t.Fatalf("Expected error loading bad key")
+	}
+	if c, _ := cs.getCertificate(nil); c.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Fatalf("Expected previous certificate to be served")
+	}
+	if _, rotated := cs.info(); !rotated.IsZero() {
+		t.Fatalf("Expected no rotation, got %v", rotated)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"math/rand"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,6 +37,12 @@ const (
 	CLIENT = iota
 	// ROUTER is another router in the cluster.
 	ROUTER
+	// LEAF is a leaf node connection, extending a cluster to an edge server.
+	LEAF
+	// GATEWAY is a link between clusters of a super-cluster.
+	GATEWAY
+	// SYSTEM is an internal client used by the server to publish events.
+	SYSTEM
 )
 
 const (
@@ -56,6 +63,9 @@ const (
 	msgScratchSize  = 1024
 	msgHeadProto    = "RMSG "
 	msgHeadProtoLen = len(msgHeadProto)
+
+	// Status header sent to requesters when there is no interest.
+	noRespondersHdr = "NATS/1.0 503\r\n\r\n"
 )
 
 // For controlling dynamic buffer sizes.
@@ -133,6 +143,10 @@ const (
 	RouteRemoved
 	ServerShutdown
 	AuthenticationExpired
+	MissingAccount
+	WrongGateway
+	Revocation
+	DuplicateClientID
 )
 
 type client struct {
@@ -151,6 +165,7 @@ type client struct {
 	out    outbound
 	srv    *Server
 	acc    *Account
+	lacc   *Account // Account bound by the listener the client connected to.
 	subs   map[string]*subscription
 	perms  *permissions
 	mperms *msgDeny
@@ -167,10 +182,14 @@ type client struct {
 	rttStart time.Time
 
 	route *route
+	leaf  *leaf
+	gw    *gateway
+	mqtt  *mqtt
 
-	debug bool
-	trace bool
-	echo  bool
+	debug   bool
+	trace   bool
+	echo    bool
+	headers bool // Remote side can receive HMSG.
 
 	flags clientFlag // Compact booleans into a single field. Size will be increased when needed.
 }
@@ -265,6 +284,9 @@ func (c *client) GetOpts() *clientOpts {
 // otherwise. Implements the ClientAuth interface.
 func (c *client) GetTLSConnectionState() *tls.ConnectionState {
 	tc, ok := c.nc.(*tls.Conn)
+	if ws, isWS := c.nc.(*wsConn); isWS {
+		tc, ok = ws.tlsConn(), ws.tlsConn() != nil
+	}
 	if !ok {
 		return nil
 	}
@@ -280,6 +302,7 @@ type subscription struct {
 	client  *client
 	im      *streamImport   // This is for import stream support.
 	shadow  []*subscription // This is to track shadowed accounts.
+	icb     msgHandler      // This is for internal subscriptions of the system client.
 	subject []byte
 	queue   []byte
 	sid     []byte
@@ -305,10 +328,17 @@ type clientOpts struct {
 	Protocol      int    `json:"protocol"`
 	Account       string `json:"account,omitempty"`
 	AccountNew    bool   `json:"new_account,omitempty"`
+	Headers       bool   `json:"headers,omitempty"`
+	NoResponders  bool   `json:"no_responders,omitempty"`
 
 	// Routes only
-	Import *SubjectPermission `json:"import,omitempty"`
-	Export *SubjectPermission `json:"export,omitempty"`
+	Import     *SubjectPermission `json:"import,omitempty"`
+	Export     *SubjectPermission `json:"export,omitempty"`
+	ShareNodes bool               `json:"share_nodes,omitempty"`
+	SysEvents  bool               `json:"sys_events,omitempty"`
+
+	// Gateways only
+	Gateway string `json:"gateway,omitempty"`
 }
 
 var defaultOpts = clientOpts{Verbose: true, Pedantic: true, Echo: true}
@@ -320,7 +350,10 @@ func init() {
 // Lock should be held
 func (c *client) initClient() {
 	s := c.srv
-	c.cid = atomic.AddUint64(&s.gcid, 1)
+	// The internal system client does not use a connection id.
+	if c.typ != SYSTEM {
+		c.cid = atomic.AddUint64(&s.gcid, 1)
+	}
 
 	// Outbound data structure setup
 	c.out.sz = startBufSize
@@ -348,9 +381,12 @@ func (c *client) initClient() {
 
 	// snapshot the string version of the connection
 	conn := "-"
-	if ip, ok := c.nc.(*net.TCPConn); ok {
-		addr := ip.RemoteAddr().(*net.TCPAddr)
+	switch nc := c.nc.(type) {
+	case *net.TCPConn, *wsConn:
+		addr := nc.RemoteAddr().(*net.TCPAddr)
 		conn = fmt.Sprintf("%s:%d", addr.IP, addr.Port)
+	case *net.UnixConn:
+		conn = "unix:" + nc.LocalAddr().String()
 	}
 
 	switch c.typ {
@@ -358,6 +394,12 @@ func (c *client) initClient() {
 		c.ncs = fmt.Sprintf("%s - cid:%d", conn, c.cid)
 	case ROUTER:
 		c.ncs = fmt.Sprintf("%s - rid:%d", conn, c.cid)
+	case LEAF:
+		c.ncs = fmt.Sprintf("%s - lid:%d", conn, c.cid)
+	case GATEWAY:
+		c.ncs = fmt.Sprintf("%s - gid:%d", conn, c.cid)
+	case SYSTEM:
+		c.ncs = "SYSTEM"
 	}
 }
 
@@ -606,6 +648,14 @@ func (c *client) readLoop() {
 		return
 	}
 
+	// MQTT clients use their own parser, and may have a will to publish
+	// when the connection goes away.
+	parse := c.parse
+	if c.mqtt != nil {
+		parse = c.mqttParse
+		defer c.mqttPublishWill()
+	}
+
 	// Start read buffer.
 
 	b := make([]byte, c.in.rsz)
@@ -631,7 +681,7 @@ func (c *client) readLoop() {
 
 		// Main call into parser for inbound data. This will generate callouts
 		// to process messages, etc.
-		if err := c.parse(b[:n]); err != nil {
+		if err := parse(b[:n]); err != nil {
 			// handled inline
 			if err != ErrMaxPayload && err != ErrAuthentication {
 				c.Errorf("%s", err.Error())
@@ -653,7 +703,7 @@ func (c *client) readLoop() {
 		// Client will be checked on several fronts to see
 		// if applicable. Routes will never wait in place.
 		budget := 500 * time.Microsecond
-		if c.typ == ROUTER {
+		if c.typ != CLIENT {
 			budget = 0
 		}
 
@@ -875,8 +925,13 @@ func (c *client) processInfo(arg []byte) error {
 	if err := json.Unmarshal(arg, &info); err != nil {
 		return err
 	}
-	if c.typ == ROUTER {
+	switch c.typ {
+	case ROUTER:
 		c.processRouteInfo(&info)
+	case LEAF:
+		c.processLeafNodeInfo(&info)
+	case GATEWAY:
+		c.processGatewayInfo(&info)
 	}
 	return nil
 }
@@ -887,6 +942,10 @@ func (c *client) processErr(errStr string) {
 		c.Errorf("Client Error %s", errStr)
 	case ROUTER:
 		c.Errorf("Route Error %s", errStr)
+	case LEAF:
+		c.Errorf("Leafnode Error %s", errStr)
+	case GATEWAY:
+		c.Errorf("Gateway Error %s", errStr)
 	}
 	c.closeConnection(ParseError)
 }
@@ -960,6 +1019,9 @@ func (c *client) processConnect(arg []byte) error {
 	c.flags.set(connectReceived)
 	// Capture these under lock
 	c.echo = c.opts.Echo
+	if typ == CLIENT || typ == LEAF {
+		c.headers = c.opts.Headers
+	}
 	proto := c.opts.Protocol
 	verbose := c.opts.Verbose
 	lang := c.opts.Lang
@@ -1018,6 +1080,11 @@ func (c *client) processConnect(arg []byte) error {
 			c.registerWithAccount(srv.gacc)
 		}
 
+		// A listener bound to an account only admits clients of that account.
+		if c.lacc != nil && c.acc != c.lacc {
+			c.authViolation()
+			return ErrAuthentication
+		}
 	}
 
 	// Check client protocol request if it exists.
@@ -1031,6 +1098,16 @@ func (c *client) processConnect(arg []byte) error {
 		c.sendErr(ErrClientConnectedToRoutePort.Error())
 		c.closeConnection(WrongPort)
 		return ErrClientConnectedToRoutePort
+	} else if typ == LEAF && lang != "" {
+		// Same as above for clients connecting to the leafnode port.
+		c.sendErr(ErrClientConnectedToLeafNodePort.Error())
+		c.closeConnection(WrongPort)
+		return ErrClientConnectedToLeafNodePort
+	} else if typ == GATEWAY && lang != "" {
+		// Same as above for clients connecting to the gateway port.
+		c.sendErr(ErrClientConnectedToGatewayPort.Error())
+		c.closeConnection(WrongPort)
+		return ErrClientConnectedToGatewayPort
 	}
 
 	// Grab connection name of remote route.
@@ -1045,6 +1122,28 @@ func (c *client) processConnect(arg []byte) error {
 		c.mu.Unlock()
 	}
 
+	// Leaf node is now authorized, register it and send over our interest.
+	if typ == LEAF && srv != nil {
+		c.mu.Lock()
+		c.leaf.remoteID = c.opts.Name
+		c.mu.Unlock()
+		srv.registerLeafNode(c)
+	}
+
+	// Gateways need to tell us which cluster they are coming from.
+	if typ == GATEWAY && srv != nil {
+		if err := c.processGatewayConnect(); err != nil {
+			return err
+		}
+	}
+
+	// Let the system account know about this new client.
+	if typ == CLIENT && srv != nil {
+		srv.accountConnectEvent(c)
+		// Informers only learn about the client once it has its name and account.
+		srv.informerClientConnected(c)
+	}
+
 	if verbose {
 		c.sendOK()
 	}
@@ -1076,6 +1175,11 @@ func (c *client) accountAuthExpired() {
 	c.closeConnection(AuthenticationExpired)
 }
 
+func (c *client) userRevoked() {
+	c.sendErrAndDebug("User Authentication Revoked")
+	c.closeConnection(Revocation)
+}
+
 func (c *client) authViolation() {
 	var hasTrustedNkeys, hasNkeys, hasUsers bool
 	if s := c.srv; s != nil {
@@ -1242,7 +1346,10 @@ func (c *client) sendInfo(info []byte) {
 func (c *client) sendErr(err string) {
 	c.mu.Lock()
 	c.traceOutOp("-ERR", []byte(err))
-	c.sendProto([]byte(fmt.Sprintf("-ERR '%s'\r\n", err)), true)
+	// MQTT has no error packet, the connection is closed when needed.
+	if c.mqtt == nil {
+		c.sendProto([]byte(fmt.Sprintf("-ERR '%s'\r\n", err)), true)
+	}
 	c.mu.Unlock()
 }
 
@@ -1324,9 +1431,25 @@ func (c *client) processPub(trace bool, arg []byte) error {
 	if trace {
 		c.traceInOp("PUB", arg)
 	}
+	return c.processPubArgs(arg, false)
+}
+
+// processHeaderPub processes an HPUB, which carries the size of the
+// message headers right before the total size.
+func (c *client) processHeaderPub(trace bool, arg []byte) error {
+	if trace {
+		c.traceInOp("HPUB", arg)
+	}
+	if !c.headers {
+		c.sendErr("Headers Not Supported")
+		return ErrHeadersNotSupported
+	}
+	return c.processPubArgs(arg, true)
+}
 
+func (c *client) processPubArgs(arg []byte, hdr bool) error {
 	// Unroll splitArgs to avoid runtime/heap issues
-	a := [MAX_PUB_ARGS][]byte{}
+	a := [MAX_HPUB_ARGS][]byte{}
 	args := a[:0]
 	start := -1
 	for i, b := range arg {
@@ -1347,6 +1470,12 @@ func (c *client) processPub(trace bool, arg []byte) error {
 	}
 
 	c.pa.arg = arg
+	c.pa.hdr, c.pa.hdb = 0, nil
+	if hdr {
+		if args = c.splitHeaderSize(args); c.pa.hdr <= 0 {
+			return fmt.Errorf("processHeaderPub Bad or Missing Header Size: '%s'", arg)
+		}
+	}
 	switch len(args) {
 	case 2:
 		c.pa.subject = args[0]
@@ -1364,6 +1493,9 @@ func (c *client) processPub(trace bool, arg []byte) error {
 	if c.pa.size < 0 {
 		return fmt.Errorf("processPub Bad or Missing Size: '%s'", arg)
 	}
+	if c.pa.hdr > c.pa.size {
+		return fmt.Errorf("processHeaderPub Header Size Exceeds Size: '%s'", arg)
+	}
 	maxPayload := atomic.LoadInt32(&c.mpay)
 	if maxPayload > 0 && int32(c.pa.size) > maxPayload {
 		c.maxPayloadViolation(c.pa.size, maxPayload)
@@ -1376,6 +1508,20 @@ func (c *client) processPub(trace bool, arg []byte) error {
 	return nil
 }
 
+// splitHeaderSize records the header size of an HPUB or HMSG, which is
+// the argument before the total size, and returns the other arguments.
+func (c *client) splitHeaderSize(args [][]byte) [][]byte {
+	n := len(args)
+	if n < 2 {
+		c.pa.hdr = -1
+		return args
+	}
+	c.pa.hdb = args[n-2]
+	c.pa.hdr = parseSize(c.pa.hdb)
+	args[n-2] = args[n-1]
+	return args[:n-1]
+}
+
 func splitArg(arg []byte) [][]byte {
 	a := [MAX_MSG_ARGS][]byte{}
 	args := a[:0]
@@ -1752,7 +1898,16 @@ func (c *client) checkDenySub(subject string) bool {
 	return false
 }
 
+// msgHeader builds the MSG, or HMSG if the subscriber can receive headers.
+// The passed header reserves the first byte for the H of HMSG.
 func (c *client) msgHeader(mh []byte, sub *subscription, reply []byte) []byte {
+	// The headers flag is set before the connection can have subscriptions.
+	hdrs := c.pa.hdr > 0 && sub.client.headers
+	if hdrs {
+		mh[0] = 'H'
+	} else {
+		mh = mh[1:]
+	}
 	if len(sub.sid) > 0 {
 		mh = append(mh, sub.sid...)
 		mh = append(mh, ' ')
@@ -1761,11 +1916,27 @@ func (c *client) msgHeader(mh []byte, sub *subscription, reply []byte) []byte {
 		mh = append(mh, reply...)
 		mh = append(mh, ' ')
 	}
-	mh = append(mh, c.pa.szb...)
+	mh = c.appendMsgSize(mh, hdrs)
 	mh = append(mh, _CRLF_...)
 	return mh
 }
 
+// appendMsgSize appends the size part of a message header. When the message
+// has headers but they are not sent, only the size of the body is used.
+func (c *client) appendMsgSize(mh []byte, hdrs bool) []byte {
+	switch {
+	case hdrs:
+		mh = append(mh, c.pa.hdb...)
+		mh = append(mh, ' ')
+		mh = append(mh, c.pa.szb...)
+	case c.pa.hdr > 0:
+		mh = strconv.AppendInt(mh, int64(c.pa.size-c.pa.hdr), 10)
+	default:
+		mh = append(mh, c.pa.szb...)
+	}
+	return mh
+}
+
 // Used to treat maps as efficient set
 var needFlush = struct{}{}
 
@@ -1791,6 +1962,11 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 
 	srv := client.srv
 
+	// Strip the headers for connections that did not ask for them.
+	if c.pa.hdr > 0 && !client.headers {
+		msg = msg[c.pa.hdr:]
+	}
+
 	sub.nm++
 	// Check if we should auto-unsubscribe.
 	if sub.max > 0 {
@@ -1824,6 +2000,15 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 		}
 	}
 
+	// Internal subscriptions are handled by a callback, there is no connection.
+	if client.typ == SYSTEM {
+		client.mu.Unlock()
+		if sub.icb != nil {
+			sub.icb(sub, string(c.pa.subject), string(c.pa.reply), msg[:len(msg)-LEN_CR_LF])
+		}
+		return true
+	}
+
 	// Check for closed connection
 	if client.nc == nil {
 		client.mu.Unlock()
@@ -1843,9 +2028,19 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 	atomic.AddInt64(&srv.outMsgs, 1)
 	atomic.AddInt64(&srv.outBytes, msgSize)
 
+	// Routes and gateways are not bound to an account.
+	if client.acc != nil {
+		atomic.AddInt64(&client.acc.outMsgs, 1)
+		atomic.AddInt64(&client.acc.outBytes, msgSize)
+	}
+
 	// Queue to outbound buffer
-	client.queueOutbound(mh)
-	client.queueOutbound(msg)
+	if client.mqtt != nil {
+		client.mqttEnqueuePublish(sub, c.pa.subject, msg[:len(msg)-LEN_CR_LF])
+	} else {
+		client.queueOutbound(mh)
+		client.queueOutbound(msg)
+	}
 
 	client.out.pm++
 
@@ -1959,12 +2154,17 @@ func isServiceReply(reply []byte) bool {
 	return len(reply) > 3 && string(reply[:4]) == replyPrefix
 }
 
-// This will decide to call the client code or router code.
+// This will decide to call the client code, router code or leafnode code.
 func (c *client) processInboundMsg(msg []byte) {
-	if c.typ == CLIENT {
+	switch c.typ {
+	case CLIENT:
 		c.processInboundClientMsg(msg)
-	} else {
+	case ROUTER:
 		c.processInboundRoutedMsg(msg)
+	case LEAF:
+		c.processInboundLeafMsg(msg)
+	case GATEWAY:
+		c.processInboundGatewayMsg(msg)
 	}
 }
 
@@ -2000,6 +2200,9 @@ func (c *client) processInboundClientMsg(msg []byte) {
 		return
 	}
 
+	atomic.AddInt64(&c.acc.inMsgs, 1)
+	atomic.AddInt64(&c.acc.inBytes, int64(len(msg)-LEN_CR_LF))
+
 	// Match the subscriptions. We will use our own L1 map if
 	// it's still valid, avoiding contention on the shared sublist.
 	var r *SublistResult
@@ -2031,22 +2234,64 @@ func (c *client) processInboundClientMsg(msg []byte) {
 	}
 
 	// Check to see if we need to map/route to another account.
+	imported := false
 	if c.acc.imports.services != nil {
-		c.checkForImportServices(c.acc, msg)
+		imported = c.checkForImportServices(c.acc, msg)
 	}
 
 	// Check for no interest, short circuit if so.
 	// This is the fanout scale.
-	if len(r.psubs)+len(r.qsubs) > 0 {
-		c.processMsgResults(c.acc, r, msg, c.pa.subject, c.pa.reply)
+	var qnames [][]byte
+	interest := len(r.psubs)+len(r.qsubs) > 0
+	if interest {
+		qnames = c.processMsgResults(c.acc, r, msg, c.pa.subject, c.pa.reply)
+	}
+
+	// Now deal with gateways.
+	gwSent := false
+	if c.srv.gatewaysEnabled() {
+		gwSent = c.sendMsgToGateways(c.acc, msg, c.pa.subject, c.pa.reply, qnames)
+	}
+
+	// Let the requester know right away that nobody can answer. This is a
+	// status header, so the client has to support headers.
+	if c.pa.reply != nil && c.opts.NoResponders && c.headers && !interest && !imported && !gwSent {
+		c.sendNoResponders()
+	}
+}
+
+// sendNoResponders delivers a 503 status message to the reply subject of
+// the current message. It only goes through the requester's own subscription,
+// a reply subject with interest elsewhere does not get it.
+func (c *client) sendNoResponders() {
+	var sub *subscription
+	r := c.acc.sl.Match(string(c.pa.reply))
+	for _, psub := range r.psubs {
+		if psub.client == c {
+			sub = psub
+			break
+		}
+	}
+	if sub == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hl := len(noRespondersHdr)
+	proto := fmt.Sprintf("HMSG %s %s %d %d\r\n%s\r\n", c.pa.reply, sub.sid, hl, hl, noRespondersHdr)
+	if c.trace {
+		c.traceOutOp(proto[:strings.Index(proto, _CRLF_)], nil)
 	}
+	c.sendProto([]byte(proto), false)
+	c.pcd[c] = needFlush
 }
 
 // This checks and process import services by doing the mapping and sending the
-// message onward if applicable.
-func (c *client) checkForImportServices(acc *Account, msg []byte) {
+// message onward if applicable. Returns true if the imported service had interest.
+func (c *client) checkForImportServices(acc *Account, msg []byte) bool {
 	if acc == nil || acc.imports.services == nil {
-		return
+		return false
 	}
 	acc.mu.RLock()
 	rm := acc.imports.services[string(c.pa.subject)]
@@ -2065,7 +2310,9 @@ func (c *client) checkForImportServices(acc *Account, msg []byte) {
 		// FIXME(dlc) - Do L1 cache trick from above.
 		rr := rm.acc.sl.Match(rm.to)
 		c.processMsgResults(rm.acc, rr, msg, []byte(rm.to), nrr)
+		return len(rr.psubs)+len(rr.qsubs) > 0
 	}
+	return false
 }
 
 func (c *client) addSubToRouteTargets(sub *subscription) {
@@ -2104,9 +2351,11 @@ func (c *client) addSubToRouteTargets(sub *subscription) {
 }
 
 // This processes the sublist results for a given message.
-func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject, reply []byte) {
+// Returns the names of the queue groups the message was delivered to,
+// which is needed to avoid duplicate queue delivery across gateways.
+func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject, reply []byte) [][]byte {
 	// msg header for clients.
-	msgh := c.msgb[1:msgHeadProtoLen]
+	msgh := c.msgb[:msgHeadProtoLen]
 	msgh = append(msgh, subject...)
 	msgh = append(msgh, ' ')
 	si := len(msgh)
@@ -2119,19 +2368,27 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 
 	// Loop over all normal subscriptions that match.
 	for _, sub := range r.psubs {
-		// Check if this is a send to a ROUTER. We now process
+		// Check if this is a send to a ROUTER or a LEAF. We now process
 		// these after everything else.
-		if sub.client.typ == ROUTER {
+		switch sub.client.typ {
+		case ROUTER:
 			if c.typ == ROUTER {
 				continue
 			}
 			c.addSubToRouteTargets(sub)
 			continue
+		case LEAF:
+			// Never send back to the leaf node we received this from.
+			if c == sub.client {
+				continue
+			}
+			c.addSubToRouteTargets(sub)
+			continue
 		}
 		// Check for stream import mapped subs. These apply to local subs only.
 		if sub.im != nil && sub.im.prefix != "" {
 			// Redo the subject here on the fly.
-			msgh = c.msgb[1:msgHeadProtoLen]
+			msgh = c.msgb[:msgHeadProtoLen]
 			msgh = append(msgh, sub.im.prefix...)
 			msgh = append(msgh, c.pa.subject...)
 			msgh = append(msgh, ' ')
@@ -2142,16 +2399,23 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 		c.deliverMsg(sub, mh, msg)
 	}
 
-	// If we are sourced from a route we need to have direct filtered queues.
-	if c.typ == ROUTER && c.pa.queues == nil {
-		return
+	// If we are sourced from a route or a leaf node we need to have direct
+	// filtered queues. Leaf nodes may still need the message though.
+	if (c.typ == ROUTER || c.typ == LEAF) && c.pa.queues == nil {
+		c.sendMsgToRouteTargets(acc, msg, subject, reply)
+		return nil
 	}
 
 	// Set these up to optionally filter based on the queue lists.
 	// This is for messages received from routes which will have directed
-	// guidance on which queue groups we should deliver to.
+	// guidance on which queue groups we should deliver to. Messages from
+	// gateways instead list the queue groups that were already handled
+	// in the originating cluster.
 	qf := c.pa.queues
 
+	// Queue groups we delivered to.
+	var queues [][]byte
+
 	// Check to see if we have our own rand yet. Global rand
 	// has contention with lots of clients, etc.
 	if c.in.prand == nil {
@@ -2167,6 +2431,12 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 		// and more cache friendly.
 		if qf != nil && len(qsubs) > 0 {
 			tqn := qsubs[0].queue
+			if c.typ == GATEWAY {
+				if queueInList(tqn, qf) {
+					continue
+				}
+				goto selectQSub
+			}
 			for _, qn := range qf {
 				if bytes.Equal(qn, tqn) {
 					goto selectQSub
@@ -2190,24 +2460,26 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 			if sub == nil {
 				continue
 			}
-			// Potentially sending to a remote sub across a route.
-			if sub.client.typ == ROUTER {
-				if c.typ == ROUTER {
-					// We just came from a route, so skip and prefer local subs.
-					// Keep our first rsub in case all else fails.
-					if rsub == nil {
+			// Potentially sending to a remote sub across a route or leaf node.
+			if sub.client.typ == ROUTER || sub.client.typ == LEAF {
+				if c.typ == ROUTER || c.typ == LEAF {
+					// We just came from a route or leaf node, so skip and prefer
+					// local subs. Keep our first rsub in case all else fails, but
+					// never send back to the leaf node we received this from.
+					if rsub == nil && (c.typ == ROUTER || sub.client != c) {
 						rsub = sub
 					}
 					continue
 				} else {
 					c.addSubToRouteTargets(sub)
+					queues = append(queues, sub.queue)
 				}
 				break
 			}
 			// Check for mapped subs
 			if sub.im != nil && sub.im.prefix != "" {
 				// Redo the subject here on the fly.
-				msgh = c.msgb[1:msgHeadProtoLen]
+				msgh = c.msgb[:msgHeadProtoLen]
 				msgh = append(msgh, sub.im.prefix...)
 				msgh = append(msgh, c.pa.subject...)
 				msgh = append(msgh, ' ')
@@ -2218,6 +2490,7 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 			if c.deliverMsg(sub, mh, msg) {
 				// Clear rsub
 				rsub = nil
+				queues = append(queues, sub.queue)
 				break
 			}
 		}
@@ -2226,10 +2499,28 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 			// If we are here we tried to deliver to a local qsub
 			// but failed. So we will send it to a remote.
 			c.addSubToRouteTargets(rsub)
+			queues = append(queues, rsub.queue)
 		}
 	}
 
-	// If no messages for routes return here.
+	c.sendMsgToRouteTargets(acc, msg, subject, reply)
+	return queues
+}
+
+// queueInList returns true if the queue name is in the list.
+func queueInList(queue []byte, qnames [][]byte) bool {
+	for _, qn := range qnames {
+		if bytes.Equal(qn, queue) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendMsgToRouteTargets will send the message to the routes and leaf nodes
+// collected while processing the sublist results.
+func (c *client) sendMsgToRouteTargets(acc *Account, msg, subject, reply []byte) {
+	// If no messages for routes or leaf nodes return here.
 	if len(c.in.rts) == 0 {
 		return
 	}
@@ -2239,9 +2530,23 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 	for i := range c.in.rts {
 		rt := &c.in.rts[i]
 
+		// Leaf nodes are bound to a single account, so LMSG does
+		// not carry the account name. Neither does an HMSG to a leaf.
 		mh := c.msgb[:msgHeadProtoLen]
-		mh = append(mh, acc.Name...)
-		mh = append(mh, ' ')
+		leaf := rt.sub.client.typ == LEAF
+		hdrs := c.pa.hdr > 0 && rt.sub.client.headers
+		switch {
+		case hdrs:
+			mh[0] = 'H'
+		case leaf:
+			mh[0] = 'L'
+		default:
+			mh[0] = 'R'
+		}
+		if !leaf {
+			mh = append(mh, acc.Name...)
+			mh = append(mh, ' ')
+		}
 		mh = append(mh, subject...)
 		mh = append(mh, ' ')
 
@@ -2258,7 +2563,7 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 			mh = append(mh, reply...)
 			mh = append(mh, ' ')
 		}
-		mh = append(mh, c.pa.szb...)
+		mh = c.appendMsgSize(mh, hdrs)
 		mh = append(mh, _CRLF_...)
 		c.deliverMsg(rt.sub, mh, msg)
 	}
@@ -2394,6 +2699,12 @@ func (c *client) typeString() string {
 		return "Client"
 	case ROUTER:
 		return "Router"
+	case LEAF:
+		return "Leafnode"
+	case GATEWAY:
+		return "Gateway"
+	case SYSTEM:
+		return "System"
 	}
 	return "Unknown Type"
 }
@@ -2485,10 +2796,12 @@ func (c *client) closeConnection(reason ClosedState) {
 
 	c.clearAuthTimer()
 	c.clearPingTimer()
+	nc := c.nc
 	c.clearConnection(reason)
 	c.nc = nil
 
 	ctype := c.typ
+	connected := c.flags.isSet(connectReceived)
 
 	// Snapshot for use if we are a client connection.
 	// FIXME(dlc) - we can just stub in a new one for client
@@ -2517,14 +2830,25 @@ func (c *client) closeConnection(reason ClosedState) {
 		connectURLs = c.route.connectURLs
 	}
 
+	var sess *mqttSession
+	if c.mqtt != nil {
+		sess = c.mqtt.sess
+	}
+
 	acc := c.acc
 	c.mu.Unlock()
 
 	// Remove clients subscriptions.
-	if ctype == CLIENT {
+	switch ctype {
+	case CLIENT:
 		acc.sl.RemoveBatch(subs)
-	} else {
+		if sess != nil && srv != nil {
+			srv.mqttDetachSession(sess, c)
+		}
+	case ROUTER:
 		go c.removeRemoteSubs()
+	case LEAF:
+		c.removeLeafNodeSubs()
 	}
 
 	if srv != nil {
@@ -2540,6 +2864,11 @@ func (c *client) closeConnection(reason ClosedState) {
 		// Unregister
 		srv.removeClient(c)
 
+		// Let the system account know that this client is gone.
+		if ctype == CLIENT && connected {
+			srv.accountDisconnectEvent(c, nc, reason)
+		}
+
 		// Update remote subscriptions.
 		if acc != nil && ctype == CLIENT {
 			qsubs := map[string]*qsub{}
@@ -2570,6 +2899,20 @@ func (c *client) closeConnection(reason ClosedState) {
 		}
 	}
 
+	// Check for a solicited leaf node or gateway. If it was, start up a reconnect.
+	switch ctype {
+	case LEAF:
+		if srv != nil {
+			srv.reConnectLeafNodeIfSolicited(c)
+		}
+		return
+	case GATEWAY:
+		if srv != nil {
+			srv.reConnectGatewayIfSolicited(c)
+		}
+		return
+	}
+
 	// Don't reconnect routes that are being closed.
 	if routeClosed {
 		return
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"regexp"
@@ -45,7 +46,7 @@ type serverInfo struct {
 
 func createClientAsync(ch chan *client, s *Server, cli net.Conn) {
 	go func() {
-		c := s.createClient(cli)
+		c := s.createClient(cli, nil)
 		// Must be here to suppress +OK
 		c.opts.Verbose = false
 		ch <- c
@@ -1161,3 +1162,151 @@ func TestClientTraceRace(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// createHeadersClientConn connects a raw client that may enable headers,
+// and checks that the server advertises header support.
+func createHeadersClientConn(t *testing.T, host string, port int, headers bool) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	if err != nil {
+		t.Fatalf("Error dialing server: %v\n", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	cr := bufio.NewReaderSize(conn, maxBufSize)
+	l, err := cr.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error receiving info from server: %v\n", err)
+	}
+	var info Info
+	if err := json.Unmarshal([]byte(l[5:]), &info); err != nil {
+		t.Fatalf("Could not parse INFO json: %v\n", err)
+	}
+	if !info.Headers {
+		t.Fatalf("Expected server to support headers: %q", l)
+	}
+	fmt.Fprintf(conn, "CONNECT {\"verbose\":false,\"headers\":%v}\r\nPING\r\n", headers)
+	if l, _ := cr.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+	return conn, cr
+}
+
+// expectMsg reads a message and checks its protocol line and payload.
+func expectMsg(t *testing.T, cr *bufio.Reader, proto, payload string) {
+	t.Helper()
+	l, err := cr.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error receiving msg: %v\n", err)
+	}
+	if l != proto {
+		t.Fatalf("Expected %q, got %q", proto, l)
+	}
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(cr, buf); err != nil {
+		t.Fatalf("Error receiving payload: %v\n", err)
+	}
+	if string(buf) != payload {
+		t.Fatalf("Expected payload %q, got %q", payload, buf)
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	opts := DefaultOptions()
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	hsub, hcr := createHeadersClientConn(t, opts.Host, opts.Port, true)
+	defer hsub.Close()
+	psub, pcr := createHeadersClientConn(t, opts.Host, opts.Port, false)
+	defer psub.Close()
+	for _, sc := range []struct {
+		conn net.Conn
+		cr   *bufio.Reader
+	}{{hsub, hcr}, {psub, pcr}} {
+		sc.conn.Write([]byte("SUB foo 1\r\nPING\r\n"))
+		if l, _ := sc.cr.ReadString('\n'); l != "PONG\r\n" {
+			t.Fatalf("Expected PONG, got %q", l)
+		}
+	}
+
+	pub, _ := createHeadersClientConn(t, opts.Host, opts.Port, true)
+	defer pub.Close()
+	pub.Write([]byte("HPUB foo bar 12 17\r\nNATS/1.0\r\n\r\nhello\r\nPUB foo 2\r\nok\r\n"))
+
+	expectMsg(t, hcr, "HMSG foo 1 bar 12 17\r\n", "NATS/1.0\r\n\r\nhello\r\n")
+	expectMsg(t, hcr, "MSG foo 1 2\r\n", "ok\r\n")
+	// A subscriber that did not opt in only gets the body.
+	expectMsg(t, pcr, "MSG foo 1 bar 5\r\n", "hello\r\n")
+	expectMsg(t, pcr, "MSG foo 1 2\r\n", "ok\r\n")
+
+	// A publisher that did not opt in can not send headers.
+	nopub, cr := createHeadersClientConn(t, opts.Host, opts.Port, false)
+	defer nopub.Close()
+	nopub.Write([]byte("HPUB foo 12 17\r\nNATS/1.0\r\n\r\nhello\r\n"))
+	if l, _ := cr.ReadString('\n'); !strings.Contains(l, "Headers Not Supported") {
+		t.Fatalf("Expected error, got %q", l)
+	}
+}
+
+func TestClientNoResponders(t *testing.T) {
+	opts := DefaultOptions()
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	connect := func(connect string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", net.JoinHostPort(opts.Host, fmt.Sprintf("%d", opts.Port)))
+		if err != nil {
+			t.Fatalf("Error dialing server: %v\n", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		cr := bufio.NewReaderSize(conn, maxBufSize)
+		if _, err := cr.ReadString('\n'); err != nil {
+			t.Fatalf("Error receiving info from server: %v\n", err)
+		}
+		fmt.Fprintf(conn, "CONNECT %s\r\nSUB reply 1\r\nPING\r\n", connect)
+		if l, _ := cr.ReadString('\n'); l != "PONG\r\n" {
+			t.Fatalf("Expected PONG, got %q", l)
+		}
+		return conn, cr
+	}
+
+	// With headers the status is carried in the header.
+	conn, cr := connect(`{"verbose":false,"headers":true,"no_responders":true}`)
+	defer conn.Close()
+	conn.Write([]byte("PUB foo reply 2\r\nok\r\n"))
+	expectMsg(t, cr, "HMSG reply 1 16 16\r\n", "NATS/1.0 503\r\n\r\n\r\n")
+
+	// A publish without a reply does not get anything.
+	conn.Write([]byte("PUB foo 2\r\nok\r\nPING\r\n"))
+	if l, _ := cr.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+
+	// Without headers nothing is sent, an empty message would look
+	// like a real reply.
+	conn2, cr2 := connect(`{"verbose":false,"no_responders":true}`)
+	defer conn2.Close()
+	conn2.Write([]byte("PUB foo reply 2\r\nok\r\nPING\r\n"))
+	if l, _ := cr2.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+
+	// Nothing unless asked for.
+	conn3, cr3 := connect(`{"verbose":false}`)
+	defer conn3.Close()
+	conn3.Write([]byte("PUB foo reply 2\r\nok\r\nPING\r\n"))
+	if l, _ := cr3.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+
+	// Once there is a responder the request goes through.
+	conn3.Write([]byte("SUB foo 2\r\nPING\r\n"))
+	if l, _ := cr3.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+	conn.Write([]byte("PUB foo reply 2\r\nok\r\nPING\r\n"))
+	expectMsg(t, cr3, "MSG foo 2 reply 2\r\n", "ok\r\n")
+	if l, _ := cr.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+}
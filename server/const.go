@@ -112,6 +112,34 @@ const (
 	// DEFAULT_ROUTE_DIAL Route dial timeout.
 	DEFAULT_ROUTE_DIAL = 1 * time.Second
 
+	// DEFAULT_LEAF_NODE_RECONNECT LeafNode reconnect interval.
+	DEFAULT_LEAF_NODE_RECONNECT = 1 * time.Second
+
+	// DEFAULT_LEAF_NODE_DIAL LeafNode dial timeout.
+	DEFAULT_LEAF_NODE_DIAL = 1 * time.Second
+
+	// DEFAULT_GATEWAY_RECONNECT Gateway reconnect interval.
+	DEFAULT_GATEWAY_RECONNECT = 1 * time.Second
+
+	// DEFAULT_GATEWAY_DIAL Gateway dial timeout.
+	DEFAULT_GATEWAY_DIAL = 1 * time.Second
+
+	// DEFAULT_WEBSOCKET_HANDSHAKE_TIMEOUT is how long a websocket client has
+	// to complete the HTTP upgrade.
+	DEFAULT_WEBSOCKET_HANDSHAKE_TIMEOUT = 2 * time.Second
+
+	// DEFAULT_MQTT_MAX_INFLIGHT is the number of QoS 1 messages an MQTT
+	// client can have waiting for their PUBACK.
+	DEFAULT_MQTT_MAX_INFLIGHT = 100
+
+	// DEFAULT_MQTT_SESSION_EXPIRY is how long the session of an MQTT client
+	// is kept once the client is gone.
+	DEFAULT_MQTT_SESSION_EXPIRY = 24 * time.Hour
+
+	// DEFAULT_UNIX_SOCKET_MODE is the file mode of the client unix socket,
+	// which only lets the server's user connect.
+	DEFAULT_UNIX_SOCKET_MODE = 0600
+
 	// PROTO_SNIPPET_SIZE is the default size of proto to print on parse errors.
 	PROTO_SNIPPET_SIZE = 32
 
@@ -121,6 +149,9 @@ const (
 	// MAX_PUB_ARGS Maximum possible number of arguments from PUB proto.
 	MAX_PUB_ARGS = 3
 
+	// MAX_HPUB_ARGS Maximum possible number of arguments from HPUB proto.
+	MAX_HPUB_ARGS = 4
+
 	// DEFAULT_REMOTE_QSUBS_SWEEPER is how often we sweep for orphans. Deprecated
 	DEFAULT_REMOTE_QSUBS_SWEEPER = 30 * time.Second
 
@@ -136,4 +167,33 @@ const (
 	// DEFAULT_LAME_DUCK_DURATION is the time in which the server spreads
 	// the closing of clients when signaled to go in lame duck mode.
 	DEFAULT_LAME_DUCK_DURATION = 30 * time.Second
+
+	// DEFAULT_SYSTEM_ACCOUNT is the name of the reserved account used for
+	// internal system events when no system account is configured.
+	DEFAULT_SYSTEM_ACCOUNT = "$SYS"
+
+	// DEFAULT_URL_RESOLVER_TIMEOUT is the timeout of the requests of the URL account resolver.
+	DEFAULT_URL_RESOLVER_TIMEOUT = 2 * time.Second
+
+	// DEFAULT_URL_RESOLVER_TTL is the time the URL account resolver caches an account JWT.
+	DEFAULT_URL_RESOLVER_TTL = 2 * time.Minute
+
+	// DEFAULT_URL_RESOLVER_CACHE_SIZE is the number of account JWTs cached by the URL account resolver.
+	DEFAULT_URL_RESOLVER_CACHE_SIZE = 1024
+
+	// DEFAULT_AUTH_CALLOUT_TIMEOUT is how long the authorization service has to respond.
+	// This is below AUTH_TIMEOUT so that the denial can be reported to the client.
+	DEFAULT_AUTH_CALLOUT_TIMEOUT = 750 * time.Millisecond
+
+	// DEFAULT_AUTH_CALLOUT_CACHE_TTL is the time a response of the authorization service is reused.
+	DEFAULT_AUTH_CALLOUT_CACHE_TTL = time.Minute
+
+	// DEFAULT_AUTH_CALLOUT_CACHE_SIZE is the number of responses of the authorization service cached.
+	DEFAULT_AUTH_CALLOUT_CACHE_SIZE = 1024
+
+	// DEFAULT_INFORMER_QUEUE_SIZE is the number of payloads waiting for delivery to an informer.
+	DEFAULT_INFORMER_QUEUE_SIZE = 64
+
+	// DEFAULT_INFORMER_BATCH_WINDOW is how long connect and disconnect events are collected before delivery.
+	DEFAULT_INFORMER_BATCH_WINDOW = 100 * time.Millisecond
 )
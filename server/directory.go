@@ -0,0 +1,90 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+)
+
+// UserDirectory is an external directory of users, such as LDAP.
+type UserDirectory interface {
+	// Authenticate checks the password of the user and returns the
+	// groups the user is a member of.
+	Authenticate(user, password string) ([]string, error)
+}
+
+// DirectoryGroup maps a group of the directory to permissions and an
+// account. The name matches either the full group name, or the value of
+// its first RDN when groups are DNs, e.g. `admins` for
+// `cn=admins,ou=groups,dc=example,dc=com`.
+type DirectoryGroup struct {
+	Name        string
+	Permissions *Permissions
+	Account     *Account
+
+	// Account name from the configuration, resolved once all accounts
+	// are parsed.
+	accName string
+}
+
+// DirectoryAuth authenticates clients with the username and password of
+// the CONNECT against a UserDirectory. The first group of Groups the user
+// is a member of applies, users that are in none of them are rejected.
+type DirectoryAuth struct {
+	Directory UserDirectory
+	Groups    []*DirectoryGroup
+}
+
+// Check implements Authentication.
+func (d *DirectoryAuth) Check(c ClientAuthentication) bool {
+	opts := c.GetOpts()
+	if opts.Username == _EMPTY_ || opts.Password == _EMPTY_ {
+		return false
+	}
+	groups, err := d.Directory.Authenticate(opts.Username, opts.Password)
+	if err != nil {
+		if cl, ok := c.(*client); ok {
+			cl.Debugf("Directory authentication of %q failed: %v", opts.Username, err)
+		}
+		return false
+	}
+	for _, g := range d.Groups {
+		for _, name := range groups {
+			if directoryGroupMatches(g.Name, name) {
+				c.RegisterUser(&User{Username: opts.Username, Permissions: g.Permissions, Account: g.Account})
+				return true
+			}
+		}
+	}
+	if cl, ok := c.(*client); ok {
+		cl.Debugf("User %q is not a member of a configured directory group", opts.Username)
+	}
+	return false
+}
+
+// directoryGroupMatches returns true if the group returned by the directory
+// is the configured one.
+func directoryGroupMatches(configured, group string) bool {
+	if strings.EqualFold(configured, group) {
+		return true
+	}
+	rdn := group
+	if i := strings.IndexByte(rdn, ','); i >= 0 {
+		rdn = rdn[:i]
+	}
+	if i := strings.IndexByte(rdn, '='); i >= 0 {
+		return strings.EqualFold(configured, strings.TrimSpace(rdn[i+1:]))
+	}
+	return false
+}
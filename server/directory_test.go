@@ -0,0 +1,352 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// testLDAPEntry is an entry of the fake directory.
+type testLDAPEntry struct {
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPServer is a fake LDAP server that supports simple binds and the
+// searches done by LDAPDirectory. Responses use the long form of BER
+// lengths, as some directories do.
+type testLDAPServer struct {
+	ln      net.Listener
+	entries map[string]*testLDAPEntry
+	binds   int32
+}
+
+func newTestLDAPServer(t *testing.T, entries map[string]*testLDAPEntry) *testLDAPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	ts := &testLDAPServer{ln: ln, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ts.serve(conn)
+		}
+	}()
+	return ts
+}
+
+func (ts *testLDAPServer) url() string {
+	return "ldap://" + ts.ln.Addr().String()
+}
+
+func (ts *testLDAPServer) close() {
+	ts.ln.Close()
+}
+
+// testBerLong encodes the element with a 4 bytes length.
+func testBerLong(tag byte, content ...[]byte) []byte {
+	var b []byte
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	n := len(b)
+	return append([]byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, b...)
+}
+
+func (ts *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	bound := ""
+	for {
+		_, msg, err := berReadElement(br)
+		if err != nil {
+			return
+		}
+		_, id, rest, _ := berParse(msg)
+		tag, op, _, _ := berParse(rest)
+		reply := func(ops ...[]byte) {
+			for _, op := range ops {
+				conn.Write(testBerLong(berSequence, berEncode(berInteger, id), op))
+			}
+		}
+		result := func(tag byte, code int64) []byte {
+			return testBerLong(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+		}
+		switch tag {
+		case ldapBindRequest:
+			atomic.AddInt32(&ts.binds, 1)
+			_, _, rest, _ := berParse(op)
+			_, dn, rest, _ := berParse(rest)
+			_, pass, _, _ := berParse(rest)
+			if e := ts.entries[string(dn)]; e != nil && e.password == string(pass) {
+				bound = string(dn)
+				reply(result(ldapBindResponse, ldapResultSuccess))
+			} else {
+				reply(result(ldapBindResponse, ldapResultInvalidCredentials))
+			}
+		case ldapSearchRequest:
+			if bound == "" {
+				reply(result(ldapSearchResultDone, 50))
+				continue
+			}
+			_, base, rest, _ := berParse(op)
+			_, scope, rest, _ := berParse(rest)
+			for i := 0; i < 4; i++ {
+				_, _, rest, _ = berParse(rest)
+			}
+			ftag, filter, rest, _ := berParse(rest)
+			_, attrList, _, _ := berParse(rest)
+			var want []string
+			for len(attrList) > 0 {
+				var a []byte
+				_, a, attrList, _ = berParse(attrList)
+				want = append(want, string(a))
+			}
+			var matches []string
+			if berDecodeInt(scope) == ldapScopeBase && ftag == ldapFilterPresent {
+				if ts.entries[string(base)] != nil {
+					matches = append(matches, string(base))
+				}
+			} else if ftag == ldapFilterEqual {
+				_, attr, rest, _ := berParse(filter)
+				_, val, _, _ := berParse(rest)
+				for dn, e := range ts.entries {
+					if !strings.HasSuffix(dn, string(base)) {
+						continue
+					}
+					for _, v := range e.attrs[string(attr)] {
+						if v == string(val) {
+							matches = append(matches, dn)
+						}
+					}
+				}
+			}
+			for _, dn := range matches {
+				var attrs [][]byte
+				for _, a := range want {
+					vals := ts.entries[dn].attrs[a]
+					if len(vals) == 0 {
+						continue
+					}
+					var vs [][]byte
+					for _, v := range vals {
+						vs = append(vs, berString(berOctetString, v))
+					}
+					attrs = append(attrs, testBerLong(berSequence, berString(berOctetString, a), testBerLong(berSet, vs...)))
+				}
+				reply(testBerLong(ldapSearchResultEntry, berString(berOctetString, dn), testBerLong(berSequence, attrs...)))
+			}
+			reply(result(ldapSearchResultDone, ldapResultSuccess))
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func testLDAPEntries() map[string]*testLDAPEntry {
+	return map[string]*testLDAPEntry{
+		"uid=alice,ou=people,dc=example,dc=com": {
+			password: "alicepwd",
+			attrs:    map[string][]string{"memberOf": {"cn=staff,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}},
+		},
+		"uid=bob,ou=people,dc=example,dc=com": {
+			password: "bobpwd",
+			attrs:    map[string][]string{"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"}},
+		},
+		`uid=eve\,x,ou=people,dc=example,dc=com`: {
+			password: "evepwd",
+		},
+		"cn=admins,ou=groups,dc=example,dc=com": {
+			attrs: map[string][]string{"member": {"uid=alice,ou=people,dc=example,dc=com"}},
+		},
+		"cn=staff,ou=groups,dc=example,dc=com": {
+			attrs: map[string][]string{"member": {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"}},
+		},
+	}
+}
+
+func TestLDAPDirectory(t *testing.T) {
+	ts := newTestLDAPServer(t, testLDAPEntries())
+	defer ts.close()
+
+	ld := &LDAPDirectory{URL: ts.url(), UserDN: "uid=%s,ou=people,dc=example,dc=com", Timeout: time.Second}
+	groups, err := ld.Authenticate("alice", "alicepwd")
+	if err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+	expected := []string{"cn=staff,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("Expected groups %q, got %q", expected, groups)
+	}
+	if _, err := ld.Authenticate("alice", "bad"); err != errLDAPInvalidCredentials {
+		t.Fatalf("Expected invalid credentials, got %v", err)
+	}
+	if _, err := ld.Authenticate("mallory", "pwd"); err != errLDAPInvalidCredentials {
+		t.Fatalf("Expected invalid credentials, got %v", err)
+	}
+	// The username is escaped in the DN.
+	if groups, err := ld.Authenticate("eve,x", "evepwd"); err != nil || len(groups) != 0 {
+		t.Fatalf("Expected no groups, got %q, %v", groups, err)
+	}
+	// An empty password would be an unauthenticated bind.
+	binds := atomic.LoadInt32(&ts.binds)
+	if _, err := ld.Authenticate("alice", ""); err == nil {
+		t.Fatalf("Expected empty password to fail")
+	}
+	if atomic.LoadInt32(&ts.binds) != binds {
+		t.Fatalf("Expected no bind with an empty password")
+	}
+
+	// Groups found by searching for the user's DN as member.
+	ld.GroupBaseDN = "ou=groups,dc=example,dc=com"
+	groups, err = ld.Authenticate("bob", "bobpwd")
+	if err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+	if len(groups) != 1 || groups[0] != "cn=staff,ou=groups,dc=example,dc=com" {
+		t.Fatalf("Unexpected groups %q", groups)
+	}
+	groups, _ = ld.Authenticate("alice", "alicepwd")
+	sort.Strings(groups)
+	if !reflect.DeepEqual(groups, []string{"cn=admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"}) {
+		t.Fatalf("Unexpected groups %q", groups)
+	}
+
+	// A server that does not answer is bounded by the timeout.
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer ln.Close()
+	ld = &LDAPDirectory{URL: "ldap://" + ln.Addr().String(), UserDN: "uid=%s", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err := ld.Authenticate("alice", "alicepwd"); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("Expected timeout, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestDirectoryAuth(t *testing.T) {
+	ts := newTestLDAPServer(t, testLDAPEntries())
+	defer ts.close()
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		accounts { OPS {}, STAFF {} }
+		authorization {
+			directory {
+				ldap {
+					url: %q
+					user_dn: "uid=%%s,ou=people,dc=example,dc=com"
+				}
+				groups [
+					{name: admins, account: OPS}
+					{name: "cn=staff,ou=groups,dc=example,dc=com", account: STAFF, permissions: {publish: "staff.>"}}
+				]
+			}
+		}
+	`, ts.url())))
+	defer os.Remove(conf)
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://127.0.0.1:%d", opts.Port)
+	clientAccount := func(name string) string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, c := range s.clients {
+			c.mu.Lock()
+			n, acc := c.opts.Name, c.acc
+			c.mu.Unlock()
+			if n == name && acc != nil {
+				return acc.Name
+			}
+		}
+		return ""
+	}
+
+	// The first configured group of the user applies.
+	nc, err := nats.Connect(url, nats.UserInfo("alice", "alicepwd"), nats.Name("alice"))
+	if err != nil {
+		t.Fatalf("Expected alice to connect, got %v", err)
+	}
+	defer nc.Close()
+	if acc := clientAccount("alice"); acc != "OPS" {
+		t.Fatalf("Expected alice in account OPS, got %q", acc)
+	}
+
+	nc2, err := nats.Connect(url, nats.UserInfo("bob", "bobpwd"), nats.Name("bob"))
+	if err != nil {
+		t.Fatalf("Expected bob to connect, got %v", err)
+	}
+	defer nc2.Close()
+	if acc := clientAccount("bob"); acc != "STAFF" {
+		t.Fatalf("Expected bob in account STAFF, got %q", acc)
+	}
+	errCh := make(chan error, 1)
+	nc2.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) { errCh <- err })
+	nc2.Publish("ops.restart", nil)
+	select {
+	case err := <-errCh:
+		if !strings.Contains(err.Error(), "Permissions Violation") {
+			t.Fatalf("Expected permissions violation, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected permissions violation")
+	}
+
+	for _, test := range []struct{ user, pass string }{
+		{"bob", "wrong"},
+		{"eve,x", "evepwd"}, // in no configured group
+		{"alice", ""},
+	} {
+		if nc, err := nats.Connect(url, nats.UserInfo(test.user, test.pass), nats.MaxReconnects(0)); err == nil {
+			nc.Close()
+			t.Fatalf("Expected %q to be rejected", test.user)
+		}
+	}
+}
+
+func TestDirectoryConfig(t *testing.T) {
+	for _, test := range []struct {
+		conf string
+		err  string
+	}{
+		{`directory { groups [{name: a}] }`, "requires an ldap block"},
+		{`directory { ldap { url: "ldap://h", user_dn: "uid=%s" } }`, "requires groups"},
+		{`directory { ldap { url: "http://h", user_dn: "uid=%s" }, groups [{name: a}] }`, "must be an ldap:// or ldaps:// url"},
+		{`directory { ldap { url: "ldap://h", user_dn: "uid=x" }, groups [{name: a}] }`, "must contain %s once"},
+		{`directory { ldap { url: "ldap://h", user_dn: "uid=%s" }, groups [{account: A}] }`, "requires a name"},
+		{`directory { ldap { url: "ldap://h", user_dn: "uid=%s" }, groups [{name: a, account: B}] }`, `unknown account "B"`},
+		{`directory { ldap { url: "ldap://h", user_dn: "uid=%s" }, groups [{name: a}] }, users [{user: u, password: p}]`, "Can not have a directory and a users array"},
+	} {
+		conf := createConfFile(t, []byte(`accounts { A {} }, authorization { `+test.conf+` }`))
+		_, err := ProcessConfigFile(conf)
+		os.Remove(conf)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expected error %q, got %v", test.err, err)
+		}
+	}
+}
@@ -34,6 +34,22 @@ var (
 	// ErrMaxPayload represents an error condition when the payload is too big.
 	ErrMaxPayload = errors.New("Maximum Payload Exceeded")
 
+	// ErrHeadersNotSupported represents an error condition when a client sends
+	// HPUB without having enabled headers in its CONNECT.
+	ErrHeadersNotSupported = errors.New("Headers Not Supported")
+
+	// ErrWebsocketProtocol represents an error condition when a websocket
+	// client sends frames that do not follow RFC 6455.
+	ErrWebsocketProtocol = errors.New("Websocket Protocol Violation")
+
+	// ErrMQTTProtocol represents an error condition when an MQTT client
+	// sends packets that do not follow the MQTT 3.1.1 specification.
+	ErrMQTTProtocol = errors.New("MQTT Protocol Violation")
+
+	// ErrMQTTInvalidTopic represents an error condition when an MQTT topic
+	// can not be mapped to a NATS subject.
+	ErrMQTTInvalidTopic = errors.New("MQTT Topic Can Not Be Mapped To A Subject")
+
 	// ErrMaxControlLine represents an error condition when the control line is too big.
 	ErrMaxControlLine = errors.New("Maximum Control Line Exceeded")
 
@@ -59,6 +75,27 @@ var (
 	// attempted to connect to the route listen port.
 	ErrClientConnectedToRoutePort = errors.New("Attempted To Connect To Route Port")
 
+	// ErrClientConnectedToLeafNodePort represents an error condition when a client
+	// attempted to connect to the leaf node listen port.
+	ErrClientConnectedToLeafNodePort = errors.New("Attempted To Connect To Leaf Node Port")
+
+	// ErrClientConnectedToGatewayPort represents an error condition when a client
+	// attempted to connect to the gateway listen port.
+	ErrClientConnectedToGatewayPort = errors.New("Attempted To Connect To Gateway Port")
+
+	// ErrWrongGateway represents an error condition when a server receives a connect
+	// request from a remote Gateway with a destination name that does not match the server's
+	// Gateway's name.
+	ErrWrongGateway = errors.New("Wrong Gateway")
+
+	// ErrMissingGatewayName represents an error condition when a gateway
+	// connection does not carry the name of the remote gateway.
+	ErrMissingGatewayName = errors.New("Missing Gateway Name")
+
+	// ErrNoSysAccount is returned when an attempt to publish or subscribe is made
+	// when there is no internal system account defined.
+	ErrNoSysAccount = errors.New("System Account Not Setup")
+
 	// ErrAccountExists is returned when an account is attempted to be registered
 	// but already exists.
 	ErrAccountExists = errors.New("Account Exists")
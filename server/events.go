@@ -0,0 +1,600 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/jwt"
+)
+
+const (
+	connectEventSubj    = "$SYS.ACCOUNT.%s.CONNECT"
+	disconnectEventSubj = "$SYS.ACCOUNT.%s.DISCONNECT"
+	serverDirectReqSubj = "$SYS.REQ.SERVER.%s.%s"
+	serverPingReqSubj   = "$SYS.REQ.SERVER.PING"
+	serverStatsSubj     = "$SYS.SERVER.%s.STATSZ"
+	accUpdateEventSubj  = "$SYS.ACCOUNT.%s.CLAIMS.UPDATE"
+
+	// Position of the account public key in the claims update subject.
+	accUpdateAccIndex = 2
+	accUpdateTokens   = 5
+
+	// Size of the queue of events waiting to be published.
+	internalSendQLen = 4096
+
+	// Number of missed heartbeats before a remote server is considered lost.
+	statszLostHBs = 3
+
+	// Number of missed heartbeats before a remote server is forgotten.
+	statszForgetHBs = 30
+)
+
+// Interval between the statsz heartbeats. This is a var so that
+// tests can lower it.
+var statszHBInterval = 10 * time.Second
+
+// internal holds the system account and the internal
+// client used to publish events into it.
+type internal struct {
+	account *Account
+	client  *client
+	seq     uint64
+	sid     uint64
+	sendq   chan *pubMsg
+	servers map[string]*serverUpdate
+}
+
+// serverUpdate is the last heartbeat received from a remote server.
+type serverUpdate struct {
+	host  string
+	seq   uint64
+	ltime time.Time
+}
+
+// pubMsg is an event waiting to be published. The message is
+// marshaled to JSON, unless already encoded.
+type pubMsg struct {
+	subj string
+	msg  interface{}
+}
+
+// msgHandler is the callback of an internal subscription.
+type msgHandler func(sub *subscription, subject, reply string, msg []byte)
+
+// ServerInfo identifies the server that sent an event.
+type ServerInfo struct {
+	Host string `json:"host"`
+	ID   string `json:"id"`
+	Seq  uint64 `json:"seq"`
+}
+
+// ConnectEventMsg is sent when a new client connection has been accepted.
+type ConnectEventMsg struct {
+	Server  ServerInfo `json:"server"`
+	Account string     `json:"acc"`
+	Client  ConnInfo   `json:"client"`
+}
+
+// DisconnectEventMsg is sent when a client connection is closed.
+// The reason is set in the client information.
+type DisconnectEventMsg struct {
+	Server  ServerInfo `json:"server"`
+	Account string     `json:"acc"`
+	Client  ConnInfo   `json:"client"`
+}
+
+// ServerStatsMsg is sent periodically with the statistics of the server.
+type ServerStatsMsg struct {
+	Server ServerInfo  `json:"server"`
+	Stats  ServerStats `json:"statsz"`
+}
+
+// ServerStats holds the statistics of the server sent in heartbeats.
+type ServerStats struct {
+	Start            time.Time    `json:"start"`
+	Mem              int64        `json:"mem"`
+	Cores            int          `json:"cores"`
+	CPU              float64      `json:"cpu"`
+	Connections      int          `json:"connections"`
+	TotalConnections uint64       `json:"total_connections"`
+	NumSubs          uint32       `json:"subscriptions"`
+	Sent             DataStats    `json:"sent"`
+	Received         DataStats    `json:"received"`
+	SlowConsumers    int64        `json:"slow_consumers"`
+	Routes           []*RouteStat `json:"routes,omitempty"`
+}
+
+// RouteStat holds the statistics of a route.
+type RouteStat struct {
+	ID       uint64    `json:"rid"`
+	RemoteID string    `json:"remote_id"`
+	Sent     DataStats `json:"sent"`
+	Received DataStats `json:"received"`
+	Pending  int       `json:"pending"`
+}
+
+// DataStats holds message and byte counts.
+type DataStats struct {
+	Msgs  int64 `json:"msgs"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ServerAPIResponse is the response to a monitoring request.
+type ServerAPIResponse struct {
+	Server ServerInfo  `json:"server"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// configureSystemAccount will setup the system account and the internal
+// client used to publish events. If no system account is configured, the
+// reserved system account is used.
+// Lock should be held.
+func (s *Server) configureSystemAccount() {
+	name := s.opts.SystemAccount
+	if name == "" {
+		name = DEFAULT_SYSTEM_ACCOUNT
+	}
+	acc := s.accounts[name]
+	if acc == nil {
+		acc = &Account{Name: name}
+		s.registerAccount(acc)
+	}
+
+	c := &client{srv: s, typ: SYSTEM, acc: acc}
+	c.initClient()
+
+	s.sys = &internal{
+		account: acc,
+		client:  c,
+		sendq:   make(chan *pubMsg, internalSendQLen),
+		servers: make(map[string]*serverUpdate),
+	}
+}
+
+// isReservedSysAccount returns true if the account is the reserved system
+// account, which only has the internal subscriptions of the servers.
+func (s *Server) isReservedSysAccount(acc *Account) bool {
+	return s.sys != nil && acc == s.sys.account && s.getOpts().SystemAccount == _EMPTY_
+}
+
+// SystemAccount returns the account used for internal system events.
+func (s *Server) SystemAccount() *Account {
+	if s.sys == nil {
+		return nil
+	}
+	return s.sys.account
+}
+
+// internalSendLoop publishes the queued events into the system account.
+// This is the only go routine using the internal client.
+func (s *Server) internalSendLoop() {
+	defer s.grWG.Done()
+
+	c := s.sys.client
+	for {
+		select {
+		case pm := <-s.sys.sendq:
+			b, ok := pm.msg.([]byte)
+			if !ok {
+				var err error
+				if b, err = json.Marshal(pm.msg); err != nil {
+					s.Errorf("Error marshaling event for %q: %v", pm.subj, err)
+					continue
+				}
+			}
+			c.pa.subject = []byte(pm.subj)
+			c.pa.reply = nil
+			c.pa.size = len(b)
+			c.pa.szb = []byte(strconv.FormatInt(int64(len(b)), 10))
+			// Add in the CR_LF, the message is processed like a client's.
+			b = append(b, _CRLF_...)
+			c.processInboundClientMsg(b)
+			c.flushClients()
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// flushClients signals the flush of the clients that the
+// internal client delivered messages to.
+func (c *client) flushClients() {
+	last := time.Now()
+	for cp := range c.pcd {
+		cp.mu.Lock()
+		cp.last = last
+		cp.out.fsp--
+		cp.flushSignal()
+		cp.mu.Unlock()
+		delete(c.pcd, cp)
+	}
+}
+
+// sendInternalMsg queues the event to be published on the given subject.
+// This never blocks, the event is dropped if the queue is full.
+func (s *Server) sendInternalMsg(subj string, msg interface{}) {
+	if s.sys == nil {
+		return
+	}
+	select {
+	case s.sys.sendq <- &pubMsg{subj, msg}:
+	default:
+		s.Warnf("Internal send queue is full, dropping event for %q", subj)
+	}
+}
+
+// eventServerInfo returns the information about this server
+// to be included in events.
+func (s *Server) eventServerInfo() ServerInfo {
+	return ServerInfo{
+		Host: s.info.Host,
+		ID:   s.info.ID,
+		Seq:  atomic.AddUint64(&s.sys.seq, 1),
+	}
+}
+
+// accountConnectEvent will send a connect event to the system account.
+func (s *Server) accountConnectEvent(c *client) {
+	if s.sys == nil {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	if c.acc == nil {
+		c.mu.Unlock()
+		return
+	}
+	m := &ConnectEventMsg{Account: c.acc.Name}
+	// The client is still connecting, so do not send a PING for the RTT.
+	m.Client.fillNoRTT(c, c.nc, now)
+	m.Client.AuthorizedUser = c.opts.Username
+	c.mu.Unlock()
+
+	m.Server = s.eventServerInfo()
+	s.sendInternalMsg(fmt.Sprintf(connectEventSubj, m.Account), m)
+}
+
+// accountDisconnectEvent will send a disconnect event to the system account.
+// The client connection has been closed already, so nc is the connection
+// that was used by the client.
+func (s *Server) accountDisconnectEvent(c *client, nc net.Conn, reason ClosedState) {
+	if s.sys == nil {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	if c.acc == nil {
+		c.mu.Unlock()
+		return
+	}
+	m := &DisconnectEventMsg{Account: c.acc.Name}
+	m.Client.fill(c, nc, now)
+	m.Client.AuthorizedUser = c.opts.Username
+	m.Client.Stop = &now
+	m.Client.Reason = reason.String()
+	c.mu.Unlock()
+
+	m.Server = s.eventServerInfo()
+	s.sendInternalMsg(fmt.Sprintf(disconnectEventSubj, m.Account), m)
+}
+
+// initEventTracking will setup the internal subscriptions of
+// the system account. The heartbeats are exchanged over the routes even
+// for the reserved system account, but the monitoring requests are not
+// setup for it since no user can connect to it.
+func (s *Server) initEventTracking() {
+	if s.sys == nil {
+		return
+	}
+	// Listen for the heartbeats of the other servers.
+	if _, err := s.sysSubscribe(fmt.Sprintf(serverStatsSubj, "*"), s.remoteServerUpdate); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	s.startGoRoutine(s.statszLoop)
+
+	if s.getOpts().SystemAccount == "" {
+		return
+	}
+	monSrvc := map[string]msgHandler{
+		"VARZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &VarzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Varz(optz) })
+		},
+		"CONNZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &ConnzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Connz(optz) })
+		},
+		"ROUTEZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &RoutezOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Routez(optz) })
+		},
+		"SUBSZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &SubszOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Subsz(optz) })
+		},
+		"ACCOUNTZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &AccountzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Accountz(optz) })
+		},
+	}
+	for name, req := range monSrvc {
+		// Requests for this server only.
+		subject := fmt.Sprintf(serverDirectReqSubj, s.info.ID, name)
+		if _, err := s.sysSubscribe(subject, req); err != nil {
+			s.Errorf("Error setting up internal tracking: %v", err)
+		}
+		// Requests that all servers will answer.
+		subject = serverPingReqSubj + "." + name
+		if _, err := s.sysSubscribe(subject, req); err != nil {
+			s.Errorf("Error setting up internal tracking: %v", err)
+		}
+	}
+	if _, err := s.sysSubscribe(serverPingReqSubj, s.pingReq); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	// Listen for account claims pushed to the servers.
+	if _, err := s.sysSubscribe(fmt.Sprintf(accUpdateEventSubj, "*"), s.accountClaimUpdate); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+}
+
+// statszLoop publishes the statsz heartbeats of this server and
+// forgets about the remote servers that stopped sending theirs.
+func (s *Server) statszLoop() {
+	defer s.grWG.Done()
+
+	interval := statszHBInterval
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	// Let the other servers know about us right away.
+	s.sendStatsz()
+	for {
+		select {
+		case <-t.C:
+			s.sendStatsz()
+			s.sweepRemoteServers(interval)
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// sendStatsz will publish the statistics of this server.
+func (s *Server) sendStatsz() {
+	m := &ServerStatsMsg{}
+	v := &Varz{}
+	updateUsage(v)
+	m.Stats.Mem = v.Mem
+	m.Stats.CPU = v.CPU
+	m.Stats.Cores = v.Cores
+	m.Stats.NumSubs = s.NumSubscriptions()
+
+	s.mu.Lock()
+	m.Stats.Start = s.start
+	m.Stats.Connections = len(s.clients)
+	m.Stats.TotalConnections = s.totalClients
+	m.Stats.Received.Msgs = atomic.LoadInt64(&s.inMsgs)
+	m.Stats.Received.Bytes = atomic.LoadInt64(&s.inBytes)
+	m.Stats.Sent.Msgs = atomic.LoadInt64(&s.outMsgs)
+	m.Stats.Sent.Bytes = atomic.LoadInt64(&s.outBytes)
+	m.Stats.SlowConsumers = atomic.LoadInt64(&s.slowConsumers)
+	for _, r := range s.routes {
+		r.mu.Lock()
+		rs := &RouteStat{
+			ID:       r.cid,
+			RemoteID: r.route.remoteID,
+			Sent:     DataStats{Msgs: r.outMsgs, Bytes: r.outBytes},
+			Received: DataStats{Msgs: atomic.LoadInt64(&r.inMsgs), Bytes: atomic.LoadInt64(&r.inBytes)},
+			Pending:  int(r.out.pb),
+		}
+		r.mu.Unlock()
+		m.Stats.Routes = append(m.Stats.Routes, rs)
+	}
+	s.mu.Unlock()
+
+	m.Server = s.eventServerInfo()
+	s.sendInternalMsg(fmt.Sprintf(serverStatsSubj, m.Server.ID), m)
+}
+
+// remoteServerUpdate records the statsz heartbeat of a remote server.
+func (s *Server) remoteServerUpdate(sub *subscription, subject, reply string, msg []byte) {
+	m := &ServerStatsMsg{}
+	if err := json.Unmarshal(msg, m); err != nil {
+		s.Debugf("Error unmarshaling statsz on %q: %v", subject, err)
+		return
+	}
+	si := m.Server
+	if si.ID == "" || si.ID == s.info.ID {
+		return
+	}
+	s.mu.Lock()
+	su := s.sys.servers[si.ID]
+	if su == nil {
+		su = &serverUpdate{}
+		s.sys.servers[si.ID] = su
+	}
+	su.host, su.seq, su.ltime = si.Host, si.Seq, time.Now()
+	s.mu.Unlock()
+}
+
+// accountClaimUpdate applies the account JWT published on the claims update
+// subject to the account, if registered. The JWT has to be for the account
+// of the subject, signed by one of our trusted operators and issued after
+// the current claims of the account.
+func (s *Server) accountClaimUpdate(sub *subscription, subject, reply string, msg []byte) {
+	err := s.updateAccountFromPush(subject, string(msg))
+	if err != nil {
+		s.Debugf("Error updating account from %q: %v", subject, err)
+	}
+	if reply == "" {
+		return
+	}
+	resp := &ServerAPIResponse{Server: s.eventServerInfo()}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	s.sendInternalMsg(reply, resp)
+}
+
+// updateAccountFromPush verifies and applies a pushed account JWT.
+func (s *Server) updateAccountFromPush(subject, claimJWT string) error {
+	tokens := strings.Split(subject, tsep)
+	if len(tokens) != accUpdateTokens {
+		return fmt.Errorf("invalid subject")
+	}
+	pub := tokens[accUpdateAccIndex]
+	accClaims, err := s.verifyAccountClaims(claimJWT)
+	if err != nil {
+		return err
+	}
+	if accClaims.Subject != pub {
+		return fmt.Errorf("claims are for account [%s]", accClaims.Subject)
+	}
+	if !s.isTrustedIssuer(accClaims.Issuer) {
+		return ErrAccountValidation
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc := s.accounts[pub]
+	if acc == nil {
+		// Nothing to do, the account will be fetched when first needed.
+		return nil
+	}
+	if acc.claimJWT == claimJWT {
+		return nil
+	}
+	// Do not let a replayed or out of order push roll back the claims.
+	if acc.claimJWT != "" {
+		if cur, err := jwt.DecodeAccountClaims(acc.claimJWT); err == nil && accClaims.IssuedAt <= cur.IssuedAt {
+			return fmt.Errorf("claims are not newer than the current ones")
+		}
+	}
+	if err := s.updateAccountWithClaimJWT(acc, claimJWT); err != nil {
+		return err
+	}
+	acc.updated = time.Now()
+	s.Noticef("Updated account [%s] from pushed claims", pub)
+	return nil
+}
+
+// sweepRemoteServers forgets about the remote servers that we have
+// not heard from in a long time.
+func (s *Server) sweepRemoteServers(interval time.Duration) {
+	now := time.Now()
+	s.mu.Lock()
+	for id, su := range s.sys.servers {
+		if now.Sub(su.ltime) > statszForgetHBs*interval {
+			delete(s.sys.servers, id)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// remoteServers returns the liveness information of the remote servers
+// sending statsz heartbeats, sorted by server id.
+// Lock should be held.
+func (s *Server) remoteServers() []*RemoteServerInfo {
+	if s.sys == nil || len(s.sys.servers) == 0 {
+		return nil
+	}
+	now := time.Now()
+	rsi := make([]*RemoteServerInfo, 0, len(s.sys.servers))
+	for id, su := range s.sys.servers {
+		rsi = append(rsi, &RemoteServerInfo{
+			ID:       id,
+			Host:     su.host,
+			Seq:      su.seq,
+			LastSeen: su.ltime,
+			Alive:    now.Sub(su.ltime) < statszLostHBs*statszHBInterval,
+		})
+	}
+	sort.Slice(rsi, func(i, j int) bool { return rsi[i].ID < rsi[j].ID })
+	return rsi
+}
+
+// pingReq answers a PING request with the information about this server.
+func (s *Server) pingReq(sub *subscription, subject, reply string, msg []byte) {
+	if reply == "" {
+		return
+	}
+	s.sendInternalMsg(reply, &ServerAPIResponse{Server: s.eventServerInfo()})
+}
+
+// zReq handles a monitoring request. The request, if not empty, is
+// decoded into optz, the options of the monitoring endpoint.
+func (s *Server) zReq(reply string, msg []byte, optz interface{}, respf func() (interface{}, error)) {
+	if reply == "" {
+		return
+	}
+	resp := &ServerAPIResponse{}
+	var err error
+	if len(msg) != 0 {
+		if err = json.Unmarshal(msg, optz); err != nil {
+			resp.Error = fmt.Sprintf("Error decoding request: %v", err)
+		}
+	}
+	if err == nil {
+		if resp.Data, err = respf(); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	resp.Server = s.eventServerInfo()
+	// Encode now since the response may reference live server state.
+	b, err := json.Marshal(resp)
+	if err != nil {
+		s.Errorf("Error marshaling response to %q: %v", reply, err)
+		return
+	}
+	s.sendInternalMsg(reply, b)
+}
+
+// sysSubscribe will create an internal subscription in the system account.
+// Messages are delivered to the callback, in the go routine of the publisher.
+func (s *Server) sysSubscribe(subject string, cb msgHandler) (*subscription, error) {
+	if s.sys == nil {
+		return nil, ErrNoSysAccount
+	}
+	c := s.sys.client
+	acc := s.sys.account
+
+	c.mu.Lock()
+	s.sys.sid++
+	sid := strconv.FormatUint(s.sys.sid, 10)
+	sub := &subscription{client: c, subject: []byte(subject), sid: []byte(sid), icb: cb}
+	c.subs[sid] = sub
+	c.mu.Unlock()
+
+	if err := acc.sl.Insert(sub); err != nil {
+		c.mu.Lock()
+		delete(c.subs, sid)
+		c.mu.Unlock()
+		return nil, err
+	}
+	// Let the rest of the cluster know about our interest.
+	s.updateRouteSubscriptionMap(acc, sub, 1)
+	return sub, nil
+}
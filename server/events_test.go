@@ -0,0 +1,552 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
+)
+
+func testSystemAccountOptions(t *testing.T) *Options {
+	t.Helper()
+	conf := createConfFile(t, []byte(`
+    listen: "127.0.0.1:-1"
+    system_account: SYS
+    accounts {
+      SYS {
+        users = [{user: sys, password: pass}]
+      }
+      FOO {
+        users = [{user: foo, password: pass}]
+      }
+    }
+    `))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Received an error processing config file: %v", err)
+	}
+	opts.NoLog, opts.NoSigs = true, true
+	return opts
+}
+
+func runSystemAccountServer(t *testing.T) (*Server, *Options) {
+	t.Helper()
+	opts := testSystemAccountOptions(t)
+	return RunServer(opts), opts
+}
+
+func sysConnect(t *testing.T, opts *Options) *nats.Conn {
+	t.Helper()
+	url := fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port)
+	nc, err := nats.Connect(url, nats.UserInfo("sys", "pass"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	return nc
+}
+
+func sysRequest(t *testing.T, nc *nats.Conn, subj string, body []byte, data interface{}) *ServerAPIResponse {
+	t.Helper()
+	msg, err := nc.Request(subj, body, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request to %q: %v", subj, err)
+	}
+	resp := &ServerAPIResponse{Data: data}
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		t.Fatalf("Error unmarshaling response: %v", err)
+	}
+	if resp.Error != "" {
+		t.Fatalf("Unexpected error in response: %s", resp.Error)
+	}
+	return resp
+}
+
+func TestSystemAccountDefault(t *testing.T) {
+	s := RunServer(DefaultOptions())
+	defer s.Shutdown()
+
+	sacc := s.SystemAccount()
+	if sacc == nil || sacc.Name != DEFAULT_SYSTEM_ACCOUNT {
+		t.Fatalf("Expected the reserved system account, got %+v", sacc)
+	}
+	if s.LookupAccount(DEFAULT_SYSTEM_ACCOUNT) != sacc {
+		t.Fatalf("Expected the system account to be registered")
+	}
+}
+
+func TestSystemAccountConfigured(t *testing.T) {
+	s, _ := runSystemAccountServer(t)
+	defer s.Shutdown()
+
+	if sacc := s.SystemAccount(); sacc == nil || sacc.Name != "SYS" {
+		t.Fatalf("Expected the SYS system account, got %+v", sacc)
+	}
+	if s.LookupAccount(DEFAULT_SYSTEM_ACCOUNT) != nil {
+		t.Fatalf("Did not expect the reserved system account to be registered")
+	}
+}
+
+func TestSystemAccountConnectDisconnectEvents(t *testing.T) {
+	s, opts := runSystemAccountServer(t)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port)
+	ncs, err := nats.Connect(url, nats.UserInfo("sys", "pass"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncs.Close()
+
+	csub, _ := ncs.SubscribeSync(fmt.Sprintf(connectEventSubj, "*"))
+	dsub, _ := ncs.SubscribeSync(fmt.Sprintf(disconnectEventSubj, "*"))
+	ncs.Flush()
+
+	nc, err := nats.Connect(url, nats.UserInfo("foo", "pass"), nats.Name("events"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+
+	msg, err := csub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive the connect event: %v", err)
+	}
+	if msg.Subject != fmt.Sprintf(connectEventSubj, "FOO") {
+		t.Fatalf("Unexpected subject: %q", msg.Subject)
+	}
+	cm := ConnectEventMsg{}
+	if err := json.Unmarshal(msg.Data, &cm); err != nil {
+		t.Fatalf("Error unmarshaling connect event: %v", err)
+	}
+	if cm.Server.ID != s.ID() || cm.Account != "FOO" {
+		t.Fatalf("Unexpected connect event: %+v", cm)
+	}
+	if cm.Client.Name != "events" || cm.Client.AuthorizedUser != "foo" || cm.Client.Cid == 0 {
+		t.Fatalf("Unexpected client information: %+v", cm.Client)
+	}
+
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+	nc.Close()
+
+	msg, err = dsub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive the disconnect event: %v", err)
+	}
+	if msg.Subject != fmt.Sprintf(disconnectEventSubj, "FOO") {
+		t.Fatalf("Unexpected subject: %q", msg.Subject)
+	}
+	dm := DisconnectEventMsg{}
+	if err := json.Unmarshal(msg.Data, &dm); err != nil {
+		t.Fatalf("Error unmarshaling disconnect event: %v", err)
+	}
+	if dm.Account != "FOO" || dm.Client.Cid != cm.Client.Cid {
+		t.Fatalf("Unexpected disconnect event: %+v", dm)
+	}
+	if dm.Client.Reason != ClientClosed.String() || dm.Client.Stop == nil {
+		t.Fatalf("Expected reason %q, got %q", ClientClosed, dm.Client.Reason)
+	}
+	if dm.Client.InMsgs != 1 {
+		t.Fatalf("Expected 1 inbound message, got %d", dm.Client.InMsgs)
+	}
+	if dm.Server.Seq <= cm.Server.Seq {
+		t.Fatalf("Expected the sequence to increase, got %d and %d", cm.Server.Seq, dm.Server.Seq)
+	}
+}
+
+func TestSystemAccountEventsNotVisibleToOtherAccounts(t *testing.T) {
+	s, opts := runSystemAccountServer(t)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port)
+	nc, err := nats.Connect(url, nats.UserInfo("foo", "pass"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync("$SYS.>")
+	nc.Flush()
+
+	nc2, err := nats.Connect(url, nats.UserInfo("foo", "pass"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	nc2.Close()
+
+	if _, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Did not expect to receive system events in a regular account")
+	}
+}
+
+func TestSystemAccountServerRequests(t *testing.T) {
+	s, opts := runSystemAccountServer(t)
+	defer s.Shutdown()
+
+	nc := sysConnect(t, opts)
+	defer nc.Close()
+
+	varz := &Varz{}
+	resp := sysRequest(t, nc, fmt.Sprintf(serverDirectReqSubj, s.ID(), "VARZ"), nil, varz)
+	if resp.Server.ID != s.ID() {
+		t.Fatalf("Expected the response from %q, got %q", s.ID(), resp.Server.ID)
+	}
+	if varz.ID != s.ID() || varz.Port != opts.Port {
+		t.Fatalf("Unexpected varz: %+v", varz)
+	}
+
+	connz := &Connz{}
+	body := []byte(`{"subscriptions": true}`)
+	sysRequest(t, nc, fmt.Sprintf(serverDirectReqSubj, s.ID(), "CONNZ"), body, connz)
+	if connz.NumConns != 1 || len(connz.Conns) != 1 {
+		t.Fatalf("Expected 1 connection, got %+v", connz)
+	}
+	if connz.Conns[0].Subs == nil {
+		t.Fatalf("Expected the subscriptions to be included")
+	}
+
+	subsz := &Subsz{}
+	sysRequest(t, nc, fmt.Sprintf(serverDirectReqSubj, s.ID(), "SUBSZ"), []byte(`{"limit": 5}`), subsz)
+	if subsz.Limit != 5 {
+		t.Fatalf("Expected the limit of the request to be used, got %d", subsz.Limit)
+	}
+
+	routez := &Routez{}
+	sysRequest(t, nc, fmt.Sprintf(serverDirectReqSubj, s.ID(), "ROUTEZ"), nil, routez)
+	if routez.ID != s.ID() || routez.NumRoutes != 0 {
+		t.Fatalf("Unexpected routez: %+v", routez)
+	}
+
+	// A bad request is reported in the response.
+	msg, err := nc.Request(fmt.Sprintf(serverDirectReqSubj, s.ID(), "CONNZ"), []byte("{"), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	resp = &ServerAPIResponse{}
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		t.Fatalf("Error unmarshaling response: %v", err)
+	}
+	if resp.Error == "" || resp.Data != nil {
+		t.Fatalf("Expected an error in the response, got %+v", resp)
+	}
+}
+
+func runSystemAccountCluster(t *testing.T) (*Server, *Options, *Server, *Options) {
+	t.Helper()
+	optsA := testSystemAccountOptions(t)
+	optsA.Cluster.Host = "127.0.0.1"
+	optsA.Cluster.Port = -1
+	sa := RunServer(optsA)
+
+	optsB := testSystemAccountOptions(t)
+	optsB.Cluster.Host = "127.0.0.1"
+	optsB.Cluster.Port = -1
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", sa.ClusterAddr().Port))
+	sb := RunServer(optsB)
+
+	checkClusterFormed(t, sa, sb)
+	return sa, optsA, sb, optsB
+}
+
+func TestSystemAccountPingRequest(t *testing.T) {
+	sa, optsA, sb, _ := runSystemAccountCluster(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nc := sysConnect(t, optsA)
+	defer nc.Close()
+
+	inbox := nats.NewInbox()
+	sub, _ := nc.SubscribeSync(inbox)
+	nc.Flush()
+
+	expected := map[string]bool{sa.ID(): true, sb.ID(): true}
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		// The interest of the remote server may not be propagated yet.
+		nc.PublishRequest(serverPingReqSubj, inbox, nil)
+		got := map[string]bool{}
+		for {
+			msg, err := sub.NextMsg(100 * time.Millisecond)
+			if err != nil {
+				break
+			}
+			resp := &ServerAPIResponse{}
+			if err := json.Unmarshal(msg.Data, resp); err != nil {
+				t.Fatalf("Error unmarshaling response: %v", err)
+			}
+			got[resp.Server.ID] = true
+		}
+		for id := range expected {
+			if !got[id] {
+				return fmt.Errorf("No response from server %q", id)
+			}
+		}
+		return nil
+	})
+}
+
+func TestSystemAccountStatszHeartbeat(t *testing.T) {
+	oldInterval := statszHBInterval
+	statszHBInterval = 50 * time.Millisecond
+	defer func() { statszHBInterval = oldInterval }()
+
+	sa, optsA, sb, _ := runSystemAccountCluster(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nc := sysConnect(t, optsA)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(fmt.Sprintf(serverStatsSubj, sb.ID()))
+	nc.Flush()
+
+	// Wait for a heartbeat sent once the route is up.
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			return err
+		}
+		m := &ServerStatsMsg{}
+		if err := json.Unmarshal(msg.Data, m); err != nil {
+			t.Fatalf("Error unmarshaling statsz: %v", err)
+		}
+		if m.Server.ID != sb.ID() {
+			t.Fatalf("Expected statsz from %q, got %q", sb.ID(), m.Server.ID)
+		}
+		if m.Stats.Mem == 0 || m.Stats.Start.IsZero() {
+			t.Fatalf("Expected usage to be set, got %+v", m.Stats)
+		}
+		if len(m.Stats.Routes) != 1 || m.Stats.Routes[0].RemoteID != sa.ID() {
+			return fmt.Errorf("Expected 1 route to %q, got %+v", sa.ID(), m.Stats.Routes)
+		}
+		return nil
+	})
+
+	checkRemoteServer := func(alive bool) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+			rz, _ := sa.Routez(nil)
+			if len(rz.Servers) != 1 || rz.Servers[0].ID != sb.ID() {
+				return fmt.Errorf("Expected remote server %q, got %+v", sb.ID(), rz.Servers)
+			}
+			if rs := rz.Servers[0]; rs.Alive != alive {
+				return fmt.Errorf("Expected remote server alive to be %v, got %+v", alive, rs)
+			}
+			return nil
+		})
+	}
+	checkRemoteServer(true)
+
+	// Once the remote server is gone, it should be reported lost.
+	sb.Shutdown()
+	checkRemoteServer(false)
+}
+
+func TestSystemAccountDefaultStatszHeartbeat(t *testing.T) {
+	oldInterval := statszHBInterval
+	statszHBInterval = 50 * time.Millisecond
+	defer func() { statszHBInterval = oldInterval }()
+
+	// No system account configured, the reserved one is used.
+	optsA := DefaultOptions()
+	optsA.Cluster.Host = "127.0.0.1"
+	optsA.Cluster.Port = -1
+	sa := RunServer(optsA)
+	defer sa.Shutdown()
+
+	optsB := DefaultOptions()
+	optsB.Cluster.Host = "127.0.0.1"
+	optsB.Cluster.Port = -1
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", sa.ClusterAddr().Port))
+	sb := RunServer(optsB)
+	defer sb.Shutdown()
+	checkClusterFormed(t, sa, sb)
+
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		rz, _ := sa.Routez(nil)
+		if len(rz.Servers) != 1 || rz.Servers[0].ID != sb.ID() || !rz.Servers[0].Alive {
+			return fmt.Errorf("Expected remote server %q to be alive, got %+v", sb.ID(), rz.Servers)
+		}
+		return nil
+	})
+}
+
+func TestSystemAccountClaimsUpdate(t *testing.T) {
+	sa, optsA, sb, _ := runSystemAccountCluster(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	// Connect first since trusted keys would require user JWTs.
+	nc := sysConnect(t, optsA)
+	defer nc.Close()
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+	fooKP, _ := nkeys.CreateAccount()
+	fooPub, _ := fooKP.PublicKey()
+	// Pushed claims have to be issued after the current ones and
+	// the issue time has a resolution of a second.
+	var issuedAt int64
+	accJWT := func(kp nkeys.KeyPair, subs int64) string {
+		t.Helper()
+		for time.Now().Unix() <= issuedAt {
+			time.Sleep(10 * time.Millisecond)
+		}
+		ac := jwt.NewAccountClaims(string(fooPub))
+		ac.Limits.Subs = subs
+		ajwt, err := ac.Encode(kp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		issuedAt = ac.IssuedAt
+		return ajwt
+	}
+	oldJWT := accJWT(okp, 10)
+	for _, s := range []*Server{sa, sb} {
+		s.mu.Lock()
+		s.trustedNkeys = []string{string(opub)}
+		s.accResolver = &MemAccResolver{}
+		s.mu.Unlock()
+		addAccountToMemResolver(s, string(fooPub), oldJWT)
+		if s.LookupAccount(string(fooPub)) == nil {
+			t.Fatalf("Expected the account to be registered")
+		}
+	}
+	// Make sure server B's interest has been propagated to server A.
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		if sa.SystemAccount().sl.Match(fmt.Sprintf(accUpdateEventSubj, fooPub)).psubs == nil {
+			return fmt.Errorf("No interest yet")
+		}
+		return nil
+	})
+	checkMaxSubs := func(expected int) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+			for _, s := range []*Server{sa, sb} {
+				acc := s.LookupAccount(string(fooPub))
+				acc.mu.RLock()
+				msubs := acc.msubs
+				acc.mu.RUnlock()
+				if msubs != expected {
+					return fmt.Errorf("Expected account to have msubs of %d, got %d", expected, msubs)
+				}
+			}
+			return nil
+		})
+	}
+	checkMaxSubs(10)
+
+	subj := fmt.Sprintf(accUpdateEventSubj, fooPub)
+	sysRequest(t, nc, subj, []byte(accJWT(okp, 20)), nil)
+	checkMaxSubs(20)
+
+	// Claims not signed by a trusted operator are rejected.
+	badKP, _ := nkeys.CreateOperator()
+	msg, err := nc.Request(subj, []byte(accJWT(badKP, 30)), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	resp := &ServerAPIResponse{}
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		t.Fatalf("Error unmarshaling response: %v", err)
+	}
+	if resp.Error == "" {
+		t.Fatalf("Expected an error for untrusted claims")
+	}
+	// Claims for another account are rejected too.
+	barKP, _ := nkeys.CreateAccount()
+	barPub, _ := barKP.PublicKey()
+	msg, err = nc.Request(fmt.Sprintf(accUpdateEventSubj, barPub), []byte(accJWT(okp, 30)), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	resp = &ServerAPIResponse{}
+	if err := json.Unmarshal(msg.Data, resp); err != nil || resp.Error == "" {
+		t.Fatalf("Expected an error for claims of another account, got %+v", resp)
+	}
+	// Older claims, replayed or out of order, are rejected.
+	msg, err = nc.Request(subj, []byte(oldJWT), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	resp = &ServerAPIResponse{}
+	if err := json.Unmarshal(msg.Data, resp); err != nil || resp.Error == "" {
+		t.Fatalf("Expected an error for older claims, got %+v", resp)
+	}
+	time.Sleep(100 * time.Millisecond)
+	checkMaxSubs(20)
+}
+
+func TestSystemAccountClaimsUpdateLowersMaxConns(t *testing.T) {
+	s, opts := runSystemAccountServer(t)
+	defer s.Shutdown()
+
+	nc := sysConnect(t, opts)
+	defer nc.Close()
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+	fooKP, _ := nkeys.CreateAccount()
+	fooPub, _ := fooKP.PublicKey()
+	var issuedAt int64
+	accJWT := func(conns int64) string {
+		t.Helper()
+		for time.Now().Unix() <= issuedAt {
+			time.Sleep(10 * time.Millisecond)
+		}
+		ac := jwt.NewAccountClaims(string(fooPub))
+		ac.Limits.Conn = conns
+		ajwt, err := ac.Encode(okp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		issuedAt = ac.IssuedAt
+		return ajwt
+	}
+	s.mu.Lock()
+	s.trustedNkeys = []string{string(opub)}
+	s.accResolver = &MemAccResolver{}
+	s.mu.Unlock()
+	addAccountToMemResolver(s, string(fooPub), accJWT(10))
+	acc := s.LookupAccount(string(fooPub))
+	if acc == nil {
+		t.Fatalf("Expected the account to be registered")
+	}
+	for i := 0; i < 4; i++ {
+		c, cr, _ := newClientForServer(s)
+		go io.Copy(ioutil.Discard, cr)
+		if err := c.registerWithAccount(acc); err != nil {
+			t.Fatalf("Error registering client: %v", err)
+		}
+	}
+
+	// Lowering the limit closes the connections over it, which must
+	// not deadlock while the claims are applied.
+	sysRequest(t, nc, fmt.Sprintf(accUpdateEventSubj, fooPub), []byte(accJWT(2)), nil)
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		if n := acc.NumClients(); n != 2 {
+			return fmt.Errorf("Expected 2 clients, got %d", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,1142 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Number of subjects without interest sent to a remote gateway for
+	// a given account before switching that account to interest-only mode.
+	defaultGatewayMaxRUnsubBeforeSwitch = 1000
+
+	// Commands sent in the INFO protocol to a remote gateway.
+	gatewayCmdAllSubsStart    byte = 1
+	gatewayCmdAllSubsComplete byte = 2
+)
+
+// Can be changed for tests.
+var gatewayMaxRUnsubBeforeSwitch = defaultGatewayMaxRUnsubBeforeSwitch
+
+// GatewayInterestMode represents an account interest mode for a gateway connection.
+type GatewayInterestMode byte
+
+const (
+	// Optimistic is the default mode where a cluster sends all messages to a
+	// remote gateway, unless told that there is no interest for a subject.
+	Optimistic GatewayInterestMode = iota
+	// Transitioning is the mode used while the remote gateway sends its
+	// complete interest for an account.
+	Transitioning
+	// InterestOnly means that a cluster sends messages to a remote gateway
+	// only for subjects that the remote has registered interest on.
+	InterestOnly
+)
+
+func (im GatewayInterestMode) String() string {
+	switch im {
+	case Optimistic:
+		return "Optimistic"
+	case Transitioning:
+		return "Transitioning"
+	case InterestOnly:
+		return "Interest-Only"
+	default:
+		return "Unknown"
+	}
+}
+
+// srvGateway holds the server state for gateways.
+type srvGateway struct {
+	sync.RWMutex
+	enabled  bool                          // Immutable, true if both a name and port are configured.
+	name     string                        // Name of our gateway, which is the name of our cluster.
+	out      map[string]*client            // Outbound gateway connections, keyed by remote gateway name.
+	in       map[uint64]*client            // Inbound gateway connections, keyed by client id.
+	remotes  map[string]*RemoteGatewayOpts // Configured remote gateways.
+	info     Info                          // Our gateway INFO protocol.
+	infoJSON []byte
+	listener net.Listener // Protected by the server lock.
+}
+
+// gateway holds the state of a gateway connection.
+type gateway struct {
+	name     string             // Name of the remote gateway.
+	outbound bool               // True if we solicited the connection.
+	cfg      *RemoteGatewayOpts // Set for outbound connections.
+	url      *url.URL           // URL used for outbound connections.
+	remoteID string             // Server ID of the remote side.
+	// For outbound connections, the interest of the remote per account.
+	// A nil entry means that the remote has no interest at all in the account.
+	outsim map[string]*outsie
+	// For inbound connections, the interest we sent to the remote per account.
+	// A nil entry means that we told the remote we have no interest at all.
+	insim map[string]*insie
+}
+
+// outsie is the per account interest of a remote gateway,
+// kept on an outbound connection.
+type outsie struct {
+	mode GatewayInterestMode
+	ni   map[string]struct{}      // Subjects with no interest, in optimistic mode.
+	sl   *Sublist                 // Interest of the remote, in interest-only mode.
+	subs map[string]*subscription // Subscriptions in the sublist, keyed by subject and queue.
+}
+
+// insie is the per account interest that we sent to a remote
+// gateway, kept on an inbound connection.
+type insie struct {
+	mode GatewayInterestMode
+	ni   map[string]struct{} // Subjects we sent no interest for, in optimistic mode.
+	smap map[string]int32    // Interest we sent, in interest-only mode.
+}
+
+// Creates the server gateway structure from the options.
+func newServerGateway(opts *Options) *srvGateway {
+	gw := &srvGateway{
+		enabled: opts.Gateway.Name != "" && opts.Gateway.Port != 0,
+		name:    opts.Gateway.Name,
+		out:     make(map[string]*client),
+		in:      make(map[uint64]*client),
+		remotes: make(map[string]*RemoteGatewayOpts),
+	}
+	for _, rgo := range opts.Gateway.Gateways {
+		// Skip our own gateway, this allows the same list to be used in all clusters.
+		if rgo.Name == gw.name {
+			continue
+		}
+		gw.remotes[rgo.Name] = rgo
+	}
+	return gw
+}
+
+// gatewaysEnabled returns true if this server is part of a super-cluster.
+func (s *Server) gatewaysEnabled() bool {
+	return s.gateway != nil && s.gateway.enabled
+}
+
+// GatewayAddr returns the net.Addr object for the gateway listener.
+func (s *Server) GatewayAddr() *net.TCPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gateway.listener == nil {
+		return nil
+	}
+	return s.gateway.listener.Addr().(*net.TCPAddr)
+}
+
+// NumOutboundGateways returns the number of outbound gateway connections.
+func (s *Server) NumOutboundGateways() int {
+	s.gateway.RLock()
+	defer s.gateway.RUnlock()
+	return len(s.gateway.out)
+}
+
+// NumInboundGateways returns the number of inbound gateway connections.
+func (s *Server) NumInboundGateways() int {
+	s.gateway.RLock()
+	defer s.gateway.RUnlock()
+	return len(s.gateway.in)
+}
+
+// startGateways will start the gateway accept loop and
+// solicit the configured remote gateways.
+func (s *Server) startGateways() {
+	if !s.gatewaysEnabled() {
+		s.Errorf("Gateway requires a name, not starting gateway")
+		return
+	}
+	s.startGatewayAcceptLoop()
+	s.solicitGateways()
+}
+
+func (s *Server) startGatewayAcceptLoop() {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	port := opts.Gateway.Port
+	if port == -1 {
+		port = 0
+	}
+
+	hp := net.JoinHostPort(opts.Gateway.Host, strconv.Itoa(port))
+	l, e := net.Listen("tcp", hp)
+	if e != nil {
+		s.Fatalf("Error listening on gateway port: %d - %v", opts.Gateway.Port, e)
+		return
+	}
+	s.Noticef("Gateway name is %s", s.gateway.name)
+	s.Noticef("Listening for gateways connections on %s",
+		net.JoinHostPort(opts.Gateway.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)))
+
+	s.mu.Lock()
+	// If we have selected a random port...
+	if port == 0 {
+		// Write resolved port back to options.
+		opts.Gateway.Port = l.Addr().(*net.TCPAddr).Port
+	}
+	tlsReq := opts.Gateway.TLSConfig != nil
+	s.gateway.Lock()
+	s.gateway.info = Info{
+		ID:           s.info.ID,
+		Version:      s.info.Version,
+		GoVersion:    runtime.Version(),
+		Host:         opts.Gateway.Host,
+		Port:         opts.Gateway.Port,
+		AuthRequired: opts.Gateway.Username != "",
+		TLSRequired:  tlsReq,
+		TLSVerify:    tlsReq,
+		MaxPayload:   s.info.MaxPayload,
+		Gateway:      s.gateway.name,
+		Headers:      true,
+	}
+	b, _ := json.Marshal(s.gateway.info)
+	s.gateway.infoJSON = []byte(fmt.Sprintf(InfoProto, b))
+	s.gateway.Unlock()
+	// Setup state that can enable shutdown
+	s.gateway.listener = l
+	s.mu.Unlock()
+
+	go s.gatewayAcceptLoop(l)
+}
+
+func (s *Server) gatewayAcceptLoop(l net.Listener) {
+	tmpDelay := ACCEPT_MIN_SLEEP
+
+	for s.isRunning() {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.Debugf("Temporary Gateway Accept Error(%v), sleeping %dms",
+					ne, tmpDelay/time.Millisecond)
+				time.Sleep(tmpDelay)
+				tmpDelay *= 2
+				if tmpDelay > ACCEPT_MAX_SLEEP {
+					tmpDelay = ACCEPT_MAX_SLEEP
+				}
+			} else if s.isRunning() {
+				s.Noticef("Accept error: %v", err)
+			}
+			continue
+		}
+		tmpDelay = ACCEPT_MIN_SLEEP
+		s.startGoRoutine(func() {
+			s.createGateway(conn, nil, nil)
+			s.grWG.Done()
+		})
+	}
+	s.Debugf("Gateway accept loop exiting..")
+	s.done <- true
+}
+
+// solicitGateways will create an outbound connection to each configured remote gateway.
+func (s *Server) solicitGateways() {
+	s.gateway.RLock()
+	defer s.gateway.RUnlock()
+	for _, r := range s.gateway.remotes {
+		cfg := r
+		s.startGoRoutine(func() { s.connectToGateway(cfg) })
+	}
+}
+
+// reConnectGatewayIfSolicited will start a reconnect to the remote
+// gateway if we solicited this connection and are still running.
+func (s *Server) reConnectGatewayIfSolicited(c *client) {
+	c.mu.Lock()
+	var cfg *RemoteGatewayOpts
+	if c.gw != nil && c.gw.outbound {
+		cfg = c.gw.cfg
+	}
+	c.mu.Unlock()
+
+	if cfg == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// It is possible that the server is being shutdown.
+	// If so, don't try to reconnect
+	if !s.running {
+		return
+	}
+	s.startGoRoutine(func() {
+		select {
+		case <-time.After(DEFAULT_GATEWAY_RECONNECT):
+		case <-s.quitCh:
+			s.grWG.Done()
+			return
+		}
+		s.connectToGateway(cfg)
+	})
+}
+
+// connectToGateway will keep trying to connect to one of the URLs
+// of the remote gateway until it succeeds or we are shutdown.
+func (s *Server) connectToGateway(cfg *RemoteGatewayOpts) {
+	defer s.grWG.Done()
+
+	if len(cfg.URLs) == 0 {
+		s.Errorf("No URL configured for gateway %q", cfg.Name)
+		return
+	}
+
+	for s.isRunning() {
+		// Pick a random URL so that servers in our cluster
+		// spread their connections to the remote cluster.
+		u := cfg.URLs[rand.Intn(len(cfg.URLs))]
+		s.Debugf("Trying to connect to gateway %q at %s", cfg.Name, u.Host)
+		conn, err := net.DialTimeout("tcp", u.Host, DEFAULT_GATEWAY_DIAL)
+		if err != nil {
+			s.Debugf("Error trying to connect to gateway %q: %v", cfg.Name, err)
+			select {
+			case <-s.quitCh:
+				return
+			case <-time.After(DEFAULT_GATEWAY_RECONNECT):
+				continue
+			}
+		}
+		// We have a gateway connection here.
+		// Go ahead and create it and exit this func.
+		s.createGateway(conn, cfg, u)
+		return
+	}
+}
+
+func (s *Server) createGateway(conn net.Conn, cfg *RemoteGatewayOpts, u *url.URL) *client {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	outbound := cfg != nil
+	gw := &gateway{outbound: outbound, cfg: cfg, url: u}
+	if outbound {
+		gw.name = cfg.Name
+		gw.outsim = make(map[string]*outsie)
+	} else {
+		gw.insim = make(map[string]*insie)
+	}
+	c := &client{srv: s, nc: conn, opts: clientOpts{}, typ: GATEWAY, gw: gw}
+
+	// Grab server variables
+	s.gateway.RLock()
+	infoJSON := s.gateway.infoJSON
+	authRequired := s.gateway.info.AuthRequired
+	s.gateway.RUnlock()
+
+	// Determine which TLS config to use, if any. For outbound connections
+	// the remote specific config takes precedence over the gateway one.
+	tlsConfig, timeout := opts.Gateway.TLSConfig, opts.Gateway.TLSTimeout
+	if outbound && cfg.TLSConfig != nil {
+		tlsConfig, timeout = cfg.TLSConfig, cfg.TLSTimeout
+	}
+	tlsRequired := tlsConfig != nil
+
+	// Grab lock
+	c.mu.Lock()
+
+	// Initialize
+	c.initClient()
+
+	// Initialize the route cache, used for the L1 of inbound messages.
+	c.in.rcache = make(map[string]*routeCache, maxRouteCacheSize)
+
+	// Check for TLS
+	if tlsRequired {
+		tlsConfig = tlsConfig.Clone()
+
+		// If we solicited, we will act like the client, otherwise the server.
+		if outbound {
+			c.Debugf("Starting TLS gateway client handshake")
+			// Specify the ServerName we are expecting.
+			host, _, _ := net.SplitHostPort(u.Host)
+			tlsConfig.ServerName = host
+			c.nc = tls.Client(c.nc, tlsConfig)
+		} else {
+			c.Debugf("Starting TLS gateway server handshake")
+			c.nc = tls.Server(c.nc, tlsConfig)
+		}
+
+		conn := c.nc.(*tls.Conn)
+
+		// Setup the timeout
+		ttl := secondsToDuration(timeout)
+		time.AfterFunc(ttl, func() { tlsTimeout(c, conn) })
+		conn.SetReadDeadline(time.Now().Add(ttl))
+
+		c.mu.Unlock()
+		if err := conn.Handshake(); err != nil {
+			c.Errorf("TLS gateway handshake error: %v", err)
+			c.sendErr("Secure Connection - TLS Required")
+			c.closeConnection(TLSHandshakeError)
+			return nil
+		}
+		// Reset the read deadline
+		conn.SetReadDeadline(time.Time{})
+
+		// Re-Grab lock
+		c.mu.Lock()
+
+		// Verify that the connection did not go away while we released the lock.
+		if c.nc == nil {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+
+	// Do final client initialization
+
+	// Set the Ping timer
+	c.setPingTimer()
+	c.mu.Unlock()
+
+	// Register with the server. Do this without holding the client
+	// lock so that we respect the server then client lock ordering.
+	s.mu.Lock()
+	running := s.running
+	if running {
+		s.gateway.Lock()
+		if outbound {
+			s.gateway.out[gw.name] = c
+		} else {
+			s.gateway.in[c.cid] = c
+		}
+		s.gateway.Unlock()
+	}
+	s.mu.Unlock()
+	if !running {
+		c.closeConnection(ServerShutdown)
+		return nil
+	}
+
+	c.mu.Lock()
+	// Check for Auth required state for incoming connections.
+	// Make sure to do this before spinning up readLoop.
+	if authRequired && !outbound {
+		ttl := secondsToDuration(opts.Gateway.AuthTimeout)
+		c.setAuthTimer(ttl)
+	}
+
+	// Spin up the read loop.
+	s.startGoRoutine(func() { c.readLoop() })
+
+	// Spin up the write loop.
+	s.startGoRoutine(c.writeLoop)
+
+	if tlsRequired {
+		c.Debugf("TLS handshake complete")
+		cs := c.nc.(*tls.Conn).ConnectionState()
+		c.Debugf("TLS version %s, cipher suite %s", tlsVersion(cs.Version), tlsCipher(cs.CipherSuite))
+	}
+
+	// Queue Connect proto if we solicited the connection,
+	// otherwise send our info to the other side.
+	if outbound {
+		c.Debugf("Gateway connect msg sent")
+		c.sendGatewayConnect(tlsRequired)
+	} else {
+		c.sendInfo(infoJSON)
+	}
+	c.mu.Unlock()
+
+	if outbound {
+		c.Noticef("Outbound gateway connection to %q created", gw.name)
+	} else {
+		c.Noticef("Inbound gateway connection created")
+	}
+	return c
+}
+
+// Lock should be held entering here.
+func (c *client) sendGatewayConnect(tlsRequired bool) {
+	var user, pass string
+	if userInfo := c.gw.url.User; userInfo != nil {
+		user = userInfo.Username()
+		pass, _ = userInfo.Password()
+	}
+	cinfo := connectInfo{
+		Echo:     true,
+		Verbose:  false,
+		Pedantic: false,
+		User:     user,
+		Pass:     pass,
+		TLS:      tlsRequired,
+		Name:     c.srv.info.ID,
+		Gateway:  c.srv.gateway.name,
+	}
+
+	b, err := json.Marshal(cinfo)
+	if err != nil {
+		c.Errorf("Error marshaling CONNECT to gateway: %v\n", err)
+		c.closeConnection(ProtocolViolation)
+		return
+	}
+	c.sendProto([]byte(fmt.Sprintf(ConProto, b)), true)
+}
+
+// processGatewayConnect is called on inbound gateway connections once the
+// CONNECT protocol has been processed and the remote authorized.
+func (c *client) processGatewayConnect() error {
+	c.mu.Lock()
+	name := c.opts.Gateway
+	c.gw.remoteID = c.opts.Name
+	c.gw.name = name
+	srv := c.srv
+	c.mu.Unlock()
+
+	if name == "" {
+		c.sendErrAndErr(ErrMissingGatewayName.Error())
+		c.closeConnection(ProtocolViolation)
+		return ErrMissingGatewayName
+	}
+	// A gateway from our own cluster is a misconfiguration.
+	if name == srv.gateway.name {
+		c.sendErrAndErr(fmt.Sprintf("%s: remote gateway has our name %q", ErrWrongGateway, name))
+		c.closeConnection(WrongGateway)
+		return ErrWrongGateway
+	}
+	c.Debugf("Inbound gateway connection from %q (%s) registered", name, c.opts.Name)
+	return nil
+}
+
+// processGatewayInfo handles the INFO protocol received on a gateway connection.
+func (c *client) processGatewayInfo(info *Info) {
+	c.mu.Lock()
+	// Connection can be closed at any time (by auth timeout, etc).
+	// Does not make sense to continue here if connection is gone.
+	if c.gw == nil || c.nc == nil {
+		c.mu.Unlock()
+		return
+	}
+	outbound := c.gw.outbound
+	name := c.gw.name
+	first := c.gw.remoteID == ""
+	if outbound && first {
+		c.gw.remoteID = info.ID
+		c.headers = info.Headers
+	}
+	s := c.srv
+	c.mu.Unlock()
+
+	// We only expect INFO protocols on outbound connections.
+	if !outbound {
+		return
+	}
+
+	if info.GatewayCmd != 0 {
+		c.processGatewayCmd(info)
+		return
+	}
+
+	if first {
+		if info.ID == s.info.ID {
+			c.Errorf("Detected gateway connection to ourselves, closing")
+			c.closeConnection(WrongGateway)
+			return
+		}
+		if info.Gateway != name {
+			c.Errorf("%s: expected %q, got %q", ErrWrongGateway, name, info.Gateway)
+			c.closeConnection(WrongGateway)
+			return
+		}
+		c.Debugf("Outbound gateway connection to %q (%s) registered", name, info.ID)
+	}
+}
+
+// processGatewayCmd handles the commands that are sent by the remote gateway
+// when switching an account to interest-only mode.
+func (c *client) processGatewayCmd(info *Info) {
+	accName := string(info.GatewayCmdPayload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.gw.outsim[accName]
+	switch info.GatewayCmd {
+	case gatewayCmdAllSubsStart:
+		if e == nil {
+			e = &outsie{}
+			c.gw.outsim[accName] = e
+		}
+		// Keep using the no-interest map until we have the complete interest.
+		e.mode = Transitioning
+		e.sl = NewSublist()
+		e.subs = make(map[string]*subscription)
+	case gatewayCmdAllSubsComplete:
+		if e == nil || e.mode != Transitioning {
+			return
+		}
+		e.mode = InterestOnly
+		e.ni = nil
+		c.Debugf("Gateway %q switched account %q to %s mode", c.gw.name, accName, InterestOnly)
+	}
+}
+
+// processGatewayAccountSub handles an A+ from the remote gateway,
+// meaning that it has now interest in the given account.
+func (c *client) processGatewayAccountSub(accName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.gw.outbound {
+		return nil
+	}
+	if e, ok := c.gw.outsim[accName]; ok && e == nil {
+		// Go back to optimistic mode for this account.
+		delete(c.gw.outsim, accName)
+	}
+	return nil
+}
+
+// processGatewayAccountUnsub handles an A- from the remote gateway,
+// meaning that it has no interest at all in the given account.
+func (c *client) processGatewayAccountUnsub(accName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.gw.outbound {
+		return
+	}
+	// Do not override the interest we already have in interest-only mode.
+	if e := c.gw.outsim[accName]; e != nil && e.mode != Optimistic {
+		return
+	}
+	c.gw.outsim[accName] = nil
+}
+
+// processGatewayRSub handles an RS+ from the remote gateway.
+func (c *client) processGatewayRSub(arg []byte) error {
+	c.traceInOp("RS+", arg)
+
+	// Indicate activity.
+	c.in.subs++
+
+	args := splitArg(arg)
+	var queue []byte
+	var qw int32
+
+	switch len(args) {
+	case 2:
+	case 4:
+		queue = args[2]
+		qw = int32(parseSize(args[3]))
+	default:
+		return fmt.Errorf("processGatewayRSub Parse Error: '%s'", arg)
+	}
+	accName := string(args[0])
+	subject := args[1]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.gw.outbound {
+		return nil
+	}
+
+	e := c.gw.outsim[accName]
+	if e == nil {
+		// Interest in an account we were told had no interest, or
+		// that we never heard of, means we are back to optimistic.
+		delete(c.gw.outsim, accName)
+		return nil
+	}
+	// In optimistic mode, this removes the subject from the no-interest map.
+	if e.ni != nil && queue == nil {
+		delete(e.ni, string(subject))
+	}
+	if e.sl == nil {
+		return nil
+	}
+	// Otherwise register the interest.
+	key := string(subject)
+	if queue != nil {
+		key += " " + string(queue)
+	}
+	if sub := e.subs[key]; sub != nil {
+		atomic.StoreInt32(&sub.qw, qw)
+		return nil
+	}
+	// Copy since arg references the read buffer.
+	sub := &subscription{client: c, subject: []byte(string(subject)), qw: qw}
+	if queue != nil {
+		sub.queue = []byte(string(queue))
+	}
+	if err := e.sl.Insert(sub); err != nil {
+		c.Errorf("Could not insert gateway subscription: %v", err)
+		return nil
+	}
+	e.subs[key] = sub
+	return nil
+}
+
+// processGatewayRUnsub handles an RS- from the remote gateway.
+func (c *client) processGatewayRUnsub(arg []byte) error {
+	c.traceInOp("RS-", arg)
+
+	// Indicate any activity, so pub and sub or unsubs.
+	c.in.subs++
+
+	args := splitArg(arg)
+	var queue []byte
+
+	switch len(args) {
+	case 2:
+	case 3:
+		queue = args[2]
+	default:
+		return fmt.Errorf("processGatewayRUnsub Parse Error: '%s'", arg)
+	}
+	accName := string(args[0])
+	subject := string(args[1])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.gw.outbound {
+		return nil
+	}
+
+	e, ok := c.gw.outsim[accName]
+	if ok && e == nil {
+		// We already know there is no interest at all for this account.
+		return nil
+	}
+	if !ok {
+		e = &outsie{}
+		c.gw.outsim[accName] = e
+	}
+	// In interest-only mode, remove the registered interest.
+	if e.sl != nil {
+		key := subject
+		if queue != nil {
+			key += " " + string(queue)
+		}
+		if sub := e.subs[key]; sub != nil {
+			delete(e.subs, key)
+			e.sl.Remove(sub)
+		}
+	}
+	// Until we have the complete interest, track subjects without interest.
+	if e.mode != InterestOnly && queue == nil {
+		if e.ni == nil {
+			e.ni = make(map[string]struct{})
+		}
+		e.ni[subject] = struct{}{}
+	}
+	return nil
+}
+
+// gatewayInterest returns true if the message for this account and subject
+// should be sent on this outbound gateway. Queue groups in the handled list
+// were already served in our cluster, so do not count as interest.
+func (c *client) gatewayInterest(accName string, subject []byte, handled [][]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.gw.outsim[accName]
+	if !ok {
+		// Optimistic mode without any information.
+		return true
+	}
+	if e == nil {
+		// No interest at all for this account.
+		return false
+	}
+	if e.mode == InterestOnly {
+		r := e.sl.Match(string(subject))
+		if len(r.psubs) > 0 {
+			return true
+		}
+		for _, qsubs := range r.qsubs {
+			if len(qsubs) > 0 && !queueInList(qsubs[0].queue, handled) {
+				return true
+			}
+		}
+		return false
+	}
+	_, noInterest := e.ni[string(subject)]
+	return !noInterest
+}
+
+// sendMsgToGateways sends the message to all outbound gateways that may
+// have interest. Queue groups in qgroups were already served in our
+// cluster and will be skipped by the remote. Returns true if the message
+// was sent to at least one gateway.
+func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgroups [][]byte) bool {
+	// The events of the reserved system account stay within the cluster.
+	if c.srv.isReservedSysAccount(acc) {
+		return false
+	}
+	var _gws [16]*client
+	gws := c.srv.getOutboundGatewayConnections(_gws[:0])
+	if len(gws) == 0 {
+		return false
+	}
+
+	sent := false
+	for _, gwc := range gws {
+		if !gwc.gatewayInterest(acc.Name, subject, qgroups) {
+			continue
+		}
+		hdrs := false
+		if c.pa.hdr > 0 {
+			gwc.mu.Lock()
+			hdrs = gwc.headers
+			gwc.mu.Unlock()
+		}
+		mh := c.msgb[:msgHeadProtoLen]
+		if hdrs {
+			mh[0] = 'H'
+		} else {
+			mh[0] = 'R'
+		}
+		mh = append(mh, acc.Name...)
+		mh = append(mh, ' ')
+		mh = append(mh, subject...)
+		mh = append(mh, ' ')
+		if len(qgroups) > 0 {
+			if reply != nil {
+				mh = append(mh, "+ "...) // Signal that there is a reply.
+				mh = append(mh, reply...)
+				mh = append(mh, ' ')
+			} else {
+				mh = append(mh, "| "...) // Only queues
+			}
+			for _, qn := range qgroups {
+				mh = append(mh, qn...)
+				mh = append(mh, ' ')
+			}
+		} else if reply != nil {
+			mh = append(mh, reply...)
+			mh = append(mh, ' ')
+		}
+		mh = c.appendMsgSize(mh, hdrs)
+		mh = append(mh, _CRLF_...)
+		sub := subscription{client: gwc}
+		if c.deliverMsg(&sub, mh, msg) {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// processInboundGatewayMsg is called to process an inbound msg from a gateway.
+func (c *client) processInboundGatewayMsg(msg []byte) {
+	// Update statistics
+	c.in.msgs++
+	// The msg includes the CR_LF, so pull back out for accounting.
+	c.in.bytes += len(msg) - LEN_CR_LF
+
+	if c.trace {
+		c.traceMsg(msg)
+	}
+
+	// Mostly under testing scenarios.
+	if c.srv == nil {
+		return
+	}
+
+	// Messages are only expected on inbound connections.
+	if c.gw.outbound {
+		return
+	}
+
+	var (
+		acc *Account
+		rc  *routeCache
+		r   *SublistResult
+		ok  bool
+	)
+
+	// Check our cache first.
+	if rc, ok = c.in.rcache[string(c.pa.rcache)]; ok {
+		// Check the genid to see if it's still valid.
+		if genid := atomic.LoadUint64(&rc.acc.sl.genid); genid != rc.genid {
+			ok = false
+			delete(c.in.rcache, string(c.pa.rcache))
+		} else {
+			acc = rc.acc
+			r = rc.results
+		}
+	}
+
+	if !ok {
+		// Match correct account and sublist.
+		acc = c.srv.LookupAccount(string(c.pa.account))
+		if acc == nil {
+			c.Debugf("Unknown account %q for gateway message on subject: %q", c.pa.account, c.pa.subject)
+			c.gatewayAccountNoInterest(string(c.pa.account))
+			return
+		}
+
+		// Match against the account sublist.
+		r = acc.sl.Match(string(c.pa.subject))
+
+		// Store in our cache
+		c.in.rcache[string(c.pa.rcache)] = &routeCache{acc, r, atomic.LoadUint64(&acc.sl.genid)}
+
+		// Check if we need to prune.
+		if len(c.in.rcache) > maxRouteCacheSize {
+			c.pruneRouteCache()
+		}
+	}
+
+	// Check to see if we need to map/route to another account.
+	if acc.imports.services != nil {
+		c.checkForImportServices(acc, msg)
+	}
+
+	// Check for no interest, and let the remote gateway know.
+	if len(r.psubs)+len(r.qsubs) == 0 {
+		if acc.sl.Count() == 0 {
+			c.gatewayAccountNoInterest(acc.Name)
+		} else {
+			c.gatewaySubjectNoInterest(acc, string(c.pa.subject))
+		}
+		return
+	}
+
+	c.processMsgResults(acc, r, msg, c.pa.subject, c.pa.reply)
+}
+
+// gatewayAccountNoInterest sends an A- to the remote gateway, if not already done.
+func (c *client) gatewayAccountNoInterest(accName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.gw.insim[accName]; ok && (e == nil || e.mode != Optimistic) {
+		return
+	}
+	c.gw.insim[accName] = nil
+	proto := fmt.Sprintf("A- %s", accName)
+	if c.trace {
+		c.traceOutOp("", []byte(proto))
+	}
+	c.sendProto([]byte(proto+_CRLF_), false)
+}
+
+// gatewaySubjectNoInterest sends an RS- for the subject to the remote gateway,
+// if not already done. After too many of those for the same account, the
+// account is switched to interest-only mode.
+func (c *client) gatewaySubjectNoInterest(acc *Account, subject string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.gw.insim[acc.Name]
+	if ok && e == nil {
+		// We told the remote there is no interest for this account.
+		return
+	}
+	if !ok {
+		e = &insie{}
+		c.gw.insim[acc.Name] = e
+	}
+	if e.mode != Optimistic {
+		// The remote knows about all of our interest already.
+		return
+	}
+	if e.ni == nil {
+		e.ni = make(map[string]struct{})
+	}
+	if _, ok := e.ni[subject]; ok {
+		return
+	}
+	e.ni[subject] = struct{}{}
+	var b bytes.Buffer
+	c.writeGatewaySub(&b, acc.Name, subject, 0)
+	c.sendProto(b.Bytes(), false)
+
+	if len(e.ni) >= gatewayMaxRUnsubBeforeSwitch {
+		c.gatewaySwitchAccountToInterestOnly(acc, e)
+	}
+}
+
+// gatewaySwitchAccountToInterestOnly sends our complete interest for this
+// account to the remote gateway, which will from now on send only messages
+// we have interest on.
+// Lock should be held.
+func (c *client) gatewaySwitchAccountToInterestOnly(acc *Account, e *insie) {
+	e.mode = InterestOnly
+	e.ni = nil
+	e.smap = make(map[string]int32)
+
+	var _subs [4096]*subscription
+	subs := _subs[:0]
+	acc.sl.All(&subs)
+	for _, sub := range subs {
+		e.smap[keyFromSub(sub)]++
+	}
+
+	var b bytes.Buffer
+	c.writeGatewayCmd(&b, gatewayCmdAllSubsStart, acc.Name)
+	for key, n := range e.smap {
+		c.writeGatewaySub(&b, acc.Name, key, n)
+	}
+	c.writeGatewayCmd(&b, gatewayCmdAllSubsComplete, acc.Name)
+	c.queueOutbound(b.Bytes())
+	c.flushSignal()
+
+	c.Debugf("Switched account %q to %s mode for gateway %q", acc.Name, InterestOnly, c.gw.name)
+}
+
+// gatewayUpdateSubInterest is called when the interest of our cluster changes
+// for the given account. Inbound gateways that told the remote about missing
+// interest, or that are in interest-only mode, are updated.
+func (s *Server) gatewayUpdateSubInterest(accName string, sub *subscription, delta int32) {
+	if !s.gatewaysEnabled() {
+		return
+	}
+	var _gws [16]*client
+	gws := _gws[:0]
+	s.gateway.RLock()
+	for _, c := range s.gateway.in {
+		gws = append(gws, c)
+	}
+	s.gateway.RUnlock()
+
+	for _, c := range gws {
+		c.updateGatewaySubInterest(accName, sub, delta)
+	}
+}
+
+func (c *client) updateGatewaySubInterest(accName string, sub *subscription, delta int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nc == nil {
+		return
+	}
+	e, ok := c.gw.insim[accName]
+	if !ok {
+		// Nothing was ever sent to the remote for this account.
+		return
+	}
+
+	var b bytes.Buffer
+	switch {
+	case e == nil:
+		// We told the remote there was no interest at all.
+		if delta > 0 {
+			delete(c.gw.insim, accName)
+			proto := fmt.Sprintf("A+ %s", accName)
+			if c.trace {
+				c.traceOutOp("", []byte(proto))
+			}
+			b.WriteString(proto + _CRLF_)
+		}
+	case e.mode == InterestOnly:
+		key := keyFromSub(sub)
+		n, ok := e.smap[key]
+		// Ignore removal of interest we never sent.
+		if !ok && delta < 0 {
+			return
+		}
+		n += delta
+		if n > 0 {
+			e.smap[key] = n
+		} else {
+			delete(e.smap, key)
+		}
+		// Plain subscriptions only need an update when interest comes and goes,
+		// queue subscriptions always carry their weight.
+		if sub.queue != nil || !ok || n <= 0 {
+			c.writeGatewaySub(&b, accName, key, n)
+		}
+	case delta > 0:
+		// Optimistic mode, clear subjects that now have interest.
+		for subject := range e.ni {
+			if matchLiteral(subject, string(sub.subject)) {
+				delete(e.ni, subject)
+				c.writeGatewaySub(&b, accName, subject, 1)
+			}
+		}
+	}
+	if b.Len() > 0 {
+		c.sendProto(b.Bytes(), false)
+	}
+}
+
+// writeGatewaySub writes the RS+ or RS- protocol for the given account
+// and key, which is the subject optionally followed by the queue name.
+// Lock should be held.
+func (c *client) writeGatewaySub(w *bytes.Buffer, accName, key string, n int32) {
+	var proto string
+	if n <= 0 {
+		proto = fmt.Sprintf("RS- %s %s", accName, key)
+	} else if bytes.IndexByte([]byte(key), ' ') > 0 {
+		proto = fmt.Sprintf("RS+ %s %s %d", accName, key, n)
+	} else {
+		proto = fmt.Sprintf("RS+ %s %s", accName, key)
+	}
+	if c.trace {
+		c.traceOutOp("", []byte(proto))
+	}
+	w.WriteString(proto)
+	w.WriteString(_CRLF_)
+}
+
+// writeGatewayCmd writes an INFO protocol carrying a gateway command.
+// Lock should be held.
+func (c *client) writeGatewayCmd(w *bytes.Buffer, cmd byte, accName string) {
+	info := Info{
+		ID:                c.srv.info.ID,
+		Gateway:           c.srv.gateway.name,
+		GatewayCmd:        cmd,
+		GatewayCmdPayload: []byte(accName),
+	}
+	b, _ := json.Marshal(info)
+	w.WriteString(fmt.Sprintf(InfoProto, b))
+}
+
+// getOutboundGatewayConnections appends the outbound gateway connections to a.
+func (s *Server) getOutboundGatewayConnections(a []*client) []*client {
+	s.gateway.RLock()
+	for _, c := range s.gateway.out {
+		a = append(a, c)
+	}
+	s.gateway.RUnlock()
+	return a
+}
+
+// getAllGatewayConnections adds all gateway connections to the given map.
+func (s *Server) getAllGatewayConnections(conns map[uint64]*client) {
+	s.gateway.RLock()
+	for _, c := range s.gateway.out {
+		conns[c.cid] = c
+	}
+	for cid, c := range s.gateway.in {
+		conns[cid] = c
+	}
+	s.gateway.RUnlock()
+}
+
+// removeRemoteGatewayConnection removes the gateway connection from the server maps.
+func (s *Server) removeRemoteGatewayConnection(c *client) {
+	s.gateway.Lock()
+	if c.gw != nil && c.gw.outbound {
+		if s.gateway.out[c.gw.name] == c {
+			delete(s.gateway.out, c.gw.name)
+		}
+	} else {
+		delete(s.gateway.in, c.cid)
+	}
+	s.gateway.Unlock()
+}
@@ -0,0 +1,508 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+func testDefaultOptionsForGateway(name string) *Options {
+	o := DefaultOptions()
+	o.Gateway.Name = name
+	o.Gateway.Host = o.Host
+	o.Gateway.Port = -1
+	return o
+}
+
+func testGatewayOptionsFromToWithServers(t *testing.T, name, remote string, servers ...*Server) *Options {
+	t.Helper()
+	o := testDefaultOptionsForGateway(name)
+	rgw := &RemoteGatewayOpts{Name: remote}
+	for _, s := range servers {
+		u, err := url.Parse(fmt.Sprintf("nats://%s", s.GatewayAddr()))
+		if err != nil {
+			t.Fatalf("Error parsing url: %v", err)
+		}
+		rgw.URLs = append(rgw.URLs, u)
+	}
+	o.Gateway.Gateways = []*RemoteGatewayOpts{rgw}
+	return o
+}
+
+func checkNumOutboundGateways(t *testing.T, s *Server, expected int) {
+	t.Helper()
+	checkFor(t, 5*time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumOutboundGateways(); n != expected {
+			return fmt.Errorf("Expected %v outbound gateways, got %v", expected, n)
+		}
+		return nil
+	})
+}
+
+func checkNumInboundGateways(t *testing.T, s *Server, expected int) {
+	t.Helper()
+	checkFor(t, 5*time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumInboundGateways(); n != expected {
+			return fmt.Errorf("Expected %v inbound gateways, got %v", expected, n)
+		}
+		return nil
+	})
+}
+
+// Runs two single server clusters "A" and "B" connected to each other.
+func runGatewayPair(t *testing.T) (*Server, *Server) {
+	t.Helper()
+	sb := RunServer(testDefaultOptionsForGateway("B"))
+	sa := RunServer(testGatewayOptionsFromToWithServers(t, "A", "B", sb))
+
+	// Now that "A" is running, make "B" connect to it too.
+	ob := testGatewayOptionsFromToWithServers(t, "B", "A", sa)
+	ob.Gateway.Port = sb.getOpts().Gateway.Port
+	sb.Shutdown()
+	sb = RunServer(ob)
+
+	checkNumOutboundGateways(t, sa, 1)
+	checkNumOutboundGateways(t, sb, 1)
+	checkNumInboundGateways(t, sa, 1)
+	checkNumInboundGateways(t, sb, 1)
+	return sa, sb
+}
+
+func natsConnect(t *testing.T, s *Server) *nats.Conn {
+	t.Helper()
+	opts := s.getOpts()
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	return nc
+}
+
+func TestGatewayConfig(t *testing.T) {
+	opts, err := ProcessConfigFile("./configs/gateway.conf")
+	if err != nil {
+		t.Fatalf("Received an error reading gateway config file: %v\n", err)
+	}
+	if opts.Gateway.Name != "A" {
+		t.Fatalf("Expected gateway name A, got %q", opts.Gateway.Name)
+	}
+	if opts.Gateway.Host != "127.0.0.1" || opts.Gateway.Port != 7222 {
+		t.Fatalf("Unexpected gateway listen: %s:%d", opts.Gateway.Host, opts.Gateway.Port)
+	}
+	if opts.Gateway.Username != "gw" || opts.Gateway.Password != "secret" {
+		t.Fatalf("Unexpected gateway authorization: %q/%q", opts.Gateway.Username, opts.Gateway.Password)
+	}
+	if opts.Gateway.AuthTimeout != 2.0 {
+		t.Fatalf("Expected auth timeout of 2, got %v", opts.Gateway.AuthTimeout)
+	}
+	if len(opts.Gateway.Gateways) != 2 {
+		t.Fatalf("Expected 2 remote gateways, got %d", len(opts.Gateway.Gateways))
+	}
+	b := opts.Gateway.Gateways[0]
+	if b.Name != "B" || len(b.URLs) != 1 || b.URLs[0].Host != "127.0.0.1:7223" {
+		t.Fatalf("Unexpected remote gateway: %+v", b)
+	}
+	c := opts.Gateway.Gateways[1]
+	if c.Name != "C" || len(c.URLs) != 2 || c.URLs[1].Host != "127.0.0.1:7225" {
+		t.Fatalf("Unexpected remote gateway: %+v", c)
+	}
+}
+
+func TestGatewayRequiresName(t *testing.T) {
+	cf := createConfFile(t, []byte(`
+    gateway {
+      port: -1
+    }
+    `))
+	defer os.Remove(cf)
+	if _, err := ProcessConfigFile(cf); err == nil {
+		t.Fatalf("Expected an error with gateway without a name")
+	}
+	// Remote gateways need a name and url.
+	cf = createConfFile(t, []byte(`
+    gateway {
+      name: "A"
+      port: -1
+      gateways = [{url: "nats://127.0.0.1:7223"}]
+    }
+    `))
+	defer os.Remove(cf)
+	if _, err := ProcessConfigFile(cf); err == nil {
+		t.Fatalf("Expected an error with remote gateway without a name")
+	}
+	cf = createConfFile(t, []byte(`
+    gateway {
+      name: "A"
+      port: -1
+      gateways = [{name: "B"}]
+    }
+    `))
+	defer os.Remove(cf)
+	if _, err := ProcessConfigFile(cf); err == nil {
+		t.Fatalf("Expected an error with remote gateway without a url")
+	}
+}
+
+func TestGatewayBasicPubSub(t *testing.T) {
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nca := natsConnect(t, sa)
+	defer nca.Close()
+	ncb := natsConnect(t, sb)
+	defer ncb.Close()
+
+	subb, _ := ncb.SubscribeSync("foo")
+	ncb.Flush()
+
+	nca.Publish("foo", []byte("from A"))
+	if _, err := subb.NextMsg(time.Second); err != nil {
+		t.Fatalf("Did not receive message in B: %v", err)
+	}
+
+	suba, _ := nca.SubscribeSync("bar")
+	nca.Flush()
+
+	ncb.Publish("bar", []byte("from B"))
+	if _, err := suba.NextMsg(time.Second); err != nil {
+		t.Fatalf("Did not receive message in A: %v", err)
+	}
+
+	// Request/reply across clusters.
+	ncb.Subscribe("service", func(m *nats.Msg) { ncb.Publish(m.Reply, []byte("ok")) })
+	ncb.Flush()
+	if _, err := nca.Request("service", []byte("req"), time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+}
+
+func TestGatewayNoInterest(t *testing.T) {
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nca := natsConnect(t, sa)
+	defer nca.Close()
+	ncb := natsConnect(t, sb)
+	defer ncb.Close()
+
+	// Have some interest in B so that we do not get an A-.
+	ncb.SubscribeSync("bar")
+	ncb.Flush()
+
+	getOutMsgs := func() int64 {
+		t.Helper()
+		gwz, _ := sa.Gatewayz(nil)
+		rgw := gwz.OutboundGateways["B"]
+		if rgw == nil || rgw.Connection == nil {
+			t.Fatalf("Outbound gateway B not found")
+		}
+		return rgw.Connection.OutMsgs
+	}
+
+	nca.Publish("foo", []byte("hello"))
+	nca.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := getOutMsgs(); n != 1 {
+			return fmt.Errorf("Expected 1 message sent to B, got %v", n)
+		}
+		return nil
+	})
+	// Wait for the RS- to be processed, then further messages
+	// on that subject should not be sent.
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		gwz, _ := sa.Gatewayz(&GatewayzOptions{Accounts: true})
+		accs := gwz.OutboundGateways["B"].Accounts
+		if len(accs) != 1 || accs[0].NoInterestCount != 1 {
+			return fmt.Errorf("Expected no interest on 1 subject, got %+v", accs)
+		}
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		nca.Publish("foo", []byte("hello"))
+	}
+	nca.Flush()
+	if n := getOutMsgs(); n != 1 {
+		t.Fatalf("Expected 1 message sent to B, got %v", n)
+	}
+
+	// Interest in B should clear the no interest state.
+	subb, _ := ncb.SubscribeSync("foo")
+	ncb.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		nca.Publish("foo", []byte("hello"))
+		if _, err := subb.NextMsg(50 * time.Millisecond); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func TestGatewayAccountNoInterest(t *testing.T) {
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nca := natsConnect(t, sa)
+	defer nca.Close()
+
+	nca.Publish("foo", []byte("hello"))
+	nca.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		gwz, _ := sa.Gatewayz(&GatewayzOptions{Accounts: true})
+		accs := gwz.OutboundGateways["B"].Accounts
+		if len(accs) != 1 || accs[0].NoInterestCount != -1 {
+			return fmt.Errorf("Expected no interest on account, got %+v", accs)
+		}
+		return nil
+	})
+
+	// Any subscription in B should bring the account back.
+	ncb := natsConnect(t, sb)
+	defer ncb.Close()
+	subb, _ := ncb.SubscribeSync("foo")
+	ncb.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		nca.Publish("foo", []byte("hello"))
+		if _, err := subb.NextMsg(50 * time.Millisecond); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func TestGatewaySwitchToInterestOnly(t *testing.T) {
+	gatewayMaxRUnsubBeforeSwitch = 5
+	defer func() { gatewayMaxRUnsubBeforeSwitch = defaultGatewayMaxRUnsubBeforeSwitch }()
+
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nca := natsConnect(t, sa)
+	defer nca.Close()
+	ncb := natsConnect(t, sb)
+	defer ncb.Close()
+
+	subb, _ := ncb.SubscribeSync("bar")
+	qsubb, _ := ncb.QueueSubscribeSync("baz", "queue")
+	ncb.Flush()
+
+	for i := 0; i < gatewayMaxRUnsubBeforeSwitch; i++ {
+		nca.Publish(fmt.Sprintf("foo.%d", i), []byte("hello"))
+	}
+	nca.Flush()
+
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		gwz, _ := sa.Gatewayz(&GatewayzOptions{Accounts: true})
+		accs := gwz.OutboundGateways["B"].Accounts
+		if len(accs) != 1 || accs[0].InterestMode != InterestOnly.String() {
+			return fmt.Errorf("Expected account in interest-only mode, got %+v", accs)
+		}
+		if accs[0].TotalSubscriptions != 2 {
+			return fmt.Errorf("Expected 2 subscriptions, got %v", accs[0].TotalSubscriptions)
+		}
+		return nil
+	})
+
+	gwz, _ := sb.Gatewayz(&GatewayzOptions{Accounts: true})
+	rgws := gwz.InboundGateways["A"]
+	if len(rgws) != 1 || len(rgws[0].Accounts) != 1 || rgws[0].Accounts[0].InterestMode != InterestOnly.String() {
+		t.Fatalf("Unexpected inbound gateway state: %+v", rgws)
+	}
+
+	// Existing interest is still served.
+	nca.Publish("bar", []byte("hello"))
+	if _, err := subb.NextMsg(time.Second); err != nil {
+		t.Fatalf("Did not receive message in B: %v", err)
+	}
+	nca.Publish("baz", []byte("hello"))
+	if _, err := qsubb.NextMsg(time.Second); err != nil {
+		t.Fatalf("Did not receive queue message in B: %v", err)
+	}
+
+	// New interest is propagated.
+	newsub, _ := ncb.SubscribeSync("new")
+	ncb.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		nca.Publish("new", []byte("hello"))
+		if _, err := newsub.NextMsg(50 * time.Millisecond); err != nil {
+			return err
+		}
+		return nil
+	})
+
+	// And so is the removal of interest.
+	newsub.Unsubscribe()
+	ncb.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		gwz, _ := sa.Gatewayz(&GatewayzOptions{Accounts: true})
+		if n := gwz.OutboundGateways["B"].Accounts[0].TotalSubscriptions; n != 2 {
+			return fmt.Errorf("Expected 2 subscriptions, got %v", n)
+		}
+		return nil
+	})
+}
+
+func TestGatewayQueueSubsPreferLocalCluster(t *testing.T) {
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nca := natsConnect(t, sa)
+	defer nca.Close()
+	ncb := natsConnect(t, sb)
+	defer ncb.Close()
+
+	qsuba, _ := nca.QueueSubscribeSync("foo", "bar")
+	nca.Flush()
+	qsubb, _ := ncb.QueueSubscribeSync("foo", "bar")
+	ncb.Flush()
+
+	// Messages published in A should all go to the queue subscriber in A.
+	for i := 0; i < 10; i++ {
+		nca.Publish("foo", []byte("hello"))
+	}
+	nca.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n, _, _ := qsuba.Pending(); n != 10 {
+			return fmt.Errorf("Expected 10 messages in A, got %v", n)
+		}
+		return nil
+	})
+	time.Sleep(50 * time.Millisecond)
+	if n, _, _ := qsubb.Pending(); n != 0 {
+		t.Fatalf("Expected no message in B, got %v", n)
+	}
+
+	// Without a queue subscriber in A, messages go to B.
+	qsuba.Unsubscribe()
+	nca.Flush()
+	for i := 0; i < 10; i++ {
+		nca.Publish("foo", []byte("hello"))
+	}
+	nca.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n, _, _ := qsubb.Pending(); n != 10 {
+			return fmt.Errorf("Expected 10 messages in B, got %v", n)
+		}
+		return nil
+	})
+}
+
+func TestGatewayReconnect(t *testing.T) {
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+
+	ob := sb.getOpts()
+	sb.Shutdown()
+	checkNumOutboundGateways(t, sa, 0)
+	checkNumInboundGateways(t, sa, 0)
+
+	// Restart on the same gateway port and make sure we reconnect.
+	ob.Port = -1
+	ob.Cluster.Port = -1
+	ob.HTTPPort = -1
+	sb = RunServer(ob)
+	defer sb.Shutdown()
+
+	checkNumOutboundGateways(t, sa, 1)
+	checkNumInboundGateways(t, sa, 1)
+}
+
+func TestGatewayWrongName(t *testing.T) {
+	sb := RunServer(testDefaultOptionsForGateway("B"))
+	defer sb.Shutdown()
+
+	// Configured to connect to "C", but this is "B".
+	sa := RunServer(testGatewayOptionsFromToWithServers(t, "A", "C", sb))
+	defer sa.Shutdown()
+
+	time.Sleep(100 * time.Millisecond)
+	checkNumOutboundGateways(t, sa, 0)
+}
+
+func TestGatewayClientOnGatewayPort(t *testing.T) {
+	s := RunServer(testDefaultOptionsForGateway("A"))
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(fmt.Sprintf("nats://%s", s.GatewayAddr())); err == nil {
+		t.Fatalf("Expected client connection to the gateway port to fail")
+	}
+}
+
+func TestGatewayAuthorization(t *testing.T) {
+	ob := testDefaultOptionsForGateway("B")
+	ob.Gateway.Username = "gw"
+	ob.Gateway.Password = "pwd"
+	sb := RunServer(ob)
+	defer sb.Shutdown()
+
+	// Bad credentials.
+	oa := testGatewayOptionsFromToWithServers(t, "A", "B", sb)
+	oa.Gateway.Gateways[0].URLs[0].User = url.UserPassword("gw", "wrong")
+	sa := RunServer(oa)
+	time.Sleep(100 * time.Millisecond)
+	checkNumInboundGateways(t, sb, 0)
+	sa.Shutdown()
+
+	// Good credentials.
+	oa = testGatewayOptionsFromToWithServers(t, "A", "B", sb)
+	oa.Gateway.Gateways[0].URLs[0].User = url.UserPassword("gw", "pwd")
+	sa = RunServer(oa)
+	defer sa.Shutdown()
+	checkNumInboundGateways(t, sb, 1)
+}
+
+func TestGatewayz(t *testing.T) {
+	sa, sb := runGatewayPair(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/gatewayz?accs=1", sa.MonitorAddr().Port)
+	body := readBody(t, url)
+	gwz := &Gatewayz{}
+	if err := json.Unmarshal(body, gwz); err != nil {
+		t.Fatalf("Got an error unmarshalling the body: %v\n", err)
+	}
+	if gwz.Name != "A" {
+		t.Fatalf("Expected name A, got %q", gwz.Name)
+	}
+	rgw := gwz.OutboundGateways["B"]
+	if rgw == nil || !rgw.IsConfigured || rgw.Connection == nil || rgw.Connection.RemoteID != sb.ID() {
+		t.Fatalf("Unexpected outbound gateway: %+v", rgw)
+	}
+	if rgws := gwz.InboundGateways["B"]; len(rgws) != 1 || rgws[0].Connection.RemoteID != sb.ID() {
+		t.Fatalf("Unexpected inbound gateways: %+v", gwz.InboundGateways)
+	}
+
+	// Filter by name.
+	url = fmt.Sprintf("http://127.0.0.1:%d/gatewayz?gw_name=C", sa.MonitorAddr().Port)
+	body = readBody(t, url)
+	gwz = &Gatewayz{}
+	if err := json.Unmarshal(body, gwz); err != nil {
+		t.Fatalf("Got an error unmarshalling the body: %v\n", err)
+	}
+	if len(gwz.OutboundGateways) != 0 || len(gwz.InboundGateways) != 0 {
+		t.Fatalf("Expected no gateway, got %+v", gwz)
+	}
+}
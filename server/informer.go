@@ -0,0 +1,723 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Delays between the attempts of a failed delivery, doubled after
+	// each failure.
+	informerRetryMin = 250 * time.Millisecond
+	informerRetryMax = 30 * time.Second
+)
+
+const (
+	// Timeout of a delivery to an informer.
+	informerRequestTimeout = 2 * time.Second
+
+	// Maximum size of a registration request.
+	informerMaxRegisterSize = 4096
+
+	// Prefix of the URLs of informers that are published to, instead of
+	// posted to.
+	informerNATSScheme = "nats://"
+
+	// Size of the events of an INFO protocol sending clients to a
+	// route, small enough for the control line limit.
+	routeNodesMaxSize = MAX_CONTROL_LINE_SIZE / 2
+
+	// Maximum length of the client supplied fields of a node, so that a
+	// node fits in a route INFO protocol.
+	informerMaxFieldLen = 256
+
+	// Header with the HMAC-SHA256 of the body, keyed with the informer
+	// secret, of registrations and deliveries.
+	InformerSignatureHeader = "X-Nats-Signature"
+
+	// Types of InformerPayload.
+	InformerResync = "resync"
+	InformerDelta  = "delta"
+
+	// Operations of InformerEvent.
+	InformerNodeAdd = "add"
+	InformerNodeDel = "del"
+)
+
+// InformerOpts are the options of the informers, endpoints that are sent
+// the clients of the cluster when they connect or disconnect.
+type InformerOpts struct {
+	// Informers always registered, http(s) URLs to post to or nats URLs
+	// of a subject to publish to.
+	URLs []string `json:"urls,omitempty"`
+	// File the registrations made with /reg_informer are saved to.
+	StateFile string `json:"state_file,omitempty"`
+	// Shared secret that registrations and deliveries are signed with.
+	Secret string `json:"-"`
+	// Maximum number of payloads waiting for delivery to an informer.
+	QueueSize int `json:"queue_size,omitempty"`
+	// Time during which connect and disconnect events are collected
+	// into a single payload.
+	BatchWindow time.Duration `json:"batch_window,omitempty"`
+}
+
+// InformerPayload is the body of a delivery to an informer. Each informer
+// gets its own sequence, incremented by one for every payload. A resync
+// carries all the clients and replaces what the informer knew, a
+// delta carries the events since the previous payload. Informers are
+// resynced when registered, and after a gap in the sequence, which happens
+// when they fall more than the queue size behind.
+type InformerPayload struct {
+	Seq    uint64              `json:"seq"`
+	Type   string              `json:"type"`
+	Nodes  map[string][]*CNode `json:"nodes,omitempty"`
+	Events []*InformerEvent    `json:"events,omitempty"`
+}
+
+// InformerEvent is a client that connected ("add"), or disconnected
+// ("del").
+type InformerEvent struct {
+	Op   string `json:"op"`
+	Name string `json:"name"`
+	Node *CNode `json:"node"`
+}
+
+// informerNode is a client known to the informers, of this server or
+// of a route peer.
+type informerNode struct {
+	name string
+	node *CNode
+}
+
+// informer delivers the queued payloads, in order, to its URL. When the
+// queue is full, it is emptied and the informer resynced.
+type informer struct {
+	url    string
+	static bool
+	max    int
+	seq    uint64
+
+	// Subject and account of a nats URL, and the client publishing to it,
+	// only used by the delivery loop.
+	subject string
+	account string
+	client  *client
+
+	mu      sync.Mutex
+	queue   [][]byte
+	dropped uint64
+
+	signal chan struct{}
+	quit   chan struct{}
+}
+
+// informerState is the content of the state file.
+type informerState struct {
+	Informers []string `json:"informers"`
+}
+
+// enqueue adds the payload to the queue and wakes up the delivery loop.
+// When the queue is full, it is emptied instead and false is returned: the
+// informer missed payloads and needs a resync.
+func (inf *informer) enqueue(b []byte) bool {
+	inf.mu.Lock()
+	if len(inf.queue) >= inf.max {
+		for i := range inf.queue {
+			inf.queue[i] = nil
+		}
+		inf.dropped += uint64(len(inf.queue))
+		inf.queue = inf.queue[:0]
+		inf.mu.Unlock()
+		return false
+	}
+	inf.queue = append(inf.queue, b)
+	inf.mu.Unlock()
+
+	select {
+	case inf.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next removes and returns the oldest payload, nil if there is none.
+func (inf *informer) next() []byte {
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+	if len(inf.queue) == 0 {
+		return nil
+	}
+	b := inf.queue[0]
+	inf.queue[0] = nil
+	inf.queue = inf.queue[1:]
+	return b
+}
+
+// signInformerPayload returns the value of the signature header of the body.
+func signInformerPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validInformerURL returns an error if the informer can not be delivered to.
+func validInformerURL(u string) error {
+	if strings.HasPrefix(u, informerNATSScheme) {
+		_, _, err := parseInformerSubject(u)
+		return err
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return fmt.Errorf("unsupported informer url scheme %q", pu.Scheme)
+	}
+	return nil
+}
+
+// parseInformerSubject returns the subject and account of a nats URL, such
+// as nats://informer.nodes?account=APP. The account defaults to the global
+// one.
+func parseInformerSubject(u string) (string, string, error) {
+	subject, query := strings.TrimPrefix(u, informerNATSScheme), _EMPTY_
+	if i := strings.IndexByte(subject, '?'); i >= 0 {
+		subject, query = subject[:i], subject[i+1:]
+	}
+	if !IsValidLiteralSubject(subject) {
+		return _EMPTY_, _EMPTY_, fmt.Errorf("invalid informer subject %q", subject)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return _EMPTY_, _EMPTY_, err
+	}
+	account := globalAccountName
+	for k, v := range values {
+		if k != "account" || len(v) != 1 || v[0] == _EMPTY_ {
+			return _EMPTY_, _EMPTY_, fmt.Errorf("invalid informer url parameter %q", k)
+		}
+		account = v[0]
+	}
+	return subject, account, nil
+}
+
+// startInformers registers the informers of the configuration and the ones
+// saved in the state file.
+func (s *Server) startInformers() {
+	opts := s.getOpts()
+	s.informersMu.Lock()
+	defer s.informersMu.Unlock()
+
+	for _, u := range opts.Informer.URLs {
+		s.addInformerLocked(u, true)
+	}
+	if opts.Informer.StateFile == _EMPTY_ {
+		return
+	}
+	b, err := ioutil.ReadFile(opts.Informer.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.Errorf("Error reading informers state file: %v", err)
+		}
+		return
+	}
+	var state informerState
+	if err := json.Unmarshal(b, &state); err != nil {
+		s.Errorf("Error parsing informers state file: %v", err)
+		return
+	}
+	for _, u := range state.Informers {
+		if err := validInformerURL(u); err != nil {
+			s.Warnf("Ignoring informer %q: %v", u, err)
+			continue
+		}
+		s.addInformerLocked(u, false)
+	}
+}
+
+// addInformer registers the informer, saving it to the state file. An
+// informer registered again is resynced.
+func (s *Server) addInformer(u string) error {
+	s.informersMu.Lock()
+	defer s.informersMu.Unlock()
+
+	// The pending events are part of the resync, deliver them to the
+	// other informers first.
+	s.flushInformerEventsLocked()
+	inf, added := s.addInformerLocked(u, false)
+	if !added {
+		s.resyncInformerLocked(inf)
+		return nil
+	}
+	return s.saveInformersLocked()
+}
+
+// addInformerLocked registers the informer if not already, starts its
+// delivery loop and queues its first resync. Returns the informer and
+// whether it was added.
+// Lock should be held.
+func (s *Server) addInformerLocked(u string, static bool) (*informer, bool) {
+	if inf := s.informers[u]; inf != nil {
+		inf.static = inf.static || static
+		return inf, false
+	}
+	if s.informers == nil {
+		s.informers = make(map[string]*informer)
+	}
+	opts := s.getOpts()
+	inf := &informer{
+		url:    u,
+		static: static,
+		max:    opts.Informer.QueueSize,
+		signal: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+	if inf.max <= 0 {
+		inf.max = DEFAULT_INFORMER_QUEUE_SIZE
+	}
+	if strings.HasPrefix(u, informerNATSScheme) {
+		inf.subject, inf.account, _ = parseInformerSubject(u)
+	}
+	s.informers[u] = inf
+	s.startGoRoutine(func() { s.informerLoop(inf) })
+	s.resyncInformerLocked(inf)
+	return inf, true
+}
+
+// removeInformer unregisters the informer, which can not be one of the
+// configuration.
+func (s *Server) removeInformer(u string) error {
+	s.informersMu.Lock()
+	defer s.informersMu.Unlock()
+	inf := s.informers[u]
+	if inf == nil {
+		return nil
+	}
+	if inf.static {
+		return fmt.Errorf("informer %q is declared in the configuration", u)
+	}
+	close(inf.quit)
+	delete(s.informers, u)
+	return s.saveInformersLocked()
+}
+
+// saveInformersLocked writes the registered informers to the state file.
+// Lock should be held.
+func (s *Server) saveInformersLocked() error {
+	file := s.getOpts().Informer.StateFile
+	if file == _EMPTY_ {
+		return nil
+	}
+	state := informerState{Informers: make([]string, 0, len(s.informers))}
+	for u, inf := range s.informers {
+		if !inf.static {
+			state.Informers = append(state.Informers, u)
+		}
+	}
+	sort.Strings(state.Informers)
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file at once so that it is never partially written.
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// getInformers returns the URLs of the registered informers.
+func (s *Server) getInformers() []string {
+	s.informersMu.Lock()
+	defer s.informersMu.Unlock()
+	informers := make([]string, 0, len(s.informers))
+	for u := range s.informers {
+		informers = append(informers, u)
+	}
+	sort.Strings(informers)
+	return informers
+}
+
+// informerClientConnected records the client for the informers.
+func (s *Server) informerClientConnected(c *client) {
+	name, node := clientNode(c)
+	if node == nil {
+		return
+	}
+	s.addInformerNode(c, name, node)
+}
+
+// addInformerNode records the node of the client, unless the client was
+// closed in the meantime. Its removal may then have run already, so the
+// node would never be removed.
+func (s *Server) addInformerNode(c *client, name string, node *CNode) {
+	s.informersMu.Lock()
+	c.mu.Lock()
+	closed := c.nc == nil
+	c.mu.Unlock()
+	if closed {
+		s.informersMu.Unlock()
+		return
+	}
+	if s.informerNodes == nil {
+		s.informerNodes = make(map[uint64]*informerNode)
+	}
+	s.informerNodes[c.cid] = &informerNode{name: name, node: node}
+	s.addInformerEventLocked(&InformerEvent{Op: InformerNodeAdd, Name: name, Node: node}, true)
+	s.informersMu.Unlock()
+}
+
+// informerClientDisconnected removes the client, if recorded, for the
+// informers.
+func (s *Server) informerClientDisconnected(cid uint64) {
+	s.informersMu.Lock()
+	if n := s.informerNodes[cid]; n != nil {
+		delete(s.informerNodes, cid)
+		s.addInformerEventLocked(&InformerEvent{Op: InformerNodeDel, Name: n.name, Node: n.node}, true)
+	}
+	s.informersMu.Unlock()
+}
+
+// addInformerEventLocked adds the event to the batch, which is delivered
+// once the batch window of its first event ends. Events of local clients
+// are also sent to the routes.
+// Lock should be held.
+func (s *Server) addInformerEventLocked(e *InformerEvent, local bool) {
+	if len(s.informers) > 0 {
+		s.informerBatch = append(s.informerBatch, e)
+	}
+	if local && s.NumRoutes() > 0 {
+		s.informerRouteBatch = append(s.informerRouteBatch, e)
+	}
+	if s.informerTimer == nil && (len(s.informerBatch) > 0 || len(s.informerRouteBatch) > 0) {
+		s.informerTimer = time.AfterFunc(s.getOpts().Informer.BatchWindow, s.flushInformerEvents)
+	}
+}
+
+// flushInformerEvents queues the batched events for delivery.
+func (s *Server) flushInformerEvents() {
+	s.informersMu.Lock()
+	s.flushInformerEventsLocked()
+	s.informersMu.Unlock()
+}
+
+// flushInformerEventsLocked queues the batched events for delivery.
+// Lock should be held.
+func (s *Server) flushInformerEventsLocked() {
+	if s.informerTimer != nil {
+		s.informerTimer.Stop()
+		s.informerTimer = nil
+	}
+	events, routeEvents := s.informerBatch, s.informerRouteBatch
+	s.informerBatch, s.informerRouteBatch = nil, nil
+	if len(events) > 0 {
+		for _, inf := range s.informers {
+			s.sendToInformerLocked(inf, &InformerPayload{Type: InformerDelta, Events: events})
+		}
+	}
+	if len(routeEvents) > 0 {
+		s.mu.Lock()
+		routes := make([]*client, 0, len(s.routes))
+		for _, r := range s.routes {
+			routes = append(routes, r)
+		}
+		s.mu.Unlock()
+		protos := s.routeNodesProtos(InformerDelta, routeEvents)
+		for _, r := range routes {
+			r.mu.Lock()
+			if r.route != nil && r.route.shareNodes {
+				for _, proto := range protos {
+					r.sendProto(proto, false)
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// resyncInformerLocked queues all the clients for the informer.
+// Lock should be held.
+func (s *Server) resyncInformerLocked(inf *informer) {
+	nodes := make(map[string][]*CNode)
+	for _, n := range s.informerNodes {
+		nodes[n.name] = append(nodes[n.name], n.node)
+	}
+	for _, rnodes := range s.remoteNodes {
+		for _, n := range rnodes {
+			nodes[n.name] = append(nodes[n.name], n.node)
+		}
+	}
+	s.sendToInformerLocked(inf, &InformerPayload{Type: InformerResync, Nodes: nodes})
+}
+
+// sendToInformerLocked queues the payload with the next sequence of the
+// informer. An informer whose queue is full is resynced instead.
+// Lock should be held.
+func (s *Server) sendToInformerLocked(inf *informer, p *InformerPayload) {
+	inf.seq++
+	p.Seq = inf.seq
+	body, err := json.Marshal(p)
+	if err != nil {
+		s.Errorf("Error marshaling informer payload: %v", err)
+		return
+	}
+	if !inf.enqueue(body) {
+		s.Warnf("Informer %q is more than %d payloads behind, resyncing", inf.url, inf.max)
+		s.resyncInformerLocked(inf)
+	}
+}
+
+// sendNodesToRoute sends the clients of this server to a new route.
+// The route then gets the events of the following batches.
+func (s *Server) sendNodesToRoute(c *client) {
+	s.informersMu.Lock()
+	defer s.informersMu.Unlock()
+
+	// The route knows none of our clients until told otherwise.
+	if len(s.informerNodes) == 0 {
+		return
+	}
+	// The pending events are part of the resync.
+	s.flushInformerEventsLocked()
+	events := make([]*InformerEvent, 0, len(s.informerNodes))
+	for _, n := range s.informerNodes {
+		events = append(events, &InformerEvent{Op: InformerNodeAdd, Name: n.name, Node: n.node})
+	}
+	protos := s.routeNodesProtos(InformerResync, events)
+	c.mu.Lock()
+	if c.route != nil && c.route.shareNodes {
+		for _, proto := range protos {
+			c.sendProto(proto, false)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// routeNodesProtos returns the INFO protocols sending the events to a route,
+// as many as needed to stay within the control line limit. Only the first
+// one has the given type.
+func (s *Server) routeNodesProtos(typ string, events []*InformerEvent) [][]byte {
+	var protos [][]byte
+	p, size := &InformerPayload{Type: typ}, 0
+	add := func() {
+		b, _ := marshalRouteNodes(&Info{ID: s.info.ID, Nodes: p})
+		protos = append(protos, []byte(fmt.Sprintf(InfoProto, b)))
+		p, size = &InformerPayload{Type: InformerDelta}, 0
+	}
+	for _, e := range events {
+		b, _ := marshalRouteNodes(e)
+		if len(b) > routeNodesMaxSize {
+			s.Warnf("Not sending client %q to routes, %d bytes is over the limit of %d", e.Name, len(b), routeNodesMaxSize)
+			continue
+		}
+		if size > 0 && size+len(b) > routeNodesMaxSize {
+			add()
+		}
+		p.Events = append(p.Events, e)
+		size += len(b) + 1
+	}
+	if len(p.Events) > 0 {
+		add()
+	}
+	return protos
+}
+
+// marshalRouteNodes marshals v without escaping HTML characters, which
+// would make a line of them six times longer.
+func marshalRouteNodes(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// processRouteNodes applies the clients sent by a route peer, and
+// passes them on to the informers.
+func (s *Server) processRouteNodes(id string, p *InformerPayload) {
+	s.informersMu.Lock()
+	defer s.informersMu.Unlock()
+
+	if p.Type == InformerResync {
+		s.removeRouteNodesLocked(id)
+	}
+	nodes := s.remoteNodes[id]
+	for _, e := range p.Events {
+		if e == nil || e.Node == nil {
+			continue
+		}
+		e.Node.Server = id
+		switch e.Op {
+		case InformerNodeAdd:
+			nodes = append(nodes, &informerNode{name: e.Name, node: e.Node})
+		case InformerNodeDel:
+			i := 0
+			for ; i < len(nodes); i++ {
+				if nodes[i].name == e.Name && nodes[i].node.CID == e.Node.CID {
+					break
+				}
+			}
+			if i == len(nodes) {
+				continue
+			}
+			e.Node = nodes[i].node
+			nodes[i] = nodes[len(nodes)-1]
+			nodes[len(nodes)-1] = nil
+			nodes = nodes[:len(nodes)-1]
+		default:
+			continue
+		}
+		s.addInformerEventLocked(e, false)
+	}
+	if len(nodes) == 0 {
+		delete(s.remoteNodes, id)
+		return
+	}
+	if s.remoteNodes == nil {
+		s.remoteNodes = make(map[string][]*informerNode)
+	}
+	s.remoteNodes[id] = nodes
+}
+
+// removeRouteNodes forgets the clients of a route peer that is gone.
+func (s *Server) removeRouteNodes(id string) {
+	s.informersMu.Lock()
+	s.removeRouteNodesLocked(id)
+	s.informersMu.Unlock()
+}
+
+// removeRouteNodesLocked forgets the clients of a route peer.
+// Lock should be held.
+func (s *Server) removeRouteNodesLocked(id string) {
+	for _, n := range s.remoteNodes[id] {
+		s.addInformerEventLocked(&InformerEvent{Op: InformerNodeDel, Name: n.name, Node: n.node}, false)
+	}
+	delete(s.remoteNodes, id)
+}
+
+// informerLoop delivers the payloads queued for the informer until it is
+// removed or the server shuts down.
+func (s *Server) informerLoop(inf *informer) {
+	defer s.grWG.Done()
+
+	for {
+		select {
+		case <-inf.signal:
+		case <-inf.quit:
+			return
+		case <-s.quitCh:
+			return
+		}
+		for body := inf.next(); body != nil; body = inf.next() {
+			if !s.deliverToInformer(inf, body) {
+				return
+			}
+		}
+	}
+}
+
+// deliverToInformer posts the payload until it is accepted, backing off
+// between attempts. Returns false if the informer or the server stopped.
+func (s *Server) deliverToInformer(inf *informer, body []byte) bool {
+	delay := informerRetryMin
+	for attempt := 1; ; attempt++ {
+		var err error
+		if inf.subject != _EMPTY_ {
+			err = s.publishToInformer(inf, body)
+		} else {
+			err = s.postToInformer(inf.url, body)
+		}
+		if err == nil {
+			return true
+		}
+		if attempt == 1 {
+			s.Warnf("Error delivering to informer %q, retrying: %v", inf.url, err)
+		} else {
+			s.Debugf("Error delivering to informer %q (attempt %d): %v", inf.url, attempt, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-inf.quit:
+			return false
+		case <-s.quitCh:
+			return false
+		}
+		if delay *= 2; delay > informerRetryMax {
+			delay = informerRetryMax
+		}
+	}
+}
+
+// postToInformer does a single delivery of the payload.
+func (s *Server) postToInformer(u string, body []byte) error {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := s.getOpts().Informer.Secret; secret != _EMPTY_ {
+		req.Header.Set(InformerSignatureHeader, signInformerPayload(secret, body))
+	}
+	hc := &http.Client{Timeout: informerRequestTimeout}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, informerMaxRegisterSize))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("informer returned %v", resp.Status)
+	}
+	return nil
+}
+
+// publishToInformer publishes the payload to the subject of the informer, as
+// if from a client of its account.
+func (s *Server) publishToInformer(inf *informer, body []byte) error {
+	acc := s.LookupAccount(inf.account)
+	if acc == nil {
+		return fmt.Errorf("account %q not found", inf.account)
+	}
+	if inf.client == nil || inf.client.acc != acc {
+		inf.client = &client{srv: s, typ: SYSTEM, acc: acc}
+		inf.client.initClient()
+	}
+	c := inf.client
+	c.pa.subject = []byte(inf.subject)
+	c.pa.size = len(body)
+	c.pa.szb = []byte(strconv.Itoa(len(body)))
+	c.processInboundClientMsg(append(body, _CRLF_...))
+	c.flushClients()
+	c.pa.subject, c.pa.szb = nil, nil
+	return nil
+}
@@ -0,0 +1,731 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// testInformer records the deliveries it accepts, failing the first ones
+// if asked to.
+type testInformer struct {
+	*httptest.Server
+	secret   string
+	failures int32
+	attempts int32
+	bodies   chan []byte
+}
+
+func newTestInformer(t *testing.T, secret string, failures int32) *testInformer {
+	ti := &testInformer{secret: secret, failures: failures, bodies: make(chan []byte, 100)}
+	ti.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if n := atomic.AddInt32(&ti.attempts, 1); n <= ti.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if ti.secret != _EMPTY_ && r.Header.Get(InformerSignatureHeader) != signInformerPayload(ti.secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ti.bodies <- body
+	}))
+	return ti
+}
+
+func (ti *testInformer) next(t *testing.T) *InformerPayload {
+	t.Helper()
+	select {
+	case body := <-ti.bodies:
+		p := &InformerPayload{}
+		if err := json.Unmarshal(body, p); err != nil {
+			t.Fatalf("Error unmarshaling delivery: %v", err)
+		}
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timeout waiting for a delivery")
+	}
+	return nil
+}
+
+func regInformer(s *Server, secret, opt, u string) (int, error) {
+	body, _ := json.Marshal(&CInformerInfo{Url: u, Opt: opt})
+	req, _ := http.NewRequest("POST", fmt.Sprintf("http://127.0.0.1:%d%s", s.MonitorAddr().Port, RegInformerPath), bytes.NewReader(body))
+	if secret != _EMPTY_ {
+		req.Header.Set(InformerSignatureHeader, signInformerPayload(secret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestInformerSignedDeliveries(t *testing.T) {
+	ti := newTestInformer(t, "s3cr3t", 0)
+	defer ti.Close()
+
+	opts := DefaultMonitorOptions()
+	opts.Informer.Secret = "s3cr3t"
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	// Registrations must be signed.
+	if code, err := regInformer(s, _EMPTY_, "add", ti.URL); err != nil || code != http.StatusUnauthorized {
+		t.Fatalf("Expected unsigned registration to be rejected, got %v %v", code, err)
+	}
+	if code, err := regInformer(s, "wrong", "add", ti.URL); err != nil || code != http.StatusUnauthorized {
+		t.Fatalf("Expected badly signed registration to be rejected, got %v %v", code, err)
+	}
+	if code, err := regInformer(s, "s3cr3t", "add", "ftp://example.com"); err != nil || code != http.StatusBadRequest {
+		t.Fatalf("Expected invalid url to be rejected, got %v %v", code, err)
+	}
+	if code, err := regInformer(s, "s3cr3t", "add", ti.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	if informers := s.getInformers(); !reflect.DeepEqual(informers, []string{ti.URL}) {
+		t.Fatalf("Unexpected informers: %v", informers)
+	}
+
+	// The current clients are delivered on registration.
+	if p := ti.next(t); p.Type != InformerResync || p.Seq != 1 || len(p.Nodes) != 0 {
+		t.Fatalf("Unexpected payload: %+v", p)
+	}
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", opts.Port), nats.Name("node1"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	nc.Flush()
+	nc.Close()
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		for {
+			select {
+			case body := <-ti.bodies:
+				if strings.Contains(string(body), "node1") {
+					return nil
+				}
+			default:
+				return fmt.Errorf("node1 not delivered")
+			}
+		}
+	})
+	if n := atomic.LoadInt32(&ti.attempts); n == 0 {
+		t.Fatalf("Expected deliveries")
+	}
+}
+
+func TestInformerRetry(t *testing.T) {
+	defer func(d time.Duration) { informerRetryMin = d }(informerRetryMin)
+	informerRetryMin = 10 * time.Millisecond
+
+	ti := newTestInformer(t, _EMPTY_, 3)
+	defer ti.Close()
+
+	s := runMonitorServer()
+	defer s.Shutdown()
+
+	if code, err := regInformer(s, _EMPTY_, "add", ti.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	ti.next(t)
+	if n := atomic.LoadInt32(&ti.attempts); n != 4 {
+		t.Fatalf("Expected 4 attempts, got %d", n)
+	}
+}
+
+func TestInformerClientClosedWhileConnecting(t *testing.T) {
+	s := runMonitorServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port), nats.Name("node1"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+	nc.Flush()
+
+	var c *client
+	s.mu.Lock()
+	for _, cl := range s.clients {
+		c = cl
+	}
+	s.mu.Unlock()
+
+	// The client is closed, and removed, after its node is made but
+	// before it is recorded.
+	name, node := clientNode(c)
+	if node == nil {
+		t.Fatalf("Expected a node for the client")
+	}
+	c.closeConnection(ClientClosed)
+	checkClientsCount(t, s, 0)
+	s.addInformerNode(c, name, node)
+
+	s.informersMu.Lock()
+	n := len(s.informerNodes)
+	s.informersMu.Unlock()
+	if n != 0 {
+		t.Fatalf("Expected no nodes, got %d", n)
+	}
+}
+
+func TestInformerQueueBounded(t *testing.T) {
+	inf := &informer{max: 2, signal: make(chan struct{}, 1)}
+	for i := 0; i < 2; i++ {
+		if !inf.enqueue([]byte{byte(i)}) {
+			t.Fatalf("Expected payload %d to be queued", i)
+		}
+	}
+	if inf.enqueue([]byte{2}) {
+		t.Fatalf("Expected full queue")
+	}
+	if inf.dropped != 2 {
+		t.Fatalf("Expected 2 dropped payloads, got %d", inf.dropped)
+	}
+	if b := inf.next(); b != nil {
+		t.Fatalf("Expected empty queue, got %v", b)
+	}
+}
+
+func TestInformerDeltas(t *testing.T) {
+	ti := newTestInformer(t, _EMPTY_, 0)
+	defer ti.Close()
+
+	opts := DefaultMonitorOptions()
+	opts.Informer.BatchWindow = 500 * time.Millisecond
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://127.0.0.1:%d", opts.Port)
+	nc1, err := nats.Connect(url, nats.Name("node1"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc1.Close()
+	nc1.Flush()
+
+	if code, err := regInformer(s, _EMPTY_, "add", ti.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	p := ti.next(t)
+	if p.Type != InformerResync || p.Seq != 1 || len(p.Nodes["node1"]) != 1 || p.Nodes["node1"][0].IP != "127.0.0.1" {
+		t.Fatalf("Unexpected resync: %+v", p)
+	}
+
+	// A burst of events within the window is a single delta.
+	var ncs []*nats.Conn
+	for i := 0; i < 3; i++ {
+		nc, err := nats.Connect(url, nats.Name(fmt.Sprintf("node%d", i+2)))
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		nc.Flush()
+		ncs = append(ncs, nc)
+	}
+	nc1.Close()
+
+	p = ti.next(t)
+	if p.Type != InformerDelta || p.Seq != 2 || len(p.Events) != 4 {
+		t.Fatalf("Unexpected delta: %+v", p)
+	}
+	ops := make(map[string]string)
+	for _, e := range p.Events {
+		if e.Node == nil || e.Node.IP != "127.0.0.1" {
+			t.Fatalf("Unexpected event: %+v", e)
+		}
+		ops[e.Name] = e.Op
+	}
+	expected := map[string]string{"node1": InformerNodeDel, "node2": InformerNodeAdd, "node3": InformerNodeAdd, "node4": InformerNodeAdd}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("Expected events %v, got %v", expected, ops)
+	}
+
+	for _, nc := range ncs {
+		nc.Close()
+	}
+	p = ti.next(t)
+	if p.Type != InformerDelta || p.Seq != 3 || len(p.Events) != 3 {
+		t.Fatalf("Unexpected delta: %+v", p)
+	}
+
+	// Registering again resyncs.
+	if code, err := regInformer(s, _EMPTY_, "add", ti.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	if p = ti.next(t); p.Type != InformerResync || p.Seq != 4 || len(p.Nodes) != 0 {
+		t.Fatalf("Unexpected resync: %+v", p)
+	}
+}
+
+func TestInformerResyncAfterGap(t *testing.T) {
+	s := RunServer(DefaultMonitorOptions())
+	defer s.Shutdown()
+
+	// An informer that is not delivered to, so that its queue fills up.
+	inf := &informer{url: "http://127.0.0.1:1", max: 2, signal: make(chan struct{}, 1)}
+	s.informersMu.Lock()
+	s.informers = map[string]*informer{inf.url: inf}
+	s.informerNodes = map[uint64]*informerNode{1: {name: "node1", node: &CNode{IP: "10.0.0.1"}}}
+	s.resyncInformerLocked(inf)
+	s.sendToInformerLocked(inf, &InformerPayload{Type: InformerDelta})
+	s.sendToInformerLocked(inf, &InformerPayload{Type: InformerDelta})
+	s.informersMu.Unlock()
+
+	b := inf.next()
+	if inf.next() != nil {
+		t.Fatalf("Expected a single payload")
+	}
+	var p InformerPayload
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatalf("Error unmarshaling payload: %v", err)
+	}
+	// Sequence 3 was dropped, and the informer sees the gap.
+	if p.Type != InformerResync || p.Seq != 4 || len(p.Nodes["node1"]) != 1 {
+		t.Fatalf("Unexpected payload: %+v", p)
+	}
+}
+
+func TestInformerStateFile(t *testing.T) {
+	static := newTestInformer(t, _EMPTY_, 0)
+	defer static.Close()
+	dynamic := newTestInformer(t, _EMPTY_, 0)
+	defer dynamic.Close()
+
+	dir, err := ioutil.TempDir("", "informer")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "informers.json")
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		http: "127.0.0.1:-1"
+		informer {
+			urls: [%q]
+			state_file: %q
+		}
+	`, static.URL, stateFile)))
+	defer os.Remove(conf)
+
+	s, _ := RunServerWithConfig(conf)
+	if code, err := regInformer(s, _EMPTY_, "add", dynamic.URL); err != nil || code != http.StatusOK {
+		s.Shutdown()
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	// Informers of the configuration can not be removed.
+	if code, err := regInformer(s, _EMPTY_, "del", static.URL); err != nil || code != http.StatusBadRequest {
+		s.Shutdown()
+		t.Fatalf("Expected removal to fail, got %v %v", code, err)
+	}
+	s.Shutdown()
+
+	// Only the registered informer is saved.
+	b, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Error reading state file: %v", err)
+	}
+	var state informerState
+	if err := json.Unmarshal(b, &state); err != nil || !reflect.DeepEqual(state.Informers, []string{dynamic.URL}) {
+		t.Fatalf("Unexpected state %q: %v", b, err)
+	}
+
+	// And registered again on restart.
+	s, _ = RunServerWithConfig(conf)
+	defer s.Shutdown()
+	expected := []string{static.URL, dynamic.URL}
+	if expected[0] > expected[1] {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if informers := s.getInformers(); !reflect.DeepEqual(informers, expected) {
+		t.Fatalf("Expected informers %v, got %v", expected, informers)
+	}
+
+	if code, err := regInformer(s, _EMPTY_, "del", dynamic.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected removal to succeed, got %v %v", code, err)
+	}
+	if informers := s.getInformers(); !reflect.DeepEqual(informers, []string{static.URL}) {
+		t.Fatalf("Unexpected informers: %v", informers)
+	}
+	b, _ = ioutil.ReadFile(stateFile)
+	if err := json.Unmarshal(b, &state); err != nil || len(state.Informers) != 0 {
+		t.Fatalf("Unexpected state %q: %v", b, err)
+	}
+}
+
+func TestInformerConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		informer {
+			urls: "http://127.0.0.1:8080/hook"
+			state_file: "informers.json"
+			secret: "s3cr3t"
+			queue_size: 10
+			batch_window: "50ms"
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	expected := InformerOpts{
+		URLs:        []string{"http://127.0.0.1:8080/hook"},
+		StateFile:   "informers.json",
+		Secret:      "s3cr3t",
+		QueueSize:   10,
+		BatchWindow: 50 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(opts.Informer, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, opts.Informer)
+	}
+
+	for _, test := range []struct {
+		conf string
+		err  string
+	}{
+		{`informer { urls: ["ftp://127.0.0.1"] }`, "unsupported informer url scheme"},
+		{`informer { urls: ["nats://informer.*"] }`, "invalid informer subject"},
+		{`informer { urls: ["nats://informer.nodes?acc=A"] }`, "invalid informer url parameter"},
+		{`informer { batch_window: "soon" }`, "Invalid informer batch_window"},
+		{`informer { bad: 1 }`, "unknown field"},
+	} {
+		conf := createConfFile(t, []byte(test.conf))
+		_, err := ProcessConfigFile(conf)
+		os.Remove(conf)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expected error %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestInformerCluster(t *testing.T) {
+	ti := newTestInformer(t, _EMPTY_, 0)
+	defer ti.Close()
+
+	optsA := DefaultMonitorOptions()
+	optsA.Cluster.Host = "127.0.0.1"
+	optsA.Cluster.Port = -1
+	optsA.Informer.BatchWindow = 10 * time.Millisecond
+	srvA := RunServer(optsA)
+	defer srvA.Shutdown()
+
+	urlA := fmt.Sprintf("nats://127.0.0.1:%d", optsA.Port)
+	a1, err := nats.Connect(urlA, nats.Name("a1"), nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer a1.Close()
+	a1.Flush()
+
+	optsB := DefaultMonitorOptions()
+	optsB.Cluster.Host = "127.0.0.1"
+	optsB.Cluster.Port = -1
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", srvA.ClusterAddr().Port))
+	optsB.Informer.BatchWindow = 10 * time.Millisecond
+	srvB := RunServer(optsB)
+	defer srvB.Shutdown()
+	checkClusterFormed(t, srvA, srvB)
+
+	checkNodes := func(s *Server, expected map[string]string) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+			got := make(map[string]string)
+			n, _ := s.Nodes(nil)
+			for name, nodes := range n.Nodes {
+				for _, n := range nodes {
+					got[name] = n.Server
+				}
+			}
+			if !reflect.DeepEqual(got, expected) {
+				return fmt.Errorf("Expected nodes %v, got %v", expected, got)
+			}
+			return nil
+		})
+	}
+	// The clients of A are sent to B when the route connects.
+	checkNodes(srvB, map[string]string{"a1": srvA.ID()})
+
+	// An informer registered on B gets the clients of the cluster.
+	if code, err := regInformer(srvB, _EMPTY_, "add", ti.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	if p := ti.next(t); p.Type != InformerResync || len(p.Nodes["a1"]) != 1 || p.Nodes["a1"][0].Server != srvA.ID() {
+		t.Fatalf("Unexpected resync: %+v", p)
+	}
+
+	b1, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", optsB.Port), nats.Name("b1"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer b1.Close()
+	b1.Flush()
+	a2, err := nats.Connect(urlA, nats.Name("a2"), nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	a2.Flush()
+
+	expected := map[string]string{"a1": srvA.ID(), "a2": srvA.ID(), "b1": srvB.ID()}
+	checkNodes(srvA, expected)
+	checkNodes(srvB, expected)
+
+	// The events are delivered with the server of the client.
+	events := func(n int) map[string]string {
+		t.Helper()
+		got := make(map[string]string)
+		for len(got) < n {
+			p := ti.next(t)
+			if p.Type != InformerDelta {
+				t.Fatalf("Unexpected payload: %+v", p)
+			}
+			for _, e := range p.Events {
+				got[e.Op+" "+e.Name] = e.Node.Server
+			}
+		}
+		return got
+	}
+	if got := events(2); !reflect.DeepEqual(got, map[string]string{"add a2": srvA.ID(), "add b1": srvB.ID()}) {
+		t.Fatalf("Unexpected events: %v", got)
+	}
+
+	a2.Close()
+	checkNodes(srvB, map[string]string{"a1": srvA.ID(), "b1": srvB.ID()})
+	if got := events(1); !reflect.DeepEqual(got, map[string]string{"del a2": srvA.ID()}) {
+		t.Fatalf("Unexpected events: %v", got)
+	}
+
+	// The clients of a server that is gone are removed.
+	srvA.Shutdown()
+	checkNodes(srvB, map[string]string{"b1": srvB.ID()})
+	if got := events(1); !reflect.DeepEqual(got, map[string]string{"del a1": srvA.ID()}) {
+		t.Fatalf("Unexpected events: %v", got)
+	}
+}
+
+func TestInformerRouteNodesProtos(t *testing.T) {
+	s := &Server{info: Info{ID: "SRV"}}
+	var events []*InformerEvent
+	for i := 0; i < 200; i++ {
+		events = append(events, &InformerEvent{Op: InformerNodeAdd, Name: fmt.Sprintf("node%d", i), Node: &CNode{IP: "10.0.0.1"}})
+	}
+	protos := s.routeNodesProtos(InformerResync, events)
+	if len(protos) < 2 {
+		t.Fatalf("Expected the events to be split, got %d protocols", len(protos))
+	}
+	n := 0
+	for i, proto := range protos {
+		if len(proto) > MAX_CONTROL_LINE_SIZE {
+			t.Fatalf("Protocol %d is too big: %d", i, len(proto))
+		}
+		var info Info
+		if err := json.Unmarshal(bytes.TrimSuffix(bytes.TrimPrefix(proto, []byte("INFO ")), []byte(CR_LF)), &info); err != nil {
+			t.Fatalf("Error unmarshaling protocol: %v", err)
+		}
+		expected := InformerDelta
+		if i == 0 {
+			expected = InformerResync
+		}
+		if info.ID != "SRV" || info.Nodes == nil || info.Nodes.Type != expected {
+			t.Fatalf("Unexpected protocol %d: %s", i, proto)
+		}
+		n += len(info.Nodes.Events)
+	}
+	if n != len(events) {
+		t.Fatalf("Expected %d events, got %d", len(events), n)
+	}
+}
+
+func TestInformerRouteOversizedName(t *testing.T) {
+	optsA := DefaultMonitorOptions()
+	optsA.Cluster.Host = "127.0.0.1"
+	optsA.Cluster.Port = -1
+	optsA.Informer.BatchWindow = 10 * time.Millisecond
+	srvA := RunServer(optsA)
+	defer srvA.Shutdown()
+
+	optsB := DefaultMonitorOptions()
+	optsB.Cluster.Host = "127.0.0.1"
+	optsB.Cluster.Port = -1
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", srvA.ClusterAddr().Port))
+	optsB.Informer.BatchWindow = 10 * time.Millisecond
+	srvB := RunServer(optsB)
+	defer srvB.Shutdown()
+	checkClusterFormed(t, srvA, srvB)
+
+	// Each '<' would be escaped to 6 bytes by default.
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", optsA.Port))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+	long := strings.Repeat("<", 2000)
+	fmt.Fprintf(conn, "CONNECT {\"verbose\":false,\"name\":%q,\"lang\":%q}\r\nPING\r\n", long, long)
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		if strings.HasPrefix(line, "PONG") {
+			break
+		}
+	}
+
+	name := strings.Repeat("<", informerMaxFieldLen)
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		n, _ := srvB.Nodes(nil)
+		if nodes := n.Nodes[name]; len(nodes) != 1 || nodes[0].Lang != name {
+			return fmt.Errorf("Expected truncated node, got %+v", n.Nodes)
+		}
+		return nil
+	})
+	// The route is still up.
+	time.Sleep(100 * time.Millisecond)
+	checkClusterFormed(t, srvA, srvB)
+
+	// A node that can't fit is not sent at all.
+	events := []*InformerEvent{
+		{Op: InformerNodeAdd, Name: strings.Repeat("\x01", 1000), Node: &CNode{}},
+		{Op: InformerNodeAdd, Name: "ok", Node: &CNode{}},
+	}
+	protos := srvA.routeNodesProtos(InformerDelta, events)
+	if len(protos) != 1 || len(protos[0]) > MAX_CONTROL_LINE_SIZE || !bytes.Contains(protos[0], []byte(`"ok"`)) {
+		t.Fatalf("Unexpected protocols: %q", protos)
+	}
+	if bytes.Contains(protos[0], []byte(`\u0001`)) {
+		t.Fatalf("Expected oversized node to be skipped: %q", protos[0])
+	}
+}
+
+func TestInformerNATS(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		http: "127.0.0.1:-1"
+		accounts {
+			A { users [{user: a, password: pwd}] }
+			B { users [{user: b, password: pwd}] }
+		}
+		informer {
+			urls: ["nats://informer.nodes?account=A"]
+			batch_window: 10
+		}
+	`))
+	defer os.Remove(conf)
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://127.0.0.1:%d", opts.Port)
+	subscribe := func(user, subject string) (*nats.Conn, chan *nats.Msg) {
+		t.Helper()
+		nc, err := nats.Connect(url, nats.UserInfo(user, "pwd"))
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		ch := make(chan *nats.Msg, 10)
+		if _, err := nc.ChanSubscribe(subject, ch); err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		nc.Flush()
+		return nc, ch
+	}
+	// Returns the next payload on the subject, keeping the ones received
+	// on other subjects for later.
+	pending := make(map[string][]*InformerPayload)
+	next := func(ch chan *nats.Msg, subject string) *InformerPayload {
+		t.Helper()
+		for len(pending[subject]) == 0 {
+			select {
+			case m := <-ch:
+				p := &InformerPayload{}
+				if err := json.Unmarshal(m.Data, p); err != nil {
+					t.Fatalf("Error unmarshaling payload: %v", err)
+				}
+				pending[m.Subject] = append(pending[m.Subject], p)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timeout waiting for a payload on %q", subject)
+			}
+		}
+		p := pending[subject][0]
+		pending[subject] = pending[subject][1:]
+		return p
+	}
+
+	sa, cha := subscribe("a", "informer.>")
+	defer sa.Close()
+	sb, chb := subscribe("b", "informer.>")
+	defer sb.Close()
+
+	// The subscribers are in a delta of the informer of the configuration.
+	if p := next(cha, "informer.nodes"); p.Type != InformerDelta || p.Seq < 2 {
+		t.Fatalf("Unexpected payload: %+v", p)
+	}
+
+	// Informers can also be registered with a subject.
+	if code, err := regInformer(s, _EMPTY_, "add", "nats://informer.other?account=A"); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %v %v", code, err)
+	}
+	if code, err := regInformer(s, _EMPTY_, "add", "nats://informer.*"); err != nil || code != http.StatusBadRequest {
+		t.Fatalf("Expected registration to fail, got %v %v", code, err)
+	}
+	if p := next(cha, "informer.other"); p.Type != InformerResync || p.Seq != 1 || len(p.Nodes[""]) != 2 {
+		t.Fatalf("Unexpected payload: %+v", p)
+	}
+
+	// Both get the same events.
+	nc, err := nats.Connect(url, nats.UserInfo("a", "pwd"), nats.Name("node1"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	nc.Flush()
+	nc.Close()
+	for _, subject := range []string{"informer.nodes", "informer.other"} {
+		for {
+			p := next(cha, subject)
+			if p.Type != InformerDelta || len(p.Events) == 0 {
+				t.Fatalf("Unexpected payload: %+v", p)
+			}
+			if e := p.Events[0]; e.Name == "node1" {
+				if e.Op != InformerNodeAdd || e.Node.Account != "A" {
+					t.Fatalf("Unexpected event: %+v", e)
+				}
+				break
+			}
+		}
+	}
+
+	// Nothing is published to the other accounts.
+	select {
+	case m := <-chb:
+		t.Fatalf("Unexpected payload in account B: %s", m.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -14,12 +14,18 @@
 package server
 
 import (
+	"bufio"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -239,6 +245,113 @@ func TestJWTUserExpiresAfterConnect(t *testing.T) {
 	}
 }
 
+// encodeAccountRevocations will encode the account claims with the given
+// revocations added to them.
+func encodeAccountRevocations(t *testing.T, ac *jwt.AccountClaims, kp nkeys.KeyPair, revocations map[string]int64) string {
+	t.Helper()
+	ajwt, err := ac.Encode(kp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	chunks := strings.Split(ajwt, ".")
+	data, _ := base64.RawStdEncoding.DecodeString(chunks[1])
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatalf("Error decoding account JWT: %v", err)
+	}
+	claims["nats"].(map[string]interface{})["revocations"] = revocations
+	data, _ = json.Marshal(claims)
+	payload := base64.RawStdEncoding.EncodeToString(data)
+	sig, err := kp.Sign([]byte(payload))
+	if err != nil {
+		t.Fatalf("Error signing account JWT: %v", err)
+	}
+	return fmt.Sprintf("%s.%s.%s", chunks[0], payload, base64.RawStdEncoding.EncodeToString(sig))
+}
+
+func TestJWTUserRevocation(t *testing.T) {
+	s := opTrustBasicSetup()
+	defer s.Shutdown()
+	buildMemAccResolver(s)
+
+	okp, _ := nkeys.FromSeed(oSeed)
+
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	nac := jwt.NewAccountClaims(string(apub))
+	ajwt, err := nac.Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	addAccountToMemResolver(s, string(apub), ajwt)
+
+	connect := func(nkp nkeys.KeyPair) *bufio.Reader {
+		t.Helper()
+		pub, _ := nkp.PublicKey()
+		ujwt, err := jwt.NewUserClaims(string(pub)).Encode(akp)
+		if err != nil {
+			t.Fatalf("Error generating user JWT: %v", err)
+		}
+		c, cr, l := newClientForServer(s)
+		var info nonceInfo
+		json.Unmarshal([]byte(l[5:]), &info)
+		sigraw, _ := nkp.Sign([]byte(info.Nonce))
+		sig := base64.StdEncoding.EncodeToString(sigraw)
+		cs := fmt.Sprintf("CONNECT {\"jwt\":%q,\"sig\":\"%s\",\"verbose\":true,\"pedantic\":true}\r\nPING\r\n", ujwt, sig)
+		go c.parse([]byte(cs))
+		return cr
+	}
+	expectOK := func(cr *bufio.Reader) {
+		t.Helper()
+		if l, _ := cr.ReadString('\n'); !strings.HasPrefix(l, "+OK") {
+			t.Fatalf("Expected an OK, got: %v", l)
+		}
+		if l, _ := cr.ReadString('\n'); !strings.HasPrefix(l, "PONG") {
+			t.Fatalf("Expected a PONG, got: %v", l)
+		}
+	}
+	revokedKP, _ := nkeys.CreateUser()
+	revokedPub, _ := revokedKP.PublicKey()
+	cr := connect(revokedKP)
+	expectOK(cr)
+	otherKP, _ := nkeys.CreateUser()
+	ocr := connect(otherKP)
+	expectOK(ocr)
+
+	// Revoke the user, the live connection should be closed. Keep reading
+	// so that the error sent before closing does not block on the pipe.
+	go io.Copy(ioutil.Discard, cr)
+	ajwt = encodeAccountRevocations(t, nac, okp, map[string]int64{string(revokedPub): time.Now().Unix()})
+	addAccountToMemResolver(s, string(apub), ajwt)
+	acc := s.LookupAccount(string(apub))
+	s.mu.Lock()
+	err = s.updateAccountWithClaimJWT(acc, ajwt)
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Error updating account: %v", err)
+	}
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		cz, _ := s.Connz(&ConnzOptions{State: ConnClosed})
+		if len(cz.Conns) != 1 || cz.Conns[0].Reason != Revocation.String() {
+			return fmt.Errorf("Expected the revoked connection to be closed, got %+v", cz.Conns)
+		}
+		if n := acc.NumClients(); n != 1 {
+			return fmt.Errorf("Expected 1 client, got %d", n)
+		}
+		return nil
+	})
+
+	// The user can not connect again with the revoked JWT.
+	cr = connect(revokedKP)
+	if l, _ := cr.ReadString('\n'); !strings.HasPrefix(l, "-ERR ") {
+		t.Fatalf("Expected an error, got: %v", l)
+	}
+
+	// The other user is not affected.
+	ocr = connect(otherKP)
+	expectOK(ocr)
+}
+
 func TestJWTUserPermissionClaims(t *testing.T) {
 	nkp, _ := nkeys.CreateUser()
 	pub, _ := nkp.PublicKey()
@@ -1400,3 +1513,335 @@ func TestJWTAccountLimitsMaxConns(t *testing.T) {
 	// Now this one should fail.
 	newClient("-ERR ")
 }
+
+func TestJWTAccountDirResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldInterval := dirAccResolverPollInterval
+	dirAccResolverPollInterval = 20 * time.Millisecond
+	defer func() { dirAccResolverPollInterval = oldInterval }()
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+
+	fooKP, _ := nkeys.CreateAccount()
+	fooPub, _ := fooKP.PublicKey()
+	fooFile := filepath.Join(dir, string(fooPub)+".jwt")
+	writeAccountJWT := func(subs int64, mtime time.Time) {
+		t.Helper()
+		fooAC := jwt.NewAccountClaims(string(fooPub))
+		fooAC.Limits.Subs = subs
+		fooJWT, err := fooAC.Encode(okp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		if err := ioutil.WriteFile(fooFile, []byte(fooJWT), 0644); err != nil {
+			t.Fatalf("Error writing account JWT: %v", err)
+		}
+		// Make sure the change is detected even with a coarse mtime resolution.
+		os.Chtimes(fooFile, mtime, mtime)
+	}
+	now := time.Now()
+	writeAccountJWT(10, now)
+
+	dr, err := NewDirAccResolver(dir)
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.TrustedNkeys = []string{string(opub)}
+	opts.AccountResolver = dr
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	// Unknown and invalid keys are reported as missing.
+	for _, pub := range []string{"ABCD", "../" + string(fooPub)} {
+		if _, err := dr.Fetch(pub); err != ErrMissingAccount {
+			t.Fatalf("Expected missing account for %q, got %v", pub, err)
+		}
+	}
+
+	fooAcc := s.LookupAccount(string(fooPub))
+	if fooAcc == nil {
+		t.Fatalf("Expected the account to be loaded from the directory")
+	}
+	checkMaxSubs := func(expected int) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+			fooAcc.mu.RLock()
+			msubs := fooAcc.msubs
+			fooAcc.mu.RUnlock()
+			if msubs != expected {
+				return fmt.Errorf("Expected account to have msubs of %d, got %d", expected, msubs)
+			}
+			return nil
+		})
+	}
+	checkMaxSubs(10)
+
+	// Update the JWT, the account should pick it up.
+	writeAccountJWT(20, now.Add(time.Minute))
+	checkMaxSubs(20)
+}
+
+func TestJWTAccountDirResolverLowersMaxConns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldInterval := dirAccResolverPollInterval
+	dirAccResolverPollInterval = 20 * time.Millisecond
+	defer func() { dirAccResolverPollInterval = oldInterval }()
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+
+	fooKP, _ := nkeys.CreateAccount()
+	fooPub, _ := fooKP.PublicKey()
+	fooFile := filepath.Join(dir, string(fooPub)+".jwt")
+	writeAccountJWT := func(conns int64, mtime time.Time) {
+		t.Helper()
+		fooAC := jwt.NewAccountClaims(string(fooPub))
+		fooAC.Limits.Conn = conns
+		fooJWT, err := fooAC.Encode(okp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		if err := ioutil.WriteFile(fooFile, []byte(fooJWT), 0644); err != nil {
+			t.Fatalf("Error writing account JWT: %v", err)
+		}
+		os.Chtimes(fooFile, mtime, mtime)
+	}
+	now := time.Now()
+	writeAccountJWT(10, now)
+
+	dr, err := NewDirAccResolver(dir)
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.TrustedNkeys = []string{string(opub)}
+	opts.AccountResolver = dr
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	fooAcc := s.LookupAccount(string(fooPub))
+	if fooAcc == nil {
+		t.Fatalf("Expected the account to be loaded from the directory")
+	}
+	for i := 0; i < 4; i++ {
+		c, cr, _ := newClientForServer(s)
+		go io.Copy(ioutil.Discard, cr)
+		if err := c.registerWithAccount(fooAcc); err != nil {
+			t.Fatalf("Error registering client: %v", err)
+		}
+	}
+
+	// Lowering the limit in the file closes the connections over it.
+	writeAccountJWT(2, now.Add(time.Minute))
+	checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+		if n := fooAcc.NumClients(); n != 2 {
+			return fmt.Errorf("Expected 2 clients, got %d", n)
+		}
+		return nil
+	})
+}
+
+func TestJWTAccountURLResolver(t *testing.T) {
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+
+	var mu sync.Mutex
+	jwts := make(map[string]string)
+	requests := 0
+	addAccount := func() string {
+		t.Helper()
+		kp, _ := nkeys.CreateAccount()
+		pub, _ := kp.PublicKey()
+		ajwt, err := jwt.NewAccountClaims(string(pub)).Encode(okp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		mu.Lock()
+		jwts[string(pub)] = ajwt
+		mu.Unlock()
+		return string(pub)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		ajwt, ok := jwts[strings.TrimPrefix(r.URL.Path, "/accounts/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(ajwt))
+	}))
+	defer ts.Close()
+	getRequests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	ur, err := NewURLAccResolver(ts.URL+"/accounts", time.Second, 100*time.Millisecond, 2)
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.TrustedNkeys = []string{string(opub)}
+	opts.AccountResolver = ur
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	fooPub := addAccount()
+	if acc := s.LookupAccount(fooPub); acc == nil {
+		t.Fatalf("Expected the account to be fetched from the URL")
+	}
+
+	// Second fetch is served from the cache.
+	if _, err := ur.Fetch(fooPub); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := getRequests(); n != 1 {
+		t.Fatalf("Expected 1 request, got %d", n)
+	}
+
+	// Unknown accounts are reported as missing.
+	if _, err := ur.Fetch(string(opub[:len(opub)-1]) + "A"); err != ErrMissingAccount {
+		t.Fatalf("Expected missing account, got %v", err)
+	}
+	barKP, _ := nkeys.CreateAccount()
+	barPub, _ := barKP.PublicKey()
+	if _, err := ur.Fetch(string(barPub)); err != ErrMissingAccount {
+		t.Fatalf("Expected missing account, got %v", err)
+	}
+
+	// Once expired, the entry is fetched again.
+	time.Sleep(150 * time.Millisecond)
+	if _, err := ur.Fetch(fooPub); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := getRequests(); n != 3 {
+		t.Fatalf("Expected 3 requests, got %d", n)
+	}
+
+	// The cache is bounded, the least recently used entry is evicted.
+	addAccount()
+	for i := 0; i < 2; i++ {
+		if _, err := ur.Fetch(addAccount()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	ur.mu.Lock()
+	_, cached := ur.cache[fooPub]
+	size := ur.lru.Len()
+	ur.mu.Unlock()
+	if cached || size != 2 {
+		t.Fatalf("Expected the account to be evicted and 2 entries, got %v and %d", cached, size)
+	}
+
+	// When the service is down, the stale entry is still returned.
+	lastPub := addAccount()
+	if _, err := ur.Fetch(lastPub); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ts.Close()
+	time.Sleep(150 * time.Millisecond)
+	mu.Lock()
+	expected := jwts[lastPub]
+	mu.Unlock()
+	if ajwt, err := ur.Fetch(lastPub); err != nil || ajwt != expected {
+		t.Fatalf("Expected the stale entry to be returned, got %v", err)
+	}
+	if _, err := ur.Fetch(fooPub); err == nil {
+		t.Fatalf("Expected an error for an account not in the cache")
+	}
+
+	v, err := s.Varz(nil)
+	if err != nil {
+		t.Fatalf("Error getting varz: %v", err)
+	}
+	rs := v.ResolverStats
+	if rs == nil {
+		t.Fatalf("Expected resolver stats in varz")
+	}
+	if rs.StaleHits != 1 || rs.Failures != 3 || rs.CacheHits < 1 || rs.CacheSize != 2 {
+		t.Fatalf("Unexpected resolver stats: %+v", rs)
+	}
+	if rs.MaxLatency <= 0 || rs.AvgLatency <= 0 || rs.AvgLatency > rs.MaxLatency {
+		t.Fatalf("Unexpected resolver latencies: %+v", rs)
+	}
+}
+
+func TestJWTAccountURLResolverRefresh(t *testing.T) {
+	oldInterval := urlAccResolverRefreshInterval
+	urlAccResolverRefreshInterval = 20 * time.Millisecond
+	defer func() { urlAccResolverRefreshInterval = oldInterval }()
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+
+	fooKP, _ := nkeys.CreateAccount()
+	fooPub, _ := fooKP.PublicKey()
+	var mu sync.Mutex
+	var fooJWT string
+	setAccountJWT := func(subs int64) {
+		t.Helper()
+		ac := jwt.NewAccountClaims(string(fooPub))
+		ac.Limits.Subs = subs
+		ajwt, err := ac.Encode(okp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		mu.Lock()
+		fooJWT = ajwt
+		mu.Unlock()
+	}
+	setAccountJWT(10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(fooJWT))
+	}))
+	defer ts.Close()
+
+	ur, err := NewURLAccResolver(ts.URL+"/accounts", time.Second, 100*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.TrustedNkeys = []string{string(opub)}
+	opts.AccountResolver = ur
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	fooAcc := s.LookupAccount(string(fooPub))
+	if fooAcc == nil {
+		t.Fatalf("Expected the account to be fetched from the URL")
+	}
+	checkMaxSubs := func(expected int) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+			fooAcc.mu.RLock()
+			msubs := fooAcc.msubs
+			fooAcc.mu.RUnlock()
+			if msubs != expected {
+				return fmt.Errorf("Expected account to have msubs of %d, got %d", expected, msubs)
+			}
+			return nil
+		})
+	}
+	checkMaxSubs(10)
+
+	// Once the ttl has passed, the registered account picks up the update.
+	setAccountJWT(20)
+	checkMaxSubs(20)
+}
@@ -0,0 +1,472 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The subset of LDAP (RFC 4511) needed to bind as a user and read its
+// groups. Messages are BER encoded, which is parsed by hand since
+// directories do not always use the minimal length encoding that
+// encoding/asn1 requires.
+
+// BER identifiers of the LDAP protocol elements.
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchResultEntry = 0x64
+	ldapSearchResultDone  = 0x65
+	ldapSearchResultRef   = 0x73
+
+	ldapAuthSimple    = 0x80
+	ldapFilterEqual   = 0xa3
+	ldapFilterPresent = 0x87
+
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+
+	// Maximum size of a message read from the directory.
+	ldapMaxMessageSize = 1024 * 1024
+
+	// Defaults of the LDAP directory.
+	ldapDefaultTimeout         = 5 * time.Second
+	ldapDefaultGroupAttribute  = "memberOf"
+	ldapDefaultMemberAttribute = "member"
+)
+
+var errLDAPInvalidCredentials = errors.New("invalid credentials")
+
+// LDAPDirectory is a UserDirectory that checks passwords with a simple bind
+// to an LDAP server, as the DN built from UserDN and the username. The
+// groups are the values of GroupAttribute of the user's entry, or if
+// GroupBaseDN is set, the DNs of the entries below it that list the user's
+// DN in MemberAttribute.
+type LDAPDirectory struct {
+	// ldap:// or ldaps:// URL of the server.
+	URL string
+	// Template of the user DN, with %s replaced by the escaped username.
+	UserDN          string
+	GroupAttribute  string
+	GroupBaseDN     string
+	MemberAttribute string
+	TLSConfig       *tls.Config
+	Timeout         time.Duration
+}
+
+// Authenticate implements UserDirectory.
+func (l *LDAPDirectory) Authenticate(user, password string) ([]string, error) {
+	// An empty password is an unauthenticated bind, which succeeds.
+	if user == _EMPTY_ || password == _EMPTY_ {
+		return nil, errLDAPInvalidCredentials
+	}
+	lc, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer lc.close()
+
+	dn := fmt.Sprintf(l.UserDN, ldapEscapeDN(user))
+	if err := lc.bind(dn, password); err != nil {
+		return nil, err
+	}
+	if l.GroupBaseDN != _EMPTY_ {
+		member := l.MemberAttribute
+		if member == _EMPTY_ {
+			member = ldapDefaultMemberAttribute
+		}
+		filter := berEncode(ldapFilterEqual, berString(berOctetString, member), berString(berOctetString, dn))
+		entries, err := lc.search(l.GroupBaseDN, ldapScopeSubtree, filter, "1.1")
+		if err != nil {
+			return nil, err
+		}
+		groups := make([]string, 0, len(entries))
+		for _, e := range entries {
+			groups = append(groups, e.dn)
+		}
+		return groups, nil
+	}
+	attr := l.GroupAttribute
+	if attr == _EMPTY_ {
+		attr = ldapDefaultGroupAttribute
+	}
+	entries, err := lc.search(dn, ldapScopeBase, berString(ldapFilterPresent, "objectClass"), attr)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, e := range entries {
+		for name, vals := range e.attrs {
+			if strings.EqualFold(name, attr) {
+				groups = append(groups, vals...)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// ldapConn is a connection to the directory, used for a single
+// authentication.
+type ldapConn struct {
+	nc  net.Conn
+	br  *bufio.Reader
+	mid int64
+}
+
+// ldapEntry is an entry returned by a search.
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+func (l *LDAPDirectory) dial() (*ldapConn, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = ldapDefaultTimeout
+	}
+	host := u.Host
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == _EMPTY_ {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		nc, err = net.DialTimeout("tcp", host, timeout)
+	case "ldaps":
+		if u.Port() == _EMPTY_ {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		config := &tls.Config{}
+		if l.TLSConfig != nil {
+			config = l.TLSConfig.Clone()
+		}
+		if config.ServerName == _EMPTY_ {
+			config.ServerName = u.Hostname()
+		}
+		nc, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, config)
+	default:
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The whole exchange is bounded by the timeout.
+	nc.SetDeadline(time.Now().Add(timeout))
+	return &ldapConn{nc: nc, br: bufio.NewReader(nc)}, nil
+}
+
+func (lc *ldapConn) close() {
+	lc.send(berEncode(ldapUnbindRequest))
+	lc.nc.Close()
+}
+
+// send writes the LDAP message with the protocol operation.
+func (lc *ldapConn) send(op []byte) error {
+	lc.mid++
+	_, err := lc.nc.Write(berEncode(berSequence, berInt(berInteger, lc.mid), op))
+	return err
+}
+
+// recv reads the next message for the last request and returns the tag and
+// content of its protocol operation.
+func (lc *ldapConn) recv() (byte, []byte, error) {
+	for {
+		tag, msg, err := berReadElement(lc.br)
+		if err != nil {
+			return 0, nil, err
+		}
+		if tag != berSequence {
+			return 0, nil, fmt.Errorf("unexpected LDAP message tag 0x%x", tag)
+		}
+		tag, id, rest, err := berParse(msg)
+		if err != nil || tag != berInteger {
+			return 0, nil, fmt.Errorf("invalid LDAP message id")
+		}
+		if berDecodeInt(id) != lc.mid {
+			// Unsolicited notification, such as a notice of disconnection.
+			continue
+		}
+		tag, op, _, err := berParse(rest)
+		return tag, op, err
+	}
+}
+
+// bind does a simple bind.
+func (lc *ldapConn) bind(dn, password string) error {
+	req := berEncode(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapAuthSimple, password))
+	if err := lc.send(req); err != nil {
+		return err
+	}
+	tag, op, err := lc.recv()
+	if err != nil {
+		return err
+	}
+	if tag != ldapBindResponse {
+		return fmt.Errorf("unexpected LDAP response tag 0x%x", tag)
+	}
+	return ldapResult(op)
+}
+
+// search returns the entries matching the filter, with the attributes.
+func (lc *ldapConn) search(base string, scope int64, filter []byte, attrs ...string) ([]*ldapEntry, error) {
+	var al [][]byte
+	for _, a := range attrs {
+		al = append(al, berString(berOctetString, a))
+	}
+	req := berEncode(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 0),    // no size limit
+		berInt(berInteger, 0),    // no time limit
+		berEncode(berBoolean, []byte{0}),
+		filter,
+		berEncode(berSequence, al...))
+	if err := lc.send(req); err != nil {
+		return nil, err
+	}
+	var entries []*ldapEntry
+	for {
+		tag, op, err := lc.recv()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case ldapSearchResultEntry:
+			e, err := ldapParseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case ldapSearchResultRef:
+			// Referrals to other servers are not followed.
+		case ldapSearchResultDone:
+			return entries, ldapResult(op)
+		default:
+			return nil, fmt.Errorf("unexpected LDAP response tag 0x%x", tag)
+		}
+	}
+}
+
+// ldapResult returns the error of an LDAPResult, if any.
+func ldapResult(b []byte) error {
+	tag, code, rest, err := berParse(b)
+	if err != nil || tag != berEnumerated {
+		return fmt.Errorf("invalid LDAP result")
+	}
+	rc := berDecodeInt(code)
+	if rc == ldapResultSuccess {
+		return nil
+	}
+	if rc == ldapResultInvalidCredentials {
+		return errLDAPInvalidCredentials
+	}
+	// Skip the matched DN to get the diagnostic message.
+	var msg []byte
+	if _, _, rest, err = berParse(rest); err == nil {
+		_, msg, _, _ = berParse(rest)
+	}
+	return fmt.Errorf("LDAP error %d: %s", rc, msg)
+}
+
+func ldapParseEntry(b []byte) (*ldapEntry, error) {
+	_, dn, rest, err := berParse(b)
+	if err != nil {
+		return nil, err
+	}
+	e := &ldapEntry{dn: string(dn), attrs: make(map[string][]string)}
+	_, attrs, _, err := berParse(rest)
+	if err != nil {
+		return nil, err
+	}
+	for len(attrs) > 0 {
+		var attr, name, vals []byte
+		if _, attr, attrs, err = berParse(attrs); err != nil {
+			return nil, err
+		}
+		if _, name, attr, err = berParse(attr); err != nil {
+			return nil, err
+		}
+		if _, vals, _, err = berParse(attr); err != nil {
+			return nil, err
+		}
+		for len(vals) > 0 {
+			var v []byte
+			if _, v, vals, err = berParse(vals); err != nil {
+				return nil, err
+			}
+			e.attrs[string(name)] = append(e.attrs[string(name)], string(v))
+		}
+	}
+	return e, nil
+}
+
+// ldapEscapeDN escapes the special characters of an attribute value of a
+// DN, as described in RFC 4514.
+func ldapEscapeDN(v string) string {
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == 0:
+			sb.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			(c == ' ' || c == '#') && i == 0,
+			c == ' ' && i == len(v)-1:
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// berEncode returns the element with the tag and the concatenated content.
+func berEncode(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	if n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var l []byte
+		for v := n; v > 0; v >>= 8 {
+			l = append([]byte{byte(v)}, l...)
+		}
+		b = append(b, 0x80|byte(len(l)))
+		b = append(b, l...)
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+// berInt encodes a non negative integer.
+func berInt(tag byte, v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	// Keep the value positive.
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berEncode(tag, b)
+}
+
+func berDecodeInt(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+// berParse returns the tag and content of the first element of b, and
+// what follows it.
+func berParse(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	tag, l := b[0], int(b[1])
+	b = b[2:]
+	if l&0x80 != 0 {
+		nl := l & 0x7f
+		if nl == 0 || nl > 4 || len(b) < nl {
+			return 0, nil, nil, fmt.Errorf("invalid BER length")
+		}
+		l = 0
+		for _, c := range b[:nl] {
+			l = l<<8 | int(c)
+		}
+		b = b[nl:]
+	}
+	if l < 0 || l > len(b) {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	return tag, b[:l], b[l:], nil
+}
+
+// berReadElement reads an element from the stream and returns its tag and
+// content.
+func berReadElement(r *bufio.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	l := int(hdr[1])
+	if l&0x80 != 0 {
+		nl := l & 0x7f
+		if nl == 0 || nl > 4 {
+			return 0, nil, fmt.Errorf("invalid BER length")
+		}
+		var lb [4]byte
+		if _, err := io.ReadFull(r, lb[:nl]); err != nil {
+			return 0, nil, err
+		}
+		l = 0
+		for _, c := range lb[:nl] {
+			l = l<<8 | int(c)
+		}
+	}
+	if l < 0 || l > ldapMaxMessageSize {
+		return 0, nil, fmt.Errorf("LDAP message too large: %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], b, nil
+}
@@ -0,0 +1,857 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Leaf node protocol constants
+const (
+	leafSubProto   = "LS+ %s" + _CRLF_
+	leafQSubProto  = "LS+ %s %d" + _CRLF_
+	leafUnsubProto = "LS- %s" + _CRLF_
+)
+
+// leaf holds the state for a leaf node connection. A leaf node connection
+// is always bound to a single account on each side, so unlike routes the
+// protocols exchanged do not carry the account name.
+type leaf struct {
+	// remote is set when we solicited the connection.
+	remote *RemoteLeafOpts
+	// remoteID is the server ID of the other side.
+	remoteID string
+	// smap is the interest we have sent to the other side.
+	smap map[string]int32
+}
+
+// isSolicitedLeafNode returns true if we solicited this leaf node connection.
+// Lock should be held.
+func (c *client) isSolicitedLeafNode() bool {
+	return c.typ == LEAF && c.leaf != nil && c.leaf.remote != nil
+}
+
+// startLeafNodeAcceptLoop will open the leaf node listener
+// and start accepting connections from remote leaf nodes.
+func (s *Server) startLeafNodeAcceptLoop() {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	port := opts.LeafNode.Port
+	if port == -1 {
+		port = 0
+	}
+
+	hp := net.JoinHostPort(opts.LeafNode.Host, strconv.Itoa(port))
+	l, e := net.Listen("tcp", hp)
+	if e != nil {
+		s.Fatalf("Error listening on leafnode port: %d - %v", opts.LeafNode.Port, e)
+		return
+	}
+
+	s.Noticef("Listening for leafnode connections on %s",
+		net.JoinHostPort(opts.LeafNode.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)))
+
+	s.mu.Lock()
+	// If we have selected a random port...
+	if port == 0 {
+		// Write resolved port back to options.
+		opts.LeafNode.Port = l.Addr().(*net.TCPAddr).Port
+	}
+	tlsReq := opts.LeafNode.TLSConfig != nil
+	s.leafNodeInfo = Info{
+		ID:           s.info.ID,
+		Version:      s.info.Version,
+		GoVersion:    runtime.Version(),
+		Host:         opts.LeafNode.Host,
+		Port:         opts.LeafNode.Port,
+		AuthRequired: opts.LeafNode.Username != "",
+		TLSRequired:  tlsReq,
+		TLSVerify:    tlsReq,
+		MaxPayload:   s.info.MaxPayload,
+		Headers:      true,
+	}
+	s.generateLeafNodeInfoJSON()
+	// Setup state that can enable shutdown
+	s.leafNodeListener = l
+	s.mu.Unlock()
+
+	go s.leafNodeAcceptLoop(l)
+}
+
+// Generate the info json for leaf nodes.
+// Lock should be held.
+func (s *Server) generateLeafNodeInfoJSON() {
+	b, _ := json.Marshal(s.leafNodeInfo)
+	pcs := [][]byte{[]byte("INFO"), b, []byte(CR_LF)}
+	s.leafNodeInfoJSON = bytes.Join(pcs, []byte(" "))
+}
+
+func (s *Server) leafNodeAcceptLoop(l net.Listener) {
+	tmpDelay := ACCEPT_MIN_SLEEP
+
+	for s.isRunning() {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.Debugf("Temporary Leafnode Accept Error(%v), sleeping %dms",
+					ne, tmpDelay/time.Millisecond)
+				time.Sleep(tmpDelay)
+				tmpDelay *= 2
+				if tmpDelay > ACCEPT_MAX_SLEEP {
+					tmpDelay = ACCEPT_MAX_SLEEP
+				}
+			} else if s.isRunning() {
+				s.Noticef("Accept error: %v", err)
+			}
+			continue
+		}
+		tmpDelay = ACCEPT_MIN_SLEEP
+		s.startGoRoutine(func() {
+			s.createLeafNode(conn, nil)
+			s.grWG.Done()
+		})
+	}
+	s.Debugf("Leafnode accept loop exiting..")
+	s.done <- true
+}
+
+// solicitLeafNodeRemotes will try to connect to all of our remote leaf node servers.
+func (s *Server) solicitLeafNodeRemotes(remotes []*RemoteLeafOpts) {
+	for _, r := range remotes {
+		remote := r
+		s.startGoRoutine(func() { s.connectToRemoteLeafNode(remote) })
+	}
+}
+
+// connectToRemoteLeafNode will keep trying to connect to the remote
+// server until it succeeds or we are shutdown.
+func (s *Server) connectToRemoteLeafNode(remote *RemoteLeafOpts) {
+	defer s.grWG.Done()
+
+	if remote == nil || remote.URL == nil {
+		s.Errorf("Attempting to connect to a leafnode remote with no URL")
+		return
+	}
+	delay := s.getOpts().LeafNode.ReconnectInterval
+
+	for s.isRunning() {
+		s.Debugf("Trying to connect as leafnode to remote server on %s", remote.URL.Host)
+		conn, err := net.DialTimeout("tcp", remote.URL.Host, DEFAULT_LEAF_NODE_DIAL)
+		if err != nil {
+			s.Debugf("Error trying to connect as leafnode to remote server: %v", err)
+			select {
+			case <-s.quitCh:
+				return
+			case <-time.After(delay):
+				continue
+			}
+		}
+		// We have a connection here to a remote server.
+		// Go ahead and create our leaf node and return.
+		s.createLeafNode(conn, remote)
+		return
+	}
+}
+
+// reConnectLeafNodeIfSolicited will start a reconnect to the remote
+// server if we solicited this leaf node connection and are still running.
+func (s *Server) reConnectLeafNodeIfSolicited(c *client) {
+	c.mu.Lock()
+	var remote *RemoteLeafOpts
+	if c.isSolicitedLeafNode() {
+		remote = c.leaf.remote
+	}
+	c.mu.Unlock()
+
+	if remote == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// It is possible that the server is being shutdown.
+	// If so, don't try to reconnect
+	if !s.running {
+		return
+	}
+	s.startGoRoutine(func() { s.reConnectToRemoteLeafNode(remote) })
+}
+
+func (s *Server) reConnectToRemoteLeafNode(remote *RemoteLeafOpts) {
+	select {
+	case <-time.After(s.getOpts().LeafNode.ReconnectInterval):
+	case <-s.quitCh:
+		s.grWG.Done()
+		return
+	}
+	s.connectToRemoteLeafNode(remote)
+}
+
+func (s *Server) createLeafNode(conn net.Conn, remote *RemoteLeafOpts) *client {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	didSolicit := remote != nil
+	c := &client{srv: s, nc: conn, opts: clientOpts{}, typ: LEAF, leaf: &leaf{remote: remote}}
+
+	// Grab server variables
+	s.mu.Lock()
+	infoJSON := s.leafNodeInfoJSON
+	authRequired := s.leafNodeInfo.AuthRequired
+	s.mu.Unlock()
+
+	// Determine which TLS config to use, if any.
+	var (
+		tlsConfig *tls.Config
+		timeout   float64
+	)
+	if didSolicit {
+		tlsConfig, timeout = remote.TLSConfig, remote.TLSTimeout
+	} else {
+		tlsConfig, timeout = opts.LeafNode.TLSConfig, opts.LeafNode.TLSTimeout
+	}
+	tlsRequired := tlsConfig != nil
+
+	// Grab lock
+	c.mu.Lock()
+
+	// Initialize
+	c.initClient()
+
+	// Check for TLS
+	if tlsRequired {
+		tlsConfig = tlsConfig.Clone()
+
+		// If we solicited, we will act like the client, otherwise the server.
+		if didSolicit {
+			c.Debugf("Starting TLS leafnode client handshake")
+			// Specify the ServerName we are expecting.
+			host, _, _ := net.SplitHostPort(remote.URL.Host)
+			tlsConfig.ServerName = host
+			c.nc = tls.Client(c.nc, tlsConfig)
+		} else {
+			c.Debugf("Starting TLS leafnode server handshake")
+			c.nc = tls.Server(c.nc, tlsConfig)
+		}
+
+		conn := c.nc.(*tls.Conn)
+
+		// Setup the timeout
+		ttl := secondsToDuration(timeout)
+		time.AfterFunc(ttl, func() { tlsTimeout(c, conn) })
+		conn.SetReadDeadline(time.Now().Add(ttl))
+
+		c.mu.Unlock()
+		if err := conn.Handshake(); err != nil {
+			c.Errorf("TLS leafnode handshake error: %v", err)
+			c.sendErr("Secure Connection - TLS Required")
+			c.closeConnection(TLSHandshakeError)
+			return nil
+		}
+		// Reset the read deadline
+		conn.SetReadDeadline(time.Time{})
+
+		// Re-Grab lock
+		c.mu.Lock()
+
+		// Verify that the connection did not go away while we released the lock.
+		if c.nc == nil {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+
+	// Do final client initialization
+
+	// Set the Ping timer
+	c.setPingTimer()
+	c.mu.Unlock()
+
+	// Register with the server. Do this without holding the client
+	// lock so that we respect the server then client lock ordering.
+	s.mu.Lock()
+	running := s.running
+	if running {
+		s.leafs[c.cid] = c
+	}
+	s.mu.Unlock()
+	if !running {
+		c.closeConnection(ServerShutdown)
+		return nil
+	}
+
+	// When we solicited, the account is the one configured for
+	// the remote, otherwise it is bound when processing CONNECT.
+	if didSolicit {
+		acc := s.gacc
+		if remote.LocalAccount != "" {
+			if acc = s.LookupAccount(remote.LocalAccount); acc == nil {
+				c.Errorf("No local account %q for leafnode", remote.LocalAccount)
+				c.closeConnection(MissingAccount)
+				return nil
+			}
+		}
+		c.mu.Lock()
+		c.acc = acc
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	// Check for Auth required state for incoming connections.
+	// Make sure to do this before spinning up readLoop.
+	if authRequired && !didSolicit {
+		ttl := secondsToDuration(opts.LeafNode.AuthTimeout)
+		c.setAuthTimer(ttl)
+	}
+
+	// Spin up the read loop.
+	s.startGoRoutine(func() { c.readLoop() })
+
+	// Spin up the write loop.
+	s.startGoRoutine(c.writeLoop)
+
+	if tlsRequired {
+		c.Debugf("TLS handshake complete")
+		cs := c.nc.(*tls.Conn).ConnectionState()
+		c.Debugf("TLS version %s, cipher suite %s", tlsVersion(cs.Version), tlsCipher(cs.CipherSuite))
+	}
+
+	// Queue Connect proto if we solicited the connection,
+	// otherwise send our info to the other side.
+	if didSolicit {
+		c.Debugf("Leafnode connect msg sent")
+		c.sendLeafConnect(tlsRequired)
+	} else {
+		c.sendInfo(infoJSON)
+	}
+	c.mu.Unlock()
+
+	c.Noticef("Leafnode connection created")
+
+	// For solicited connections we can send our interest right away.
+	if didSolicit {
+		s.registerLeafNode(c)
+	}
+	return c
+}
+
+// Lock should be held entering here.
+func (c *client) sendLeafConnect(tlsRequired bool) {
+	var user, pass string
+	if userInfo := c.leaf.remote.URL.User; userInfo != nil {
+		user = userInfo.Username()
+		pass, _ = userInfo.Password()
+	}
+	cinfo := connectInfo{
+		Echo:     true,
+		Verbose:  false,
+		Pedantic: false,
+		User:     user,
+		Pass:     pass,
+		TLS:      tlsRequired,
+		Name:     c.srv.info.ID,
+		Headers:  true,
+	}
+
+	b, err := json.Marshal(cinfo)
+	if err != nil {
+		c.Errorf("Error marshaling CONNECT to leafnode: %v\n", err)
+		c.closeConnection(ProtocolViolation)
+		return
+	}
+	c.sendProto([]byte(fmt.Sprintf(ConProto, b)), true)
+}
+
+// Process the info message if we are a leaf node.
+func (c *client) processLeafNodeInfo(info *Info) {
+	c.mu.Lock()
+	// Connection can be closed at any time (by auth timeout, etc).
+	// Does not make sense to continue here if connection is gone.
+	if c.leaf == nil || c.nc == nil {
+		c.mu.Unlock()
+		return
+	}
+	s := c.srv
+	c.leaf.remoteID = info.ID
+	c.headers = info.Headers
+	c.mu.Unlock()
+
+	// Detect if we have a mis-configuration and are connecting to ourselves.
+	if s != nil && info.ID == s.info.ID {
+		c.Errorf("Detected leafnode connection to ourselves, closing")
+		c.closeConnection(DuplicateRoute)
+	}
+}
+
+// registerLeafNode will bind the leaf node to its account so that it
+// receives interest updates, and send over our current interest.
+func (s *Server) registerLeafNode(c *client) {
+	c.mu.Lock()
+	acc := c.acc
+	closed := c.nc == nil
+	c.mu.Unlock()
+
+	if acc == nil || closed {
+		return
+	}
+
+	// Add first so that we do not miss any updates while we
+	// collect the current interest below.
+	acc.addLeafNode(c)
+
+	var _subs [4096]*subscription
+	subs := _subs[:0]
+	acc.sl.All(&subs)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nc == nil {
+		return
+	}
+	c.leaf.smap = make(map[string]int32)
+	for _, sub := range subs {
+		// Never send interest back to where it came from.
+		if sub.client == c {
+			continue
+		}
+		c.leaf.smap[keyFromSub(sub)]++
+	}
+	c.sendAllLeafSubs()
+	c.Debugf("Leafnode registered with account %q", acc.Name)
+}
+
+// Sends all of our interest to the leaf node in a single buffer.
+// Lock should be held.
+func (c *client) sendAllLeafSubs() {
+	if len(c.leaf.smap) == 0 {
+		return
+	}
+	var b bytes.Buffer
+	for key, n := range c.leaf.smap {
+		c.writeLeafSub(&b, key, n)
+	}
+	c.queueOutbound(b.Bytes())
+	c.flushSignal()
+}
+
+// updateLeafNodes will update all leaf nodes bound to this account
+// with the change in interest for the given subscription.
+func (a *Account) updateLeafNodes(sub *subscription, delta int32) {
+	if a == nil || sub == nil {
+		return
+	}
+
+	var _leafs [32]*client
+	leafs := _leafs[:0]
+
+	a.mu.RLock()
+	for _, ln := range a.lleafs {
+		// Never send interest back to the leaf node it came from.
+		if ln != sub.client {
+			leafs = append(leafs, ln)
+		}
+	}
+	a.mu.RUnlock()
+
+	for _, ln := range leafs {
+		ln.updateSmap(sub, delta)
+	}
+}
+
+// updateSmap will update our interest map for the leaf node
+// and send the LS+/LS- protocol if needed.
+func (c *client) updateSmap(sub *subscription, delta int32) {
+	key := keyFromSub(sub)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Not registered yet or already closed.
+	if c.leaf == nil || c.leaf.smap == nil || c.nc == nil {
+		return
+	}
+
+	n, ok := c.leaf.smap[key]
+	// Ignore removal of interest we never sent.
+	if !ok && delta < 0 {
+		return
+	}
+	n += delta
+	if n > 0 {
+		c.leaf.smap[key] = n
+	} else {
+		delete(c.leaf.smap, key)
+	}
+
+	// Plain subscriptions only need an update when interest comes and goes,
+	// queue subscriptions always carry their weight.
+	if sub.queue != nil || !ok || n <= 0 {
+		var b bytes.Buffer
+		c.writeLeafSub(&b, key, n)
+		c.sendProto(b.Bytes(), false)
+	}
+}
+
+// writeLeafSub writes the LS+ or LS- protocol for the given key.
+// Lock should be held.
+func (c *client) writeLeafSub(w *bytes.Buffer, key string, n int32) {
+	if key == "" {
+		return
+	}
+	var proto string
+	if n <= 0 {
+		proto = fmt.Sprintf(leafUnsubProto, key)
+	} else if bytes.IndexByte([]byte(key), ' ') > 0 {
+		proto = fmt.Sprintf(leafQSubProto, key, n)
+	} else {
+		proto = fmt.Sprintf(leafSubProto, key)
+	}
+	if c.trace {
+		c.traceOutOp("", []byte(proto[:len(proto)-LEN_CR_LF]))
+	}
+	w.WriteString(proto)
+}
+
+// keyFromSub returns the key used for the leaf node interest map,
+// 'subject' or 'subject<spc>queue' for queue subscribers.
+func keyFromSub(sub *subscription) string {
+	if sub.queue == nil {
+		return string(sub.subject)
+	}
+	return string(sub.subject) + " " + string(sub.queue)
+}
+
+// processLeafSub will process an inbound LS+ from a leaf node.
+func (c *client) processLeafSub(argo []byte) (err error) {
+	c.traceInOp("LS+", argo)
+
+	// Indicate activity.
+	c.in.subs++
+
+	srv := c.srv
+	if srv == nil {
+		return nil
+	}
+
+	// Copy so we do not reference a potentially large buffer
+	arg := make([]byte, len(argo))
+	copy(arg, argo)
+
+	args := splitArg(arg)
+	sub := &subscription{client: c}
+
+	switch len(args) {
+	case 1:
+		sub.queue = nil
+	case 3:
+		sub.queue = args[1]
+		sub.qw = int32(parseSize(args[2]))
+	default:
+		return fmt.Errorf("processLeafSub Parse Error: '%s'", arg)
+	}
+	sub.subject = args[0]
+
+	c.mu.Lock()
+	if c.nc == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	acc := c.acc
+	if acc == nil {
+		c.mu.Unlock()
+		c.Debugf("Leafnode not bound to an account, ignoring subscription on %q", sub.subject)
+		return nil
+	}
+
+	// We store leaf subs by subject and optionally queue name.
+	// If we have a queue it will have a trailing weight which we do not want.
+	if sub.queue != nil {
+		sub.sid = arg[:len(arg)-len(args[2])-1]
+	} else {
+		sub.sid = arg
+	}
+	key := string(sub.sid)
+	osub := c.subs[key]
+	if osub == nil {
+		c.subs[key] = sub
+		// Now place into the account sl.
+		if err = acc.sl.Insert(sub); err != nil {
+			delete(c.subs, key)
+			c.mu.Unlock()
+			c.Errorf("Could not insert subscription: %v", err)
+			c.sendErr("Invalid Subscription")
+			return nil
+		}
+	} else if sub.queue != nil {
+		// For a queue we need to update the weight.
+		atomic.StoreInt32(&osub.qw, sub.qw)
+		acc.sl.UpdateRemoteQSub(osub)
+	}
+	c.mu.Unlock()
+
+	// Interest from a leaf node is treated as local interest, so it is
+	// sent to our routes and any other leaf nodes bound to this account.
+	if osub == nil {
+		srv.updateRouteSubscriptionMap(acc, sub, 1)
+	}
+	return nil
+}
+
+// processLeafUnsub will process an inbound LS- from a leaf node.
+func (c *client) processLeafUnsub(arg []byte) error {
+	c.traceInOp("LS-", arg)
+
+	// Indicate any activity, so pub and sub or unsubs.
+	c.in.subs++
+
+	srv := c.srv
+	if srv == nil {
+		return nil
+	}
+
+	args := splitArg(arg)
+	var key string
+	switch len(args) {
+	case 1:
+		key = string(args[0])
+	case 2:
+		key = string(args[0]) + " " + string(args[1])
+	default:
+		return fmt.Errorf("processLeafUnsub Parse Error: '%s'", arg)
+	}
+
+	c.mu.Lock()
+	if c.nc == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	acc := c.acc
+	sub, ok := c.subs[key]
+	if ok && acc != nil {
+		delete(c.subs, key)
+		acc.sl.Remove(sub)
+	}
+	c.mu.Unlock()
+
+	if ok && acc != nil {
+		srv.updateRouteSubscriptionMap(acc, sub, -1)
+	}
+	return nil
+}
+
+// removeLeafNodeSubs will remove all subscriptions from the leaf node
+// and update our routes and other leaf nodes.
+func (c *client) removeLeafNodeSubs() {
+	c.mu.Lock()
+	srv := c.srv
+	acc := c.acc
+	subs := make([]*subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	c.subs = make(map[string]*subscription)
+	c.mu.Unlock()
+
+	if acc == nil {
+		return
+	}
+
+	// No longer interested in updates for this account.
+	acc.removeLeafNode(c)
+
+	if len(subs) == 0 {
+		return
+	}
+	c.Debugf("Removing %d subscriptions for account %q", len(subs), acc.Name)
+	acc.sl.RemoveBatch(subs)
+	if srv != nil {
+		for _, sub := range subs {
+			srv.updateRouteSubscriptionMap(acc, sub, -1)
+		}
+	}
+}
+
+// processLeafMsgArgs will process the arguments of an inbound LMSG.
+// The format is the same as RMSG minus the account.
+func (c *client) processLeafMsgArgs(trace bool, arg []byte) error {
+	if trace {
+		c.traceInOp("LMSG", arg)
+	}
+	return c.processLeafArgs(arg, false)
+}
+
+// processLeafHeaderMsgArgs will process the arguments of an inbound HMSG
+// from a leaf node, which has the header size before the total size.
+func (c *client) processLeafHeaderMsgArgs(trace bool, arg []byte) error {
+	if trace {
+		c.traceInOp("HMSG", arg)
+	}
+	return c.processLeafArgs(arg, true)
+}
+
+func (c *client) processLeafArgs(arg []byte, hdr bool) error {
+	// Unroll splitArgs to avoid runtime/heap issues
+	a := [MAX_MSG_ARGS][]byte{}
+	args := a[:0]
+	start := -1
+	for i, b := range arg {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			if start >= 0 {
+				args = append(args, arg[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		args = append(args, arg[start:])
+	}
+
+	c.pa.arg = arg
+	c.pa.hdr, c.pa.hdb = 0, nil
+	if hdr {
+		if args = c.splitHeaderSize(args); c.pa.hdr <= 0 {
+			return fmt.Errorf("processLeafHeaderMsgArgs Bad or Missing Header Size: '%s'", arg)
+		}
+	}
+	switch len(args) {
+	case 0, 1:
+		return fmt.Errorf("processLeafMsgArgs Parse Error: '%s'", args)
+	case 2:
+		c.pa.reply = nil
+		c.pa.queues = nil
+		c.pa.szb = args[1]
+		c.pa.size = parseSize(args[1])
+	case 3:
+		c.pa.reply = args[1]
+		c.pa.queues = nil
+		c.pa.szb = args[2]
+		c.pa.size = parseSize(args[2])
+	default:
+		// args[1] is our reply indicator. Should be + or | normally.
+		if len(args[1]) != 1 {
+			return fmt.Errorf("processLeafMsgArgs Bad or Missing Reply Indicator: '%s'", args[1])
+		}
+		switch args[1][0] {
+		case '+':
+			c.pa.reply = args[2]
+		case '|':
+			c.pa.reply = nil
+		default:
+			return fmt.Errorf("processLeafMsgArgs Bad or Missing Reply Indicator: '%s'", args[1])
+		}
+		// Grab size.
+		c.pa.szb = args[len(args)-1]
+		c.pa.size = parseSize(c.pa.szb)
+
+		// Grab queue names.
+		if c.pa.reply != nil {
+			c.pa.queues = args[3 : len(args)-1]
+		} else {
+			c.pa.queues = args[2 : len(args)-1]
+		}
+	}
+	if c.pa.size < 0 {
+		return fmt.Errorf("processLeafMsgArgs Bad or Missing Size: '%s'", args)
+	}
+	if c.pa.hdr > c.pa.size {
+		return fmt.Errorf("processLeafHeaderMsgArgs Header Size Exceeds Size: '%s'", arg)
+	}
+
+	// Common ones processed after check for arg length
+	c.pa.account = nil
+	c.pa.subject = args[0]
+	return nil
+}
+
+// processInboundLeafMsg is called to process an inbound msg from a leaf node.
+func (c *client) processInboundLeafMsg(msg []byte) {
+	// Update statistics
+	c.in.msgs++
+	// The msg includes the CR_LF, so pull back out for accounting.
+	c.in.bytes += len(msg) - LEN_CR_LF
+
+	if c.trace {
+		c.traceMsg(msg)
+	}
+
+	// Mostly under testing scenarios.
+	if c.srv == nil || c.acc == nil {
+		return
+	}
+
+	atomic.AddInt64(&c.acc.inMsgs, 1)
+	atomic.AddInt64(&c.acc.inBytes, int64(len(msg)-LEN_CR_LF))
+
+	// Match the subscriptions. We will use our own L1 map if
+	// it's still valid, avoiding contention on the shared sublist.
+	var r *SublistResult
+	var ok bool
+
+	genid := atomic.LoadUint64(&c.acc.sl.genid)
+	if genid == c.in.genid && c.in.results != nil {
+		r, ok = c.in.results[string(c.pa.subject)]
+	} else {
+		// Reset our L1 completely.
+		c.in.results = make(map[string]*SublistResult)
+		c.in.genid = genid
+	}
+
+	// Go back to the sublist data structure.
+	if !ok {
+		r = c.acc.sl.Match(string(c.pa.subject))
+		c.in.results[string(c.pa.subject)] = r
+		// Prune the results cache. Keeps us from unbounded growth. Random delete.
+		if len(c.in.results) > maxResultCacheSize {
+			n := 0
+			for subject := range c.in.results {
+				delete(c.in.results, subject)
+				if n++; n > pruneSize {
+					break
+				}
+			}
+		}
+	}
+
+	// Check to see if we need to map/route to another account.
+	if c.acc.imports.services != nil {
+		c.checkForImportServices(c.acc, msg)
+	}
+
+	// Check for no interest, short circuit if so.
+	// This is the fanout scale.
+	var qnames [][]byte
+	if len(r.psubs)+len(r.qsubs) > 0 {
+		qnames = c.processMsgResults(c.acc, r, msg, c.pa.subject, c.pa.reply)
+	}
+
+	// Leaf nodes are part of our cluster, so deal with gateways too.
+	if c.srv.gatewaysEnabled() {
+		c.sendMsgToGateways(c.acc, msg, c.pa.subject, c.pa.reply, qnames)
+	}
+}
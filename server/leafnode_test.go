@@ -0,0 +1,287 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+func checkNumLeafNodes(t *testing.T, s *Server, expected int) {
+	t.Helper()
+	checkFor(t, 5*time.Second, 15*time.Millisecond, func() error {
+		if nln := s.NumLeafNodes(); nln != expected {
+			return fmt.Errorf("Expected %v leaf nodes, got %v", expected, nln)
+		}
+		return nil
+	})
+}
+
+func testDefaultOptionsForLeafNodes() *Options {
+	o := DefaultOptions()
+	o.LeafNode.Host = o.Host
+	o.LeafNode.Port = -1
+	return o
+}
+
+func testDefaultRemoteLeafNodeOptions(t *testing.T, s *Server) *Options {
+	t.Helper()
+	o := DefaultOptions()
+	u, err := url.Parse(fmt.Sprintf("nats-leaf://%s", s.LeafNodeAddr()))
+	if err != nil {
+		t.Fatalf("Error parsing url: %v", err)
+	}
+	o.LeafNode.Remotes = []*RemoteLeafOpts{{URL: u}}
+	o.LeafNode.ReconnectInterval = 50 * time.Millisecond
+	return o
+}
+
+func TestLeafNodeConfig(t *testing.T) {
+	opts, err := ProcessConfigFile("./configs/leafnode.conf")
+	if err != nil {
+		t.Fatalf("Received an error reading leafnode config file: %v\n", err)
+	}
+	if opts.LeafNode.Host != "127.0.0.1" || opts.LeafNode.Port != 7422 {
+		t.Fatalf("Unexpected leafnode listen: %s:%d", opts.LeafNode.Host, opts.LeafNode.Port)
+	}
+	if opts.LeafNode.Username != "leaf" || opts.LeafNode.Password != "secret" {
+		t.Fatalf("Unexpected leafnode authorization: %q/%q", opts.LeafNode.Username, opts.LeafNode.Password)
+	}
+	if opts.LeafNode.AuthTimeout != 2.0 {
+		t.Fatalf("Expected auth timeout of 2, got %v", opts.LeafNode.AuthTimeout)
+	}
+	if opts.LeafNode.ReconnectInterval != 5*time.Second {
+		t.Fatalf("Expected reconnect interval of 5s, got %v", opts.LeafNode.ReconnectInterval)
+	}
+	if len(opts.LeafNode.Remotes) != 1 {
+		t.Fatalf("Expected 1 remote, got %d", len(opts.LeafNode.Remotes))
+	}
+	r := opts.LeafNode.Remotes[0]
+	if r.URL.Host != "127.0.0.1:7423" || r.LocalAccount != "FOO" {
+		t.Fatalf("Unexpected remote: %+v", r)
+	}
+}
+
+func TestLeafNodeBasicPubSub(t *testing.T) {
+	hub := RunServer(testDefaultOptionsForLeafNodes())
+	defer hub.Shutdown()
+
+	ln := RunServer(testDefaultRemoteLeafNodeOptions(t, hub))
+	defer ln.Shutdown()
+
+	checkNumLeafNodes(t, hub, 1)
+	checkNumLeafNodes(t, ln, 1)
+
+	hubOpts := hub.getOpts()
+	nch, err := nats.Connect(fmt.Sprintf("nats://%s:%d", hubOpts.Host, hubOpts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nch.Close()
+
+	lnOpts := ln.getOpts()
+	ncl, err := nats.Connect(fmt.Sprintf("nats://%s:%d", lnOpts.Host, lnOpts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncl.Close()
+
+	// Interest on the leaf node should be propagated to the hub.
+	lsub, _ := ncl.SubscribeSync("foo")
+	ncl.Flush()
+	checkExpectedSubs(t, 1, hub, ln)
+
+	nch.Publish("foo", []byte("from hub"))
+	if _, err := lsub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Did not receive message on leaf node: %v", err)
+	}
+
+	// And the other way around.
+	hsub, _ := nch.SubscribeSync("bar")
+	nch.Flush()
+	checkExpectedSubs(t, 2, hub, ln)
+
+	ncl.Publish("bar", []byte("from leaf"))
+	if _, err := hsub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Did not receive message on hub: %v", err)
+	}
+
+	// Removing interest should be propagated as well.
+	lsub.Unsubscribe()
+	ncl.Flush()
+	checkExpectedSubs(t, 1, hub, ln)
+}
+
+func TestLeafNodeQueueSubscriptions(t *testing.T) {
+	hub := RunServer(testDefaultOptionsForLeafNodes())
+	defer hub.Shutdown()
+
+	ln := RunServer(testDefaultRemoteLeafNodeOptions(t, hub))
+	defer ln.Shutdown()
+
+	checkNumLeafNodes(t, hub, 1)
+
+	lnOpts := ln.getOpts()
+	ncl, err := nats.Connect(fmt.Sprintf("nats://%s:%d", lnOpts.Host, lnOpts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncl.Close()
+
+	qsub1, _ := ncl.QueueSubscribeSync("foo", "bar")
+	qsub2, _ := ncl.QueueSubscribeSync("foo", "bar")
+	ncl.Flush()
+	checkExpectedSubs(t, 2, ln)
+	// The hub only tracks a single subscription with the queue weight.
+	checkExpectedSubs(t, 1, hub)
+
+	hubOpts := hub.getOpts()
+	nch, err := nats.Connect(fmt.Sprintf("nats://%s:%d", hubOpts.Host, hubOpts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nch.Close()
+
+	for i := 0; i < 10; i++ {
+		nch.Publish("foo", []byte("hello"))
+	}
+	nch.Flush()
+
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		n1, _, _ := qsub1.Pending()
+		n2, _, _ := qsub2.Pending()
+		if n1+n2 != 10 {
+			return fmt.Errorf("Expected 10 messages total, got %d", n1+n2)
+		}
+		return nil
+	})
+}
+
+func TestLeafNodeInterestDedupedAcrossRoutes(t *testing.T) {
+	hubA := RunServer(testDefaultOptionsForLeafNodes())
+	defer hubA.Shutdown()
+
+	optsB := DefaultOptions()
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", hubA.ClusterAddr().Port))
+	hubB := RunServer(optsB)
+	defer hubB.Shutdown()
+
+	checkClusterFormed(t, hubA, hubB)
+
+	ln := RunServer(testDefaultRemoteLeafNodeOptions(t, hubA))
+	defer ln.Shutdown()
+
+	checkNumLeafNodes(t, hubA, 1)
+
+	lnOpts := ln.getOpts()
+	ncl, err := nats.Connect(fmt.Sprintf("nats://%s:%d", lnOpts.Host, lnOpts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncl.Close()
+
+	// Many subscriptions on the same subject from the leaf node
+	// should result in a single one in the rest of the cluster.
+	var subs []*nats.Subscription
+	for i := 0; i < 10; i++ {
+		sub, _ := ncl.SubscribeSync("foo")
+		subs = append(subs, sub)
+	}
+	ncl.Flush()
+	checkExpectedSubs(t, 10, ln)
+	checkExpectedSubs(t, 1, hubA, hubB)
+
+	// A message published on the other server of the cluster should
+	// reach each of them, once.
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", optsB.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+	for i, sub := range subs {
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Subscription %d did not receive message from the cluster: %v", i, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i, sub := range subs {
+		if n, _, _ := sub.Pending(); n != 0 {
+			t.Fatalf("Subscription %d received %d duplicates", i, n)
+		}
+	}
+}
+
+func TestLeafNodeReconnect(t *testing.T) {
+	hubOpts := testDefaultOptionsForLeafNodes()
+	hub := RunServer(hubOpts)
+
+	ln := RunServer(testDefaultRemoteLeafNodeOptions(t, hub))
+	defer ln.Shutdown()
+
+	checkNumLeafNodes(t, ln, 1)
+
+	hub.Shutdown()
+	checkNumLeafNodes(t, ln, 0)
+
+	// Restart on the same leafnode port and make sure we reconnect.
+	hubOpts.Port = -1
+	hubOpts.Cluster.Port = -1
+	hub = RunServer(hubOpts)
+	defer hub.Shutdown()
+
+	checkNumLeafNodes(t, ln, 1)
+	checkNumLeafNodes(t, hub, 1)
+}
+
+func TestLeafNodeClientOnLeafNodePort(t *testing.T) {
+	hub := RunServer(testDefaultOptionsForLeafNodes())
+	defer hub.Shutdown()
+
+	if _, err := nats.Connect(fmt.Sprintf("nats://%s", hub.LeafNodeAddr())); err == nil {
+		t.Fatalf("Expected client connection to the leafnode port to fail")
+	}
+}
+
+func TestLeafNodeAuthorization(t *testing.T) {
+	opts := testDefaultOptionsForLeafNodes()
+	opts.LeafNode.Username = "leaf"
+	opts.LeafNode.Password = "pwd"
+	hub := RunServer(opts)
+	defer hub.Shutdown()
+
+	// Bad credentials.
+	lopts := testDefaultRemoteLeafNodeOptions(t, hub)
+	lopts.LeafNode.Remotes[0].URL.User = url.UserPassword("leaf", "wrong")
+	ln := RunServer(lopts)
+	defer ln.Shutdown()
+
+	time.Sleep(100 * time.Millisecond)
+	checkNumLeafNodes(t, hub, 0)
+	ln.Shutdown()
+
+	// Good credentials.
+	lopts = testDefaultRemoteLeafNodeOptions(t, hub)
+	lopts.LeafNode.Remotes[0].URL.User = url.UserPassword("leaf", "pwd")
+	ln = RunServer(lopts)
+	defer ln.Shutdown()
+
+	checkNumLeafNodes(t, hub, 1)
+}
@@ -14,9 +14,12 @@
 package server
 
 import (
+	"crypto/hmac"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"runtime"
@@ -25,10 +28,9 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nats-io/gnatsd/server/pse"
-	"io/ioutil"
-	"bytes"
 )
 
 // Snapshot this
@@ -87,8 +89,10 @@ const (
 // ConnInfo has detailed information on a per connection basis.
 type ConnInfo struct {
 	Cid            uint64     `json:"cid"`
+	Type           string     `json:"type"`
 	IP             string     `json:"ip"`
 	Port           int        `json:"port"`
+	UnixSocket     string     `json:"unix_socket,omitempty"`
 	Start          time.Time  `json:"start"`
 	LastActivity   time.Time  `json:"last_activity"`
 	Stop           *time.Time `json:"stop,omitempty"`
@@ -111,6 +115,14 @@ type ConnInfo struct {
 	Subs           []string   `json:"subscriptions_list,omitempty"`
 }
 
+// Connection types reported in ConnInfo, based on the protocol used
+// by the client.
+const (
+	ConnTypeNATS      = "nats"
+	ConnTypeWebsocket = "websocket"
+	ConnTypeMQTT      = "mqtt"
+)
+
 // DefaultConnListSize is the default size of the connection list.
 const DefaultConnListSize = 1024
 
@@ -357,12 +369,18 @@ func (s *Server) Connz(opts *ConnzOptions) (*Connz, error) {
 // Fills in the ConnInfo from the client.
 // client should be locked.
 func (ci *ConnInfo) fill(client *client, nc net.Conn, now time.Time) {
+	ci.fillNoRTT(client, nc, now)
+	ci.RTT = client.getRTT()
+}
+
+// fillNoRTT fills the ConnInfo but the RTT, which may require sending
+// a PING to the client.
+func (ci *ConnInfo) fillNoRTT(client *client, nc net.Conn, now time.Time) {
 	ci.Cid = client.cid
 	ci.Start = client.start
 	ci.LastActivity = client.last
 	ci.Uptime = myUptime(now.Sub(client.start))
 	ci.Idle = myUptime(now.Sub(client.last))
-	ci.RTT = client.getRTT()
 	ci.OutMsgs = client.outMsgs
 	ci.OutBytes = client.outBytes
 	ci.NumSubs = uint32(len(client.subs))
@@ -386,10 +404,20 @@ func (ci *ConnInfo) fill(client *client, nc net.Conn, now time.Time) {
 	}
 
 	switch conn := nc.(type) {
-	case *net.TCPConn, *tls.Conn:
+	case *net.TCPConn, *tls.Conn, *wsConn:
 		addr := conn.RemoteAddr().(*net.TCPAddr)
 		ci.Port = addr.Port
 		ci.IP = addr.IP.String()
+	case *net.UnixConn:
+		ci.UnixSocket = conn.LocalAddr().String()
+	}
+
+	if _, ok := nc.(*wsConn); ok {
+		ci.Type = ConnTypeWebsocket
+	} else if client.mqtt != nil {
+		ci.Type = ConnTypeMQTT
+	} else {
+		ci.Type = ConnTypeNATS
 	}
 }
 
@@ -533,12 +561,13 @@ func (s *Server) HandleConnz(w http.ResponseWriter, r *http.Request) {
 
 // Routez represents detailed information on current client connections.
 type Routez struct {
-	ID        string             `json:"server_id"`
-	Now       time.Time          `json:"now"`
-	Import    *SubjectPermission `json:"import,omitempty"`
-	Export    *SubjectPermission `json:"export,omitempty"`
-	NumRoutes int                `json:"num_routes"`
-	Routes    []*RouteInfo       `json:"routes"`
+	ID        string              `json:"server_id"`
+	Now       time.Time           `json:"now"`
+	Import    *SubjectPermission  `json:"import,omitempty"`
+	Export    *SubjectPermission  `json:"export,omitempty"`
+	NumRoutes int                 `json:"num_routes"`
+	Routes    []*RouteInfo        `json:"routes"`
+	Servers   []*RemoteServerInfo `json:"servers,omitempty"`
 }
 
 // RoutezOptions are options passed to Routez
@@ -566,6 +595,16 @@ type RouteInfo struct {
 	Subs         []string           `json:"subscriptions_list,omitempty"`
 }
 
+// RemoteServerInfo has the liveness information of a remote server,
+// as tracked by the statsz heartbeats it sends.
+type RemoteServerInfo struct {
+	ID       string    `json:"server_id"`
+	Host     string    `json:"host"`
+	Seq      uint64    `json:"seq"`
+	LastSeen time.Time `json:"last_seen"`
+	Alive    bool      `json:"alive"`
+}
+
 // Routez returns a Routez struct containing inormation about routes.
 func (s *Server) Routez(routezOpts *RoutezOptions) (*Routez, error) {
 	rs := &Routez{Routes: []*RouteInfo{}}
@@ -585,6 +624,12 @@ func (s *Server) Routez(routezOpts *RoutezOptions) (*Routez, error) {
 		rs.Export = perms.Export
 	}
 
+	// The subscriptions of the reserved system account are internal.
+	var sysPrefix string
+	if sacc := s.SystemAccount(); sacc != nil && s.isReservedSysAccount(sacc) {
+		sysPrefix = sacc.Name + " "
+	}
+
 	// Walk the list
 	for _, r := range s.routes {
 		r.mu.Lock()
@@ -597,14 +642,19 @@ func (s *Server) Routez(routezOpts *RoutezOptions) (*Routez, error) {
 			OutMsgs:      r.outMsgs,
 			InBytes:      atomic.LoadInt64(&r.inBytes),
 			OutBytes:     r.outBytes,
-			NumSubs:      uint32(len(r.subs)),
 			Import:       r.opts.Import,
 			Export:       r.opts.Export,
 		}
 
 		if subs && len(r.subs) > 0 {
 			ri.Subs = make([]string, 0, len(r.subs))
-			for _, sub := range r.subs {
+		}
+		for key, sub := range r.subs {
+			if sysPrefix != _EMPTY_ && strings.HasPrefix(key, sysPrefix) {
+				continue
+			}
+			ri.NumSubs++
+			if subs {
 				ri.Subs = append(ri.Subs, string(sub.subject))
 			}
 		}
@@ -617,6 +667,7 @@ func (s *Server) Routez(routezOpts *RoutezOptions) (*Routez, error) {
 		r.mu.Unlock()
 		rs.Routes = append(rs.Routes, ri)
 	}
+	rs.Servers = s.remoteServers()
 	s.mu.Unlock()
 	return rs, nil
 }
@@ -647,6 +698,362 @@ func (s *Server) HandleRoutez(w http.ResponseWriter, r *http.Request) {
 	ResponseHandler(w, r, b)
 }
 
+// Gatewayz represents detailed information on gateways.
+type Gatewayz struct {
+	ID               string                       `json:"server_id"`
+	Now              time.Time                    `json:"now"`
+	Name             string                       `json:"name,omitempty"`
+	Host             string                       `json:"host,omitempty"`
+	Port             int                          `json:"port,omitempty"`
+	OutboundGateways map[string]*RemoteGatewayz   `json:"outbound_gateways"`
+	InboundGateways  map[string][]*RemoteGatewayz `json:"inbound_gateways"`
+}
+
+// GatewayzOptions are options passed to Gatewayz.
+type GatewayzOptions struct {
+	// Name will return only remote gateways with this name.
+	Name string `json:"name"`
+
+	// Accounts indicates if the per account interest mode should be included.
+	Accounts bool `json:"accounts"`
+}
+
+// RemoteGatewayz represents information about a remote gateway connection.
+type RemoteGatewayz struct {
+	IsConfigured bool               `json:"configured"`
+	Connection   *GatewayConnInfo   `json:"connection,omitempty"`
+	Accounts     []*AccountGatewayz `json:"accounts,omitempty"`
+}
+
+// GatewayConnInfo has detailed information on a gateway connection.
+type GatewayConnInfo struct {
+	Cid      uint64 `json:"cid"`
+	RemoteID string `json:"remote_id"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Pending  int    `json:"pending_bytes"`
+	InMsgs   int64  `json:"in_msgs"`
+	OutMsgs  int64  `json:"out_msgs"`
+	InBytes  int64  `json:"in_bytes"`
+	OutBytes int64  `json:"out_bytes"`
+}
+
+// AccountGatewayz represents the interest mode of an account on a gateway connection.
+type AccountGatewayz struct {
+	Name                  string `json:"name"`
+	InterestMode          string `json:"interest_mode"`
+	NoInterestCount       int    `json:"no_interest_count,omitempty"`
+	InterestOnlyThreshold int    `json:"interest_only_threshold,omitempty"`
+	TotalSubscriptions    int    `json:"num_subs,omitempty"`
+}
+
+// Gatewayz returns a Gatewayz struct containing information about gateways.
+func (s *Server) Gatewayz(gwOpts *GatewayzOptions) (*Gatewayz, error) {
+	if gwOpts == nil {
+		gwOpts = &GatewayzOptions{}
+	}
+	opts := s.getOpts()
+	gwz := &Gatewayz{
+		ID:               s.ID(),
+		Now:              time.Now(),
+		OutboundGateways: make(map[string]*RemoteGatewayz),
+		InboundGateways:  make(map[string][]*RemoteGatewayz),
+	}
+	if !s.gatewaysEnabled() {
+		return gwz, nil
+	}
+	gwz.Name = s.gateway.name
+	gwz.Host = opts.Gateway.Host
+	gwz.Port = opts.Gateway.Port
+
+	s.gateway.RLock()
+	// Configured gateways we are not connected to are reported too.
+	for name := range s.gateway.remotes {
+		if gwOpts.Name != "" && name != gwOpts.Name {
+			continue
+		}
+		gwz.OutboundGateways[name] = &RemoteGatewayz{IsConfigured: true}
+	}
+	out := make([]*client, 0, len(s.gateway.out))
+	for _, c := range s.gateway.out {
+		out = append(out, c)
+	}
+	in := make([]*client, 0, len(s.gateway.in))
+	for _, c := range s.gateway.in {
+		in = append(in, c)
+	}
+	s.gateway.RUnlock()
+
+	for _, c := range out {
+		name, rgw := createRemoteGatewayz(c, gwOpts)
+		if gwOpts.Name != "" && name != gwOpts.Name {
+			continue
+		}
+		if e := gwz.OutboundGateways[name]; e != nil {
+			rgw.IsConfigured = e.IsConfigured
+		}
+		gwz.OutboundGateways[name] = rgw
+	}
+	for _, c := range in {
+		name, rgw := createRemoteGatewayz(c, gwOpts)
+		if name == "" || (gwOpts.Name != "" && name != gwOpts.Name) {
+			continue
+		}
+		gwz.InboundGateways[name] = append(gwz.InboundGateways[name], rgw)
+	}
+	return gwz, nil
+}
+
+// createRemoteGatewayz returns the name of the remote gateway and the
+// monitoring information for the gateway connection.
+func createRemoteGatewayz(c *client, gwOpts *GatewayzOptions) (string, *RemoteGatewayz) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ci := &GatewayConnInfo{
+		Cid:      c.cid,
+		RemoteID: c.gw.remoteID,
+		Pending:  int(c.out.pb),
+		InMsgs:   atomic.LoadInt64(&c.inMsgs),
+		OutMsgs:  c.outMsgs,
+		InBytes:  atomic.LoadInt64(&c.inBytes),
+		OutBytes: c.outBytes,
+	}
+	switch conn := c.nc.(type) {
+	case *net.TCPConn, *tls.Conn:
+		addr := conn.RemoteAddr().(*net.TCPAddr)
+		ci.Port = addr.Port
+		ci.IP = addr.IP.String()
+	}
+	rgw := &RemoteGatewayz{IsConfigured: c.gw.outbound, Connection: ci}
+	if !gwOpts.Accounts {
+		return c.gw.name, rgw
+	}
+	if c.gw.outbound {
+		for name, e := range c.gw.outsim {
+			ag := &AccountGatewayz{Name: name}
+			if e == nil {
+				ag.InterestMode = Optimistic.String()
+				ag.NoInterestCount = -1
+			} else {
+				ag.InterestMode = e.mode.String()
+				ag.NoInterestCount = len(e.ni)
+				if e.sl != nil {
+					ag.TotalSubscriptions = int(e.sl.Count())
+				}
+			}
+			rgw.Accounts = append(rgw.Accounts, ag)
+		}
+	} else {
+		for name, e := range c.gw.insim {
+			ag := &AccountGatewayz{Name: name, InterestOnlyThreshold: gatewayMaxRUnsubBeforeSwitch}
+			if e == nil {
+				ag.InterestMode = Optimistic.String()
+				ag.NoInterestCount = -1
+			} else {
+				ag.InterestMode = e.mode.String()
+				ag.NoInterestCount = len(e.ni)
+				ag.TotalSubscriptions = len(e.smap)
+			}
+			rgw.Accounts = append(rgw.Accounts, ag)
+		}
+	}
+	sort.Slice(rgw.Accounts, func(i, j int) bool { return rgw.Accounts[i].Name < rgw.Accounts[j].Name })
+	return c.gw.name, rgw
+}
+
+// HandleGatewayz process HTTP requests for gateway information.
+func (s *Server) HandleGatewayz(w http.ResponseWriter, r *http.Request) {
+	accs, err := decodeBool(w, r, "accs")
+	if err != nil {
+		return
+	}
+	opts := &GatewayzOptions{
+		Name:     r.URL.Query().Get("gw_name"),
+		Accounts: accs,
+	}
+
+	s.mu.Lock()
+	s.httpReqStats[GatewayzPath]++
+	s.mu.Unlock()
+
+	// As of now, no error is ever returned.
+	gwz, _ := s.Gatewayz(opts)
+	b, err := json.MarshalIndent(gwz, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /gatewayz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// Accountz represents detailed information on the accounts.
+type Accountz struct {
+	ID          string         `json:"server_id"`
+	Now         time.Time      `json:"now"`
+	NumAccounts int            `json:"num_accounts"`
+	Accounts    []*AccountInfo `json:"accounts"`
+}
+
+// AccountzOptions are options passed to Accountz
+type AccountzOptions struct {
+	// Account will only return the account with this name.
+	Account string `json:"account"`
+}
+
+// AccountInfo has detailed information on a per account basis.
+type AccountInfo struct {
+	Name         string        `json:"name"`
+	Expired      bool          `json:"expired"`
+	Limits       AccountLimits `json:"limits"`
+	Exports      []*ExportInfo `json:"exports,omitempty"`
+	Imports      []*ImportInfo `json:"imports,omitempty"`
+	NumClients   int           `json:"num_connections"`
+	NumLeafNodes int           `json:"num_leafnodes"`
+	NumSubs      uint32        `json:"num_subscriptions"`
+	InMsgs       int64         `json:"in_msgs"`
+	OutMsgs      int64         `json:"out_msgs"`
+	InBytes      int64         `json:"in_bytes"`
+	OutBytes     int64         `json:"out_bytes"`
+}
+
+// AccountLimits are the limits of an account. Zero means no limit.
+type AccountLimits struct {
+	MaxPayload      int32  `json:"max_payload"`
+	MaxSubs         int    `json:"max_subscriptions"`
+	MaxConns        int    `json:"max_connections"`
+	MaxResponseMaps int    `json:"max_response_maps"`
+	ResponseMapsTTL string `json:"response_maps_ttl"`
+}
+
+// ExportInfo is a stream or service exported by an account.
+type ExportInfo struct {
+	Subject       string   `json:"subject"`
+	Type          string   `json:"type"`
+	TokenRequired bool     `json:"token_required,omitempty"`
+	Approved      []string `json:"approved_accounts,omitempty"`
+}
+
+// ImportInfo is a stream or service imported by an account.
+type ImportInfo struct {
+	Subject string `json:"subject"`
+	Type    string `json:"type"`
+	Account string `json:"account"`
+	To      string `json:"to,omitempty"`
+	Invalid bool   `json:"invalid,omitempty"`
+}
+
+// Accountz returns a Accountz struct containing information about accounts.
+func (s *Server) Accountz(accOpts *AccountzOptions) (*Accountz, error) {
+	if accOpts == nil {
+		accOpts = &AccountzOptions{}
+	}
+	az := &Accountz{Accounts: []*AccountInfo{}}
+	az.Now = time.Now()
+
+	s.mu.Lock()
+	az.ID = s.info.ID
+	accs := make([]*Account, 0, len(s.accounts))
+	for _, acc := range s.accounts {
+		if accOpts.Account == "" || acc.Name == accOpts.Account {
+			accs = append(accs, acc)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, acc := range accs {
+		az.Accounts = append(az.Accounts, createAccountInfo(acc))
+	}
+	sort.Slice(az.Accounts, func(i, j int) bool { return az.Accounts[i].Name < az.Accounts[j].Name })
+	az.NumAccounts = len(az.Accounts)
+	return az, nil
+}
+
+// createAccountInfo returns the monitoring information for the account.
+func createAccountInfo(acc *Account) *AccountInfo {
+	acc.mu.RLock()
+	defer acc.mu.RUnlock()
+
+	ai := &AccountInfo{
+		Name:    acc.Name,
+		Expired: acc.expired,
+		Limits: AccountLimits{
+			MaxPayload:      acc.mpay,
+			MaxSubs:         acc.msubs,
+			MaxConns:        acc.mconns,
+			MaxResponseMaps: acc.maxnae,
+			ResponseMapsTTL: acc.maxaettl.String(),
+		},
+		NumClients:   len(acc.clients),
+		NumLeafNodes: len(acc.lleafs),
+		NumSubs:      acc.sl.Count(),
+		InMsgs:       atomic.LoadInt64(&acc.inMsgs),
+		OutMsgs:      atomic.LoadInt64(&acc.outMsgs),
+		InBytes:      atomic.LoadInt64(&acc.inBytes),
+		OutBytes:     atomic.LoadInt64(&acc.outBytes),
+	}
+	addExports := func(typ string, exports map[string]*exportAuth) {
+		for subj, ea := range exports {
+			ei := &ExportInfo{Subject: subj, Type: typ}
+			if ea != nil {
+				ei.TokenRequired = ea.tokenReq
+				for name := range ea.approved {
+					ei.Approved = append(ei.Approved, name)
+				}
+				sort.Strings(ei.Approved)
+			}
+			ai.Exports = append(ai.Exports, ei)
+		}
+	}
+	addExports("stream", acc.exports.streams)
+	addExports("service", acc.exports.services)
+	sort.Slice(ai.Exports, func(i, j int) bool { return ai.Exports[i].Subject < ai.Exports[j].Subject })
+
+	for _, si := range acc.imports.streams {
+		ai.Imports = append(ai.Imports, &ImportInfo{
+			Subject: si.from,
+			Type:    "stream",
+			Account: si.acc.Name,
+			To:      si.prefix,
+			Invalid: si.invalid,
+		})
+	}
+	for _, si := range acc.imports.services {
+		// Skip the response mappings created for requests.
+		if si.ae {
+			continue
+		}
+		ai.Imports = append(ai.Imports, &ImportInfo{
+			Subject: si.from,
+			Type:    "service",
+			Account: si.acc.Name,
+			To:      si.to,
+		})
+	}
+	sort.Slice(ai.Imports, func(i, j int) bool { return ai.Imports[i].Subject < ai.Imports[j].Subject })
+	return ai
+}
+
+// HandleAccountz process HTTP requests for account information.
+func (s *Server) HandleAccountz(w http.ResponseWriter, r *http.Request) {
+	opts := &AccountzOptions{Account: r.URL.Query().Get("acc")}
+
+	s.mu.Lock()
+	s.httpReqStats[AccountzPath]++
+	s.mu.Unlock()
+
+	// As of now, no error is ever returned.
+	az, _ := s.Accountz(opts)
+	b, err := json.MarshalIndent(az, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /accountz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
 // Subsz represents detail information on current connections.
 type Subsz struct {
 	*SublistStats
@@ -859,6 +1266,22 @@ type Varz struct {
 	Subscriptions    uint32            `json:"subscriptions"`
 	HTTPReqStats     map[string]uint64 `json:"http_req_stats"`
 	ConfigLoadTime   time.Time         `json:"config_load_time"`
+	ResolverStats    *ResolverStats    `json:"resolver_stats,omitempty"`
+	TLSCerts         []CertInfo        `json:"tls_certs,omitempty"`
+	TLSCertRotated   *time.Time        `json:"tls_cert_rotated,omitempty"`
+}
+
+// ResolverStats are the statistics of the lookups done by the URL account resolver.
+type ResolverStats struct {
+	Lookups      uint64        `json:"lookups"`
+	CacheHits    uint64        `json:"cache_hits"`
+	Fetches      uint64        `json:"fetches"`
+	Failures     uint64        `json:"failures"`
+	StaleHits    uint64        `json:"stale_hits"`
+	TotalLatency time.Duration `json:"total_latency"`
+	AvgLatency   time.Duration `json:"avg_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+	CacheSize    int           `json:"cache_size"`
 }
 
 // VarzOptions are the options passed to Varz().
@@ -912,6 +1335,8 @@ func (s *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	<a href=/varz>varz</a><br/>
 	<a href=/connz>connz</a><br/>
 	<a href=/routez>routez</a><br/>
+	<a href=/gatewayz>gatewayz</a><br/>
+	<a href=/accountz>accountz</a><br/>
 	<a href=/subsz>subsz</a><br/>
 	<a href=/get_informer>informer</a><br/>
 	<a href=/nodes>nodes</a><br/>
@@ -953,8 +1378,23 @@ func (s *Server) Varz(varzOpts *VarzOptions) (*Varz, error) {
 	for key, val := range s.httpReqStats {
 		v.HTTPReqStats[key] = val
 	}
+	ur, _ := s.accResolver.(*URLAccResolver)
+	certs := s.certs
 	s.mu.Unlock()
 
+	if ur != nil {
+		v.ResolverStats = ur.Stats()
+	}
+	if certs != nil {
+		var rotated time.Time
+		v.TLSCerts, rotated = certs.info()
+		if !rotated.IsZero() {
+			v.TLSCertRotated = &rotated
+		}
+	} else if opts.TLSConfig != nil && len(opts.TLSConfig.Certificates) > 0 {
+		v.TLSCerts = certChainInfo(&opts.TLSConfig.Certificates[0])
+	}
+
 	return v, nil
 }
 
@@ -975,39 +1415,99 @@ func (s *Server) HandleVarz(w http.ResponseWriter, r *http.Request) {
 	ResponseHandler(w, r, b)
 }
 
+// CInformerInfo is the request of /reg_informer.
 type CInformerInfo struct {
 	Url string `json:"url"`
 	Opt string `json:"opt"` //"add", "del"
 }
 
+// CNode is a client connection of the cluster.
 type CNode struct {
-	IP   string `json:"ip"`
-	//Port int    `json:"port"`
+	CID            uint64    `json:"cid"`
+	Type           string    `json:"type"`
+	IP             string    `json:"ip,omitempty"`
+	Port           int       `json:"port,omitempty"`
+	UnixSocket     string    `json:"unix_socket,omitempty"`
+	Account        string    `json:"account,omitempty"`
+	AuthorizedUser string    `json:"authorized_user,omitempty"`
+	Lang           string    `json:"lang,omitempty"`
+	Version        string    `json:"version,omitempty"`
+	Start          time.Time `json:"start"`
+	TLS            bool      `json:"tls"`
+	TLSVersion     string    `json:"tls_version,omitempty"`
+	Server         string    `json:"server_id"`
+}
+
+// Nodez represents the client connections of the cluster, by client name.
+type Nodez struct {
+	ID     string              `json:"server_id"`
+	Now    time.Time           `json:"now"`
+	Total  int                 `json:"total"`
+	Offset int                 `json:"offset"`
+	Limit  int                 `json:"limit"`
+	Nodes  map[string][]*CNode `json:"nodes"`
+}
+
+// NodesOptions are the options passed to Nodes()
+type NodesOptions struct {
+	// Name filters the clients whose name starts with it.
+	Name string `json:"name"`
+
+	// Account filters the clients of this account.
+	Account string `json:"account"`
+
+	// CIDR filters the clients connected from an address of this network,
+	// e.g. 10.0.0.0/8.
+	CIDR string `json:"cidr"`
+
+	// Offset is used for pagination. Nodes() only returns connections starting at this
+	// offset from the global results, ordered by name, server and connection ID.
+	Offset int `json:"offset"`
+
+	// Limit is the maximum number of connections that should be returned by Nodes().
+	Limit int `json:"limit"`
 }
 
+// HandleRegInformer registers or unregisters an informer. When a secret is
+// configured, the request must be signed with it.
 func (s *Server) HandleRegInformer(w http.ResponseWriter, r *http.Request) {
 	s.mu.Lock()
 	s.httpReqStats[RegInformerPath]++
 	s.mu.Unlock()
 
-	body, _ := ioutil.ReadAll(r.Body)
-	fmt.Println(string(body))
-
-	info := &CInformerInfo{}
-	err := json.Unmarshal(body, info)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, informerMaxRegisterSize))
 	if err != nil {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(err.Error()))
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if secret := s.getOpts().Informer.Secret; secret != _EMPTY_ {
+		sig := r.Header.Get(InformerSignatureHeader)
+		if !hmac.Equal([]byte(sig), []byte(signInformerPayload(secret, body))) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+	}
 
-	if info.Opt == "add" {
-		s.informers.Store(info.Url, "")
-	} else {
-		s.informers.Delete(info.Url)
+	info := &CInformerInfo{}
+	if err := json.Unmarshal(body, info); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	s.BroadcastClientInfoToInformer()
+	switch info.Opt {
+	case "add":
+		if err = validInformerURL(info.Url); err == nil {
+			err = s.addInformer(info.Url)
+		}
+	case "del":
+		err = s.removeInformer(info.Url)
+	default:
+		err = fmt.Errorf("unknown informer operation %q", info.Opt)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	ResponseHandler(w, r, []byte(""))
 }
@@ -1021,143 +1521,212 @@ func (s *Server) HandleGetInformer(w http.ResponseWriter, r *http.Request) {
 
 	b, err := json.MarshalIndent(list, "", "  ")
 	if err != nil {
-		s.Errorf("Error marshaling response to /connz request: %v", err)
+		s.Errorf("Error marshaling response to /get_informer request: %v", err)
 	}
 
 	// Handle response
 	ResponseHandler(w, r, b)
 }
 
-func (s *Server) getInformers() []string {
-	informers := make([]string, 0)
-
-	s.informers.Range(func(key, value interface{}) bool {
-		url, ok := key.(string)
-		if !ok {
-			fmt.Println("!ok")
-			return true
-		}
-
-		informers = append(informers, url)
-
-		return true
-	})
-
-	return informers
-}
-
+// HandleNodes process HTTP requests for the client connections of the cluster.
 func (s *Server) HandleNodes(w http.ResponseWriter, r *http.Request) {
+	offset, err := decodeInt(w, r, "offset")
+	if err != nil {
+		return
+	}
+	limit, err := decodeInt(w, r, "limit")
+	if err != nil {
+		return
+	}
+	nodesOpts := &NodesOptions{
+		Name:    r.URL.Query().Get("name"),
+		Account: r.URL.Query().Get("account"),
+		CIDR:    r.URL.Query().Get("cidr"),
+		Offset:  offset,
+		Limit:   limit,
+	}
+
 	s.mu.Lock()
 	s.httpReqStats[NodesPath]++
 	s.mu.Unlock()
 
-	m := s.getNodes()
-
-	b, err := json.MarshalIndent(m, "", "  ")
+	n, err := s.Nodes(nodesOpts)
 	if err != nil {
-		s.Errorf("Error marshaling response to /connz request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /nodes request: %v", err)
 	}
 
 	// Handle response
 	ResponseHandler(w, r, b)
 }
 
-func (s *Server) getNodes() map[string][]*CNode {
-	s.mu.Lock()
-	clients := s.clients
-	s.mu.Unlock()
-
-	m := make(map[string][]*CNode)
-
-	for _, client := range clients {
-		fmt.Println("client:", *client)
+// Nodes returns the client connections of this server and of the other
+// servers of the cluster.
+func (s *Server) Nodes(opts *NodesOptions) (*Nodez, error) {
+	var (
+		name, account string
+		network       *net.IPNet
+		offset        int
+		limit         = DefaultConnListSize
+	)
+	if opts != nil {
+		name, account = opts.Name, opts.Account
+		if opts.CIDR != "" {
+			_, ipNet, err := net.ParseCIDR(opts.CIDR)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid cidr: %v", err)
+			}
+			network = ipNet
+		}
+		if opts.Offset > 0 {
+			offset = opts.Offset
+		}
+		if opts.Limit > 0 {
+			limit = opts.Limit
+		}
+	}
 
-		name := client.opts.Name
+	n := &Nodez{
+		ID:     s.info.ID,
+		Now:    time.Now(),
+		Offset: offset,
+		Limit:  limit,
+		Nodes:  make(map[string][]*CNode),
+	}
 
-		if name == "" {
+	var nodes []*informerNode
+	for _, in := range s.allNodes() {
+		if !strings.HasPrefix(in.name, name) {
 			continue
 		}
-
-		detail := &CNode{}
-
-		switch conn := client.nc.(type) {
-		case *net.TCPConn, *tls.Conn:
-			addr := conn.RemoteAddr().(*net.TCPAddr)
-			detail.IP = addr.IP.String()
-			//detail.Port = addr.Port
-		default:
+		if account != "" && in.node.Account != account {
 			continue
 		}
-
-		m[name] = append(m[name], detail)
+		if network != nil {
+			if ip := net.ParseIP(in.node.IP); ip == nil || !network.Contains(ip) {
+				continue
+			}
+		}
+		nodes = append(nodes, in)
 	}
+	n.Total = len(nodes)
 
-	return m
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.node.Server != b.node.Server {
+			return a.node.Server < b.node.Server
+		}
+		return a.node.CID < b.node.CID
+	})
+	if offset > len(nodes) {
+		offset = len(nodes)
+	}
+	nodes = nodes[offset:]
+	if len(nodes) > limit {
+		nodes = nodes[:limit]
+	}
+	for _, in := range nodes {
+		n.Nodes[in.name] = append(n.Nodes[in.name], in.node)
+	}
+	return n, nil
 }
 
-func (s *Server) BroadcastClientInfoToInformer() {
-	go func() {
-		m := s.getNodes()
+// allNodes returns the client connections of this server and the ones sent
+// by the route peers.
+func (s *Server) allNodes() []*informerNode {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
 
-		body, err := json.Marshal(&m)
-		if err != nil {
-			fmt.Println(err)
-			return
+	nodes := make([]*informerNode, 0, len(clients))
+	for _, c := range clients {
+		if name, node := clientNode(c); node != nil {
+			nodes = append(nodes, &informerNode{name: name, node: node})
 		}
+	}
 
-		fmt.Println("body:", string(body))
-
-		delInformers := make([]string, 0)
-
-		s.informers.Range(func(key, value interface{}) bool {
-			url, ok := key.(string)
-			if !ok {
-				fmt.Println("!ok")
-				return true
-			}
-
-			req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-			if err != nil {
-				fmt.Println(err)
-				return true
-			}
-
-			req.Header.Set("Content-Type", "application/octet-stream")
-			req.Close = true
-
-			var isStatusOK bool
-
-			for i := 0; i < 3; i++ {
-				var httpClient = &http.Client{Timeout: 1 * time.Second}
-				resp, err := httpClient.Do(req)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-
-				if resp.StatusCode != http.StatusOK {
-					fmt.Println("resp.StatusCode != http.StatusOK")
-					continue
-				}
-
-				resp.Body.Close()
-
-				isStatusOK = true
+	s.informersMu.Lock()
+	for _, rnodes := range s.remoteNodes {
+		nodes = append(nodes, rnodes...)
+	}
+	s.informersMu.Unlock()
+	return nodes
+}
 
-				break
-			}
+// clientNode returns the name and node of a client, nil if the client has
+// not sent its CONNECT yet or is closed.
+func clientNode(c *client) (string, *CNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			if !isStatusOK {
-				delInformers = append(delInformers, url)
-			}
+	nc := c.nc
+	if nc == nil || !c.flags.isSet(connectReceived) {
+		return _EMPTY_, nil
+	}
+	node := &CNode{
+		CID:            c.cid,
+		Type:           ConnTypeNATS,
+		AuthorizedUser: truncateNodeField(c.opts.Username),
+		Lang:           truncateNodeField(c.opts.Lang),
+		Version:        truncateNodeField(c.opts.Version),
+		Start:          c.start,
+	}
+	if node.AuthorizedUser == _EMPTY_ {
+		node.AuthorizedUser = c.opts.Nkey
+	}
+	if c.acc != nil {
+		node.Account = c.acc.Name
+	}
+	if c.srv != nil {
+		node.Server = c.srv.info.ID
+	}
 
-			return true
-		})
+	tc, _ := nc.(*tls.Conn)
+	if ws, ok := nc.(*wsConn); ok {
+		node.Type = ConnTypeWebsocket
+		tc = ws.tlsConn()
+	} else if c.mqtt != nil {
+		node.Type = ConnTypeMQTT
+	}
+	if tc != nil {
+		node.TLS = true
+		node.TLSVersion = tlsVersion(tc.ConnectionState().Version)
+	}
 
-		for _, url := range delInformers {
-			s.informers.Delete(url)
+	switch conn := nc.(type) {
+	case *net.TCPConn, *tls.Conn, *wsConn:
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			node.IP = addr.IP.String()
+			node.Port = addr.Port
 		}
-	}()
+	case *net.UnixConn:
+		node.UnixSocket = conn.LocalAddr().String()
+	}
+	return truncateNodeField(c.opts.Name), node
+}
+
+// truncateNodeField cuts a field sent by a client to informerMaxFieldLen
+// bytes, on a rune boundary.
+func truncateNodeField(f string) string {
+	if len(f) <= informerMaxFieldLen {
+		return f
+	}
+	n := informerMaxFieldLen
+	for n > 0 && !utf8.RuneStart(f[n]) {
+		n--
+	}
+	return f[:n]
 }
 
 // Grab RSS and PCPU
@@ -1234,6 +1803,14 @@ func (reason ClosedState) String() string {
 		return "Server Shutdown"
 	case AuthenticationExpired:
 		return "Authentication Expired"
+	case MissingAccount:
+		return "Missing Account"
+	case WrongGateway:
+		return "Wrong Gateway"
+	case Revocation:
+		return "Credentials Revoked"
+	case DuplicateClientID:
+		return "Duplicate Client ID"
 	}
 	return "Unknown State"
 }
@@ -20,6 +20,9 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strings"
@@ -1678,6 +1681,111 @@ func createClientConnWithName(t *testing.T, name string, s *Server) *nats.Conn {
 	return nc
 }
 
+func TestNodez(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnatsd")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nats.sock")
+
+	opts := testWSOptions()
+	opts.HTTPHost = "127.0.0.1"
+	opts.HTTPPort = MONITOR_PORT
+	opts.UnixSocket = path
+	accA, accB := &Account{Name: "A"}, &Account{Name: "B"}
+	opts.Accounts = []*Account{accA, accB}
+	opts.Users = []*User{
+		{Username: "a", Password: "pwd", Account: accA},
+		{Username: "b", Password: "pwd", Account: accB},
+	}
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://127.0.0.1:%d", opts.Port)
+	// Anonymous clients are reported under an empty name.
+	for _, c := range []struct{ name, user string }{{"app-1", "a"}, {"app-2", "b"}, {"", "a"}} {
+		nc, err := nats.Connect(url, nats.Name(c.name), nats.UserInfo(c.user, "pwd"))
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		defer nc.Close()
+	}
+	wc, _ := testWSConnect(t, s)
+	defer wc.conn.Close()
+	wc.expect("INFO ")
+	wc.send("CONNECT {\"verbose\":false,\"name\":\"ws\",\"user\":\"a\",\"pass\":\"pwd\"}\r\nPING\r\n")
+	wc.expect("PONG\r\n")
+	uc, br := testUnixSocketConnect(t, path, `CONNECT {"verbose":false,"name":"unix","user":"b","pass":"pwd"}`)
+	defer uc.Close()
+	if line, err := br.ReadString('\n'); err != nil || line != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q, %v", line, err)
+	}
+
+	n, err := s.Nodes(nil)
+	if err != nil {
+		t.Fatalf("Error getting nodes: %v", err)
+	}
+	if n.Total != 5 || len(n.Nodes) != 5 || n.ID != s.ID() || n.Limit != DefaultConnListSize {
+		t.Fatalf("Unexpected nodes: %+v", n)
+	}
+	app := n.Nodes["app-1"][0]
+	if app.CID == 0 || app.Type != ConnTypeNATS || app.IP != "127.0.0.1" || app.Port == 0 ||
+		app.Account != "A" || app.AuthorizedUser != "a" || app.Lang != "go" || app.Version == "" ||
+		app.Start.IsZero() || app.TLS || app.Server != s.ID() {
+		t.Fatalf("Unexpected node: %+v", app)
+	}
+	if ws := n.Nodes["ws"][0]; ws.Type != ConnTypeWebsocket || ws.IP != "127.0.0.1" || ws.Port == 0 || ws.Account != "A" {
+		t.Fatalf("Unexpected websocket node: %+v", ws)
+	}
+	if unix := n.Nodes["unix"][0]; unix.UnixSocket != path || unix.IP != "" || unix.Account != "B" {
+		t.Fatalf("Unexpected unix node: %+v", unix)
+	}
+
+	names := func(opts *NodesOptions) []string {
+		t.Helper()
+		n, err := s.Nodes(opts)
+		if err != nil {
+			t.Fatalf("Error getting nodes: %v", err)
+		}
+		var names []string
+		for name := range n.Nodes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	for _, test := range []struct {
+		opts     *NodesOptions
+		expected []string
+	}{
+		{&NodesOptions{Name: "app-"}, []string{"app-1", "app-2"}},
+		{&NodesOptions{Account: "B"}, []string{"app-2", "unix"}},
+		{&NodesOptions{CIDR: "127.0.0.0/8"}, []string{"", "app-1", "app-2", "ws"}},
+		{&NodesOptions{CIDR: "10.0.0.0/8"}, nil},
+		{&NodesOptions{Offset: 2, Limit: 2}, []string{"app-2", "unix"}},
+		{&NodesOptions{Offset: 10}, nil},
+	} {
+		if got := names(test.opts); !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("Expected %v for %+v, got %v", test.expected, test.opts, got)
+		}
+	}
+	if _, err := s.Nodes(&NodesOptions{CIDR: "10.0.0.0"}); err == nil {
+		t.Fatalf("Expected an error for an invalid cidr")
+	}
+
+	// Same over HTTP.
+	murl := fmt.Sprintf("http://127.0.0.1:%d/nodes", s.MonitorAddr().Port)
+	var nz Nodez
+	if err := json.Unmarshal(readBody(t, murl+"?name=app-&account=A&limit=1"), &nz); err != nil {
+		t.Fatalf("Error unmarshaling nodes: %v", err)
+	}
+	if nz.Total != 1 || nz.Limit != 1 || len(nz.Nodes["app-1"]) != 1 {
+		t.Fatalf("Unexpected nodes: %+v", nz)
+	}
+	readBodyEx(t, murl+"?cidr=bad", http.StatusBadRequest, textPlain)
+}
+
 func TestStacksz(t *testing.T) {
 	s := runMonitorServer()
 	defer s.Shutdown()
@@ -1984,6 +2092,102 @@ func TestRoutezPermissions(t *testing.T) {
 	}
 }
 
+func TestAccountz(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+    listen: "127.0.0.1:-1"
+    http: "127.0.0.1:-1"
+    accounts {
+      A {
+        users = [{user: a, password: pass}]
+        exports = [{stream: "foo.>"}]
+      }
+      B {
+        users = [{user: b, password: pass}]
+        imports = [{stream: {account: A, subject: "foo.>"}, prefix: "a"}]
+      }
+    }
+    `))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Received an error processing config file: %v", err)
+	}
+	opts.NoLog, opts.NoSigs = true, true
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port)
+	nca, err := nats.Connect(url, nats.UserInfo("a", "pass"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nca.Close()
+	ncb, err := nats.Connect(url, nats.UserInfo("b", "pass"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncb.Close()
+
+	sub, _ := ncb.SubscribeSync("a.foo.bar")
+	ncb.Flush()
+	nca.Publish("foo.bar", []byte("hello"))
+	nca.Publish("foo.bar", []byte("world"))
+	nca.Flush()
+	for i := 0; i < 2; i++ {
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Did not receive the message: %v", err)
+		}
+	}
+
+	getAccountz := func(query string) *Accountz {
+		t.Helper()
+		url := fmt.Sprintf("http://127.0.0.1:%d/accountz%s", s.MonitorAddr().Port, query)
+		az := &Accountz{}
+		if err := json.Unmarshal(readBody(t, url), az); err != nil {
+			t.Fatalf("Got an error unmarshalling the body: %v\n", err)
+		}
+		return az
+	}
+
+	az := getAccountz("")
+	if az.ID != s.ID() || az.NumAccounts != len(az.Accounts) {
+		t.Fatalf("Unexpected accountz: %+v", az)
+	}
+	accs := make(map[string]*AccountInfo)
+	for _, ai := range az.Accounts {
+		accs[ai.Name] = ai
+	}
+	for _, name := range []string{"A", "B", globalAccountName, DEFAULT_SYSTEM_ACCOUNT} {
+		if accs[name] == nil {
+			t.Fatalf("Expected account %q to be listed, got %+v", name, az.Accounts)
+		}
+	}
+
+	a := accs["A"]
+	if a.NumClients != 1 || a.InMsgs != 2 || a.InBytes != 10 || a.OutMsgs != 0 {
+		t.Fatalf("Unexpected information for account A: %+v", a)
+	}
+	if len(a.Exports) != 1 || a.Exports[0].Subject != "foo.>" || a.Exports[0].Type != "stream" {
+		t.Fatalf("Unexpected exports for account A: %+v", a.Exports)
+	}
+
+	// Filter by account.
+	az = getAccountz("?acc=B")
+	if az.NumAccounts != 1 || az.Accounts[0].Name != "B" {
+		t.Fatalf("Expected only account B, got %+v", az.Accounts)
+	}
+	b := az.Accounts[0]
+	if b.NumClients != 1 || b.NumSubs != 1 || b.InMsgs != 0 || b.OutMsgs != 2 || b.OutBytes != 10 {
+		t.Fatalf("Unexpected information for account B: %+v", b)
+	}
+	if len(b.Imports) != 1 {
+		t.Fatalf("Expected 1 import for account B, got %+v", b.Imports)
+	}
+	if im := b.Imports[0]; im.Subject != "foo.>" || im.Account != "A" || im.To != "a." || im.Type != "stream" {
+		t.Fatalf("Unexpected import for account B: %+v", im)
+	}
+}
+
 // Benchmark our Connz generation. Don't use HTTP here, just measure server endpoint.
 func Benchmark_Connz(b *testing.B) {
 	runtime.MemProfileRate = 0
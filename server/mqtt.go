@@ -0,0 +1,1114 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nats-io/nkeys"
+)
+
+// MQTT 3.1.1 control packet types and flags.
+const (
+	mqttPacketConnect    = 0x10
+	mqttPacketConnAck    = 0x20
+	mqttPacketPub        = 0x30
+	mqttPacketPubAck     = 0x40
+	mqttPacketSub        = 0x80
+	mqttPacketSubAck     = 0x90
+	mqttPacketUnsub      = 0xa0
+	mqttPacketUnsubAck   = 0xb0
+	mqttPacketPing       = 0xc0
+	mqttPacketPingResp   = 0xd0
+	mqttPacketDisconnect = 0xe0
+	mqttPacketMask       = 0xf0
+	mqttFlagsMask        = 0x0f
+
+	// SUBSCRIBE and UNSUBSCRIBE must have these fixed header flags.
+	mqttSubFlags = 0x02
+
+	mqttPubFlagDup = 0x08
+	mqttPubQoSMask = 0x06
+
+	mqttConnFlagReserved   = 0x01
+	mqttConnFlagClean      = 0x02
+	mqttConnFlagWill       = 0x04
+	mqttConnFlagWillQoS    = 0x18
+	mqttConnFlagWillRetain = 0x20
+	mqttConnFlagPassword   = 0x40
+	mqttConnFlagUsername   = 0x80
+
+	mqttConnAckAccepted             = 0
+	mqttConnAckUnacceptableProtocol = 1
+	mqttConnAckIdentifierRejected   = 2
+	mqttConnAckBadCredentials       = 4
+	mqttConnAckNotAuthorized        = 5
+
+	mqttSubAckFailure = 0x80
+
+	mqttProtoName  = "MQTT"
+	mqttProtoLevel = 4
+
+	// Largest PUBLISH header: topic with its length and the packet identifier.
+	mqttMaxPubOverhead = 2 + 65535 + 2
+)
+
+// srvMQTT holds the MQTT listener and the sessions of the MQTT clients.
+type srvMQTT struct {
+	listener net.Listener
+	mu       sync.Mutex
+	sessions map[mqttSessionKey]*mqttSession
+}
+
+// mqttSessionKey identifies a session. The client identifier is scoped to
+// the account and user of the connection, so that a client can neither
+// take over nor resume the session of another user.
+type mqttSessionKey struct {
+	acc  string
+	user string
+	id   string
+}
+
+// mqttSession is the state kept for a client identifier. Unless the client
+// asked for a clean session, it survives the connection for a while so that
+// the subscriptions are restored and the unacknowledged QoS 1 messages are
+// redelivered when the client reconnects.
+type mqttSession struct {
+	mu          sync.Mutex
+	key         mqttSessionKey
+	clean       bool
+	c           *client
+	subs        map[string]byte // Granted QoS keyed by subject, which is also the sid.
+	pending     map[uint16]*mqttPending
+	pi          uint16
+	maxInFlight int
+	queued      []*mqttPending // QoS 1 messages waiting for room in the in-flight window.
+	qb          int64          // Size of the queued payloads.
+	expire      *time.Timer    // Removes the session once detached for too long.
+}
+
+// mqttPending is a QoS 1 message waiting for its PUBACK, or to be sent.
+type mqttPending struct {
+	subject string
+	topic   []byte
+	payload []byte
+}
+
+// mqtt is the state of an MQTT client connection. The session is
+// protected by the client's lock, the rest is owned by the readLoop.
+type mqtt struct {
+	sess      *mqttSession
+	connected bool
+	keepAlive time.Duration
+	will      *mqttWill
+	buf       []byte // Partial packet carried over to the next read.
+}
+
+// mqttWill is the message published when a client goes away without
+// sending a DISCONNECT.
+type mqttWill struct {
+	subject string
+	payload []byte
+}
+
+// startMQTT starts accepting MQTT client connections.
+func (s *Server) startMQTT() {
+	// Snapshot server options.
+	opts := s.getOpts()
+	o := &opts.MQTT
+
+	port := o.Port
+	if port == -1 {
+		port = 0
+	}
+	hp := net.JoinHostPort(o.Host, strconv.Itoa(port))
+	l, e := net.Listen("tcp", hp)
+	if e != nil {
+		s.Fatalf("Error listening on MQTT port: %d - %v", o.Port, e)
+		return
+	}
+	s.Noticef("Listening for MQTT clients on %s",
+		net.JoinHostPort(o.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)))
+	if o.TLSConfig != nil {
+		s.Noticef("TLS required for MQTT clients")
+	}
+
+	s.mu.Lock()
+	// If we have selected a random port...
+	if port == 0 {
+		// Write resolved port back to options.
+		opts.MQTT.Port = l.Addr().(*net.TCPAddr).Port
+	}
+	s.mqtt.listener = l
+	s.mu.Unlock()
+
+	s.mqtt.mu.Lock()
+	if s.mqtt.sessions == nil {
+		s.mqtt.sessions = make(map[mqttSessionKey]*mqttSession)
+	}
+	s.mqtt.mu.Unlock()
+
+	go s.mqttAcceptLoop(l)
+}
+
+func (s *Server) mqttAcceptLoop(l net.Listener) {
+	tmpDelay := ACCEPT_MIN_SLEEP
+
+	for s.isRunning() {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.Debugf("Temporary MQTT Accept Error(%v), sleeping %dms",
+					ne, tmpDelay/time.Millisecond)
+				time.Sleep(tmpDelay)
+				tmpDelay *= 2
+				if tmpDelay > ACCEPT_MAX_SLEEP {
+					tmpDelay = ACCEPT_MAX_SLEEP
+				}
+			} else if s.isRunning() {
+				s.Noticef("Accept error: %v", err)
+			}
+			continue
+		}
+		tmpDelay = ACCEPT_MIN_SLEEP
+		s.startGoRoutine(func() {
+			s.createMQTTClient(conn)
+			s.grWG.Done()
+		})
+	}
+	s.Debugf("MQTT accept loop exiting..")
+	s.done <- true
+}
+
+// createMQTTClient creates a regular client that speaks MQTT on the wire.
+// Nothing is sent until the client's CONNECT is received.
+func (s *Server) createMQTTClient(conn net.Conn) *client {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	now := time.Now()
+	c := &client{srv: s, nc: conn, mpay: int32(opts.MaxPayload), msubs: opts.MaxSubs, start: now, last: now, mqtt: &mqtt{}}
+	// There is no "no local" in MQTT 3.1.1, and acknowledgments are
+	// part of the protocol, so never verbose.
+	c.opts = clientOpts{Echo: true}
+
+	c.registerWithAccount(s.gacc)
+
+	s.mu.Lock()
+	s.totalClients++
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	c.initClient()
+	c.echo = true
+	c.Debugf("MQTT client connection created")
+	c.mu.Unlock()
+
+	s.mu.Lock()
+	if !s.running || s.ldm {
+		s.mu.Unlock()
+		return c
+	}
+	if opts.MaxConn > 0 && len(s.clients) >= opts.MaxConn {
+		s.mu.Unlock()
+		c.maxConnExceeded()
+		return nil
+	}
+	s.clients[c.cid] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+
+	if opts.MQTT.TLSConfig != nil {
+		c.Debugf("Starting TLS MQTT client connection handshake")
+		c.nc = tls.Server(c.nc, opts.MQTT.TLSConfig)
+		conn := c.nc.(*tls.Conn)
+
+		ttl := secondsToDuration(opts.MQTT.TLSTimeout)
+		time.AfterFunc(ttl, func() { tlsTimeout(c, conn) })
+		conn.SetReadDeadline(time.Now().Add(ttl))
+
+		c.mu.Unlock()
+		if err := conn.Handshake(); err != nil {
+			c.Errorf("TLS handshake error: %v", err)
+			c.closeConnection(TLSHandshakeError)
+			return nil
+		}
+		conn.SetReadDeadline(time.Time{})
+		c.mu.Lock()
+		c.flags.set(handshakeComplete)
+	}
+
+	// The connection may have been closed
+	if c.nc == nil {
+		c.mu.Unlock()
+		return c
+	}
+
+	// The CONNECT packet has to be received within the auth timeout.
+	// There is no ping timer, MQTT clients are kept alive by PINGREQ.
+	c.setAuthTimer(secondsToDuration(opts.MQTT.AuthTimeout))
+
+	s.startGoRoutine(c.readLoop)
+	s.startGoRoutine(c.writeLoop)
+
+	c.mu.Unlock()
+
+	return c
+}
+
+// mqttParse processes the MQTT packets in buf. A trailing partial packet
+// is kept until the next read.
+func (c *client) mqttParse(buf []byte) error {
+	mq := c.mqtt
+	if len(mq.buf) > 0 {
+		buf = append(mq.buf, buf...)
+		mq.buf = nil
+	}
+	for len(buf) > 0 {
+		rl, n, err := mqttRemainingLength(buf[1:])
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if max := int(atomic.LoadInt32(&c.mpay)); max > 0 && rl > max+mqttMaxPubOverhead {
+			c.maxPayloadViolation(rl, int32(max))
+			return ErrMaxPayload
+		}
+		end := 1 + n + rl
+		if len(buf) < end {
+			break
+		}
+		pt, flags, pkt := buf[0]&mqttPacketMask, buf[0]&mqttFlagsMask, buf[1+n:end]
+		if !mq.connected && pt != mqttPacketConnect {
+			return fmt.Errorf("%v: expected CONNECT, got packet type %d", ErrMQTTProtocol, pt>>4)
+		}
+		switch pt {
+		case mqttPacketConnect:
+			if mq.connected {
+				return fmt.Errorf("%v: second CONNECT", ErrMQTTProtocol)
+			}
+			err = c.mqttProcessConnect(pkt)
+		case mqttPacketPub:
+			err = c.mqttProcessPublish(flags, pkt)
+		case mqttPacketPubAck:
+			err = c.mqttProcessPubAck(pkt)
+		case mqttPacketSub:
+			err = c.mqttProcessSubscribe(flags, pkt)
+		case mqttPacketUnsub:
+			err = c.mqttProcessUnsubscribe(flags, pkt)
+		case mqttPacketPing:
+			c.traceInOp("PINGREQ", nil)
+			c.mqttSendProto("PINGRESP", []byte{mqttPacketPingResp, 0})
+		case mqttPacketDisconnect:
+			c.traceInOp("DISCONNECT", nil)
+			mq.will = nil
+			c.closeConnection(ClientClosed)
+			return nil
+		default:
+			// QoS 2 flows are never started since we grant at most QoS 1.
+			return fmt.Errorf("%v: unsupported packet type %d", ErrMQTTProtocol, pt>>4)
+		}
+		if err != nil {
+			return err
+		}
+		buf = buf[end:]
+	}
+	if len(buf) > 0 {
+		// The read buffer is reused, so copy.
+		mq.buf = append([]byte(nil), buf...)
+	}
+	// A client that stays silent for one and a half keep alive periods
+	// is disconnected.
+	if mq.keepAlive > 0 {
+		c.mu.Lock()
+		if c.nc != nil {
+			c.nc.SetReadDeadline(time.Now().Add(mq.keepAlive * 3 / 2))
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *client) mqttProcessConnect(pkt []byte) error {
+	r := &mqttReader{buf: pkt}
+	proto, err := r.readString("protocol name")
+	if err != nil {
+		return err
+	}
+	level, err := r.readByte("protocol level")
+	if err != nil {
+		return err
+	}
+	if proto != mqttProtoName || level != mqttProtoLevel {
+		c.mqttSendConnAck(mqttConnAckUnacceptableProtocol, false)
+		return fmt.Errorf("%v: unsupported protocol %q level %d", ErrMQTTProtocol, proto, level)
+	}
+	flags, err := r.readByte("connect flags")
+	if err != nil {
+		return err
+	}
+	if flags&mqttConnFlagReserved != 0 {
+		return fmt.Errorf("%v: reserved connect flag set", ErrMQTTProtocol)
+	}
+	ka, err := r.readUint16("keep alive")
+	if err != nil {
+		return err
+	}
+	clientID, err := r.readString("client identifier")
+	if err != nil {
+		return err
+	}
+	clean := flags&mqttConnFlagClean != 0
+
+	var will *mqttWill
+	if flags&mqttConnFlagWill != 0 {
+		if (flags&mqttConnFlagWillQoS)>>3 > 2 {
+			return fmt.Errorf("%v: invalid will QoS", ErrMQTTProtocol)
+		}
+		topic, err := r.readString("will topic")
+		if err != nil {
+			return err
+		}
+		payload, err := r.readBytes("will message")
+		if err != nil {
+			return err
+		}
+		subject, err := mqttTopicToSubject(topic, false)
+		if err != nil {
+			return fmt.Errorf("%v: %v", ErrMQTTProtocol, err)
+		}
+		will = &mqttWill{subject: subject, payload: append([]byte(nil), payload...)}
+	} else if flags&(mqttConnFlagWillQoS|mqttConnFlagWillRetain) != 0 {
+		return fmt.Errorf("%v: will flags set without a will", ErrMQTTProtocol)
+	}
+
+	var username, password string
+	if flags&mqttConnFlagUsername != 0 {
+		if username, err = r.readString("user name"); err != nil {
+			return err
+		}
+	}
+	if flags&mqttConnFlagPassword != 0 {
+		if flags&mqttConnFlagUsername == 0 {
+			return fmt.Errorf("%v: password without a user name", ErrMQTTProtocol)
+		}
+		pwd, err := r.readBytes("password")
+		if err != nil {
+			return err
+		}
+		password = string(pwd)
+	}
+
+	if clientID == "" {
+		if !clean {
+			c.mqttSendConnAck(mqttConnAckIdentifierRejected, false)
+			return fmt.Errorf("%v: empty client identifier requires a clean session", ErrMQTTProtocol)
+		}
+		var raw [nonceLen]byte
+		c.srv.generateNonce(raw[:])
+		clientID = string(raw[:])
+	}
+
+	if c.trace {
+		c.traceInOp("CONNECT", []byte(fmt.Sprintf("client_id=%q clean=%v keep_alive=%d user=%q", clientID, clean, ka, username)))
+	}
+
+	c.mu.Lock()
+	// If we can't stop the timer, it is closing the connection.
+	if !c.clearAuthTimer() {
+		c.mu.Unlock()
+		return ErrAuthentication
+	}
+	c.last = time.Now()
+	c.opts.Name = clientID
+	c.opts.Username = username
+	c.opts.Password = password
+	// An nkey user signs its client identifier since MQTT has no room for
+	// a server nonce. The signature can then be replayed like a password,
+	// so it is only accepted over TLS.
+	nkey := nkeys.IsValidPublicUserKey(username)
+	_, isTLS := c.nc.(*tls.Conn)
+	if nkey && isTLS {
+		c.opts.Nkey = username
+		c.opts.Sig = password
+		c.nonce = []byte(clientID)
+	}
+	c.flags.set(connectReceived)
+	c.mu.Unlock()
+
+	s := c.srv
+	if nkey && !isTLS {
+		c.Errorf("Nkey authentication requires TLS on the MQTT listener")
+		c.mqttSendConnAck(mqttConnAckBadCredentials, false)
+		c.authViolation()
+		return ErrAuthentication
+	}
+	if !s.checkAuthentication(c) {
+		rc := byte(mqttConnAckNotAuthorized)
+		if username != "" {
+			rc = mqttConnAckBadCredentials
+		}
+		c.mqttSendConnAck(rc, false)
+		c.authViolation()
+		return ErrAuthentication
+	}
+
+	sess, present, prev := s.mqttAttachSession(c, c.mqttSessionKey(clientID), clean)
+	// Only one connection per session, the newest one wins.
+	if prev != nil {
+		prev.Debugf("Client identifier %q taken over by cid:%d", clientID, c.cid)
+		prev.closeConnection(DuplicateClientID)
+	}
+
+	c.mu.Lock()
+	c.mqtt.sess = sess
+	c.mu.Unlock()
+	c.mqtt.connected = true
+	c.mqtt.keepAlive = time.Duration(ka) * time.Second
+	c.mqtt.will = will
+
+	c.mqttSendConnAck(mqttConnAckAccepted, present)
+
+	// Let the system account know about this new client.
+	s.accountConnectEvent(c)
+	s.informerClientConnected(c)
+
+	if present {
+		c.mqttRestoreSession(sess)
+	}
+	return nil
+}
+
+// mqttSessionKey returns the key of the session of the client identifier
+// for the authenticated user of c.
+func (c *client) mqttSessionKey(id string) mqttSessionKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := mqttSessionKey{user: c.opts.Username, id: id}
+	if key.user == _EMPTY_ {
+		key.user = c.opts.Nkey
+	}
+	if c.acc != nil {
+		key.acc = c.acc.Name
+	}
+	return key
+}
+
+// mqttAttachSession binds the session with the given key to c, starting
+// a new one if needed. It returns whether an existing session was resumed,
+// and the connection that was using it, if any.
+func (s *Server) mqttAttachSession(c *client, key mqttSessionKey, clean bool) (*mqttSession, bool, *client) {
+	sm := &s.mqtt
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	var prev *client
+	sess := sm.sessions[key]
+	if sess != nil {
+		sess.mu.Lock()
+		prev = sess.c
+		sess.c = nil
+		if sess.expire != nil {
+			sess.expire.Stop()
+			sess.expire = nil
+		}
+		sess.mu.Unlock()
+		// A clean session discards the previous state.
+		if clean {
+			sess = nil
+		}
+	}
+	present := sess != nil
+	if sess == nil {
+		sess = &mqttSession{
+			key:     key,
+			subs:    make(map[string]byte),
+			pending: make(map[uint16]*mqttPending),
+		}
+		sm.sessions[key] = sess
+	}
+	sess.mu.Lock()
+	sess.c = c
+	sess.clean = clean
+	sess.maxInFlight = s.getOpts().MQTT.MaxInFlight
+	sess.mu.Unlock()
+	return sess, present, prev
+}
+
+// mqttDetachSession is called when the connection of a session is closed.
+// Clean sessions are then discarded, the others expire unless resumed in
+// time.
+func (s *Server) mqttDetachSession(sess *mqttSession, c *client) {
+	sm := &s.mqtt
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.c != c {
+		// Taken over by another connection.
+		return
+	}
+	sess.c = nil
+	if sm.sessions[sess.key] != sess {
+		return
+	}
+	if sess.clean {
+		delete(sm.sessions, sess.key)
+	} else if ttl := s.getOpts().MQTT.SessionExpiry; ttl > 0 {
+		sess.expire = time.AfterFunc(ttl, func() { s.mqttExpireSession(sess) })
+	}
+}
+
+// mqttExpireSession removes a session that was not resumed in time.
+func (s *Server) mqttExpireSession(sess *mqttSession) {
+	sm := &s.mqtt
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.c != nil || sess.expire == nil || sm.sessions[sess.key] != sess {
+		return
+	}
+	sess.expire = nil
+	delete(sm.sessions, sess.key)
+	s.Debugf("MQTT session %q expired", sess.key.id)
+}
+
+// mqttRestoreSession recreates the subscriptions of a resumed session and
+// redelivers the messages that were not acknowledged. Permissions may have
+// changed since, so subscriptions and messages the client is no longer
+// allowed to receive are dropped from the session.
+func (c *client) mqttRestoreSession(sess *mqttSession) {
+	sess.mu.Lock()
+	subjects := make([]string, 0, len(sess.subs))
+	for subject := range sess.subs {
+		subjects = append(subjects, subject)
+	}
+	sess.mu.Unlock()
+
+	for _, subject := range subjects {
+		c.processSub([]byte(subject + " " + subject))
+	}
+
+	c.mu.Lock()
+	sess.mu.Lock()
+	for _, subject := range subjects {
+		if _, ok := c.subs[subject]; !ok {
+			delete(sess.subs, subject)
+		}
+	}
+	pis := make([]int, 0, len(sess.pending))
+	for pi, pm := range sess.pending {
+		if !c.mqttCanReceive(pm) {
+			delete(sess.pending, pi)
+			continue
+		}
+		pis = append(pis, int(pi))
+	}
+	sort.Ints(pis)
+	for _, pi := range pis {
+		pm := sess.pending[uint16(pi)]
+		c.queueOutbound(mqttPublishHeader(pm.topic, uint16(pi), true, len(pm.payload)))
+		c.queueOutbound(pm.payload)
+	}
+	queued := sess.queued[:0]
+	for _, pm := range sess.queued {
+		if c.mqttCanReceive(pm) {
+			queued = append(queued, pm)
+		} else {
+			sess.qb -= int64(len(pm.payload))
+		}
+	}
+	sess.queued = queued
+	n := c.mqttSendQueued(sess)
+	sess.mu.Unlock()
+	if len(pis) > 0 {
+		c.Debugf("Redelivering %d unacknowledged messages", len(pis))
+	}
+	if len(pis) > 0 || n > 0 {
+		c.pcd[c] = needFlush
+	}
+	c.mu.Unlock()
+}
+
+// mqttCanReceive returns whether the client is still allowed to receive
+// a message stored in its session.
+// Lock should be held.
+func (c *client) mqttCanReceive(pm *mqttPending) bool {
+	if !c.canSubscribe(pm.subject) || (c.mperms != nil && c.checkDenySub(pm.subject)) {
+		c.Debugf("Not delivering message on %q, subscription not allowed", pm.subject)
+		return false
+	}
+	return true
+}
+
+func (c *client) mqttProcessPublish(flags byte, pkt []byte) error {
+	qos := (flags & mqttPubQoSMask) >> 1
+	if qos > 1 {
+		return fmt.Errorf("%v: QoS %d not supported", ErrMQTTProtocol, qos)
+	}
+	r := &mqttReader{buf: pkt}
+	topic, err := r.readString("topic")
+	if err != nil {
+		return err
+	}
+	var pi uint16
+	if qos == 1 {
+		if pi, err = r.readUint16("packet identifier"); err != nil {
+			return err
+		}
+		if pi == 0 {
+			return fmt.Errorf("%v: packet identifier can not be zero", ErrMQTTProtocol)
+		}
+	}
+	subject, err := mqttTopicToSubject(topic, false)
+	if err != nil {
+		return fmt.Errorf("%v: %v", ErrMQTTProtocol, err)
+	}
+	payload := r.rest()
+	if max := atomic.LoadInt32(&c.mpay); max > 0 && int32(len(payload)) > max {
+		c.maxPayloadViolation(len(payload), max)
+		return ErrMaxPayload
+	}
+	c.mqttPublish(subject, payload)
+	if qos == 1 {
+		c.mqttSendProto("PUBACK", mqttPacketWithID(mqttPacketPubAck, pi))
+	}
+	return nil
+}
+
+// mqttPublish processes a message as if it was a PUB from a NATS client.
+// The retain flag is not supported and is ignored.
+func (c *client) mqttPublish(subject string, payload []byte) {
+	msg := make([]byte, len(payload)+LEN_CR_LF)
+	copy(msg, payload)
+	copy(msg[len(payload):], CR_LF)
+	c.pa.subject = []byte(subject)
+	c.pa.size = len(payload)
+	c.pa.szb = []byte(strconv.Itoa(len(payload)))
+	c.processInboundClientMsg(msg)
+	c.pa.subject, c.pa.szb = nil, nil
+}
+
+// mqttPublishWill publishes the will of a connection that was not closed
+// by a DISCONNECT. This is called when the readLoop exits.
+func (c *client) mqttPublishWill() {
+	will := c.mqtt.will
+	if will == nil {
+		return
+	}
+	c.mqtt.will = nil
+	c.Debugf("Publishing will on %q", will.subject)
+	c.mqttPublish(will.subject, will.payload)
+	c.flushClients()
+}
+
+func (c *client) mqttProcessPubAck(pkt []byte) error {
+	r := &mqttReader{buf: pkt}
+	pi, err := r.readUint16("packet identifier")
+	if err != nil {
+		return err
+	}
+	c.traceInOp("PUBACK", []byte(strconv.Itoa(int(pi))))
+	c.mu.Lock()
+	sess := c.mqtt.sess
+	sess.mu.Lock()
+	delete(sess.pending, pi)
+	// Make use of the room for the messages waiting for it.
+	if c.mqttSendQueued(sess) > 0 {
+		c.pcd[c] = needFlush
+	}
+	sess.mu.Unlock()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *client) mqttProcessSubscribe(flags byte, pkt []byte) error {
+	if flags != mqttSubFlags {
+		return fmt.Errorf("%v: invalid SUBSCRIBE flags", ErrMQTTProtocol)
+	}
+	r := &mqttReader{buf: pkt}
+	pi, err := r.readUint16("packet identifier")
+	if err != nil {
+		return err
+	}
+	if !r.hasMore() {
+		return fmt.Errorf("%v: SUBSCRIBE without topic filters", ErrMQTTProtocol)
+	}
+	ack := mqttPacketWithID(mqttPacketSubAck, pi)
+	for r.hasMore() {
+		filter, err := r.readString("topic filter")
+		if err != nil {
+			return err
+		}
+		qos, err := r.readByte("requested QoS")
+		if err != nil {
+			return err
+		}
+		if qos > 2 {
+			return fmt.Errorf("%v: invalid requested QoS", ErrMQTTProtocol)
+		}
+		if qos > 1 {
+			qos = 1
+		}
+		subject, err := mqttTopicToSubject(filter, true)
+		if err != nil {
+			c.Debugf("Rejecting subscription to %q: %v", filter, err)
+			ack = append(ack, mqttSubAckFailure)
+			continue
+		}
+		ack = append(ack, c.mqttSubscribe(subject, qos))
+	}
+	ack[1] = byte(len(ack) - 2)
+	if len(ack)-2 > 127 {
+		ack = append(mqttAppendRemainingLength([]byte{mqttPacketSubAck}, len(ack)-2), ack[2:]...)
+	}
+	c.mqttSendProto("SUBACK", ack)
+	return nil
+}
+
+// mqttSubscribe subscribes to the subject, or updates the QoS of the
+// existing subscription. It returns the granted QoS, or the failure code
+// if the subscription was not allowed.
+func (c *client) mqttSubscribe(subject string, qos byte) byte {
+	c.mu.Lock()
+	sess := c.mqtt.sess
+	_, exists := c.subs[subject]
+	c.mu.Unlock()
+
+	sess.mu.Lock()
+	sess.subs[subject] = qos
+	sess.mu.Unlock()
+
+	if !exists {
+		c.processSub([]byte(subject + " " + subject))
+		c.mu.Lock()
+		_, exists = c.subs[subject]
+		c.mu.Unlock()
+		if !exists {
+			sess.mu.Lock()
+			delete(sess.subs, subject)
+			sess.mu.Unlock()
+			return mqttSubAckFailure
+		}
+	}
+	return qos
+}
+
+func (c *client) mqttProcessUnsubscribe(flags byte, pkt []byte) error {
+	if flags != mqttSubFlags {
+		return fmt.Errorf("%v: invalid UNSUBSCRIBE flags", ErrMQTTProtocol)
+	}
+	r := &mqttReader{buf: pkt}
+	pi, err := r.readUint16("packet identifier")
+	if err != nil {
+		return err
+	}
+	if !r.hasMore() {
+		return fmt.Errorf("%v: UNSUBSCRIBE without topic filters", ErrMQTTProtocol)
+	}
+	c.mu.Lock()
+	sess := c.mqtt.sess
+	c.mu.Unlock()
+	for r.hasMore() {
+		filter, err := r.readString("topic filter")
+		if err != nil {
+			return err
+		}
+		subject, err := mqttTopicToSubject(filter, true)
+		if err != nil {
+			continue
+		}
+		sess.mu.Lock()
+		delete(sess.subs, subject)
+		sess.mu.Unlock()
+		c.processUnsub([]byte(subject))
+	}
+	c.mqttSendProto("UNSUBACK", mqttPacketWithID(mqttPacketUnsubAck, pi))
+	return nil
+}
+
+// mqttEnqueuePublish queues a message for an MQTT subscriber, as a PUBLISH
+// with the QoS granted to the subscription. QoS 1 messages are kept in the
+// session until acknowledged. Once the client has too many of them in
+// flight, the next ones wait in the session until it acknowledges some,
+// and the client is a slow consumer if they exceed its max pending.
+// Lock should be held.
+func (c *client) mqttEnqueuePublish(sub *subscription, subject, payload []byte) {
+	sess := c.mqtt.sess
+	if sess == nil {
+		return
+	}
+	topic := mqttSubjectToTopic(subject)
+	var pi uint16
+	sess.mu.Lock()
+	if sess.subs[string(sub.sid)] == 1 {
+		pm := &mqttPending{subject: string(subject), topic: topic, payload: append([]byte(nil), payload...)}
+		// Keep the order if some are already waiting.
+		if len(sess.queued) == 0 {
+			pi = sess.trackPending(pm)
+		}
+		if pi == 0 {
+			sess.queued = append(sess.queued, pm)
+			sess.qb += int64(len(pm.payload))
+			slow := sess.qb > c.out.mp
+			sess.mu.Unlock()
+			// The messages stay in the session, so they are
+			// delivered if the client resumes it.
+			if slow {
+				c.clearConnection(SlowConsumerPendingBytes)
+				atomic.AddInt64(&c.srv.slowConsumers, 1)
+				c.Noticef("Slow Consumer Detected: MaxPending of %d Exceeded by QoS 1 messages waiting for acknowledgment", c.out.mp)
+			}
+			return
+		}
+	}
+	sess.mu.Unlock()
+	c.queueOutbound(mqttPublishHeader(topic, pi, false, len(payload)))
+	c.queueOutbound(payload)
+}
+
+// mqttSendQueued sends the QoS 1 messages waiting in the session for as
+// long as the in-flight limit allows, and returns how many were sent.
+// Client and session locks should be held.
+func (c *client) mqttSendQueued(sess *mqttSession) int {
+	n := 0
+	for len(sess.queued) > 0 {
+		pm := sess.queued[0]
+		pi := sess.trackPending(pm)
+		if pi == 0 {
+			break
+		}
+		sess.queued[0] = nil
+		sess.queued = sess.queued[1:]
+		sess.qb -= int64(len(pm.payload))
+		c.queueOutbound(mqttPublishHeader(pm.topic, pi, false, len(pm.payload)))
+		c.queueOutbound(pm.payload)
+		n++
+	}
+	if len(sess.queued) == 0 {
+		sess.queued = nil
+	}
+	return n
+}
+
+// trackPending keeps a QoS 1 message until acknowledged and returns its
+// packet identifier, or zero if the in-flight limit is reached.
+// Lock should be held.
+func (sess *mqttSession) trackPending(pm *mqttPending) uint16 {
+	max := sess.maxInFlight
+	if max <= 0 || max > 1<<16-1 {
+		max = 1<<16 - 1
+	}
+	if len(sess.pending) >= max {
+		return 0
+	}
+	for {
+		sess.pi++
+		if sess.pi == 0 {
+			sess.pi = 1
+		}
+		if _, used := sess.pending[sess.pi]; !used {
+			break
+		}
+	}
+	sess.pending[sess.pi] = pm
+	return sess.pi
+}
+
+// mqttSendProto queues a control packet, it is flushed when the readLoop
+// is done with the current buffer.
+func (c *client) mqttSendProto(op string, pkt []byte) {
+	c.mu.Lock()
+	c.traceOutOp(op, nil)
+	c.sendProto(pkt, false)
+	c.pcd[c] = needFlush
+	c.mu.Unlock()
+}
+
+func (c *client) mqttSendConnAck(rc byte, present bool) {
+	var sp byte
+	if present {
+		sp = 1
+	}
+	c.mqttSendProto("CONNACK", []byte{mqttPacketConnAck, 2, sp, rc})
+}
+
+// mqttPacketWithID returns a packet made of the packet identifier, with
+// room left for more data.
+func mqttPacketWithID(pt byte, pi uint16) []byte {
+	pkt := make([]byte, 4, 8)
+	pkt[0], pkt[1] = pt, 2
+	binary.BigEndian.PutUint16(pkt[2:], pi)
+	return pkt
+}
+
+// mqttPublishHeader returns the header of a PUBLISH packet, up to the
+// payload. A zero packet identifier means QoS 0.
+func mqttPublishHeader(topic []byte, pi uint16, dup bool, size int) []byte {
+	pt := byte(mqttPacketPub)
+	rl := 2 + len(topic) + size
+	if pi != 0 {
+		pt |= 1 << 1
+		rl += 2
+	}
+	if dup {
+		pt |= mqttPubFlagDup
+	}
+	hdr := make([]byte, 0, 5+2+len(topic)+2)
+	hdr = mqttAppendRemainingLength(append(hdr, pt), rl)
+	hdr = append(hdr, byte(len(topic)>>8), byte(len(topic)))
+	hdr = append(hdr, topic...)
+	if pi != 0 {
+		hdr = append(hdr, byte(pi>>8), byte(pi))
+	}
+	return hdr
+}
+
+// mqttRemainingLength decodes the size of a packet. It returns a zero
+// count of bytes if more data is needed.
+func mqttRemainingLength(b []byte) (int, int, error) {
+	rl, mul := 0, 1
+	for i := 0; i < 4; i++ {
+		if i == len(b) {
+			return 0, 0, nil
+		}
+		rl += int(b[i]&0x7f) * mul
+		if b[i]&0x80 == 0 {
+			return rl, i + 1, nil
+		}
+		mul <<= 7
+	}
+	return 0, 0, fmt.Errorf("%v: malformed remaining length", ErrMQTTProtocol)
+}
+
+func mqttAppendRemainingLength(b []byte, rl int) []byte {
+	for {
+		d := byte(rl & 0x7f)
+		rl >>= 7
+		if rl > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if rl == 0 {
+			return b
+		}
+	}
+}
+
+// mqttTopicToSubject converts an MQTT topic name, or a topic filter when
+// wildcards are allowed, to a NATS subject. Each level becomes a token,
+// so empty levels, or levels that would not be a literal token, are
+// rejected. Note that '#' does not match the parent level as it does in
+// MQTT, since '>' needs at least one token.
+func mqttTopicToSubject(topic string, wildcards bool) (string, error) {
+	if topic == "" {
+		return "", ErrMQTTInvalidTopic
+	}
+	levels := strings.Split(topic, "/")
+	for i, l := range levels {
+		switch {
+		case l == "":
+			return "", ErrMQTTInvalidTopic
+		case l == "+" && wildcards:
+			levels[i] = string(pwc)
+		case l == "#" && wildcards && i == len(levels)-1:
+			levels[i] = string(fwc)
+		case l == string(pwc) || l == string(fwc) || strings.ContainsAny(l, "+#. \t\r\n"):
+			return "", ErrMQTTInvalidTopic
+		}
+	}
+	return strings.Join(levels, "."), nil
+}
+
+// mqttSubjectToTopic converts the subject of a delivered message to a topic.
+func mqttSubjectToTopic(subject []byte) []byte {
+	return bytes.Replace(subject, []byte("."), []byte("/"), -1)
+}
+
+// mqttReader reads the fields of a packet.
+type mqttReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *mqttReader) hasMore() bool {
+	return r.pos < len(r.buf)
+}
+
+func (r *mqttReader) rest() []byte {
+	b := r.buf[r.pos:]
+	r.pos = len(r.buf)
+	return b
+}
+
+func (r *mqttReader) readByte(field string) (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, fmt.Errorf("%v: error reading %s", ErrMQTTProtocol, field)
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *mqttReader) readUint16(field string) (uint16, error) {
+	if r.pos+2 > len(r.buf) {
+		return 0, fmt.Errorf("%v: error reading %s", ErrMQTTProtocol, field)
+	}
+	v := binary.BigEndian.Uint16(r.buf[r.pos:])
+	r.pos += 2
+	return v, nil
+}
+
+// readBytes reads a length prefixed field. The result references the
+// packet.
+func (r *mqttReader) readBytes(field string) ([]byte, error) {
+	l, err := r.readUint16(field)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos+int(l) > len(r.buf) {
+		return nil, fmt.Errorf("%v: error reading %s", ErrMQTTProtocol, field)
+	}
+	b := r.buf[r.pos : r.pos+int(l)]
+	r.pos += int(l)
+	return b, nil
+}
+
+func (r *mqttReader) readString(field string) (string, error) {
+	b, err := r.readBytes(field)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("%v: %s is not valid UTF-8", ErrMQTTProtocol, field)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,630 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nkeys"
+)
+
+type testMQTTClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+type testMQTTConnect struct {
+	clientID string
+	clean    bool
+	user     string
+	pass     string
+	willSubj string
+	willMsg  string
+}
+
+func testMQTTOptions() *Options {
+	opts := DefaultOptions()
+	opts.MQTT.Host = "127.0.0.1"
+	opts.MQTT.Port = -1
+	return opts
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// testMQTTDial sends a CONNECT and returns the CONNACK's session present
+// flag and return code.
+func testMQTTDial(t *testing.T, s *Server, ci testMQTTConnect) (*testMQTTClient, bool, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.MQTTAddr().String())
+	if err != nil {
+		t.Fatalf("Error dialing MQTT: %v", err)
+	}
+	if s.getOpts().MQTT.TLSConfig != nil {
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	mc := &testMQTTClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+
+	var flags byte
+	if ci.clean {
+		flags |= mqttConnFlagClean
+	}
+	var payload []byte
+	payload = mqttString(payload, ci.clientID)
+	if ci.willSubj != "" {
+		flags |= mqttConnFlagWill
+		payload = mqttString(payload, ci.willSubj)
+		payload = mqttString(payload, ci.willMsg)
+	}
+	if ci.user != "" {
+		flags |= mqttConnFlagUsername
+		payload = mqttString(payload, ci.user)
+	}
+	if ci.pass != "" {
+		flags |= mqttConnFlagPassword
+		payload = mqttString(payload, ci.pass)
+	}
+	body := mqttString(nil, mqttProtoName)
+	body = append(body, mqttProtoLevel, flags, 0, 30)
+	mc.send(mqttPacketConnect, append(body, payload...))
+
+	pt, ack := mc.read()
+	if pt != mqttPacketConnAck || len(ack) != 2 {
+		t.Fatalf("Expected CONNACK, got %x %v", pt, ack)
+	}
+	return mc, ack[0] == 1, ack[1]
+}
+
+func (mc *testMQTTClient) send(pt byte, body []byte) {
+	mc.t.Helper()
+	pkt := mqttAppendRemainingLength([]byte{pt}, len(body))
+	if _, err := mc.conn.Write(append(pkt, body...)); err != nil {
+		mc.t.Fatalf("Error writing packet: %v", err)
+	}
+}
+
+// read returns the first byte of the fixed header and the body of a packet.
+func (mc *testMQTTClient) read() (byte, []byte) {
+	mc.t.Helper()
+	pt, err := mc.br.ReadByte()
+	if err != nil {
+		mc.t.Fatalf("Error reading packet: %v", err)
+	}
+	rl, mul := 0, 1
+	for {
+		b, err := mc.br.ReadByte()
+		if err != nil {
+			mc.t.Fatalf("Error reading packet: %v", err)
+		}
+		rl += int(b&0x7f) * mul
+		if b&0x80 == 0 {
+			break
+		}
+		mul <<= 7
+	}
+	body := make([]byte, rl)
+	if _, err := io.ReadFull(mc.br, body); err != nil {
+		mc.t.Fatalf("Error reading packet: %v", err)
+	}
+	return pt, body
+}
+
+func (mc *testMQTTClient) subscribe(pi uint16, filter string, qos byte) []byte {
+	mc.t.Helper()
+	body := mqttString([]byte{byte(pi >> 8), byte(pi)}, filter)
+	mc.send(mqttPacketSub|mqttSubFlags, append(body, qos))
+	pt, ack := mc.read()
+	if pt != mqttPacketSubAck || binary.BigEndian.Uint16(ack) != pi {
+		mc.t.Fatalf("Expected SUBACK for %d, got %x %v", pi, pt, ack)
+	}
+	return ack[2:]
+}
+
+func (mc *testMQTTClient) publish(topic string, pi uint16, payload string) {
+	mc.t.Helper()
+	pt := byte(mqttPacketPub)
+	body := mqttString(nil, topic)
+	if pi != 0 {
+		pt |= 1 << 1
+		body = append(body, byte(pi>>8), byte(pi))
+	}
+	mc.send(pt, append(body, payload...))
+	if pi != 0 {
+		if pt, ack := mc.read(); pt != mqttPacketPubAck || binary.BigEndian.Uint16(ack) != pi {
+			mc.t.Fatalf("Expected PUBACK for %d, got %x %v", pi, pt, ack)
+		}
+	}
+}
+
+// expectPublish reads a PUBLISH and checks its topic and payload. It
+// returns the fixed header flags and the packet identifier.
+func (mc *testMQTTClient) expectPublish(topic, payload string) (byte, uint16) {
+	mc.t.Helper()
+	pt, body := mc.read()
+	if pt&mqttPacketMask != mqttPacketPub {
+		mc.t.Fatalf("Expected PUBLISH, got %x", pt)
+	}
+	r := &mqttReader{buf: body}
+	tp, _ := r.readString("topic")
+	var pi uint16
+	if pt&mqttPubQoSMask != 0 {
+		pi, _ = r.readUint16("packet identifier")
+	}
+	if p := string(r.rest()); tp != topic || p != payload {
+		mc.t.Fatalf("Expected %q on %q, got %q on %q", payload, topic, p, tp)
+	}
+	return pt & mqttFlagsMask, pi
+}
+
+func TestMQTTTopicToSubject(t *testing.T) {
+	for _, test := range []struct {
+		topic     string
+		wildcards bool
+		subject   string
+	}{
+		{"foo", false, "foo"},
+		{"foo/bar/baz", false, "foo.bar.baz"},
+		{"sensors/+/temp", true, "sensors.*.temp"},
+		{"sensors/#", true, "sensors.>"},
+		{"+/+", true, "*.*"},
+		{"#", true, ">"},
+		{"sensors/+/temp", false, ""},
+		{"sensors/#", false, ""},
+		{"sensors/#/temp", true, ""},
+		{"sensors/te+mp", true, ""},
+		{"/sensors", false, ""},
+		{"sensors//temp", false, ""},
+		{"sensors/v1.0", false, ""},
+		{"sensors/*", false, ""},
+		{"", false, ""},
+	} {
+		subject, err := mqttTopicToSubject(test.topic, test.wildcards)
+		if test.subject == "" {
+			if err == nil {
+				t.Fatalf("Expected %q to be rejected, got %q", test.topic, subject)
+			}
+		} else if err != nil || subject != test.subject {
+			t.Fatalf("Expected %q for %q, got %q (%v)", test.subject, test.topic, subject, err)
+		}
+	}
+}
+
+func TestMQTTPubSub(t *testing.T) {
+	s := RunServer(testMQTTOptions())
+	defer s.Shutdown()
+
+	mc, sp, rc := testMQTTDial(t, s, testMQTTConnect{clientID: "sensor1", clean: true})
+	defer mc.conn.Close()
+	if sp || rc != mqttConnAckAccepted {
+		t.Fatalf("Unexpected CONNACK: %v %v", sp, rc)
+	}
+	if granted := mc.subscribe(1, "cmd/+/reset", 2); granted[0] != 1 {
+		t.Fatalf("Expected QoS 1 to be granted, got %v", granted)
+	}
+	if granted := mc.subscribe(2, "bad//topic", 0); granted[0] != mqttSubAckFailure {
+		t.Fatalf("Expected failure, got %v", granted)
+	}
+
+	nc := createClientConnWithName(t, "nats", s)
+	defer nc.Close()
+	ch := make(chan *nats.Msg, 2)
+	nc.ChanSubscribe("sensors.>", ch)
+	nc.Flush()
+
+	// MQTT to NATS, with both QoS.
+	mc.publish("sensors/sensor1/temp", 0, "21.5")
+	mc.publish("sensors/sensor1/temp", 7, "22.0")
+	for _, expected := range []string{"21.5", "22.0"} {
+		select {
+		case m := <-ch:
+			if m.Subject != "sensors.sensor1.temp" || string(m.Data) != expected {
+				t.Fatalf("Unexpected message %q on %q", m.Data, m.Subject)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not get the message from the MQTT client")
+		}
+	}
+
+	// NATS to MQTT.
+	nc.Publish("cmd.sensor1.reset", []byte("now"))
+	nc.Flush()
+	flags, pi := mc.expectPublish("cmd/sensor1/reset", "now")
+	if flags&mqttPubQoSMask != 2 || pi == 0 {
+		t.Fatalf("Expected QoS 1 delivery, got flags %x and id %d", flags, pi)
+	}
+	mc.send(mqttPacketPubAck, []byte{byte(pi >> 8), byte(pi)})
+
+	mc.send(mqttPacketPing, nil)
+	if pt, _ := mc.read(); pt != mqttPacketPingResp {
+		t.Fatalf("Expected PINGRESP, got %x", pt)
+	}
+
+	// Unsubscribed topics are no longer delivered.
+	mc.send(mqttPacketUnsub|mqttSubFlags, mqttString([]byte{0, 3}, "cmd/+/reset"))
+	if pt, ack := mc.read(); pt != mqttPacketUnsubAck || binary.BigEndian.Uint16(ack) != 3 {
+		t.Fatalf("Expected UNSUBACK, got %x %v", pt, ack)
+	}
+
+	cz, _ := s.Connz(&ConnzOptions{Subscriptions: true})
+	for _, ci := range cz.Conns {
+		switch ci.Name {
+		case "sensor1":
+			if ci.Type != ConnTypeMQTT || ci.NumSubs != 0 || ci.InMsgs != 2 || ci.OutMsgs != 1 {
+				t.Fatalf("Unexpected MQTT connection info: %+v", ci)
+			}
+		case "nats":
+			if ci.Type != ConnTypeNATS {
+				t.Fatalf("Unexpected connection type: %q", ci.Type)
+			}
+		}
+	}
+
+	// Wildcards can not be used in a PUBLISH.
+	mc.publish("sensors/+/temp", 0, "x")
+	if _, err := mc.br.ReadByte(); err == nil {
+		t.Fatalf("Expected connection to be closed")
+	}
+	checkClientsCount(t, s, 1)
+}
+
+func TestMQTTSession(t *testing.T) {
+	s := RunServer(testMQTTOptions())
+	defer s.Shutdown()
+
+	nc := createClientConnWithName(t, "nats", s)
+	defer nc.Close()
+
+	mc, sp, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	if sp {
+		t.Fatalf("Expected no session to be present")
+	}
+	mc.subscribe(1, "alerts/#", 1)
+	nc.Publish("alerts.fire", []byte("1"))
+	nc.Flush()
+	// Do not acknowledge, and go away.
+	_, pi := mc.expectPublish("alerts/fire", "1")
+	mc.conn.Close()
+	checkClientsCount(t, s, 1)
+
+	// The subscription is restored and the message redelivered.
+	mc, sp, _ = testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	if !sp {
+		t.Fatalf("Expected the session to be present")
+	}
+	flags, rpi := mc.expectPublish("alerts/fire", "1")
+	if flags&mqttPubFlagDup == 0 || rpi != pi {
+		t.Fatalf("Expected a duplicate with id %d, got flags %x and id %d", pi, flags, rpi)
+	}
+	mc.send(mqttPacketPubAck, []byte{byte(rpi >> 8), byte(rpi)})
+	checkExpectedSubs(t, 1, s)
+	nc.Publish("alerts.flood", []byte("2"))
+	nc.Flush()
+	mc.expectPublish("alerts/flood", "2")
+
+	// A new connection with the same client identifier takes over.
+	mc2, sp, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	defer mc2.conn.Close()
+	if !sp {
+		t.Fatalf("Expected the session to be present")
+	}
+	if _, err := mc.br.ReadByte(); err == nil {
+		t.Fatalf("Expected previous connection to be closed")
+	}
+	mc.conn.Close()
+
+	// Asking for a clean session discards it.
+	mc2.conn.Close()
+	checkClientsCount(t, s, 1)
+	mc3, sp, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "dev", clean: true})
+	defer mc3.conn.Close()
+	if sp {
+		t.Fatalf("Expected no session to be present")
+	}
+	checkExpectedSubs(t, 0, s)
+	mc3.conn.Close()
+	checkClientsCount(t, s, 1)
+	s.mqtt.mu.Lock()
+	n := len(s.mqtt.sessions)
+	s.mqtt.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("Expected clean session to be removed, got %d sessions", n)
+	}
+}
+
+func TestMQTTSessionPerUser(t *testing.T) {
+	opts := testMQTTOptions()
+	opts.Users = []*User{
+		{Username: "alice", Password: "pass"},
+		{Username: "bob", Password: "pass"},
+		{Username: "nats", Password: "pass"},
+	}
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://nats:pass@%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	alice := testMQTTConnect{clientID: "dev", user: "alice", pass: "pass"}
+	mc, _, _ := testMQTTDial(t, s, alice)
+	mc.subscribe(1, "alerts/#", 1)
+	nc.Publish("alerts.fire", []byte("1"))
+	nc.Flush()
+	mc.expectPublish("alerts/fire", "1")
+
+	// Another user with the same client identifier gets its own session,
+	// without closing the connection of the first one.
+	mc2, sp, rc := testMQTTDial(t, s, testMQTTConnect{clientID: "dev", user: "bob", pass: "pass"})
+	defer mc2.conn.Close()
+	if sp || rc != mqttConnAckAccepted {
+		t.Fatalf("Unexpected CONNACK: %v %v", sp, rc)
+	}
+	mc2.send(mqttPacketPing, nil)
+	if pt, _ := mc2.read(); pt != mqttPacketPingResp {
+		t.Fatalf("Expected PINGRESP and no redelivery, got %x", pt)
+	}
+	mc.send(mqttPacketPing, nil)
+	if pt, _ := mc.read(); pt != mqttPacketPingResp {
+		t.Fatalf("Expected PINGRESP, got %x", pt)
+	}
+	mc.conn.Close()
+	checkClientsCount(t, s, 2)
+
+	// Messages are not redelivered once the user is no longer allowed to
+	// receive them, and the subscriptions are not restored.
+	s.mu.Lock()
+	s.users["alice"].Permissions = &Permissions{Subscribe: &SubjectPermission{Allow: []string{"cmd.>"}}}
+	s.mu.Unlock()
+	mc, sp, _ = testMQTTDial(t, s, alice)
+	defer mc.conn.Close()
+	if !sp {
+		t.Fatalf("Expected the session to be present")
+	}
+	mc.send(mqttPacketPing, nil)
+	if pt, _ := mc.read(); pt != mqttPacketPingResp {
+		t.Fatalf("Expected PINGRESP and no redelivery, got %x", pt)
+	}
+	checkExpectedSubs(t, 0, s)
+}
+
+func TestMQTTMaxInFlight(t *testing.T) {
+	opts := testMQTTOptions()
+	opts.MQTT.MaxInFlight = 2
+	opts.MaxPending = 1024
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	nc := createClientConnWithName(t, "nats", s)
+	defer nc.Close()
+
+	mc, _, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	mc.subscribe(1, "alerts/#", 1)
+	for _, p := range []string{"1", "2", "3", "4"} {
+		nc.Publish("alerts.fire", []byte(p))
+	}
+	nc.Flush()
+	_, pi1 := mc.expectPublish("alerts/fire", "1")
+	_, pi2 := mc.expectPublish("alerts/fire", "2")
+
+	// The next ones wait for room in the in-flight window.
+	mc.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := mc.br.ReadByte(); err == nil {
+		t.Fatalf("Expected no delivery beyond the in-flight limit")
+	}
+	mc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// Acknowledging sends them, in order and with QoS 1.
+	for i, pi := range []uint16{pi1, pi2} {
+		mc.send(mqttPacketPubAck, []byte{byte(pi >> 8), byte(pi)})
+		p := fmt.Sprintf("%d", i+3)
+		if flags, pi := mc.expectPublish("alerts/fire", p); flags&mqttPubQoSMask != 2 || pi == 0 {
+			t.Fatalf("Expected QoS 1 delivery of %q, got flags %x and id %d", p, flags, pi)
+		}
+	}
+
+	// Waiting messages beyond the max pending make the client a slow
+	// consumer, but they are kept in the session.
+	payload := strings.Repeat("x", 400)
+	for i := 0; i < 3; i++ {
+		nc.Publish("alerts.flood", []byte(payload))
+	}
+	nc.Flush()
+	if _, err := io.Copy(ioutil.Discard, mc.br); err != nil {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+	mc.conn.Close()
+	cz, _ := s.Connz(&ConnzOptions{State: ConnClosed})
+	if len(cz.Conns) != 1 || cz.Conns[0].Reason != SlowConsumerPendingBytes.String() {
+		t.Fatalf("Expected a slow consumer, got %+v", cz.Conns)
+	}
+
+	// Nothing is lost when the session is resumed.
+	mc, _, _ = testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	defer mc.conn.Close()
+	var pis []uint16
+	for _, p := range []string{"3", "4"} {
+		flags, pi := mc.expectPublish("alerts/fire", p)
+		if flags&mqttPubFlagDup == 0 {
+			t.Fatalf("Expected a duplicate, got flags %x", flags)
+		}
+		pis = append(pis, pi)
+	}
+	for _, pi := range pis {
+		mc.send(mqttPacketPubAck, []byte{byte(pi >> 8), byte(pi)})
+	}
+	for i := 0; i < 3; i++ {
+		_, pi := mc.expectPublish("alerts/flood", payload)
+		mc.send(mqttPacketPubAck, []byte{byte(pi >> 8), byte(pi)})
+	}
+}
+
+func TestMQTTSessionExpiry(t *testing.T) {
+	opts := testMQTTOptions()
+	opts.MQTT.SessionExpiry = 100 * time.Millisecond
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	numSessions := func() int {
+		s.mqtt.mu.Lock()
+		defer s.mqtt.mu.Unlock()
+		return len(s.mqtt.sessions)
+	}
+
+	// A session resumed in time is kept.
+	mc, _, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	mc.subscribe(1, "alerts/#", 1)
+	mc.conn.Close()
+	checkClientsCount(t, s, 0)
+	mc, sp, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	if !sp {
+		t.Fatalf("Expected the session to be present")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := numSessions(); n != 1 {
+		t.Fatalf("Expected the session to be kept while connected, got %d sessions", n)
+	}
+
+	// Otherwise it is removed.
+	mc.conn.Close()
+	checkFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		if n := numSessions(); n != 0 {
+			return fmt.Errorf("Expected the session to expire, got %d sessions", n)
+		}
+		return nil
+	})
+	mc, sp, _ = testMQTTDial(t, s, testMQTTConnect{clientID: "dev"})
+	defer mc.conn.Close()
+	if sp {
+		t.Fatalf("Expected no session to be present")
+	}
+}
+
+func TestMQTTAuth(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	pub, _ := kp.PublicKey()
+
+	opts := testMQTTOptions()
+	opts.Users = []*User{{Username: "device", Password: "s3cr3t"}}
+	opts.Nkeys = []*NkeyUser{{Nkey: pub}}
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	tlsOpts := testMQTTOptions()
+	tlsOpts.Nkeys = []*NkeyUser{{Nkey: pub}}
+	tc := &TLSConfigOpts{
+		CertFile: "../test/configs/certs/server-cert.pem",
+		KeyFile:  "../test/configs/certs/server-key.pem",
+	}
+	var err error
+	if tlsOpts.MQTT.TLSConfig, err = GenTLSConfig(tc); err != nil {
+		t.Fatalf("Error generating TLS config: %v", err)
+	}
+	tlsOpts.MQTT.TLSTimeout = 2
+	ts := RunServer(tlsOpts)
+	defer ts.Shutdown()
+
+	sig, _ := kp.Sign([]byte("signed"))
+	for _, test := range []struct {
+		name string
+		s    *Server
+		ci   testMQTTConnect
+		rc   byte
+	}{
+		{"user", s, testMQTTConnect{clientID: "a", clean: true, user: "device", pass: "s3cr3t"}, mqttConnAckAccepted},
+		{"bad password", s, testMQTTConnect{clientID: "b", clean: true, user: "device", pass: "wrong"}, mqttConnAckBadCredentials},
+		{"no credentials", s, testMQTTConnect{clientID: "c", clean: true}, mqttConnAckNotAuthorized},
+		{"nkey without tls", s, testMQTTConnect{clientID: "signed", clean: true, user: pub, pass: base64.StdEncoding.EncodeToString(sig)}, mqttConnAckBadCredentials},
+		{"nkey", ts, testMQTTConnect{clientID: "signed", clean: true, user: pub, pass: base64.StdEncoding.EncodeToString(sig)}, mqttConnAckAccepted},
+		{"nkey other client id", ts, testMQTTConnect{clientID: "other", clean: true, user: pub, pass: base64.StdEncoding.EncodeToString(sig)}, mqttConnAckBadCredentials},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mc, _, rc := testMQTTDial(t, test.s, test.ci)
+			defer mc.conn.Close()
+			if rc != test.rc {
+				t.Fatalf("Expected return code %d, got %d", test.rc, rc)
+			}
+		})
+	}
+}
+
+func TestMQTTWill(t *testing.T) {
+	s := RunServer(testMQTTOptions())
+	defer s.Shutdown()
+
+	nc := createClientConnWithName(t, "nats", s)
+	defer nc.Close()
+	ch := make(chan *nats.Msg, 2)
+	nc.ChanSubscribe("status.>", ch)
+	nc.Flush()
+
+	// A DISCONNECT discards the will.
+	mc, _, _ := testMQTTDial(t, s, testMQTTConnect{clientID: "a", clean: true, willSubj: "status/a", willMsg: "gone"})
+	mc.send(mqttPacketDisconnect, nil)
+	mc.conn.Close()
+
+	mc, _, _ = testMQTTDial(t, s, testMQTTConnect{clientID: "b", clean: true, willSubj: "status/b", willMsg: "gone"})
+	mc.conn.Close()
+
+	select {
+	case m := <-ch:
+		if m.Subject != "status.b" || string(m.Data) != "gone" {
+			t.Fatalf("Unexpected will %q on %q", m.Data, m.Subject)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Will was not published")
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("Unexpected message %q on %q", m.Data, m.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMQTTConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		mqtt {
+			listen: "127.0.0.1:-1"
+			connect_timeout: 5
+			max_inflight: 10
+			session_expiry: 60
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	if opts.MQTT.Host != "127.0.0.1" || opts.MQTT.Port != -1 || opts.MQTT.AuthTimeout != 5 ||
+		opts.MQTT.MaxInFlight != 10 || opts.MQTT.SessionExpiry != time.Minute {
+		t.Fatalf("Unexpected options: %+v", opts.MQTT)
+	}
+}
@@ -47,60 +47,152 @@ type ClusterOpts struct {
 	ConnectRetries int               `json:"-"`
 }
 
+// LeafNodeOpts are options for a given server to accept leaf node connections and/or connect to a remote cluster.
+type LeafNodeOpts struct {
+	Host              string            `json:"addr,omitempty"`
+	Port              int               `json:"port,omitempty"`
+	Username          string            `json:"-"`
+	Password          string            `json:"-"`
+	Account           string            `json:"-"`
+	AuthTimeout       float64           `json:"auth_timeout,omitempty"`
+	TLSConfig         *tls.Config       `json:"-"`
+	TLSTimeout        float64           `json:"tls_timeout,omitempty"`
+	ReconnectInterval time.Duration     `json:"-"`
+	Remotes           []*RemoteLeafOpts `json:"remotes,omitempty"`
+}
+
+// RemoteLeafOpts are options for connecting to a remote server as a leaf node.
+type RemoteLeafOpts struct {
+	LocalAccount string      `json:"local_account,omitempty"`
+	URL          *url.URL    `json:"url,omitempty"`
+	TLSConfig    *tls.Config `json:"-"`
+	TLSTimeout   float64     `json:"tls_timeout,omitempty"`
+}
+
+// WebsocketOpts are options for accepting client connections over websocket.
+type WebsocketOpts struct {
+	Host             string        `json:"addr,omitempty"`
+	Port             int           `json:"port,omitempty"`
+	TLSConfig        *tls.Config   `json:"-"`
+	TLSTimeout       float64       `json:"tls_timeout,omitempty"`
+	AllowedOrigins   []string      `json:"allowed_origins,omitempty"`
+	Compression      bool          `json:"compression,omitempty"`
+	HandshakeTimeout time.Duration `json:"-"`
+}
+
+// MQTTOpts are options for accepting MQTT client connections.
+type MQTTOpts struct {
+	Host          string        `json:"addr,omitempty"`
+	Port          int           `json:"port,omitempty"`
+	TLSConfig     *tls.Config   `json:"-"`
+	TLSTimeout    float64       `json:"tls_timeout,omitempty"`
+	AuthTimeout   float64       `json:"auth_timeout,omitempty"`
+	MaxInFlight   int           `json:"max_inflight,omitempty"`
+	SessionExpiry time.Duration `json:"session_expiry,omitempty"`
+}
+
+// ListenerOpts are options for an additional client listen endpoint.
+// Clients that connect through it are bound to Account when set.
+type ListenerOpts struct {
+	Host        string      `json:"addr,omitempty"`
+	Port        int         `json:"port,omitempty"`
+	TLSConfig   *tls.Config `json:"-"`
+	TLSTimeout  float64     `json:"tls_timeout,omitempty"`
+	AuthTimeout float64     `json:"auth_timeout,omitempty"`
+	Account     string      `json:"account,omitempty"`
+}
+
+// GatewayOpts are options for gateways.
+type GatewayOpts struct {
+	Name        string               `json:"name"`
+	Host        string               `json:"addr,omitempty"`
+	Port        int                  `json:"port,omitempty"`
+	Username    string               `json:"-"`
+	Password    string               `json:"-"`
+	AuthTimeout float64              `json:"auth_timeout,omitempty"`
+	TLSConfig   *tls.Config          `json:"-"`
+	TLSTimeout  float64              `json:"tls_timeout,omitempty"`
+	Gateways    []*RemoteGatewayOpts `json:"gateways,omitempty"`
+}
+
+// RemoteGatewayOpts are options for connecting to a remote gateway.
+type RemoteGatewayOpts struct {
+	Name       string      `json:"name"`
+	TLSConfig  *tls.Config `json:"-"`
+	TLSTimeout float64     `json:"tls_timeout,omitempty"`
+	URLs       []*url.URL  `json:"urls,omitempty"`
+}
+
 // Options block for gnatsd server.
 type Options struct {
-	ConfigFile       string        `json:"-"`
-	Host             string        `json:"addr"`
-	Port             int           `json:"port"`
-	ClientAdvertise  string        `json:"-"`
-	Trace            bool          `json:"-"`
-	Debug            bool          `json:"-"`
-	NoLog            bool          `json:"-"`
-	NoSigs           bool          `json:"-"`
-	Logtime          bool          `json:"-"`
-	MaxConn          int           `json:"max_connections"`
-	MaxSubs          int           `json:"max_subscriptions,omitempty"`
-	Nkeys            []*NkeyUser   `json:"-"`
-	Users            []*User       `json:"-"`
-	Accounts         []*Account    `json:"-"`
-	AllowNewAccounts bool          `json:"-"`
-	Username         string        `json:"-"`
-	Password         string        `json:"-"`
-	Authorization    string        `json:"-"`
-	PingInterval     time.Duration `json:"ping_interval"`
-	MaxPingsOut      int           `json:"ping_max"`
-	HTTPHost         string        `json:"http_host"`
-	HTTPPort         int           `json:"http_port"`
-	HTTPSPort        int           `json:"https_port"`
-	AuthTimeout      float64       `json:"auth_timeout"`
-	MaxControlLine   int           `json:"max_control_line"`
-	MaxPayload       int           `json:"max_payload"`
-	MaxPending       int64         `json:"max_pending"`
-	Cluster          ClusterOpts   `json:"cluster,omitempty"`
-	ProfPort         int           `json:"-"`
-	PidFile          string        `json:"-"`
-	PortsFileDir     string        `json:"-"`
-	LogFile          string        `json:"-"`
-	Syslog           bool          `json:"-"`
-	RemoteSyslog     string        `json:"-"`
-	Routes           []*url.URL    `json:"-"`
-	RoutesStr        string        `json:"-"`
-	TLSTimeout       float64       `json:"tls_timeout"`
-	TLS              bool          `json:"-"`
-	TLSVerify        bool          `json:"-"`
-	TLSCert          string        `json:"-"`
-	TLSKey           string        `json:"-"`
-	TLSCaCert        string        `json:"-"`
-	TLSConfig        *tls.Config   `json:"-"`
-	WriteDeadline    time.Duration `json:"-"`
-	RQSubsSweep      time.Duration `json:"-"` // Deprecated
-	MaxClosedClients int           `json:"-"`
-	LameDuckDuration time.Duration `json:"-"`
-	TrustedNkeys     []string      `json:"-"`
+	ConfigFile       string          `json:"-"`
+	Host             string          `json:"addr"`
+	Port             int             `json:"port"`
+	ClientAdvertise  string          `json:"-"`
+	UnixSocket       string          `json:"unix_socket,omitempty"`
+	UnixSocketMode   os.FileMode     `json:"-"`
+	UnixSocketOwner  string          `json:"-"`
+	UnixSocketGroup  string          `json:"-"`
+	Trace            bool            `json:"-"`
+	Debug            bool            `json:"-"`
+	NoLog            bool            `json:"-"`
+	NoSigs           bool            `json:"-"`
+	Logtime          bool            `json:"-"`
+	MaxConn          int             `json:"max_connections"`
+	MaxSubs          int             `json:"max_subscriptions,omitempty"`
+	Nkeys            []*NkeyUser     `json:"-"`
+	Users            []*User         `json:"-"`
+	Accounts         []*Account      `json:"-"`
+	AllowNewAccounts bool            `json:"-"`
+	SystemAccount    string          `json:"-"`
+	Username         string          `json:"-"`
+	Password         string          `json:"-"`
+	Authorization    string          `json:"-"`
+	AuthCallout      *AuthCallout    `json:"-"`
+	PingInterval     time.Duration   `json:"ping_interval"`
+	MaxPingsOut      int             `json:"ping_max"`
+	HTTPHost         string          `json:"http_host"`
+	HTTPPort         int             `json:"http_port"`
+	HTTPSPort        int             `json:"https_port"`
+	AuthTimeout      float64         `json:"auth_timeout"`
+	MaxControlLine   int             `json:"max_control_line"`
+	MaxPayload       int             `json:"max_payload"`
+	MaxPending       int64           `json:"max_pending"`
+	Cluster          ClusterOpts     `json:"cluster,omitempty"`
+	LeafNode         LeafNodeOpts    `json:"leaf,omitempty"`
+	Gateway          GatewayOpts     `json:"gateway,omitempty"`
+	Websocket        WebsocketOpts   `json:"websocket,omitempty"`
+	MQTT             MQTTOpts        `json:"mqtt,omitempty"`
+	Listeners        []*ListenerOpts `json:"listeners,omitempty"`
+	Informer         InformerOpts    `json:"informer,omitempty"`
+	ProfPort         int             `json:"-"`
+	PidFile          string          `json:"-"`
+	PortsFileDir     string          `json:"-"`
+	LogFile          string          `json:"-"`
+	Syslog           bool            `json:"-"`
+	RemoteSyslog     string          `json:"-"`
+	Routes           []*url.URL      `json:"-"`
+	RoutesStr        string          `json:"-"`
+	TLSTimeout       float64         `json:"tls_timeout"`
+	TLS              bool            `json:"-"`
+	TLSVerify        bool            `json:"-"`
+	TLSMap           bool            `json:"-"`
+	TLSCert          string          `json:"-"`
+	TLSKey           string          `json:"-"`
+	TLSCaCert        string          `json:"-"`
+	TLSConfig        *tls.Config     `json:"-"`
+	WriteDeadline    time.Duration   `json:"-"`
+	RQSubsSweep      time.Duration   `json:"-"` // Deprecated
+	MaxClosedClients int             `json:"-"`
+	LameDuckDuration time.Duration   `json:"-"`
+	TrustedNkeys     []string        `json:"-"`
 
 	CustomClientAuthentication Authentication `json:"-"`
 	CustomRouterAuthentication Authentication `json:"-"`
 
+	// AccountResolver is used to fetch the JWTs of the accounts.
+	AccountResolver AccountResolver `json:"-"`
+
 	// CheckConfig configuration file syntax test was successful and exit.
 	CheckConfig bool `json:"-"`
 }
@@ -125,6 +217,10 @@ func (o *Options) Clone() *Options {
 			clone.Nkeys[i] = nkey.clone()
 		}
 	}
+	clone.AuthCallout = o.AuthCallout.clone()
+	if o.Informer.URLs != nil {
+		clone.Informer.URLs = append([]string(nil), o.Informer.URLs...)
+	}
 
 	if o.Routes != nil {
 		clone.Routes = make([]*url.URL, len(o.Routes))
@@ -140,6 +236,54 @@ func (o *Options) Clone() *Options {
 	if o.Cluster.TLSConfig != nil {
 		clone.Cluster.TLSConfig = o.Cluster.TLSConfig.Clone()
 	}
+	if o.LeafNode.TLSConfig != nil {
+		clone.LeafNode.TLSConfig = o.LeafNode.TLSConfig.Clone()
+	}
+	if o.LeafNode.Remotes != nil {
+		clone.LeafNode.Remotes = make([]*RemoteLeafOpts, len(o.LeafNode.Remotes))
+		for i, r := range o.LeafNode.Remotes {
+			remoteCopy := &RemoteLeafOpts{}
+			*remoteCopy = *r
+			if r.URL != nil {
+				urlCopy := &url.URL{}
+				*urlCopy = *r.URL
+				remoteCopy.URL = urlCopy
+			}
+			if r.TLSConfig != nil {
+				remoteCopy.TLSConfig = r.TLSConfig.Clone()
+			}
+			clone.LeafNode.Remotes[i] = remoteCopy
+		}
+	}
+	if o.Gateway.TLSConfig != nil {
+		clone.Gateway.TLSConfig = o.Gateway.TLSConfig.Clone()
+	}
+	if o.Websocket.TLSConfig != nil {
+		clone.Websocket.TLSConfig = o.Websocket.TLSConfig.Clone()
+	}
+	if o.Websocket.AllowedOrigins != nil {
+		clone.Websocket.AllowedOrigins = make([]string, len(o.Websocket.AllowedOrigins))
+		copy(clone.Websocket.AllowedOrigins, o.Websocket.AllowedOrigins)
+	}
+	if o.MQTT.TLSConfig != nil {
+		clone.MQTT.TLSConfig = o.MQTT.TLSConfig.Clone()
+	}
+	if o.Listeners != nil {
+		clone.Listeners = make([]*ListenerOpts, len(o.Listeners))
+		for i, l := range o.Listeners {
+			lc := *l
+			if l.TLSConfig != nil {
+				lc.TLSConfig = l.TLSConfig.Clone()
+			}
+			clone.Listeners[i] = &lc
+		}
+	}
+	if o.Gateway.Gateways != nil {
+		clone.Gateway.Gateways = make([]*RemoteGatewayOpts, len(o.Gateway.Gateways))
+		for i, g := range o.Gateway.Gateways {
+			clone.Gateway.Gateways[i] = g.clone()
+		}
+	}
 	return clone
 }
 
@@ -154,6 +298,8 @@ type authorization struct {
 	users              []*User
 	timeout            float64
 	defaultPermissions *Permissions
+	callout            *AuthCallout
+	directory          *DirectoryAuth
 }
 
 // TLSConfigOpts holds the parsed tls config information,
@@ -163,6 +309,7 @@ type TLSConfigOpts struct {
 	KeyFile          string
 	CaFile           string
 	Verify           bool
+	Map              bool
 	Timeout          float64
 	Ciphers          []uint16
 	CurvePreferences []tls.CurveID
@@ -178,6 +325,8 @@ e.g.
         key_file:  "./certs/server-key.pem"
         ca_file:   "./certs/ca.pem"
         verify:    true
+        # Or, to also map the certificate to a user:
+        # verify_and_map: true
 
         cipher_suites: [
             "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
@@ -269,6 +418,11 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 			o.Port = hp.port
 		case "client_advertise":
 			o.ClientAdvertise = v.(string)
+		case "unix_socket":
+			if err := parseUnixSocket(tk, o); err != nil {
+				errors = append(errors, err)
+				continue
+			}
 		case "port":
 			o.Port = int(v.(int64))
 		case "host", "net":
@@ -285,6 +439,8 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				errors = append(errors, err)
 				continue
 			}
+		case "system_account", "system":
+			o.SystemAccount = v.(string)
 		case "authorization":
 			auth, err := parseAuthorization(tk, o, &errors, &warnings)
 			if err != nil {
@@ -301,6 +457,15 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				continue
 			}
 			o.AuthTimeout = auth.timeout
+			o.AuthCallout = auth.callout
+			if auth.directory != nil {
+				if auth.users != nil || auth.nkeys != nil {
+					err := &configErr{tk, "Can not have a directory and a users array"}
+					errors = append(errors, err)
+					continue
+				}
+				o.CustomClientAuthentication = auth.directory
+			}
 			// Check for multiple users defined
 			if auth.users != nil {
 				if auth.user != "" {
@@ -350,6 +515,36 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				errors = append(errors, err)
 				continue
 			}
+		case "leafnodes", "leaf":
+			err := parseLeafNodes(tk, o, &errors, &warnings)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "gateway":
+			err := parseGateway(tk, o, &errors, &warnings)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "websocket", "ws":
+			err := parseWebsocket(tk, o, &errors, &warnings)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "mqtt":
+			err := parseMQTT(tk, o, &errors, &warnings)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "listeners":
+			err := parseListeners(tk, o, &errors, &warnings)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
 		case "logfile", "log_file":
 			o.LogFile = v.(string)
 		case "syslog":
@@ -388,6 +583,8 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				continue
 			}
 			o.TLSTimeout = tc.Timeout
+			o.TLSMap = tc.Map
+			o.TLSCert, o.TLSKey = tc.CertFile, tc.KeyFile
 		case "write_deadline":
 			wd, ok := v.(string)
 			if ok {
@@ -449,6 +646,18 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 					errors = append(errors, err)
 				}
 			}
+		case "informer", "informers":
+			if err := parseInformer(tk, o, &errors, &warnings); err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "resolver":
+			ar, err := parseAccountResolver(v)
+			if err != nil {
+				errors = append(errors, &configErr{tk, err.Error()})
+				continue
+			}
+			o.AccountResolver = ar
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -462,6 +671,26 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 		}
 	}
 
+	// Directory groups can refer to accounts defined after the
+	// authorization block.
+	if da, ok := o.CustomClientAuthentication.(*DirectoryAuth); ok {
+		for _, g := range da.Groups {
+			if g.accName == _EMPTY_ {
+				continue
+			}
+			for _, acc := range o.Accounts {
+				if acc.Name == g.accName {
+					g.Account = acc
+					break
+				}
+			}
+			if g.Account == nil {
+				err := &configErr{nil, fmt.Sprintf("Directory group %q refers to unknown account %q", g.Name, g.accName)}
+				errors = append(errors, err)
+			}
+		}
+	}
+
 	if len(errors) > 0 || len(warnings) > 0 {
 		return &processConfigErr{
 			errors:   errors,
@@ -499,6 +728,78 @@ func parseListen(v interface{}) (*hostPort, error) {
 	return hp, nil
 }
 
+// parseInformer parses the informer block, or the array of informer URLs.
+func parseInformer(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	parseURLs := func(tk token, v interface{}) {
+		var urls []interface{}
+		switch uv := v.(type) {
+		case string:
+			urls = []interface{}{uv}
+		case []interface{}:
+			urls = uv
+		default:
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected informer urls to be a string or an array, got %v", v)})
+			return
+		}
+		for _, u := range urls {
+			tk, u := unwrapValue(u)
+			us, ok := u.(string)
+			if !ok {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected informer url to be a string, got %v", u)})
+				continue
+			}
+			if err := validInformerURL(us); err != nil {
+				*errors = append(*errors, &configErr{tk, err.Error()})
+				continue
+			}
+			opts.Informer.URLs = append(opts.Informer.URLs, us)
+		}
+	}
+	im, ok := v.(map[string]interface{})
+	if !ok {
+		parseURLs(tk, v)
+		return nil
+	}
+	for mk, mv := range im {
+		tk, mv := unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "urls", "url":
+			parseURLs(tk, mv)
+		case "state_file":
+			opts.Informer.StateFile = mv.(string)
+		case "secret":
+			opts.Informer.Secret = mv.(string)
+		case "queue_size":
+			opts.Informer.QueueSize = int(mv.(int64))
+		case "batch_window":
+			var dur time.Duration
+			switch dv := mv.(type) {
+			case int64:
+				dur = time.Duration(dv) * time.Millisecond
+			case string:
+				dur, _ = time.ParseDuration(dv)
+			}
+			if dur <= 0 {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Invalid informer batch_window %v", mv)})
+				continue
+			}
+			opts.Informer.BatchWindow = dur
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	return nil
+}
+
 // parseCluster will parse the cluster config.
 func parseCluster(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
 	tk, v := unwrapValue(v)
@@ -607,12 +908,593 @@ func parseCluster(v interface{}, opts *Options, errors *[]error, warnings *[]err
 						token: tk,
 					},
 				}
-				*errors = append(*errors, err)
-				continue
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// parseLeafNodes will parse the leaf node config.
+func parseLeafNodes(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	cm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected map to define leafnodes, got %T", v)}
+	}
+
+	for mk, mv := range cm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.LeafNode.Host = hp.host
+			opts.LeafNode.Port = hp.port
+		case "port":
+			opts.LeafNode.Port = int(mv.(int64))
+		case "host", "net":
+			opts.LeafNode.Host = mv.(string)
+		case "account":
+			opts.LeafNode.Account = mv.(string)
+		case "authorization":
+			auth, err := parseAuthorization(tk, opts, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if auth.users != nil {
+				err := &configErr{tk, fmt.Sprintf("Leafnode authorization does not allow multiple users")}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.LeafNode.Username = auth.user
+			opts.LeafNode.Password = auth.pass
+			opts.LeafNode.AuthTimeout = auth.timeout
+		case "tls":
+			tc, err := parseTLS(tk, opts)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if opts.LeafNode.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.LeafNode.TLSTimeout = tc.Timeout
+		case "reconnect", "reconnect_interval":
+			opts.LeafNode.ReconnectInterval = time.Duration(int(mv.(int64))) * time.Second
+		case "remotes":
+			remotes, err := parseRemoteLeafNodes(tk, opts, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.LeafNode.Remotes = remotes
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// parseWebsocket will parse the websocket config.
+func parseWebsocket(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	cm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected map to define websocket, got %T", v)}
+	}
+
+	for mk, mv := range cm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.Websocket.Host = hp.host
+			opts.Websocket.Port = hp.port
+		case "port":
+			opts.Websocket.Port = int(mv.(int64))
+		case "host", "net":
+			opts.Websocket.Host = mv.(string)
+		case "tls":
+			tc, err := parseTLS(tk, opts)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if opts.Websocket.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.Websocket.TLSTimeout = tc.Timeout
+		case "allowed_origins", "allowed_origin", "origins":
+			switch mv := mv.(type) {
+			case string:
+				opts.Websocket.AllowedOrigins = []string{mv}
+			case []interface{}:
+				for _, o := range mv {
+					tk, o := unwrapValue(o)
+					origin, ok := o.(string)
+					if !ok {
+						err := &configErr{tk, fmt.Sprintf("Expected origin to be a string, got %T", o)}
+						*errors = append(*errors, err)
+						continue
+					}
+					opts.Websocket.AllowedOrigins = append(opts.Websocket.AllowedOrigins, origin)
+				}
+			default:
+				err := &configErr{tk, fmt.Sprintf("Expected allowed origins to be a string or an array, got %T", mv)}
+				*errors = append(*errors, err)
+				continue
+			}
+		case "compression":
+			opts.Websocket.Compression = mv.(bool)
+		case "handshake_timeout":
+			opts.Websocket.HandshakeTimeout = time.Duration(int(mv.(int64))) * time.Second
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// parseMQTT will parse the MQTT config.
+func parseMQTT(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	cm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected map to define mqtt, got %T", v)}
+	}
+
+	for mk, mv := range cm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.MQTT.Host = hp.host
+			opts.MQTT.Port = hp.port
+		case "port":
+			opts.MQTT.Port = int(mv.(int64))
+		case "host", "net":
+			opts.MQTT.Host = mv.(string)
+		case "tls":
+			tc, err := parseTLS(tk, opts)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if opts.MQTT.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.MQTT.TLSTimeout = tc.Timeout
+		case "auth_timeout", "connect_timeout":
+			switch mv := mv.(type) {
+			case int64:
+				opts.MQTT.AuthTimeout = float64(mv)
+			case float64:
+				opts.MQTT.AuthTimeout = mv
+			}
+		case "max_inflight":
+			opts.MQTT.MaxInFlight = int(mv.(int64))
+		case "session_expiry":
+			opts.MQTT.SessionExpiry = time.Duration(int(mv.(int64))) * time.Second
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// parseUnixSocket will parse the unix socket listener config, which is
+// either the socket path or a map with the path, mode, owner and group.
+func parseUnixSocket(v interface{}, opts *Options) error {
+	tk, v := unwrapValue(v)
+	switch v := v.(type) {
+	case string:
+		opts.UnixSocket = v
+		return nil
+	case map[string]interface{}:
+		for mk, mv := range v {
+			tk, mv := unwrapValue(mv)
+			switch strings.ToLower(mk) {
+			case "path":
+				opts.UnixSocket = mv.(string)
+			case "mode":
+				// The mode is always read as octal, quoted or not.
+				mode, err := strconv.ParseUint(fmt.Sprintf("%v", mv), 8, 32)
+				if err != nil {
+					return &configErr{tk, fmt.Sprintf("Invalid unix socket mode %v", mv)}
+				}
+				opts.UnixSocketMode = os.FileMode(mode)
+			case "owner", "user":
+				opts.UnixSocketOwner = fmt.Sprintf("%v", mv)
+			case "group":
+				opts.UnixSocketGroup = fmt.Sprintf("%v", mv)
+			default:
+				if !tk.IsUsedVariable() {
+					return &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+				}
+			}
+		}
+		if opts.UnixSocket == _EMPTY_ {
+			return &configErr{tk, "Unix socket requires a path"}
+		}
+		return nil
+	default:
+		return &configErr{tk, fmt.Sprintf("Expected unix_socket to be a path or a map, got %T", v)}
+	}
+}
+
+// parseListeners will parse the array of additional client listeners.
+func parseListeners(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	la, ok := v.([]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected listeners field to be an array, got %T", v)}
+	}
+	for _, l := range la {
+		tk, l := unwrapValue(l)
+		lm, ok := l.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected listener entry to be a map/struct, got %v", l)})
+			continue
+		}
+		lo := &ListenerOpts{}
+		for k, v := range lm {
+			tk, v = unwrapValue(v)
+			switch strings.ToLower(k) {
+			case "listen":
+				hp, err := parseListen(v)
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				lo.Host = hp.host
+				lo.Port = hp.port
+			case "port":
+				lo.Port = int(v.(int64))
+			case "host", "net":
+				lo.Host = v.(string)
+			case "tls":
+				tc, err := parseTLS(tk, opts)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				if lo.TLSConfig, err = GenTLSConfig(tc); err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				lo.TLSTimeout = tc.Timeout
+			case "auth_timeout", "connect_timeout":
+				switch v := v.(type) {
+				case int64:
+					lo.AuthTimeout = float64(v)
+				case float64:
+					lo.AuthTimeout = v
+				}
+			case "account":
+				lo.Account = v.(string)
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: k,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+		if lo.Port == 0 {
+			*errors = append(*errors, &configErr{tk, "Listener requires a port"})
+			continue
+		}
+		opts.Listeners = append(opts.Listeners, lo)
+	}
+	return nil
+}
+
+// parseRemoteLeafNodes will parse the remotes array of the leaf node config.
+func parseRemoteLeafNodes(v interface{}, opts *Options, errors *[]error, warnings *[]error) ([]*RemoteLeafOpts, error) {
+	tk, v := unwrapValue(v)
+	ra, ok := v.([]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected remotes field to be an array, got %T", v)}
+	}
+	remotes := make([]*RemoteLeafOpts, 0, len(ra))
+	for _, r := range ra {
+		tk, r = unwrapValue(r)
+		// Check its a map/struct
+		rm, ok := r.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected remote leafnode entry to be a map/struct, got %v", r)})
+			continue
+		}
+		remote := &RemoteLeafOpts{}
+		for k, v := range rm {
+			tk, v = unwrapValue(v)
+			switch strings.ToLower(k) {
+			case "url":
+				u, err := url.Parse(v.(string))
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing remote leafnode url [%q]", v)})
+					continue
+				}
+				remote.URL = u
+			case "account", "local":
+				remote.LocalAccount = v.(string)
+			case "tls":
+				tc, err := parseTLS(tk, opts)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				if remote.TLSConfig, err = GenTLSConfig(tc); err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				// We act as a client here, so use the CA for verifying the server.
+				remote.TLSConfig.RootCAs = remote.TLSConfig.ClientCAs
+				remote.TLSTimeout = tc.Timeout
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: k,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+		if remote.URL == nil {
+			*errors = append(*errors, &configErr{tk, "Remote leafnode entry requires an url"})
+			continue
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes, nil
+}
+
+// parseGateway will parse the gateway config.
+func parseGateway(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	gm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected gateway to be a map, got %T", v)}
+	}
+	for mk, mv := range gm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "name":
+			opts.Gateway.Name = mv.(string)
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.Gateway.Host = hp.host
+			opts.Gateway.Port = hp.port
+		case "port":
+			opts.Gateway.Port = int(mv.(int64))
+		case "host", "net":
+			opts.Gateway.Host = mv.(string)
+		case "authorization":
+			auth, err := parseAuthorization(tk, opts, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if auth.users != nil {
+				*errors = append(*errors, &configErr{tk, "Gateway authorization does not allow multiple users"})
+				continue
+			}
+			opts.Gateway.Username = auth.user
+			opts.Gateway.Password = auth.pass
+			opts.Gateway.AuthTimeout = auth.timeout
+		case "tls":
+			tc, err := parseTLS(tk, opts)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if opts.Gateway.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.Gateway.TLSTimeout = tc.Timeout
+		case "gateways":
+			gateways, err := parseGateways(tk, opts, errors, warnings)
+			if err != nil {
+				return err
+			}
+			opts.Gateway.Gateways = gateways
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	if opts.Gateway.Port != 0 && opts.Gateway.Name == "" {
+		*errors = append(*errors, &configErr{tk, "Gateway requires a name"})
+	}
+	return nil
+}
+
+// parseGateways will parse the array of remote gateways.
+func parseGateways(v interface{}, opts *Options, errors *[]error, warnings *[]error) ([]*RemoteGatewayOpts, error) {
+	tk, v := unwrapValue(v)
+	ga, ok := v.([]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected gateways field to be an array, got %T", v)}
+	}
+	gateways := make([]*RemoteGatewayOpts, 0, len(ga))
+	for _, g := range ga {
+		tk, g = unwrapValue(g)
+		// Check its a map/struct
+		gm, ok := g.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected gateway entry to be a map/struct, got %v", g)})
+			continue
+		}
+		gateway := &RemoteGatewayOpts{}
+		for k, v := range gm {
+			tk, v = unwrapValue(v)
+			switch strings.ToLower(k) {
+			case "name":
+				gateway.Name = v.(string)
+			case "tls":
+				tc, err := parseTLS(tk, opts)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				if gateway.TLSConfig, err = GenTLSConfig(tc); err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				// We act as a client here, so use the CA for verifying the server.
+				gateway.TLSConfig.RootCAs = gateway.TLSConfig.ClientCAs
+				gateway.TLSTimeout = tc.Timeout
+			case "url":
+				u, err := url.Parse(v.(string))
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing gateway url [%q]", v)})
+					continue
+				}
+				gateway.URLs = append(gateway.URLs, u)
+			case "urls":
+				urls, ok := v.([]interface{})
+				if !ok {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected urls field to be an array, got %T", v)})
+					continue
+				}
+				for _, iu := range urls {
+					ut, iu := unwrapValue(iu)
+					u, err := url.Parse(iu.(string))
+					if err != nil {
+						*errors = append(*errors, &configErr{ut, fmt.Sprintf("error parsing gateway url [%q]", iu)})
+						continue
+					}
+					gateway.URLs = append(gateway.URLs, u)
+				}
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: k,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
 			}
 		}
+		if gateway.Name == "" {
+			*errors = append(*errors, &configErr{tk, "Gateway entry requires a name"})
+			continue
+		}
+		if len(gateway.URLs) == 0 {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Gateway %q requires at least one url", gateway.Name)})
+			continue
+		}
+		gateways = append(gateways, gateway)
 	}
-	return nil
+	return gateways, nil
+}
+
+// clone performs a deep copy of the RemoteGatewayOpts struct, returning
+// a new clone with all values copied.
+func (r *RemoteGatewayOpts) clone() *RemoteGatewayOpts {
+	if r == nil {
+		return nil
+	}
+	clone := &RemoteGatewayOpts{}
+	*clone = *r
+	if r.TLSConfig != nil {
+		clone.TLSConfig = r.TLSConfig.Clone()
+	}
+	if r.URLs != nil {
+		clone.URLs = make([]*url.URL, len(r.URLs))
+		for i, u := range r.URLs {
+			urlCopy := &url.URL{}
+			*urlCopy = *u
+			clone.URLs[i] = urlCopy
+		}
+	}
+	return clone
 }
 
 // Sets cluster's permissions based on given pub/sub permissions,
@@ -656,6 +1538,101 @@ func isReservedAccount(name string) bool {
 	return name == globalAccountName
 }
 
+// parseAccountResolver will parse the resolver option. Supported
+// values are MEMORY, DIR(<directory>) and URL(<url>). The URL resolver
+// can also be defined with a map to set the url, timeout, ttl and cache_size.
+func parseAccountResolver(v interface{}) (AccountResolver, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return parseURLAccountResolver(m)
+	}
+	str, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("error parsing resolver: unsupported type %T", v)
+	}
+	str = strings.TrimSpace(str)
+	upper := strings.ToUpper(str)
+	switch {
+	case upper == "MEM" || upper == "MEMORY":
+		return &MemAccResolver{}, nil
+	case strings.HasPrefix(upper, "DIR(") && strings.HasSuffix(str, ")"):
+		dir := strings.TrimSpace(str[len("DIR(") : len(str)-1])
+		dr, err := NewDirAccResolver(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing resolver: %v", err)
+		}
+		return dr, nil
+	case strings.HasPrefix(upper, "URL(") && strings.HasSuffix(str, ")"):
+		u := strings.TrimSpace(str[len("URL(") : len(str)-1])
+		ur, err := NewURLAccResolver(u, 0, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing resolver: %v", err)
+		}
+		return ur, nil
+	}
+	return nil, fmt.Errorf("error parsing resolver: unsupported resolver %q", str)
+}
+
+// parseURLAccountResolver will parse the map form of the URL resolver.
+func parseURLAccountResolver(m map[string]interface{}) (AccountResolver, error) {
+	var (
+		u         string
+		timeout   time.Duration
+		ttl       time.Duration
+		cacheSize int
+		err       error
+	)
+	for mk, mv := range m {
+		_, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "url":
+			str, ok := mv.(string)
+			if !ok {
+				return nil, fmt.Errorf("error parsing resolver: url should be a string, got %T", mv)
+			}
+			u = str
+		case "timeout":
+			if timeout, err = parseResolverDuration(mk, mv); err != nil {
+				return nil, err
+			}
+		case "ttl":
+			if ttl, err = parseResolverDuration(mk, mv); err != nil {
+				return nil, err
+			}
+		case "cache_size", "cache":
+			size, ok := mv.(int64)
+			if !ok || size <= 0 {
+				return nil, fmt.Errorf("error parsing resolver: %s should be a positive integer, got %v", mk, mv)
+			}
+			cacheSize = int(size)
+		default:
+			return nil, fmt.Errorf("error parsing resolver: unknown field %q", mk)
+		}
+	}
+	if u == "" {
+		return nil, fmt.Errorf("error parsing resolver: url is required")
+	}
+	ur, err := NewURLAccResolver(u, timeout, ttl, cacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing resolver: %v", err)
+	}
+	return ur, nil
+}
+
+// parseResolverDuration accepts a duration string or a number of seconds.
+func parseResolverDuration(field string, v interface{}) (time.Duration, error) {
+	switch vv := v.(type) {
+	case int64:
+		if vv > 0 {
+			return time.Duration(vv) * time.Second, nil
+		}
+	case string:
+		if dur, err := time.ParseDuration(vv); err == nil && dur > 0 {
+			return dur, nil
+		}
+	}
+	return 0, fmt.Errorf("error parsing resolver: invalid %s %v", field, v)
+}
+
 // parseAccounts will parse the different accounts syntax.
 func parseAccounts(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
 	var (
@@ -1174,6 +2151,20 @@ func parseAuthorization(v interface{}, opts *Options, errors *[]error, warnings
 				continue
 			}
 			auth.defaultPermissions = permissions
+		case "auth_callout", "callout":
+			callout, err := parseAuthCallout(tk, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			auth.callout = callout
+		case "directory":
+			directory, err := parseDirectory(tk, opts, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			auth.directory = directory
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1200,6 +2191,238 @@ func parseAuthorization(v interface{}, opts *Options, errors *[]error, warnings
 	return auth, nil
 }
 
+// parseAuthCallout parses the authorization callout block.
+func parseAuthCallout(v interface{}, errors *[]error, warnings *[]error) (*AuthCallout, error) {
+	tk, v := unwrapValue(v)
+	cm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected auth_callout to be a map, got %T", v)}
+	}
+	ac := &AuthCallout{}
+	for mk, mv := range cm {
+		tk, mv := unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "subject":
+			ac.Subject = mv.(string)
+		case "account":
+			ac.Account = mv.(string)
+		case "issuer":
+			ac.Issuer = mv.(string)
+		case "auth_users":
+			switch uv := mv.(type) {
+			case string:
+				ac.AuthUsers = []string{uv}
+			case []interface{}:
+				for _, u := range uv {
+					_, u = unwrapValue(u)
+					if us, ok := u.(string); ok {
+						ac.AuthUsers = append(ac.AuthUsers, us)
+					} else {
+						*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected auth_users entry to be a string, got %v", u)})
+					}
+				}
+			default:
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected auth_users to be a string or an array, got %v", mv)})
+			}
+		case "timeout", "cache_ttl":
+			var dur time.Duration
+			switch dv := mv.(type) {
+			case int64:
+				dur = time.Duration(dv) * time.Second
+			case float64:
+				dur = time.Duration(dv * float64(time.Second))
+			case string:
+				dur, _ = time.ParseDuration(dv)
+			}
+			if dur <= 0 {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Invalid auth_callout %s %v", mk, mv)})
+				continue
+			}
+			if strings.ToLower(mk) == "timeout" {
+				ac.Timeout = dur
+			} else {
+				ac.CacheTTL = dur
+			}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	if ac.Subject == _EMPTY_ {
+		return nil, &configErr{tk, "Auth callout requires a subject"}
+	}
+	if !nkeys.IsValidPublicAccountKey(ac.Issuer) {
+		return nil, &configErr{tk, fmt.Sprintf("Auth callout issuer %q is not a valid public account key", ac.Issuer)}
+	}
+	return ac, nil
+}
+
+// parseDirectory parses the user directory of the authorization block.
+func parseDirectory(v interface{}, opts *Options, errors *[]error, warnings *[]error) (*DirectoryAuth, error) {
+	tk, v := unwrapValue(v)
+	dm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected directory to be a map, got %T", v)}
+	}
+	da := &DirectoryAuth{}
+	for mk, mv := range dm {
+		tk, mv := unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "ldap":
+			ld, err := parseLDAPDirectory(tk, errors)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			da.Directory = ld
+		case "groups":
+			gv, ok := mv.([]interface{})
+			if !ok {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected groups field to be an array, got %v", mv)})
+				continue
+			}
+			for _, g := range gv {
+				group, err := parseDirectoryGroup(g, opts, errors, warnings)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				da.Groups = append(da.Groups, group)
+			}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	if da.Directory == nil {
+		return nil, &configErr{tk, "Directory requires an ldap block"}
+	}
+	if len(da.Groups) == 0 {
+		return nil, &configErr{tk, "Directory requires groups"}
+	}
+	return da, nil
+}
+
+// parseLDAPDirectory parses the LDAP server of a directory.
+func parseLDAPDirectory(v interface{}, errors *[]error) (*LDAPDirectory, error) {
+	tk, v := unwrapValue(v)
+	lm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected ldap to be a map, got %T", v)}
+	}
+	ld := &LDAPDirectory{}
+	for mk, mv := range lm {
+		tk, mv := unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "url":
+			ld.URL = mv.(string)
+		case "user_dn":
+			ld.UserDN = mv.(string)
+		case "group_attribute":
+			ld.GroupAttribute = mv.(string)
+		case "group_base_dn":
+			ld.GroupBaseDN = mv.(string)
+		case "member_attribute":
+			ld.MemberAttribute = mv.(string)
+		case "timeout":
+			switch tv := mv.(type) {
+			case int64:
+				ld.Timeout = time.Duration(tv) * time.Second
+			case float64:
+				ld.Timeout = time.Duration(tv * float64(time.Second))
+			case string:
+				ld.Timeout, _ = time.ParseDuration(tv)
+			}
+			if ld.Timeout <= 0 {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Invalid ldap timeout %v", mv)})
+			}
+		case "ca_file":
+			rootPEM, err := ioutil.ReadFile(mv.(string))
+			if err != nil {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Error reading ldap ca_file: %v", err)})
+				continue
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(rootPEM) {
+				*errors = append(*errors, &configErr{tk, "Failed to parse ldap ca_file"})
+				continue
+			}
+			ld.TLSConfig = &tls.Config{RootCAs: pool}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	if !strings.HasPrefix(ld.URL, "ldap://") && !strings.HasPrefix(ld.URL, "ldaps://") {
+		return nil, &configErr{tk, fmt.Sprintf("LDAP url %q must be an ldap:// or ldaps:// url", ld.URL)}
+	}
+	if strings.Count(ld.UserDN, "%s") != 1 {
+		return nil, &configErr{tk, fmt.Sprintf("LDAP user_dn %q must contain %%s once", ld.UserDN)}
+	}
+	return ld, nil
+}
+
+// parseDirectoryGroup parses a group of a directory.
+func parseDirectoryGroup(v interface{}, opts *Options, errors *[]error, warnings *[]error) (*DirectoryGroup, error) {
+	tk, v := unwrapValue(v)
+	gm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected group entry to be a map/struct, got %v", v)}
+	}
+	g := &DirectoryGroup{}
+	for mk, mv := range gm {
+		tk, mv := unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "name", "group":
+			g.Name = mv.(string)
+		case "account":
+			g.accName = mv.(string)
+		case "permissions", "permission":
+			perms, err := parseUserPermissions(tk, opts, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			g.Permissions = perms
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	if g.Name == _EMPTY_ {
+		return nil, &configErr{tk, "Directory group requires a name"}
+	}
+	return g, nil
+}
+
 // Helper function to parse multiple users array with optional permissions.
 func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]error) ([]*NkeyUser, []*User, error) {
 	var (
@@ -1492,6 +2715,15 @@ func parseTLS(v interface{}, opts *Options) (*TLSConfigOpts, error) {
 				return nil, &configErr{tk, fmt.Sprintf("error parsing tls config, expected 'verify' to be a boolean")}
 			}
 			tc.Verify = verify
+		case "verify_and_map":
+			verify, ok := mv.(bool)
+			if !ok {
+				return nil, &configErr{tk, fmt.Sprintf("error parsing tls config, expected 'verify_and_map' to be a boolean")}
+			}
+			if verify {
+				tc.Verify = true
+			}
+			tc.Map = verify
 		case "cipher_suites":
 			ra := mv.([]interface{})
 			if len(ra) == 0 {
@@ -1613,6 +2845,9 @@ func MergeOptions(fileOpts, flagOpts *Options) *Options {
 	if flagOpts.ClientAdvertise != "" {
 		opts.ClientAdvertise = flagOpts.ClientAdvertise
 	}
+	if flagOpts.UnixSocket != "" {
+		opts.UnixSocket = flagOpts.UnixSocket
+	}
 	if flagOpts.Username != "" {
 		opts.Username = flagOpts.Username
 	}
@@ -1810,6 +3045,99 @@ func processOptions(opts *Options) {
 			opts.Cluster.AuthTimeout = float64(AUTH_TIMEOUT) / float64(time.Second)
 		}
 	}
+	if opts.LeafNode.Port != 0 {
+		if opts.LeafNode.Host == "" {
+			opts.LeafNode.Host = DEFAULT_HOST
+		}
+		if opts.LeafNode.TLSTimeout == 0 {
+			opts.LeafNode.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+		if opts.LeafNode.AuthTimeout == 0 {
+			opts.LeafNode.AuthTimeout = float64(AUTH_TIMEOUT) / float64(time.Second)
+		}
+	}
+	if opts.Gateway.Port != 0 {
+		if opts.Gateway.Host == "" {
+			opts.Gateway.Host = DEFAULT_HOST
+		}
+		if opts.Gateway.TLSTimeout == 0 {
+			opts.Gateway.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+		if opts.Gateway.AuthTimeout == 0 {
+			opts.Gateway.AuthTimeout = float64(AUTH_TIMEOUT) / float64(time.Second)
+		}
+		for _, g := range opts.Gateway.Gateways {
+			if g.TLSConfig != nil && g.TLSTimeout == 0 {
+				g.TLSTimeout = opts.Gateway.TLSTimeout
+			}
+		}
+	}
+	if opts.Websocket.Port != 0 {
+		if opts.Websocket.Host == "" {
+			opts.Websocket.Host = DEFAULT_HOST
+		}
+		if opts.Websocket.TLSTimeout == 0 {
+			opts.Websocket.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+		if opts.Websocket.HandshakeTimeout == 0 {
+			opts.Websocket.HandshakeTimeout = DEFAULT_WEBSOCKET_HANDSHAKE_TIMEOUT
+		}
+	}
+	if opts.MQTT.Port != 0 {
+		if opts.MQTT.Host == "" {
+			opts.MQTT.Host = DEFAULT_HOST
+		}
+		if opts.MQTT.TLSTimeout == 0 {
+			opts.MQTT.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+		if opts.MQTT.AuthTimeout == 0 {
+			opts.MQTT.AuthTimeout = float64(AUTH_TIMEOUT) / float64(time.Second)
+		}
+		if opts.MQTT.MaxInFlight == 0 {
+			opts.MQTT.MaxInFlight = DEFAULT_MQTT_MAX_INFLIGHT
+		}
+		if opts.MQTT.SessionExpiry == 0 {
+			opts.MQTT.SessionExpiry = DEFAULT_MQTT_SESSION_EXPIRY
+		}
+	}
+	if opts.Informer.QueueSize == 0 {
+		opts.Informer.QueueSize = DEFAULT_INFORMER_QUEUE_SIZE
+	}
+	if opts.Informer.BatchWindow == 0 {
+		opts.Informer.BatchWindow = DEFAULT_INFORMER_BATCH_WINDOW
+	}
+	if ac := opts.AuthCallout; ac != nil {
+		if ac.Timeout == 0 {
+			ac.Timeout = DEFAULT_AUTH_CALLOUT_TIMEOUT
+		}
+		if ac.CacheTTL == 0 {
+			ac.CacheTTL = DEFAULT_AUTH_CALLOUT_CACHE_TTL
+		}
+	}
+	if opts.UnixSocket != _EMPTY_ && opts.UnixSocketMode == 0 {
+		opts.UnixSocketMode = DEFAULT_UNIX_SOCKET_MODE
+	}
+	for _, l := range opts.Listeners {
+		if l.Host == "" {
+			l.Host = DEFAULT_HOST
+		}
+		if l.TLSTimeout == 0 {
+			l.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+		if l.AuthTimeout == 0 {
+			l.AuthTimeout = opts.AuthTimeout
+		}
+	}
+	if len(opts.LeafNode.Remotes) > 0 {
+		if opts.LeafNode.ReconnectInterval == 0 {
+			opts.LeafNode.ReconnectInterval = DEFAULT_LEAF_NODE_RECONNECT
+		}
+		for _, r := range opts.LeafNode.Remotes {
+			if r.TLSTimeout == 0 {
+				r.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+			}
+		}
+	}
 	if opts.MaxControlLine == 0 {
 		opts.MaxControlLine = MAX_CONTROL_LINE_SIZE
 	}
@@ -1856,6 +3184,7 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.StringVar(&opts.Host, "a", "", "Network host to listen on.")
 	fs.StringVar(&opts.Host, "net", "", "Network host to listen on.")
 	fs.StringVar(&opts.ClientAdvertise, "client_advertise", "", "Client URL to advertise to other servers.")
+	fs.StringVar(&opts.UnixSocket, "unix_socket", "", "Path of a unix socket to listen on for clients.")
 	fs.BoolVar(&opts.Debug, "D", false, "Enable Debug logging.")
 	fs.BoolVar(&opts.Debug, "debug", false, "Enable Debug logging.")
 	fs.BoolVar(&opts.Trace, "V", false, "Enable Trace logging.")
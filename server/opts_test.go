@@ -44,6 +44,10 @@ func TestDefaultOptions(t *testing.T) {
 		RQSubsSweep:      DEFAULT_REMOTE_QSUBS_SWEEPER,
 		MaxClosedClients: DEFAULT_MAX_CLOSED_CLIENTS,
 		LameDuckDuration: DEFAULT_LAME_DUCK_DURATION,
+		Informer: InformerOpts{
+			QueueSize:   DEFAULT_INFORMER_QUEUE_SIZE,
+			BatchWindow: DEFAULT_INFORMER_BATCH_WINDOW,
+		},
 	}
 
 	opts := &Options{}
@@ -112,6 +116,8 @@ func TestTLSConfigFile(t *testing.T) {
 		Password:    "foo",
 		AuthTimeout: 1.0,
 		TLSTimeout:  2.0,
+		TLSCert:     "./configs/certs/server.pem",
+		TLSKey:      "./configs/certs/key.pem",
 	}
 	opts, err := ProcessConfigFile("./configs/tls.conf")
 	if err != nil {
@@ -223,6 +229,27 @@ func TestTLSConfigFile(t *testing.T) {
 	}
 }
 
+func TestTLSConfigVerifyAndMap(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		tls {
+			cert_file: "./configs/certs/server.pem"
+			key_file:  "./configs/certs/key.pem"
+			verify_and_map: true
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Received an error reading config file: %v\n", err)
+	}
+	if !opts.TLSMap {
+		t.Fatal("Expected opts.TLSMap to be true")
+	}
+	if opts.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("Expected client certificates to be verified, got %v", opts.TLSConfig.ClientAuth)
+	}
+}
+
 func TestMergeOverrides(t *testing.T) {
 	golden := &Options{
 		ConfigFile:     "./configs/test.conf",
@@ -1449,3 +1476,67 @@ func TestAccountUsersLoadedProperly(t *testing.T) {
 		check(t)
 	}
 }
+
+func TestAccountResolverConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	parse := func(resolver string) (*Options, error) {
+		t.Helper()
+		conf := createConfFile(t, []byte(fmt.Sprintf("resolver: %q", resolver)))
+		defer os.Remove(conf)
+		return ProcessConfigFile(conf)
+	}
+
+	opts, err := parse(fmt.Sprintf("DIR(%s)", dir))
+	if err != nil {
+		t.Fatalf("Received an error processing config file: %v", err)
+	}
+	if dr, ok := opts.AccountResolver.(*DirAccResolver); !ok || dr.dir != dir {
+		t.Fatalf("Expected a directory resolver for %q, got %+v", dir, opts.AccountResolver)
+	}
+
+	opts, err = parse("MEMORY")
+	if err != nil {
+		t.Fatalf("Received an error processing config file: %v", err)
+	}
+	if _, ok := opts.AccountResolver.(*MemAccResolver); !ok {
+		t.Fatalf("Expected a memory resolver, got %+v", opts.AccountResolver)
+	}
+
+	opts, err = parse("URL(http://localhost:9090/jwt/v1/accounts)")
+	if err != nil {
+		t.Fatalf("Received an error processing config file: %v", err)
+	}
+	ur, ok := opts.AccountResolver.(*URLAccResolver)
+	if !ok || ur.url != "http://localhost:9090/jwt/v1/accounts/" || ur.ttl != DEFAULT_URL_RESOLVER_TTL {
+		t.Fatalf("Expected an URL resolver, got %+v", opts.AccountResolver)
+	}
+
+	conf := createConfFile(t, []byte(`
+		resolver {
+			url: "http://localhost:9090/jwt/v1/accounts/"
+			timeout: "500ms"
+			ttl: 30
+			cache_size: 10
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err = ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Received an error processing config file: %v", err)
+	}
+	ur, ok = opts.AccountResolver.(*URLAccResolver)
+	if !ok || ur.timeout != 500*time.Millisecond || ur.ttl != 30*time.Second || ur.max != 10 {
+		t.Fatalf("Unexpected URL resolver: %+v", opts.AccountResolver)
+	}
+
+	for _, resolver := range []string{"FOO", fmt.Sprintf("DIR(%s)", dir+"_missing"), "URL(ftp://localhost)"} {
+		if _, err := parse(resolver); err == nil || !strings.Contains(err.Error(), "error parsing resolver") {
+			t.Fatalf("Expected an error for resolver %q, got %v", resolver, err)
+		}
+	}
+}
@@ -24,8 +24,10 @@ type pubArg struct {
 	subject []byte
 	reply   []byte
 	szb     []byte
+	hdb     []byte
 	queues  [][]byte
 	size    int
+	hdr     int
 }
 
 type parseState struct {
@@ -85,6 +87,8 @@ const (
 	AUSUB_ARG
 	OP_R
 	OP_RS
+	OP_L
+	OP_LS
 	OP_U
 	OP_UN
 	OP_UNS
@@ -102,6 +106,17 @@ const (
 	OP_INF
 	OP_INFO
 	INFO_ARG
+	OP_H
+	OP_HP
+	OP_HPU
+	OP_HPUB
+	OP_HPUB_SPC
+	HPUB_ARG
+	OP_HM
+	OP_HMS
+	OP_HMSG
+	OP_HMSG_SPC
+	HMSG_ARG
 )
 
 func (c *client) parse(buf []byte) error {
@@ -136,13 +151,19 @@ func (c *client) parse(buf []byte) error {
 			case 'U', 'u':
 				c.state = OP_U
 			case 'R', 'r':
-				if c.typ == CLIENT {
+				if c.typ != ROUTER && c.typ != GATEWAY {
 					goto parseErr
 				} else {
 					c.state = OP_R
 				}
+			case 'L', 'l':
+				if c.typ != LEAF {
+					goto parseErr
+				} else {
+					c.state = OP_L
+				}
 			case 'A', 'a':
-				if c.typ == CLIENT {
+				if c.typ != ROUTER && c.typ != GATEWAY {
 					goto parseErr
 				} else {
 					c.state = OP_A
@@ -151,6 +172,8 @@ func (c *client) parse(buf []byte) error {
 				c.state = OP_C
 			case 'I', 'i':
 				c.state = OP_I
+			case 'H', 'h':
+				c.state = OP_H
 			case '+':
 				c.state = OP_PLUS
 			case '-':
@@ -191,7 +214,53 @@ func (c *client) parse(buf []byte) error {
 				c.state = PUB_ARG
 				c.as = i
 			}
-		case PUB_ARG:
+		case OP_H:
+			switch b {
+			case 'P', 'p':
+				// Only clients publish with headers.
+				if c.typ != CLIENT {
+					goto parseErr
+				}
+				c.state = OP_HP
+			case 'M', 'm':
+				// Routes, gateways and leaf nodes forward them.
+				if c.typ == CLIENT {
+					goto parseErr
+				}
+				c.state = OP_HM
+			default:
+				goto parseErr
+			}
+		case OP_HP:
+			switch b {
+			case 'U', 'u':
+				c.state = OP_HPU
+			default:
+				goto parseErr
+			}
+		case OP_HPU:
+			switch b {
+			case 'B', 'b':
+				c.state = OP_HPUB
+			default:
+				goto parseErr
+			}
+		case OP_HPUB:
+			switch b {
+			case ' ', '\t':
+				c.state = OP_HPUB_SPC
+			default:
+				goto parseErr
+			}
+		case OP_HPUB_SPC:
+			switch b {
+			case ' ', '\t':
+				continue
+			default:
+				c.state = HPUB_ARG
+				c.as = i
+			}
+		case PUB_ARG, HPUB_ARG:
 			switch b {
 			case '\r':
 				c.drop = 1
@@ -203,7 +272,13 @@ func (c *client) parse(buf []byte) error {
 				} else {
 					arg = buf[c.as : i-c.drop]
 				}
-				if err := c.processPub(c.trace, arg); err != nil {
+				var err error
+				if c.state == HPUB_ARG {
+					err = c.processHeaderPub(c.trace, arg)
+				} else {
+					err = c.processPub(c.trace, arg)
+				}
+				if err != nil {
 					return err
 				}
 				c.drop, c.as, c.state = OP_START, i+1, MSG_PAYLOAD
@@ -261,6 +336,7 @@ func (c *client) parse(buf []byte) error {
 				// Drop all pub args
 				c.pa.arg, c.pa.rcache, c.pa.account, c.pa.subject = nil, nil, nil, nil
 				c.pa.reply, c.pa.szb, c.pa.queues = nil, nil, nil
+				c.pa.hdr, c.pa.hdb = 0, nil
 			default:
 				if c.msgBuf != nil {
 					c.msgBuf = append(c.msgBuf, b)
@@ -388,10 +464,15 @@ func (c *client) parse(buf []byte) error {
 					arg = buf[c.as : i-c.drop]
 				}
 				var err error
-				if c.typ == CLIENT {
+				switch c.typ {
+				case CLIENT:
 					err = c.processSub(arg)
-				} else {
+				case ROUTER:
 					err = c.processRemoteSub(arg)
+				case LEAF:
+					err = c.processLeafSub(arg)
+				case GATEWAY:
+					err = c.processGatewayRSub(arg)
 				}
 				if err != nil {
 					return err
@@ -420,6 +501,24 @@ func (c *client) parse(buf []byte) error {
 			default:
 				goto parseErr
 			}
+		case OP_L:
+			switch b {
+			case 'S', 's':
+				c.state = OP_LS
+			case 'M', 'm':
+				c.state = OP_M
+			default:
+				goto parseErr
+			}
+		case OP_LS:
+			switch b {
+			case '+':
+				c.state = OP_SUB
+			case '-':
+				c.state = OP_UNSUB
+			default:
+				goto parseErr
+			}
 		case OP_U:
 			switch b {
 			case 'N', 'n':
@@ -476,10 +575,15 @@ func (c *client) parse(buf []byte) error {
 					arg = buf[c.as : i-c.drop]
 				}
 				var err error
-				if c.typ == CLIENT {
+				switch c.typ {
+				case CLIENT:
 					err = c.processUnsub(arg)
-				} else {
+				case ROUTER:
 					err = c.processRemoteUnsub(arg)
+				case LEAF:
+					err = c.processLeafUnsub(arg)
+				case GATEWAY:
+					err = c.processGatewayRUnsub(arg)
 				}
 				if err != nil {
 					return err
@@ -632,7 +736,36 @@ func (c *client) parse(buf []byte) error {
 				c.state = MSG_ARG
 				c.as = i
 			}
-		case MSG_ARG:
+		case OP_HM:
+			switch b {
+			case 'S', 's':
+				c.state = OP_HMS
+			default:
+				goto parseErr
+			}
+		case OP_HMS:
+			switch b {
+			case 'G', 'g':
+				c.state = OP_HMSG
+			default:
+				goto parseErr
+			}
+		case OP_HMSG:
+			switch b {
+			case ' ', '\t':
+				c.state = OP_HMSG_SPC
+			default:
+				goto parseErr
+			}
+		case OP_HMSG_SPC:
+			switch b {
+			case ' ', '\t':
+				continue
+			default:
+				c.state = HMSG_ARG
+				c.as = i
+			}
+		case MSG_ARG, HMSG_ARG:
 			switch b {
 			case '\r':
 				c.drop = 1
@@ -644,7 +777,18 @@ func (c *client) parse(buf []byte) error {
 				} else {
 					arg = buf[c.as : i-c.drop]
 				}
-				if err := c.processRoutedMsgArgs(c.trace, arg); err != nil {
+				var err error
+				switch {
+				case c.typ == LEAF && c.state == HMSG_ARG:
+					err = c.processLeafHeaderMsgArgs(c.trace, arg)
+				case c.typ == LEAF:
+					err = c.processLeafMsgArgs(c.trace, arg)
+				case c.state == HMSG_ARG:
+					err = c.processRoutedHeaderMsgArgs(c.trace, arg)
+				default:
+					err = c.processRoutedMsgArgs(c.trace, arg)
+				}
+				if err != nil {
 					return err
 				}
 				c.drop, c.as, c.state = 0, i+1, MSG_PAYLOAD
@@ -791,7 +935,8 @@ func (c *client) parse(buf []byte) error {
 	if c.state == SUB_ARG || c.state == UNSUB_ARG || c.state == PUB_ARG ||
 		c.state == ASUB_ARG || c.state == AUSUB_ARG ||
 		c.state == MSG_ARG || c.state == MINUS_ERR_ARG ||
-		c.state == CONNECT_ARG || c.state == INFO_ARG {
+		c.state == CONNECT_ARG || c.state == INFO_ARG ||
+		c.state == HPUB_ARG || c.state == HMSG_ARG {
 		// Setup a holder buffer to deal with split buffer scenario.
 		if c.argBuf == nil {
 			c.argBuf = c.scratch[:0]
@@ -868,10 +1013,15 @@ func (c *client) clonePubArg() {
 	c.argBuf = c.scratch[:0]
 	c.argBuf = append(c.argBuf, c.pa.arg...)
 
+	// A header size means that the original was an HPUB or HMSG.
+	hdr := c.pa.hdr > 0
+
 	// This is a routed msg
 	if c.pa.account != nil {
-		c.processRoutedMsgArgs(false, c.argBuf)
+		c.processRoutedArgs(c.argBuf, hdr)
+	} else if c.typ == LEAF {
+		c.processLeafArgs(c.argBuf, hdr)
 	} else {
-		c.processPub(false, c.argBuf)
+		c.processPubArgs(c.argBuf, hdr)
 	}
 }
@@ -212,6 +212,61 @@ func TestParsePub(t *testing.T) {
 	}
 }
 
+func TestParseHeaderPub(t *testing.T) {
+	c := dummyClient()
+
+	// Without headers enabled in CONNECT this is an error.
+	hpub := []byte("HPUB foo 12 17\r\nNATS/1.0\r\n\r\nhello\r")
+	if err := c.parse(hpub); err == nil {
+		t.Fatalf("Expected an error, got none")
+	}
+
+	c = dummyClient()
+	c.headers = true
+	if err := c.parse(hpub); err != nil || c.state != MSG_END {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.subject, []byte("foo")) {
+		t.Fatalf("Did not parse subject correctly: 'foo' vs '%s'\n", c.pa.subject)
+	}
+	if c.pa.reply != nil {
+		t.Fatalf("Did not parse reply correctly: 'nil' vs '%s'\n", c.pa.reply)
+	}
+	if c.pa.hdr != 12 {
+		t.Fatalf("Did not parse header size correctly: 12 vs %d\n", c.pa.hdr)
+	}
+	if c.pa.size != 17 {
+		t.Fatalf("Did not parse msg size correctly: 17 vs %d\n", c.pa.size)
+	}
+
+	// Clear snapshots
+	c.argBuf, c.msgBuf, c.state = nil, nil, OP_START
+
+	hpub = []byte("HPUB foo.bar INBOX.22 12 23\r\nNATS/1.0\r\n\r\nhello world\r")
+	if err := c.parse(hpub); err != nil || c.state != MSG_END {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.reply, []byte("INBOX.22")) {
+		t.Fatalf("Did not parse reply correctly: 'INBOX.22' vs '%s'\n", c.pa.reply)
+	}
+	if c.pa.hdr != 12 || c.pa.size != 23 {
+		t.Fatalf("Did not parse sizes correctly: 12 23 vs %d %d\n", c.pa.hdr, c.pa.size)
+	}
+
+	for _, arg := range []string{"foo 17", "foo 0 17", "foo 18 17", "foo x 17"} {
+		c.argBuf, c.msgBuf, c.state = nil, nil, OP_START
+		if err := c.processHeaderPub(false, []byte(arg)); err == nil {
+			t.Fatalf("Expected an error for %q", arg)
+		}
+	}
+
+	// Clients can not send HMSG.
+	c.argBuf, c.msgBuf, c.state = nil, nil, OP_START
+	if err := c.parse([]byte("HMSG $G foo 12 17\r\n")); err == nil {
+		t.Fatalf("Expected an error, got none")
+	}
+}
+
 func TestParsePubArg(t *testing.T) {
 	c := dummyClient()
 
@@ -412,6 +467,50 @@ func TestParseRouteMsg(t *testing.T) {
 	}
 }
 
+func TestParseRouteHeaderMsg(t *testing.T) {
+	c := dummyRouteClient()
+
+	msg := []byte("HMSG $G foo.bar + INBOX.22 bar baz 12 23\r\nNATS/1.0\r\n\r\nhello world\r")
+	if err := c.parse(msg); err != nil || c.state != MSG_END {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.account, []byte("$G")) {
+		t.Fatalf("Did not parse account correctly: '$G' vs '%s'\n", c.pa.account)
+	}
+	if !bytes.Equal(c.pa.subject, []byte("foo.bar")) {
+		t.Fatalf("Did not parse subject correctly: 'foo.bar' vs '%s'\n", c.pa.subject)
+	}
+	if !bytes.Equal(c.pa.reply, []byte("INBOX.22")) {
+		t.Fatalf("Did not parse reply correctly: 'INBOX.22' vs '%s'\n", c.pa.reply)
+	}
+	if len(c.pa.queues) != 2 {
+		t.Fatalf("Expected 2 queues, got %d", len(c.pa.queues))
+	}
+	if c.pa.hdr != 12 || c.pa.size != 23 {
+		t.Fatalf("Did not parse sizes correctly: 12 23 vs %d %d\n", c.pa.hdr, c.pa.size)
+	}
+
+	// Routes can not send HPUB.
+	c = dummyRouteClient()
+	if err := c.parse([]byte("HPUB foo 12 17\r\n")); err == nil {
+		t.Fatalf("Expected an error, got none")
+	}
+
+	// Leaf nodes do not carry the account.
+	c = dummyClient()
+	c.typ = LEAF
+	msg = []byte("HMSG foo 12 17\r\nNATS/1.0\r\n\r\nhello\r")
+	if err := c.parse(msg); err != nil || c.state != MSG_END {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if c.pa.account != nil || !bytes.Equal(c.pa.subject, []byte("foo")) {
+		t.Fatalf("Did not parse leaf HMSG correctly: %q %q", c.pa.account, c.pa.subject)
+	}
+	if c.pa.hdr != 12 || c.pa.size != 17 {
+		t.Fatalf("Did not parse sizes correctly: 12 17 vs %d %d\n", c.pa.hdr, c.pa.size)
+	}
+}
+
 func TestParseMsgSpace(t *testing.T) {
 	c := dummyRouteClient()
 
@@ -169,6 +169,19 @@ func (t *tlsTimeoutOption) Apply(server *Server) {
 	server.Noticef("Reloaded: tls timeout = %v", t.newValue)
 }
 
+// tlsMapOption implements the option interface for the tls `verify_and_map`
+// setting.
+type tlsMapOption struct {
+	authOption
+	newValue bool
+}
+
+// Apply is a no-op because authorization will be reloaded after options are
+// applied.
+func (t *tlsMapOption) Apply(server *Server) {
+	server.Noticef("Reloaded: tls verify_and_map = %v", t.newValue)
+}
+
 // authOption is a base struct that provides default option behaviors.
 type authOption struct {
 	noopOption
@@ -245,6 +258,27 @@ func (u *nkeysOption) Apply(server *Server) {
 	server.Noticef("Reloaded: authorization nkey users")
 }
 
+// authCalloutOption implements the option interface for the authorization
+// `auth_callout` setting. The cached responses are dropped with the
+// previous callout when clients are authenticated again.
+type authCalloutOption struct {
+	authOption
+}
+
+func (a *authCalloutOption) Apply(server *Server) {
+	server.Noticef("Reloaded: authorization callout")
+}
+
+// customAuthOption implements the option interface for a custom client
+// authentication, such as the authorization `directory`.
+type customAuthOption struct {
+	authOption
+}
+
+func (a *customAuthOption) Apply(server *Server) {
+	server.Noticef("Reloaded: custom client authentication")
+}
+
 // clusterOption implements the option interface for the `cluster` setting.
 type clusterOption struct {
 	authOption
@@ -535,6 +569,11 @@ func (s *Server) reloadOptions(newOpts *Options) error {
 	// Need to save off previous cluster permissions
 	s.mu.Lock()
 	s.oldClusterPerms = s.opts.Cluster.Permissions
+	// Serve the client certificate of the new TLS config from its files
+	// before the config is in use.
+	if err := s.configureCertStore(newOpts.TLSConfig, newOpts.TLSCert, newOpts.TLSKey); err != nil {
+		s.Errorf("Error loading TLS certificate, rotation is disabled: %v", err)
+	}
 	s.mu.Unlock()
 	s.setOpts(newOpts)
 	s.applyOptions(changed)
@@ -578,6 +617,10 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			diffOpts = append(diffOpts, &tlsOption{newValue: newValue.(*tls.Config)})
 		case "tlstimeout":
 			diffOpts = append(diffOpts, &tlsTimeoutOption{newValue: newValue.(float64)})
+		case "tlscert", "tlskey":
+			// The certificate files are watched again in reloadOptions.
+		case "tlsmap":
+			diffOpts = append(diffOpts, &tlsMapOption{newValue: newValue.(bool)})
 		case "username":
 			diffOpts = append(diffOpts, &usernameOption{})
 		case "password":
@@ -590,6 +633,10 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			diffOpts = append(diffOpts, &usersOption{})
 		case "nkeys":
 			diffOpts = append(diffOpts, &nkeysOption{})
+		case "authcallout":
+			diffOpts = append(diffOpts, &authCalloutOption{})
+		case "customclientauthentication":
+			diffOpts = append(diffOpts, &customAuthOption{})
 		case "cluster":
 			newClusterOpts := newValue.(ClusterOpts)
 			oldClusterOpts := oldValue.(ClusterOpts)
@@ -628,6 +675,14 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			diffOpts = append(diffOpts, &clientAdvertiseOption{newValue: cliAdv})
 		case "accounts":
 			diffOpts = append(diffOpts, &accountsOption{})
+		case "accountresolver":
+			// The URL resolver has a cache, so only compare its configuration.
+			or, ook := oldValue.(*URLAccResolver)
+			nr, nok := newValue.(*URLAccResolver)
+			if ook && nok && or.sameConfig(nr) {
+				continue
+			}
+			return nil, fmt.Errorf("Config reload not supported for %s", field.Name)
 		case "nolog", "nosigs":
 			// Ignore NoLog and NoSigs options since they are not parsed and only used in
 			// testing.
@@ -696,6 +751,10 @@ func (s *Server) reloadAuthorization() {
 	oldAccounts := s.accounts
 	s.accounts = make(map[string]*Account)
 	s.registerAccount(s.gacc)
+	// The reserved system account is not part of the configured accounts.
+	if s.sys != nil && s.sys.account.Name == DEFAULT_SYSTEM_ACCOUNT {
+		s.registerAccount(s.sys.account)
+	}
 	for _, newAcc := range s.opts.Accounts {
 		if acc, ok := oldAccounts[newAcc.Name]; ok {
 			// If account exist in latest config, "transfer" the account's
@@ -796,6 +855,13 @@ func (s *Server) clientHasMovedToDifferentAccount(c *client) bool {
 	} else if u != nil && u.Account != nil {
 		return curAccName != u.Account.Name
 	}
+	// Users of a custom authentication or of the callout are not in the
+	// configuration, they are authorized again instead.
+	if nu == nil && u == nil {
+		if opts := s.getOpts(); opts.CustomClientAuthentication != nil || opts.AuthCallout != nil {
+			return false
+		}
+	}
 	// user/nkey no longer exists.
 	return true
 }
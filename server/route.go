@@ -64,16 +64,22 @@ type route struct {
 	closed       bool
 	connectURLs  []string
 	replySubs    map[*subscription]*time.Timer
+	shareNodes   bool
+	sysEvents    bool
 }
 
 type connectInfo struct {
-	Echo     bool   `json:"echo"`
-	Verbose  bool   `json:"verbose"`
-	Pedantic bool   `json:"pedantic"`
-	User     string `json:"user,omitempty"`
-	Pass     string `json:"pass,omitempty"`
-	TLS      bool   `json:"tls_required"`
-	Name     string `json:"name"`
+	Echo       bool   `json:"echo"`
+	Verbose    bool   `json:"verbose"`
+	Pedantic   bool   `json:"pedantic"`
+	User       string `json:"user,omitempty"`
+	Pass       string `json:"pass,omitempty"`
+	TLS        bool   `json:"tls_required"`
+	Name       string `json:"name"`
+	Gateway    string `json:"gateway,omitempty"`
+	Headers    bool   `json:"headers,omitempty"`
+	ShareNodes bool   `json:"share_nodes,omitempty"`
+	SysEvents  bool   `json:"sys_events,omitempty"`
 }
 
 // Route protocol constants
@@ -130,16 +136,25 @@ func (c *client) removeReplySubTimeout(sub *subscription) {
 }
 
 func (c *client) processAccountSub(arg []byte) error {
+	c.traceInOp("A+", arg)
+	// Gateways use this to signal renewed interest in an account.
+	if c.typ == GATEWAY {
+		return c.processGatewayAccountSub(string(arg))
+	}
 	// Placeholder in case we add in to the protocol active senders of
 	// informtation. For now we do not do account interest propagation.
-	c.traceInOp("A+", arg)
 	return nil
 }
 
 func (c *client) processAccountUnsub(arg []byte) {
+	c.traceInOp("A-", arg)
+	// Gateways use this to signal no interest at all in an account.
+	if c.typ == GATEWAY {
+		c.processGatewayAccountUnsub(string(arg))
+		return
+	}
 	// Placeholder in case we add in to the protocol active senders of
 	// informtation. For now we do not do account interest propagation.
-	c.traceInOp("A-", arg)
 }
 
 // Process an inbound RMSG specification from the remote route.
@@ -147,7 +162,19 @@ func (c *client) processRoutedMsgArgs(trace bool, arg []byte) error {
 	if trace {
 		c.traceInOp("RMSG", arg)
 	}
+	return c.processRoutedArgs(arg, false)
+}
 
+// Process an inbound HMSG from a route or gateway. This is an RMSG
+// with the header size placed before the total size.
+func (c *client) processRoutedHeaderMsgArgs(trace bool, arg []byte) error {
+	if trace {
+		c.traceInOp("HMSG", arg)
+	}
+	return c.processRoutedArgs(arg, true)
+}
+
+func (c *client) processRoutedArgs(arg []byte, hdr bool) error {
 	// Unroll splitArgs to avoid runtime/heap issues
 	a := [MAX_MSG_ARGS][]byte{}
 	args := a[:0]
@@ -170,6 +197,12 @@ func (c *client) processRoutedMsgArgs(trace bool, arg []byte) error {
 	}
 
 	c.pa.arg = arg
+	c.pa.hdr, c.pa.hdb = 0, nil
+	if hdr {
+		if args = c.splitHeaderSize(args); c.pa.hdr <= 0 {
+			return fmt.Errorf("processRoutedHeaderMsgArgs Bad or Missing Header Size: '%s'", arg)
+		}
+	}
 	switch len(args) {
 	case 0, 1, 2:
 		return fmt.Errorf("processRoutedMsgArgs Parse Error: '%s'", args)
@@ -210,6 +243,9 @@ func (c *client) processRoutedMsgArgs(trace bool, arg []byte) error {
 	if c.pa.size < 0 {
 		return fmt.Errorf("processRoutedMsgArgs Bad or Missing Size: '%s'", args)
 	}
+	if c.pa.hdr > c.pa.size {
+		return fmt.Errorf("processRoutedHeaderMsgArgs Header Size Exceeds Size: '%s'", arg)
+	}
 
 	// Common ones processed after check for arg length
 	c.pa.account = args[0]
@@ -345,13 +381,15 @@ func (c *client) sendConnect(tlsRequired bool) {
 		pass, _ = userInfo.Password()
 	}
 	cinfo := connectInfo{
-		Echo:     true,
-		Verbose:  false,
-		Pedantic: false,
-		User:     user,
-		Pass:     pass,
-		TLS:      tlsRequired,
-		Name:     c.srv.info.ID,
+		Echo:       true,
+		Verbose:    false,
+		Pedantic:   false,
+		User:       user,
+		Pass:       pass,
+		TLS:        tlsRequired,
+		Name:       c.srv.info.ID,
+		ShareNodes: true,
+		SysEvents:  true,
 	}
 
 	b, err := json.Marshal(cinfo)
@@ -376,6 +414,15 @@ func (c *client) processRouteInfo(info *Info) {
 	s := c.srv
 	remoteID := c.route.remoteID
 
+	// Named clients of the remote server.
+	if info.Nodes != nil {
+		c.mu.Unlock()
+		if remoteID == info.ID {
+			s.processRouteNodes(info.ID, info.Nodes)
+		}
+		return
+	}
+
 	// We receive an INFO from a server that informs us about another server,
 	// so the info.ID in the INFO protocol does not match the ID of this route.
 	if remoteID != "" && remoteID != info.ID {
@@ -417,6 +464,21 @@ func (c *client) processRouteInfo(info *Info) {
 	c.opts.Import = info.Import
 	c.opts.Export = info.Export
 
+	// Whether HMSG can be sent to this route. Set only once so that
+	// it is fixed before the route's subscriptions are registered.
+	c.headers = info.Headers
+
+	// Whether the route wants the client connections of this server, as
+	// told by its CONNECT if it solicited the route, or else by its INFO.
+	// The same goes for the heartbeats of the reserved system account.
+	if c.route.didSolicit {
+		c.route.shareNodes = info.ShareNodes
+		c.route.sysEvents = info.SysEvents
+	} else {
+		c.route.shareNodes = c.opts.ShareNodes
+		c.route.sysEvents = c.opts.SysEvents
+	}
+
 	// If we do not know this route's URL, construct one on the fly
 	// from the information provided.
 	if c.route.url == nil {
@@ -446,6 +508,9 @@ func (c *client) processRouteInfo(info *Info) {
 		// Send our subs to the other side.
 		s.sendSubsToRoute(c)
 
+		// And our named clients.
+		s.sendNodesToRoute(c)
+
 		// sendInfo will be false if the route that we just accepted
 		// is the only route there is.
 		if sendInfo {
@@ -688,6 +753,11 @@ func (c *client) removeRemoteSubs() {
 	for _, ase := range as {
 		c.Debugf("Removing %d subscriptions for account %q", len(ase.subs), ase.acc.Name)
 		ase.acc.sl.RemoveBatch(ase.subs)
+		// Let any leaf nodes and gateways know we lost this interest.
+		for _, sub := range ase.subs {
+			ase.acc.updateLeafNodes(sub, -1)
+			srv.gatewayUpdateSubInterest(ase.acc.Name, sub, -1)
+		}
 	}
 }
 
@@ -735,13 +805,21 @@ func (c *client) processRemoteUnsub(arg []byte) (err error) {
 	// We store local subs by account and subject and optionally queue name.
 	// RS- will have the arg exactly as the key.
 	key := string(arg)
+	var osub *subscription
 	if sub, ok := c.subs[key]; ok {
 		delete(c.subs, key)
 		acc.sl.Remove(sub)
 		c.removeReplySubTimeout(sub)
+		osub = sub
 	}
 	c.mu.Unlock()
 
+	// Update any leaf nodes bound to this account and gateways.
+	if osub != nil {
+		acc.updateLeafNodes(osub, -1)
+		srv.gatewayUpdateSubInterest(acc.Name, osub, -1)
+	}
+
 	if c.opts.Verbose {
 		c.sendOK()
 	}
@@ -835,6 +913,12 @@ func (c *client) processRemoteSub(argo []byte) (err error) {
 	}
 	c.mu.Unlock()
 
+	// Update any leaf nodes bound to this account and gateways for new interest.
+	if osub == nil {
+		acc.updateLeafNodes(sub, 1)
+		srv.gatewayUpdateSubInterest(acc.Name, sub, 1)
+	}
+
 	if c.opts.Verbose {
 		c.sendOK()
 	}
@@ -861,7 +945,12 @@ func (s *Server) sendSubsToRoute(route *client) {
 
 	route.mu.Lock()
 	for _, a := range accs {
+		if !route.route.sysEvents && s.isReservedSysAccount(a) {
+			continue
+		}
 		subs := raw[:0]
+		// The subscriptions are scoped to the account through their client.
+		ac := &client{acc: a}
 		a.mu.RLock()
 		for key, rme := range a.rm {
 			// FIXME(dlc) - Just pass rme around.
@@ -872,7 +961,7 @@ func (s *Server) sendSubsToRoute(route *client) {
 				subEnd = int(qi) - 1
 				qn = []byte(key[qi:])
 			}
-			sub := &subscription{subject: []byte(key[:subEnd]), queue: qn, qw: rme.n}
+			sub := &subscription{client: ac, subject: []byte(key[:subEnd]), queue: qn, qw: rme.n}
 			subs = append(subs, sub)
 
 		}
@@ -1219,6 +1308,13 @@ func (s *Server) updateRouteSubscriptionMap(acc *Account, sub *subscription, del
 	if acc == nil || sub == nil {
 		return
 	}
+
+	// Leaf nodes bound to this account need to know about this interest too.
+	acc.updateLeafNodes(sub, delta)
+
+	// So do the gateways that we told about our interest for this account.
+	s.gatewayUpdateSubInterest(acc.Name, sub, delta)
+
 	acc.mu.RLock()
 	rm := acc.rm
 	acc.mu.RUnlock()
@@ -1229,7 +1325,9 @@ func (s *Server) updateRouteSubscriptionMap(acc *Account, sub *subscription, del
 	}
 
 	// We only store state on local subs for transmission across routes.
-	if sub.client == nil || sub.client.typ != CLIENT {
+	// Subscriptions from leaf nodes and the internal system client are
+	// considered local to this cluster.
+	if sub.client == nil || (sub.client.typ != CLIENT && sub.client.typ != LEAF && sub.client.typ != SYSTEM) {
 		return
 	}
 
@@ -1294,20 +1392,25 @@ func (s *Server) updateRouteSubscriptionMap(acc *Account, sub *subscription, del
 	// Note that queue unsubs where entry.n > 0 are still
 	// subscribes with a smaller weight.
 	if entryN > 0 {
-		s.broadcastSubscribe(sub)
+		s.broadcastSubscribe(acc, sub)
 	} else {
-		s.broadcastUnSubscribe(sub)
+		s.broadcastUnSubscribe(acc, sub)
 	}
 }
 
 // broadcastSubscribe will forward a client subscription
 // to all active routes as needed.
-func (s *Server) broadcastSubscribe(sub *subscription) {
+func (s *Server) broadcastSubscribe(acc *Account, sub *subscription) {
 	trace := atomic.LoadInt32(&s.logging.trace) == 1
+	sysOnly := s.isReservedSysAccount(acc)
 	s.mu.Lock()
 	subs := []*subscription{sub}
 	for _, route := range s.routes {
 		route.mu.Lock()
+		if sysOnly && !route.route.sysEvents {
+			route.mu.Unlock()
+			continue
+		}
 		route.sendRouteSubProtos(subs, trace, func(sub *subscription) bool {
 			return route.canImport(string(sub.subject))
 		})
@@ -1318,12 +1421,17 @@ func (s *Server) broadcastSubscribe(sub *subscription) {
 
 // broadcastUnSubscribe will forward a client unsubscribe
 // action to all active routes.
-func (s *Server) broadcastUnSubscribe(sub *subscription) {
+func (s *Server) broadcastUnSubscribe(acc *Account, sub *subscription) {
 	trace := atomic.LoadInt32(&s.logging.trace) == 1
+	sysOnly := s.isReservedSysAccount(acc)
 	s.mu.Lock()
 	subs := []*subscription{sub}
 	for _, route := range s.routes {
 		route.mu.Lock()
+		if sysOnly && !route.route.sysEvents {
+			route.mu.Unlock()
+			continue
+		}
 		route.sendRouteUnSubProtos(subs, trace, func(sub *subscription) bool {
 			return route.canImport(string(sub.subject))
 		})
@@ -1373,6 +1481,9 @@ func (s *Server) routeAcceptLoop(ch chan struct{}) {
 		TLSVerify:    tlsReq,
 		MaxPayload:   s.info.MaxPayload,
 		Proto:        proto,
+		Headers:      true,
+		ShareNodes:   true,
+		SysEvents:    true,
 	}
 	// Set this if only if advertise is not disabled
 	if !opts.Cluster.NoAdvertise {
@@ -14,7 +14,9 @@
 package server
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"reflect"
@@ -1026,3 +1028,57 @@ func TestRouteSendLocalSubsWithLowMaxPending(t *testing.T) {
 	// Check that all subs have been sent ok
 	checkExpectedSubs(t, numSubs, srvA, srvB)
 }
+
+func TestRouteForwardsHeaders(t *testing.T) {
+	optsA := DefaultOptions()
+	optsA.Cluster.Host = "127.0.0.1"
+	optsA.Cluster.Port = -1
+	srvA := RunServer(optsA)
+	defer srvA.Shutdown()
+
+	optsB := DefaultOptions()
+	optsB.Port = -1
+	optsB.Cluster.Host = "127.0.0.1"
+	optsB.Cluster.Port = -1
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", srvA.ClusterAddr().Port))
+	srvB := RunServer(optsB)
+	defer srvB.Shutdown()
+
+	checkClusterFormed(t, srvA, srvB)
+
+	hsub, hcr := createHeadersClientConn(t, optsB.Host, optsB.Port, true)
+	defer hsub.Close()
+	hsub.Write([]byte("SUB foo 1\r\nSUB foo bar 2\r\nPING\r\n"))
+	psub, pcr := createHeadersClientConn(t, optsB.Host, optsB.Port, false)
+	defer psub.Close()
+	psub.Write([]byte("SUB foo 1\r\nPING\r\n"))
+	for _, cr := range []*bufio.Reader{hcr, pcr} {
+		if l, _ := cr.ReadString('\n'); l != "PONG\r\n" {
+			t.Fatalf("Expected PONG, got %q", l)
+		}
+	}
+	// Plain subscriptions on the same subject are sent once over the route.
+	checkExpectedSubs(t, 3, srvB)
+	checkExpectedSubs(t, 2, srvA)
+
+	pub, _ := createHeadersClientConn(t, optsA.Host, optsA.Port, true)
+	defer pub.Close()
+	pub.Write([]byte("HPUB foo reply 12 17\r\nNATS/1.0\r\n\r\nhello\r\n"))
+
+	// The queue subscriber is covered by the HMSG queue list on the route.
+	hdr := "NATS/1.0\r\n\r\nhello\r\n"
+	for i := 0; i < 2; i++ {
+		l, err := hcr.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error receiving msg: %v\n", err)
+		}
+		if l != "HMSG foo 1 reply 12 17\r\n" && l != "HMSG foo 2 reply 12 17\r\n" {
+			t.Fatalf("Unexpected msg: %q", l)
+		}
+		buf := make([]byte, len(hdr))
+		if _, err := io.ReadFull(hcr, buf); err != nil || string(buf) != hdr {
+			t.Fatalf("Unexpected payload %q: %v", buf, err)
+		}
+	}
+	expectMsg(t, pcr, "MSG foo 1 reply 5\r\n", "hello\r\n")
+}
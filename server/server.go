@@ -63,11 +63,24 @@ type Info struct {
 	IP                string   `json:"ip,omitempty"`
 	CID               uint64   `json:"client_id,omitempty"`
 	Nonce             string   `json:"nonce,omitempty"`
+	Headers           bool     `json:"headers,omitempty"`
 	ClientConnectURLs []string `json:"connect_urls,omitempty"` // Contains URLs a client can connect to.
 
 	// Route Specific
 	Import *SubjectPermission `json:"import,omitempty"`
 	Export *SubjectPermission `json:"export,omitempty"`
+
+	// Route Specific, client connections shared with the cluster.
+	ShareNodes bool             `json:"share_nodes,omitempty"`
+	Nodes      *InformerPayload `json:"nodes,omitempty"`
+
+	// Route Specific, heartbeats of the reserved system account.
+	SysEvents bool `json:"sys_events,omitempty"`
+
+	// Gateway Specific
+	Gateway           string `json:"gateway,omitempty"`
+	GatewayCmd        byte   `json:"gateway_cmd,omitempty"`
+	GatewayCmdPayload []byte `json:"gateway_cmd_payload,omitempty"`
 }
 
 // Server is our main struct.
@@ -83,6 +96,9 @@ type Server struct {
 	running        bool
 	shutdown       bool
 	listener       net.Listener
+	unixListener   net.Listener
+	certs          *certStore
+	certsWatched   bool
 	gacc           *Account
 	accounts       map[string]*Account
 	activeAccounts int
@@ -105,6 +121,25 @@ type Server struct {
 	routeInfoJSON  []byte
 	quitCh         chan struct{}
 
+	// Leaf node connections and listener.
+	leafs            map[uint64]*client
+	leafNodeListener net.Listener
+	websocket        srvWebsocket
+	mqtt             srvMQTT
+	clientListeners  []*clientListener
+	leafNodeInfo     Info
+	leafNodeInfoJSON []byte
+
+	// Gateway connections and listener.
+	gateway *srvGateway
+
+	// System account and internal client used for events.
+	sys *internal
+
+	// External authorization callout, created on first use.
+	calloutMu sync.Mutex
+	callout   *authCallout
+
 	// Tracking Go routines
 	grMu         sync.Mutex
 	grTmpClients map[uint64]*client
@@ -149,7 +184,16 @@ type Server struct {
 	// Trusted public operator keys.
 	trustedNkeys []string
 
-	informers sync.Map
+	// Informers by URL, the clients they know about, local ones by
+	// client ID and the ones of route peers by server ID, and the events
+	// waiting for the batch window to end, for informers and routes.
+	informersMu        sync.Mutex
+	informers          map[string]*informer
+	informerNodes      map[uint64]*informerNode
+	remoteNodes        map[string][]*informerNode
+	informerBatch      []*InformerEvent
+	informerRouteBatch []*InformerEvent
+	informerTimer      *time.Timer
 }
 
 // Make sure all are 64bits for atomic use
@@ -181,6 +225,7 @@ func New(opts *Options) *Server {
 		TLSRequired:  tlsReq,
 		TLSVerify:    verify,
 		MaxPayload:   opts.MaxPayload,
+		Headers:      true,
 	}
 
 	now := time.Now()
@@ -194,6 +239,9 @@ func New(opts *Options) *Server {
 		configTime: now,
 	}
 
+	// Set the account resolver, if one is configured.
+	s.accResolver = opts.AccountResolver
+
 	if !s.processTrustedNkeys() {
 		return nil
 	}
@@ -231,6 +279,12 @@ func New(opts *Options) *Server {
 	s.routes = make(map[uint64]*client)
 	s.remotes = make(map[string]*client)
 
+	// For tracking leaf nodes.
+	s.leafs = make(map[uint64]*client)
+
+	// For tracking gateways.
+	s.gateway = newServerGateway(opts)
+
 	// Used to kick out all go routines possibly waiting on server
 	// to shutdown.
 	s.quitCh = make(chan struct{})
@@ -238,6 +292,9 @@ func New(opts *Options) *Server {
 	// Used to setup Accounts.
 	s.configureAccounts()
 
+	// Used to setup the system account for internal events.
+	s.configureSystemAccount()
+
 	// Used to setup Authorization.
 	s.configureAuthorization()
 
@@ -390,8 +447,12 @@ func (s *Server) NewAccountsAllowed() bool {
 }
 
 // numReservedAccounts will return the number of reserved accounts configured in the server.
-// Currently this is 1 for the global default service.
+// Currently this is 1 for the global default service, plus 1 for the system
+// account when it is not one of the configured accounts.
 func (s *Server) numReservedAccounts() int {
+	if s.opts.SystemAccount == "" {
+		return 2
+	}
 	return 1
 }
 
@@ -508,12 +569,22 @@ func (s *Server) updateAccount(acc *Account) bool {
 		s.Debugf("Requested account update for [%s], same claims detected", acc.Name)
 		return false
 	}
+	return s.updateAccountWithClaimJWT(acc, claimJWT) == nil
+}
+
+// updateAccountWithClaimJWT will verify the claims and update the account.
+// Lock should be held upon entry.
+func (s *Server) updateAccountWithClaimJWT(acc *Account, claimJWT string) error {
 	accClaims, err := s.verifyAccountClaims(claimJWT)
-	if err == nil && accClaims != nil {
-		s.updateAccountClaims(acc, accClaims)
-		return true
+	if err != nil {
+		return err
 	}
-	return false
+	if accClaims.Subject != acc.Name {
+		return fmt.Errorf("claims are for account [%s]", accClaims.Subject)
+	}
+	acc.claimJWT = claimJWT
+	s.updateAccountClaims(acc, accClaims)
+	return nil
 }
 
 // fetchRawAccountClaims will grab raw account claims iff we have a resolver.
@@ -534,12 +605,14 @@ func (s *Server) fetchRawAccountClaims(name string) (string, error) {
 }
 
 // fetchAccountClaims will attempt to fetch new claims if a resolver is present.
-func (s *Server) fetchAccountClaims(name string) (*jwt.AccountClaims, error) {
+// The raw claims are returned too.
+func (s *Server) fetchAccountClaims(name string) (*jwt.AccountClaims, string, error) {
 	claimJWT, err := s.fetchRawAccountClaims(name)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return s.verifyAccountClaims(claimJWT)
+	accClaims, err := s.verifyAccountClaims(claimJWT)
+	return accClaims, claimJWT, err
 }
 
 // verifyAccountClaims will decode and validate any account claims.
@@ -559,8 +632,8 @@ func (s *Server) verifyAccountClaims(claimJWT string) (*jwt.AccountClaims, error
 // This will fetch an account from a resolver if defined.
 // Lock should be held upon entry.
 func (s *Server) fetchAccount(name string) *Account {
-	if accClaims, _ := s.fetchAccountClaims(name); accClaims != nil {
-		if acc := s.buildInternalAccount(accClaims); acc != nil {
+	if accClaims, claimJWT, _ := s.fetchAccountClaims(name); accClaims != nil {
+		if acc := s.buildInternalAccount(accClaims, claimJWT); acc != nil {
 			s.registerAccount(acc)
 			return acc
 		}
@@ -601,12 +674,41 @@ func (s *Server) Start() {
 		}
 	}
 
+	// Watch the account resolver for updates.
+	s.mu.Lock()
+	ar := s.accResolver
+	s.mu.Unlock()
+	switch r := ar.(type) {
+	case *DirAccResolver:
+		s.startGoRoutine(func() { s.watchAccountDir(r) })
+	case *URLAccResolver:
+		s.startGoRoutine(func() { s.refreshURLAccounts(r) })
+	}
+
+	// Serve the client certificate from its files so that it is rotated
+	// when they change.
+	s.mu.Lock()
+	if err := s.configureCertStore(opts.TLSConfig, opts.TLSCert, opts.TLSKey); err != nil {
+		s.Errorf("Error loading TLS certificate, rotation is disabled: %v", err)
+	}
+	s.mu.Unlock()
+
+	// Start sending system events and answering requests.
+	if s.sys != nil {
+		s.startGoRoutine(s.internalSendLoop)
+		s.initEventTracking()
+	}
+
 	// Start monitoring if needed
 	if err := s.StartMonitoring(); err != nil {
 		s.Fatalf("Can't start monitoring: %v", err)
 		return
 	}
 
+	// Start delivering to the informers of the configuration, and the
+	// ones registered before a restart.
+	s.startInformers()
+
 	// The Routing routine needs to wait for the client listen
 	// port to be opened and potential ephemeral port selected.
 	clientListenReady := make(chan struct{})
@@ -618,6 +720,31 @@ func (s *Server) Start() {
 		})
 	}
 
+	// Start up leaf node support if needed.
+	if opts.LeafNode.Port != 0 {
+		s.startLeafNodeAcceptLoop()
+	}
+
+	// Solicit remote servers for leaf node connections.
+	if len(opts.LeafNode.Remotes) > 0 {
+		s.solicitLeafNodeRemotes(opts.LeafNode.Remotes)
+	}
+
+	// Start up gateways if needed.
+	if opts.Gateway.Port != 0 {
+		s.startGateways()
+	}
+
+	// Start up websocket support if needed.
+	if opts.Websocket.Port != 0 {
+		s.startWebsocketServer()
+	}
+
+	// Start up the MQTT listener if needed.
+	if opts.MQTT.Port != 0 {
+		s.startMQTT()
+	}
+
 	// Pprof http endpoint for the profiler.
 	if opts.ProfPort != 0 {
 		s.StartProfiler()
@@ -627,6 +754,17 @@ func (s *Server) Start() {
 		s.logPorts()
 	}
 
+	// Start the client unix socket listener if needed.
+	if opts.UnixSocket != _EMPTY_ {
+		s.startUnixSocketListener()
+	}
+
+	// Start the additional client listeners, if any, before the main
+	// AcceptLoop computes the client connect URLs.
+	if len(opts.Listeners) > 0 {
+		s.startClientListeners()
+	}
+
 	// Wait for clients.
 	s.AcceptLoop(clientListenReady)
 }
@@ -668,6 +806,12 @@ func (s *Server) Shutdown() {
 		r.setRouteNoReconnectOnClose()
 		conns[i] = r
 	}
+	// Copy off the leaf nodes
+	for i, c := range s.leafs {
+		conns[i] = c
+	}
+	// Copy off the gateways
+	s.getAllGatewayConnections(conns)
 
 	// Number of done channel responses we expect.
 	doneExpected := 0
@@ -679,6 +823,23 @@ func (s *Server) Shutdown() {
 		s.listener = nil
 	}
 
+	// Kick unix socket AcceptLoop() and remove the socket file
+	if s.unixListener != nil {
+		doneExpected++
+		s.unixListener.Close()
+		s.unixListener = nil
+		os.Remove(opts.UnixSocket)
+	}
+
+	// Kick additional client listeners
+	for _, cl := range s.clientListeners {
+		if cl.listener != nil {
+			doneExpected++
+			cl.listener.Close()
+			cl.listener = nil
+		}
+	}
+
 	// Kick route AcceptLoop()
 	if s.routeListener != nil {
 		doneExpected++
@@ -686,6 +847,34 @@ func (s *Server) Shutdown() {
 		s.routeListener = nil
 	}
 
+	// Kick leafnode AcceptLoop()
+	if s.leafNodeListener != nil {
+		doneExpected++
+		s.leafNodeListener.Close()
+		s.leafNodeListener = nil
+	}
+
+	// Kick websocket server
+	if s.websocket.listener != nil {
+		doneExpected++
+		s.websocket.listener.Close()
+		s.websocket.listener = nil
+	}
+
+	// Kick MQTT accept loop
+	if s.mqtt.listener != nil {
+		doneExpected++
+		s.mqtt.listener.Close()
+		s.mqtt.listener = nil
+	}
+
+	// Kick gateway AcceptLoop()
+	if s.gateway.listener != nil {
+		doneExpected++
+		s.gateway.listener.Close()
+		s.gateway.listener = nil
+	}
+
 	// Kick HTTP monitoring if its running
 	if s.http != nil {
 		doneExpected++
@@ -732,8 +921,6 @@ func (s *Server) Shutdown() {
 			l.Close()
 		}
 	}
-
-	s.BroadcastClientInfoToInformer()
 }
 
 // AcceptLoop is exported for easier testing.
@@ -823,7 +1010,87 @@ func (s *Server) AcceptLoop(clr chan struct{}) {
 		}
 		tmpDelay = ACCEPT_MIN_SLEEP
 		s.startGoRoutine(func() {
-			s.createClient(conn)
+			s.createClient(conn, nil)
+			s.grWG.Done()
+		})
+	}
+	s.done <- true
+}
+
+// clientListener is an additional client listen endpoint with its own
+// TLS, auth timeout and account settings.
+type clientListener struct {
+	opts     *ListenerOpts
+	listener net.Listener
+}
+
+// startClientListeners opens all additional client listeners and starts
+// their accept loops.
+func (s *Server) startClientListeners() {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	for _, lo := range opts.Listeners {
+		port := lo.Port
+		if port == -1 {
+			port = 0
+		}
+		hp := net.JoinHostPort(lo.Host, strconv.Itoa(port))
+		l, e := net.Listen("tcp", hp)
+		if e != nil {
+			s.Fatalf("Error listening on port: %s, %q", hp, e)
+			return
+		}
+		s.Noticef("Listening for client connections on %s",
+			net.JoinHostPort(lo.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)))
+		if lo.TLSConfig != nil {
+			s.Noticef("TLS required for client connections on %s", l.Addr())
+		}
+		if lo.Account != _EMPTY_ {
+			s.Noticef("Client connections on %s are bound to account %q", l.Addr(), lo.Account)
+		}
+
+		cl := &clientListener{opts: lo, listener: l}
+		s.mu.Lock()
+		// If we have selected a random port...
+		if port == 0 {
+			// Write resolved port back to options.
+			lo.Port = l.Addr().(*net.TCPAddr).Port
+		}
+		s.clientListeners = append(s.clientListeners, cl)
+		s.mu.Unlock()
+
+		go s.clientListenerAcceptLoop(l, lo)
+	}
+}
+
+func (s *Server) clientListenerAcceptLoop(l net.Listener, lo *ListenerOpts) {
+	tmpDelay := ACCEPT_MIN_SLEEP
+
+	for s.isRunning() {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isLameDuckMode() {
+				// Wait for the Shutdown, which accounts for this loop.
+				<-s.quitCh
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.Errorf("Temporary Client Accept Error (%v), sleeping %dms",
+					ne, tmpDelay/time.Millisecond)
+				time.Sleep(tmpDelay)
+				tmpDelay *= 2
+				if tmpDelay > ACCEPT_MAX_SLEEP {
+					tmpDelay = ACCEPT_MAX_SLEEP
+				}
+			} else if s.isRunning() {
+				s.Errorf("Client Accept Error: %v", err)
+			}
+			continue
+		}
+		tmpDelay = ACCEPT_MIN_SLEEP
+		s.startGoRoutine(func() {
+			s.createClient(conn, lo)
 			s.grWG.Done()
 		})
 	}
@@ -944,6 +1211,8 @@ const (
 	RegInformerPath = "/reg_informer"
 	GetInformerPath = "/get_informer"
 	NodesPath = "/nodes"
+	GatewayzPath    = "/gatewayz"
+	AccountzPath    = "/accountz"
 )
 
 // Start the monitoring server
@@ -961,6 +1230,8 @@ func (s *Server) startMonitoring(secure bool) error {
 		RegInformerPath: 0,
 		GetInformerPath: 0,
 		NodesPath: 0,
+		GatewayzPath:    0,
+		AccountzPath:    0,
 	}
 
 	var (
@@ -1021,6 +1292,10 @@ func (s *Server) startMonitoring(secure bool) error {
 	mux.HandleFunc(GetInformerPath, s.HandleGetInformer)
 	// Nodes
 	mux.HandleFunc(NodesPath, s.HandleNodes)
+	// Gatewayz
+	mux.HandleFunc(GatewayzPath, s.HandleGatewayz)
+	// Accountz
+	mux.HandleFunc(AccountzPath, s.HandleAccountz)
 
 	// Do not set a WriteTimeout because it could cause cURL/browser
 	// to return empty response or unable to display page if the
@@ -1076,17 +1351,30 @@ func (s *Server) copyInfo() Info {
 	return info
 }
 
-func (s *Server) createClient(conn net.Conn) *client {
+// createClient creates a client for the given connection. The listener
+// options are those of the additional listener that accepted it, if any,
+// and take precedence over the server's TLS and auth timeout settings.
+func (s *Server) createClient(conn net.Conn, lo *ListenerOpts) *client {
 	// Snapshot server options.
 	opts := s.getOpts()
 
 	maxPay := int32(opts.MaxPayload)
 	maxSubs := opts.MaxSubs
 	now := time.Now()
+	tlsConfig, tlsTTL, authTimeout := opts.TLSConfig, opts.TLSTimeout, opts.AuthTimeout
 
 	c := &client{srv: s, nc: conn, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now}
 
-	c.registerWithAccount(s.gacc)
+	acc := s.gacc
+	if lo != nil && lo.Account != _EMPTY_ {
+		if acc = s.LookupAccount(lo.Account); acc == nil {
+			s.Errorf("Account %q of listener %s:%d not found", lo.Account, lo.Host, lo.Port)
+			conn.Close()
+			return nil
+		}
+		c.lacc = acc
+	}
+	c.registerWithAccount(acc)
 
 	// Grab JSON info string
 	s.mu.Lock()
@@ -1095,6 +1383,20 @@ func (s *Server) createClient(conn net.Conn) *client {
 	s.totalClients++
 	s.mu.Unlock()
 
+	if lo != nil {
+		tlsConfig, tlsTTL, authTimeout = lo.TLSConfig, lo.TLSTimeout, lo.AuthTimeout
+		info.Host, info.Port = lo.Host, lo.Port
+		info.TLSRequired = tlsConfig != nil
+		info.TLSVerify = info.TLSRequired && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	}
+
+	// Websocket connections had their TLS handshake done on upgrade,
+	// and unix socket ones never leave the host.
+	switch conn.(type) {
+	case *wsConn, *net.UnixConn:
+		info.TLSRequired, info.TLSVerify = false, false
+	}
+
 	// Grab lock
 	c.mu.Lock()
 
@@ -1130,19 +1432,17 @@ func (s *Server) createClient(conn net.Conn) *client {
 	s.clients[c.cid] = c
 	s.mu.Unlock()
 
-	s.BroadcastClientInfoToInformer()
-
 	// Re-Grab lock
 	c.mu.Lock()
 
 	// Check for TLS
 	if info.TLSRequired {
 		c.Debugf("Starting TLS client connection handshake")
-		c.nc = tls.Server(c.nc, opts.TLSConfig)
+		c.nc = tls.Server(c.nc, tlsConfig)
 		conn := c.nc.(*tls.Conn)
 
 		// Setup the timeout
-		ttl := secondsToDuration(opts.TLSTimeout)
+		ttl := secondsToDuration(tlsTTL)
 		time.AfterFunc(ttl, func() { tlsTimeout(c, conn) })
 		conn.SetReadDeadline(time.Now().Add(ttl))
 
@@ -1173,7 +1473,7 @@ func (s *Server) createClient(conn net.Conn) *client {
 	// the race where the timer fires during the handshake and causes the
 	// server to write bad data to the socket. See issue #432.
 	if info.AuthRequired {
-		c.setAuthTimer(secondsToDuration(opts.AuthTimeout))
+		c.setAuthTimer(secondsToDuration(authTimeout))
 	}
 
 	// Do final client initialization
@@ -1325,6 +1625,7 @@ func tlsCipher(cs uint16) string {
 // Remove a client or route from our internal accounting.
 func (s *Server) removeClient(c *client) {
 	var rID string
+	var removedRemote bool
 	c.mu.Lock()
 	cid := c.cid
 	typ := c.typ
@@ -1352,16 +1653,25 @@ func (s *Server) removeClient(c *client) {
 			// Only delete it if it is us..
 			if ok && c == rc {
 				delete(s.remotes, rID)
+				removedRemote = true
 			}
 		}
 		// Remove from temporary map in case it is there.
 		s.grMu.Lock()
 		delete(s.grTmpClients, cid)
 		s.grMu.Unlock()
+	case LEAF:
+		delete(s.leafs, cid)
+	case GATEWAY:
+		s.removeRemoteGatewayConnection(c)
 	}
 	s.mu.Unlock()
 
-	s.BroadcastClientInfoToInformer()
+	if typ == CLIENT {
+		s.informerClientDisconnected(cid)
+	} else if removedRemote {
+		s.removeRouteNodes(rID)
+	}
 }
 
 /////////////////////////////////////////////////////////////////
@@ -1383,6 +1693,13 @@ func (s *Server) NumRemotes() int {
 	return len(s.remotes)
 }
 
+// NumLeafNodes will report the number of leaf node connections.
+func (s *Server) NumLeafNodes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.leafs)
+}
+
 // NumClients will report the number of registered clients.
 func (s *Server) NumClients() int {
 	s.mu.Lock()
@@ -1402,7 +1719,9 @@ func (s *Server) NumSubscriptions() uint32 {
 	s.mu.Lock()
 	var subs int
 	for _, acc := range s.accounts {
-		if acc.sl != nil {
+		// The reserved system account only has the internal
+		// subscriptions of the servers.
+		if acc.sl != nil && !s.isReservedSysAccount(acc) {
 			subs += acc.TotalSubs()
 		}
 	}
@@ -1452,6 +1771,36 @@ func (s *Server) ClusterAddr() *net.TCPAddr {
 	return s.routeListener.Addr().(*net.TCPAddr)
 }
 
+// LeafNodeAddr returns the net.Addr object for the leafnode listener.
+func (s *Server) LeafNodeAddr() *net.TCPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leafNodeListener == nil {
+		return nil
+	}
+	return s.leafNodeListener.Addr().(*net.TCPAddr)
+}
+
+// WebsocketAddr returns the net.Addr object for the websocket listener.
+func (s *Server) WebsocketAddr() *net.TCPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.websocket.listener == nil {
+		return nil
+	}
+	return s.websocket.listener.Addr().(*net.TCPAddr)
+}
+
+// MQTTAddr returns the net.Addr object for the MQTT listener.
+func (s *Server) MQTTAddr() *net.TCPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mqtt.listener == nil {
+		return nil
+	}
+	return s.mqtt.listener.Addr().(*net.TCPAddr)
+}
+
 // ProfilerAddr returns the net.Addr object for the route listener.
 func (s *Server) ProfilerAddr() *net.TCPAddr {
 	s.mu.Lock()
@@ -1472,7 +1821,14 @@ func (s *Server) ReadyForConnections(dur time.Duration) bool {
 	end := time.Now().Add(dur)
 	for time.Now().Before(end) {
 		s.mu.Lock()
-		ok := s.listener != nil && (opts.Cluster.Port == 0 || s.routeListener != nil)
+		ok := s.listener != nil &&
+			(opts.Cluster.Port == 0 || s.routeListener != nil) &&
+			(opts.LeafNode.Port == 0 || s.leafNodeListener != nil) &&
+			(opts.Gateway.Port == 0 || s.gateway.listener != nil) &&
+			(opts.Websocket.Port == 0 || s.websocket.listener != nil) &&
+			(opts.MQTT.Port == 0 || s.mqtt.listener != nil) &&
+			(opts.UnixSocket == _EMPTY_ || s.unixListener != nil) &&
+			len(s.clientListeners) == len(opts.Listeners)
 		s.mu.Unlock()
 		if ok {
 			return true
@@ -1532,46 +1888,59 @@ func (s *Server) getClientConnectURLs() []string {
 		// just use the info host/port. This is updated in s.New()
 		urls = append(urls, net.JoinHostPort(s.info.Host, strconv.Itoa(s.info.Port)))
 	} else {
-		sPort := strconv.Itoa(opts.Port)
-		ipAddr, err := net.ResolveIPAddr("ip", opts.Host)
-		// If the host is "any" (0.0.0.0 or ::), get specific IPs from available
-		// interfaces.
-		if err == nil && ipAddr.IP.IsUnspecified() {
-			var ip net.IP
-			ifaces, _ := net.Interfaces()
-			for _, i := range ifaces {
-				addrs, _ := i.Addrs()
-				for _, addr := range addrs {
-					switch v := addr.(type) {
-					case *net.IPNet:
-						ip = v.IP
-					case *net.IPAddr:
-						ip = v.IP
-					}
-					// Skip non global unicast addresses
-					if !ip.IsGlobalUnicast() || ip.IsUnspecified() {
-						ip = nil
-						continue
-					}
-					urls = append(urls, net.JoinHostPort(ip.String(), sPort))
+		urls = s.appendClientConnectURLs(urls, opts.Host, opts.Port)
+	}
+	// Additional listeners are advertised as well.
+	for _, cl := range s.clientListeners {
+		urls = s.appendClientConnectURLs(urls, cl.opts.Host, cl.opts.Port)
+	}
+
+	return urls
+}
+
+// appendClientConnectURLs appends the URLs clients can use to reach the
+// given listen host and port, resolving the "any" address to the addresses
+// of the available interfaces.
+func (s *Server) appendClientConnectURLs(urls []string, host string, port int) []string {
+	start := len(urls)
+	sPort := strconv.Itoa(port)
+	ipAddr, err := net.ResolveIPAddr("ip", host)
+	// If the host is "any" (0.0.0.0 or ::), get specific IPs from available
+	// interfaces.
+	if err == nil && ipAddr.IP.IsUnspecified() {
+		var ip net.IP
+		ifaces, _ := net.Interfaces()
+		for _, i := range ifaces {
+			addrs, _ := i.Addrs()
+			for _, addr := range addrs {
+				switch v := addr.(type) {
+				case *net.IPNet:
+					ip = v.IP
+				case *net.IPAddr:
+					ip = v.IP
+				}
+				// Skip non global unicast addresses
+				if !ip.IsGlobalUnicast() || ip.IsUnspecified() {
+					ip = nil
+					continue
 				}
+				urls = append(urls, net.JoinHostPort(ip.String(), sPort))
 			}
 		}
-		if err != nil || len(urls) == 0 {
-			// We are here if s.opts.Host is not "0.0.0.0" nor "::", or if for some
-			// reason we could not add any URL in the loop above.
-			// We had a case where a Windows VM was hosed and would have err == nil
-			// and not add any address in the array in the loop above, and we
-			// ended-up returning 0.0.0.0, which is problematic for Windows clients.
-			// Check for 0.0.0.0 or :: specifically, and ignore if that's the case.
-			if opts.Host == "0.0.0.0" || opts.Host == "::" {
-				s.Errorf("Address %q can not be resolved properly", opts.Host)
-			} else {
-				urls = append(urls, net.JoinHostPort(opts.Host, sPort))
-			}
+	}
+	if err != nil || len(urls) == start {
+		// We are here if host is not "0.0.0.0" nor "::", or if for some
+		// reason we could not add any URL in the loop above.
+		// We had a case where a Windows VM was hosed and would have err == nil
+		// and not add any address in the array in the loop above, and we
+		// ended-up returning 0.0.0.0, which is problematic for Windows clients.
+		// Check for 0.0.0.0 or :: specifically, and ignore if that's the case.
+		if host == "0.0.0.0" || host == "::" {
+			s.Errorf("Address %q can not be resolved properly", host)
+		} else {
+			urls = append(urls, net.JoinHostPort(host, sPort))
 		}
 	}
-
 	return urls
 }
 
@@ -1631,6 +2000,7 @@ func (s *Server) PortsInfo(maxWait time.Duration) *Ports {
 		s.mu.Lock()
 		info := s.copyInfo()
 		listener := s.listener
+		clientListeners := append([]*clientListener(nil), s.clientListeners...)
 		httpListener := s.http
 		clusterListener := s.routeListener
 		profileListener := s.profiler
@@ -1645,6 +2015,16 @@ func (s *Server) PortsInfo(maxWait time.Duration) *Ports {
 			}
 			ports.Nats = formatURL(natsProto, listener)
 		}
+		for _, cl := range clientListeners {
+			if cl.listener == nil {
+				continue
+			}
+			natsProto := "nats"
+			if cl.opts.TLSConfig != nil {
+				natsProto = "tls"
+			}
+			ports.Nats = append(ports.Nats, formatURL(natsProto, cl.listener)...)
+		}
 
 		if httpListener != nil {
 			monProto := "http"
@@ -1760,6 +2140,9 @@ func (s *Server) serviceListeners() []net.Listener {
 	listeners := make([]net.Listener, 0)
 	opts := s.getOpts()
 	listeners = append(listeners, s.listener)
+	for _, cl := range s.clientListeners {
+		listeners = append(listeners, cl.listener)
+	}
 	if opts.Cluster.Port != 0 {
 		listeners = append(listeners, s.routeListener)
 	}
@@ -1793,6 +2176,17 @@ func (s *Server) lameDuckMode() {
 	s.ldmCh = make(chan bool, 1)
 	s.listener.Close()
 	s.listener = nil
+	// The accept loops of the other client listeners wait for the Shutdown.
+	if s.unixListener != nil {
+		s.unixListener.Close()
+		s.unixListener = nil
+	}
+	for _, cl := range s.clientListeners {
+		if cl.listener != nil {
+			cl.listener.Close()
+			cl.listener = nil
+		}
+	}
 	s.mu.Unlock()
 
 	// Wait for accept loop to be done to make sure that no new
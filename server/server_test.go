@@ -14,6 +14,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
@@ -847,3 +848,113 @@ func TestLameDuckMode(t *testing.T) {
 		t.Fatalf("Expected client to reconnect only once, got %v", n)
 	}
 }
+
+func TestServerClientListeners(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		accounts {
+			A { users: [{user: a, password: a}] }
+			B { users: [{user: b, password: b}] }
+		}
+		listeners: [
+			{
+				listen: "127.0.0.1:-1"
+				account: A
+			}
+			{
+				listen: "127.0.0.1:-1"
+				auth_timeout: 3
+				tls {
+					cert_file: "../test/configs/certs/server-cert.pem"
+					key_file: "../test/configs/certs/server-key.pem"
+					timeout: 2
+				}
+			}
+		]
+	`))
+	defer os.Remove(conf)
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	if len(opts.Listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %v", len(opts.Listeners))
+	}
+	accL, tlsL := opts.Listeners[0], opts.Listeners[1]
+	if tlsL.AuthTimeout != 3 || tlsL.TLSTimeout != 2 || accL.AuthTimeout != opts.AuthTimeout {
+		t.Fatalf("Unexpected timeouts: %+v %+v", accL, tlsL)
+	}
+
+	ports := s.PortsInfo(time.Second)
+	expected := []string{
+		fmt.Sprintf("nats://127.0.0.1:%d", opts.Port),
+		fmt.Sprintf("nats://127.0.0.1:%d", accL.Port),
+		fmt.Sprintf("tls://127.0.0.1:%d", tlsL.Port),
+	}
+	if fmt.Sprint(ports.Nats) != fmt.Sprint(expected) {
+		t.Fatalf("Expected ports %v, got %v", expected, ports.Nats)
+	}
+	s.mu.Lock()
+	urls := s.getClientConnectURLs()
+	s.mu.Unlock()
+	if len(urls) != 3 || urls[1] != fmt.Sprintf("127.0.0.1:%d", accL.Port) {
+		t.Fatalf("Unexpected connect URLs: %v", urls)
+	}
+
+	// The bound listener only admits users of its account.
+	accURL := fmt.Sprintf("nats://a:a@127.0.0.1:%d", accL.Port)
+	nca, err := nats.Connect(accURL)
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nca.Close()
+	if nc, err := nats.Connect(fmt.Sprintf("nats://b:b@127.0.0.1:%d", accL.Port)); err == nil {
+		nc.Close()
+		t.Fatalf("Expected user of account B to be rejected")
+	}
+
+	// Clients of the same account share subjects across listeners.
+	ncm, err := nats.Connect(fmt.Sprintf("nats://a:a@127.0.0.1:%d", opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncm.Close()
+	sub, _ := ncm.SubscribeSync("foo")
+	ncm.Flush()
+	nca.Publish("foo", []byte("hello"))
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error getting message: %v", err)
+	}
+
+	// The TLS listener requires TLS while the main one does not.
+	tlsURL := fmt.Sprintf("nats://b:b@127.0.0.1:%d", tlsL.Port)
+	if nc, err := nats.Connect(tlsURL); err == nil {
+		nc.Close()
+		t.Fatalf("Expected plain connection to the TLS listener to fail")
+	}
+	nct, err := nats.Connect(tlsURL, nats.Secure(&tls.Config{InsecureSkipVerify: true}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	nct.Close()
+}
+
+func TestServerClientListenersConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{"not an array", `listeners: {port: 4223}`, "Expected listeners field to be an array"},
+		{"missing port", `listeners: [{host: "127.0.0.1"}]`, "Listener requires a port"},
+		{"unknown field", `listeners: [{port: 4223, foo: bar}]`, "unknown field \"foo\""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(test.conf))
+			defer os.Remove(conf)
+			_, err := ProcessConfigFile(conf)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
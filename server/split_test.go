@@ -440,6 +440,33 @@ func TestSplitRoutedMsgArg(t *testing.T) {
 	}
 }
 
+func TestSplitRoutedHeaderMsgArg(t *testing.T) {
+	_, c, _ := setupClient()
+	// Allow parser to process HMSG
+	c.typ = ROUTER
+
+	b := make([]byte, 1024)
+
+	copy(b, []byte("HMSG $G hello.world 12 6040\r\nNATS/1.0\r\n\r\nAAAAAAAAAAAAAAAAAAA"))
+	c.parse(b)
+
+	copy(b, []byte("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB\r\n"))
+	c.parse(b)
+
+	if string(c.pa.account) != "$G" {
+		t.Fatalf("Incorrect account: want %q, got %q", "$G", c.pa.account)
+	}
+	if string(c.pa.subject) != "hello.world" {
+		t.Fatalf("Incorrect subject: want %q, got %q", "hello.world", c.pa.subject)
+	}
+	if string(c.pa.hdb) != "12" || c.pa.hdr != 12 {
+		t.Fatalf("Incorrect header size: want %q, got %q", "12", c.pa.hdb)
+	}
+	if string(c.pa.szb) != "6040" {
+		t.Fatalf("Incorrect szb: want %q, got %q", "6040", c.pa.szb)
+	}
+}
+
 func TestSplitBufferMsgOp(t *testing.T) {
 	c := &client{subs: make(map[string]*subscription), typ: ROUTER}
 	msg := []byte("RMSG $G foo.bar _INBOX.22 11\r\nhello world\r")
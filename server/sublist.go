@@ -323,7 +323,8 @@ func (s *Sublist) reduceCacheCount() {
 
 // Helper function for auto-expanding remote qsubs.
 func isRemoteQSub(sub *subscription) bool {
-	return sub != nil && sub.queue != nil && sub.client != nil && sub.client.typ == ROUTER
+	return sub != nil && sub.queue != nil && sub.client != nil &&
+		(sub.client.typ == ROUTER || sub.client.typ == LEAF)
 }
 
 // UpdateRemoteQSub should be called when we update the weight of an existing
@@ -921,49 +922,60 @@ func matchLiteral(literal, subject string) bool {
 	return li >= ll
 }
 
-func addLocalSub(sub *subscription, subs *[]*subscription) {
-	if sub != nil && sub.client != nil && sub.client.typ == CLIENT && sub.im == nil {
+func addLocalSub(sub *subscription, subs *[]*subscription, includeAll bool) {
+	if sub == nil || sub.client == nil {
+		return
+	}
+	if includeAll || (sub.client.typ == CLIENT && sub.im == nil) {
 		*subs = append(*subs, sub)
 	}
 }
 
-func (s *Sublist) addNodeToSubs(n *node, subs *[]*subscription) {
+func (s *Sublist) addNodeToSubs(n *node, subs *[]*subscription, includeAll bool) {
 	// Normal subscriptions
 	if n.plist != nil {
 		for _, sub := range n.plist {
-			addLocalSub(sub, subs)
+			addLocalSub(sub, subs, includeAll)
 		}
 	} else {
 		for _, sub := range n.psubs {
-			addLocalSub(sub, subs)
+			addLocalSub(sub, subs, includeAll)
 		}
 	}
 	// Queue subscriptions
 	for _, qr := range n.qsubs {
 		for _, sub := range qr {
-			addLocalSub(sub, subs)
+			addLocalSub(sub, subs, includeAll)
 		}
 	}
 }
 
-func (s *Sublist) collectLocalSubs(l *level, subs *[]*subscription) {
+func (s *Sublist) collectLocalSubs(l *level, subs *[]*subscription, includeAll bool) {
 	for _, n := range l.nodes {
-		s.addNodeToSubs(n, subs)
-		s.collectLocalSubs(n.next, subs)
+		s.addNodeToSubs(n, subs, includeAll)
+		s.collectLocalSubs(n.next, subs, includeAll)
 	}
 	if l.pwc != nil {
-		s.addNodeToSubs(l.pwc, subs)
-		s.collectLocalSubs(l.pwc.next, subs)
+		s.addNodeToSubs(l.pwc, subs, includeAll)
+		s.collectLocalSubs(l.pwc.next, subs, includeAll)
 	}
 	if l.fwc != nil {
-		s.addNodeToSubs(l.fwc, subs)
-		s.collectLocalSubs(l.fwc.next, subs)
+		s.addNodeToSubs(l.fwc, subs, includeAll)
+		s.collectLocalSubs(l.fwc.next, subs, includeAll)
 	}
 }
 
 // Return all local client subscriptions. Use the supplied slice.
 func (s *Sublist) localSubs(subs *[]*subscription) {
 	s.RLock()
-	s.collectLocalSubs(s.root, subs)
+	s.collectLocalSubs(s.root, subs, false)
+	s.RUnlock()
+}
+
+// All is used to collect all subscriptions, including the ones
+// from routes and leaf nodes. Use the supplied slice.
+func (s *Sublist) All(subs *[]*subscription) {
+	s.RLock()
+	s.collectLocalSubs(s.root, subs, true)
 	s.RUnlock()
 }
@@ -0,0 +1,142 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// startUnixSocketListener opens the client unix socket, applies the
+// configured mode and ownership, and starts its accept loop. Clients
+// accepted there go through createClient like TCP ones, but never
+// negotiate TLS since the connection does not leave the host.
+func (s *Server) startUnixSocketListener() {
+	// Snapshot server options.
+	opts := s.getOpts()
+	path := opts.UnixSocket
+
+	// A socket left behind by a server that did not exit cleanly would
+	// make the listen fail. Anything else at that path is left alone.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			s.Fatalf("Error listening on unix socket %q: file exists and is not a socket", path)
+			return
+		}
+		os.Remove(path)
+	}
+	l, e := net.Listen("unix", path)
+	if e != nil {
+		s.Fatalf("Error listening on unix socket %q: %v", path, e)
+		return
+	}
+	if err := os.Chmod(path, opts.UnixSocketMode); err != nil {
+		l.Close()
+		s.Fatalf("Error setting mode of unix socket %q: %v", path, err)
+		return
+	}
+	if opts.UnixSocketOwner != _EMPTY_ || opts.UnixSocketGroup != _EMPTY_ {
+		uid, gid, err := lookupOwnership(opts.UnixSocketOwner, opts.UnixSocketGroup)
+		if err == nil {
+			err = os.Chown(path, uid, gid)
+		}
+		if err != nil {
+			l.Close()
+			s.Fatalf("Error setting ownership of unix socket %q: %v", path, err)
+			return
+		}
+	}
+	s.Noticef("Listening for client connections on unix socket %s", path)
+
+	s.mu.Lock()
+	s.unixListener = l
+	s.mu.Unlock()
+
+	go s.unixSocketAcceptLoop(l)
+}
+
+func (s *Server) unixSocketAcceptLoop(l net.Listener) {
+	tmpDelay := ACCEPT_MIN_SLEEP
+
+	for s.isRunning() {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isLameDuckMode() {
+				// Wait for the Shutdown, which accounts for this loop.
+				<-s.quitCh
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.Errorf("Temporary Unix Socket Accept Error (%v), sleeping %dms",
+					ne, tmpDelay/time.Millisecond)
+				time.Sleep(tmpDelay)
+				tmpDelay *= 2
+				if tmpDelay > ACCEPT_MAX_SLEEP {
+					tmpDelay = ACCEPT_MAX_SLEEP
+				}
+			} else if s.isRunning() {
+				s.Errorf("Unix Socket Accept Error: %v", err)
+			}
+			continue
+		}
+		tmpDelay = ACCEPT_MIN_SLEEP
+		s.startGoRoutine(func() {
+			s.createClient(conn, nil)
+			s.grWG.Done()
+		})
+	}
+	s.done <- true
+}
+
+// lookupOwnership resolves the owner and group, given by name or numeric
+// id, to the ids expected by os.Chown. An empty one resolves to -1, which
+// leaves it unchanged.
+func lookupOwnership(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != _EMPTY_ {
+		id := owner
+		if _, err := strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return -1, -1, err
+			}
+			id = u.Uid
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unsupported user id %q", id)
+		}
+		uid = n
+	}
+	if group != _EMPTY_ {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return -1, -1, err
+			}
+			id = g.Gid
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unsupported group id %q", id)
+		}
+		gid = n
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUnixSocketConnect(t *testing.T, path, connect string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Error dialing unix socket: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	if line, err := br.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		t.Fatalf("Expected INFO, got %q, %v", line, err)
+	} else if strings.Contains(line, "tls_required") {
+		t.Fatalf("TLS should not be required on the unix socket: %q", line)
+	}
+	conn.Write([]byte(connect + "\r\nPING\r\n"))
+	return conn, br
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnatsd")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nats.sock")
+
+	// A stale socket file is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Error creating stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	opts := DefaultOptions()
+	opts.UnixSocket = path
+	opts.UnixSocketMode = 0660
+	opts.UnixSocketOwner = strconv.Itoa(os.Getuid())
+	opts.Username, opts.Password = "user", "pwd"
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Error getting socket info: %v", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0660 {
+		t.Fatalf("Unexpected socket mode: %v", fi.Mode())
+	}
+	if uid, _, err := lookupOwnership(opts.UnixSocketOwner, ""); err != nil || uid != os.Getuid() {
+		t.Fatalf("Unexpected owner lookup: %v, %v", uid, err)
+	}
+
+	// Authentication applies to unix socket clients.
+	conn, br := testUnixSocketConnect(t, path, `CONNECT {"verbose":false}`)
+	if line, _ := br.ReadString('\n'); !strings.Contains(line, "Authorization Violation") {
+		t.Fatalf("Expected authorization violation, got %q", line)
+	}
+	conn.Close()
+
+	conn, br = testUnixSocketConnect(t, path, `CONNECT {"verbose":false,"user":"user","pass":"pwd","name":"sidecar"}`)
+	defer conn.Close()
+	if line, _ := br.ReadString('\n'); line != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", line)
+	}
+
+	cz, _ := s.Connz(&ConnzOptions{})
+	if len(cz.Conns) != 1 {
+		t.Fatalf("Expected 1 connection, got %v", len(cz.Conns))
+	}
+	if ci := cz.Conns[0]; ci.Name != "sidecar" || ci.UnixSocket != path || ci.IP != "" || ci.Port != 0 {
+		t.Fatalf("Unexpected connection info: %+v", ci)
+	}
+
+	s.Shutdown()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected socket file to be removed, got %v", err)
+	}
+}
+
+func TestUnixSocketConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		unix_socket {
+			path: "/var/run/nats.sock"
+			mode: "0660"
+			owner: 1000
+			group: nats
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	if opts.UnixSocket != "/var/run/nats.sock" || opts.UnixSocketMode != 0660 ||
+		opts.UnixSocketOwner != "1000" || opts.UnixSocketGroup != "nats" {
+		t.Fatalf("Unexpected options: %q %v %q %q", opts.UnixSocket, opts.UnixSocketMode,
+			opts.UnixSocketOwner, opts.UnixSocketGroup)
+	}
+
+	conf2 := createConfFile(t, []byte(`unix_socket: "/tmp/nats.sock"`))
+	defer os.Remove(conf2)
+	if opts, err = ProcessConfigFile(conf2); err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	if opts.UnixSocket != "/tmp/nats.sock" {
+		t.Fatalf("Unexpected path: %q", opts.UnixSocket)
+	}
+}
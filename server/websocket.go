@@ -0,0 +1,533 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Websocket opcodes and frame bits, see RFC 6455 section 5.2.
+const (
+	wsContinuationFrame = 0
+	wsTextFrame         = 1
+	wsBinaryFrame       = 2
+	wsCloseFrame        = 8
+	wsPingFrame         = 9
+	wsPongFrame         = 10
+
+	wsFinalBit = 1 << 7
+	wsRsv1Bit  = 1 << 6 // Set on the first frame of a compressed message.
+	wsRsv23    = 3 << 4
+	wsMaskBit  = 1 << 7
+
+	wsMaxControlPayloadSize = 125
+
+	wsCloseStatusNormal        = 1000
+	wsCloseStatusProtocolError = 1002
+	wsCloseStatusMessageTooBig = 1009
+
+	// Used to compute the Sec-WebSocket-Accept header.
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// We do not keep compression contexts between messages.
+	wsPMCExtension = "permessage-deflate"
+	wsPMCResponse  = wsPMCExtension + "; server_no_context_takeover; client_no_context_takeover"
+)
+
+// A compressed message ends with an empty stored block that is removed
+// by the sender (RFC 7692). We add it back, followed by a final empty
+// block so that the inflater reaches a clean EOF.
+var (
+	wsDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+	wsInflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// srvWebsocket holds the state of the websocket listener.
+type srvWebsocket struct {
+	listener    net.Listener
+	server      *http.Server
+	compression bool
+	origins     map[string]struct{}
+}
+
+// startWebsocketServer starts the HTTP server that upgrades connections
+// to websocket. Upgraded connections are then handled as regular clients.
+func (s *Server) startWebsocketServer() {
+	// Snapshot server options.
+	opts := s.getOpts()
+	o := &opts.Websocket
+
+	port := o.Port
+	if port == -1 {
+		port = 0
+	}
+	hp := net.JoinHostPort(o.Host, strconv.Itoa(port))
+	var (
+		l     net.Listener
+		err   error
+		proto = "ws"
+	)
+	if o.TLSConfig != nil {
+		proto = "wss"
+		l, err = tls.Listen("tcp", hp, o.TLSConfig)
+	} else {
+		l, err = net.Listen("tcp", hp)
+	}
+	if err != nil {
+		s.Fatalf("Error listening on websocket port: %d - %v", o.Port, err)
+		return
+	}
+	s.Noticef("Listening for websocket clients on %s://%s", proto,
+		net.JoinHostPort(o.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)))
+
+	origins := make(map[string]struct{}, len(o.AllowedOrigins))
+	for _, ao := range o.AllowedOrigins {
+		if origin, err := wsNormalizeOrigin(ao); err == nil {
+			origins[origin] = struct{}{}
+		} else {
+			s.Errorf("Ignoring websocket allowed origin %q: %v", ao, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.wsUpgrade)
+	// The read timeout bounds the TLS handshake and the upgrade request.
+	// It is cleared once the connection is hijacked.
+	hs := &http.Server{
+		Handler:        mux,
+		ReadTimeout:    o.HandshakeTimeout,
+		MaxHeaderBytes: 1 << 16,
+		ErrorLog:       log.New(&wsErrorLog{s}, "", 0),
+	}
+
+	s.mu.Lock()
+	// If we have selected a random port...
+	if port == 0 {
+		// Write resolved port back to options.
+		opts.Websocket.Port = l.Addr().(*net.TCPAddr).Port
+	}
+	s.websocket.listener = l
+	s.websocket.server = hs
+	s.websocket.compression = o.Compression
+	s.websocket.origins = origins
+	s.mu.Unlock()
+
+	go func() {
+		hs.Serve(l)
+		s.done <- true
+	}()
+}
+
+// wsErrorLog sends the errors of the websocket HTTP server, such as failed
+// TLS handshakes, to the server's logger.
+type wsErrorLog struct {
+	s *Server
+}
+
+func (w *wsErrorLog) Write(p []byte) (int, error) {
+	w.s.Errorf("Websocket: %s", bytes.TrimSpace(p))
+	return len(p), nil
+}
+
+// wsUpgrade performs the websocket opening handshake and hands the
+// connection over to createClient.
+func (s *Server) wsUpgrade(w http.ResponseWriter, r *http.Request) {
+	compress, status, err := s.wsCheckUpgrade(r)
+	if err != nil {
+		s.Debugf("Websocket handshake error from %s: %v", r.RemoteAddr, err)
+		if status == http.StatusBadRequest {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		s.Errorf("Websocket hijack error from %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\n")
+	b.WriteString("Connection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: ")
+	b.WriteString(wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	b.WriteString(_CRLF_)
+	if compress {
+		b.WriteString("Sec-WebSocket-Extensions: " + wsPMCResponse + _CRLF_)
+	}
+	b.WriteString(_CRLF_)
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		s.Debugf("Websocket handshake error from %s: %v", r.RemoteAddr, err)
+		conn.Close()
+		return
+	}
+	// Clear the handshake deadline.
+	conn.SetDeadline(time.Time{})
+
+	opts := s.getOpts()
+	s.createClient(&wsConn{
+		Conn:     conn,
+		br:       brw.Reader,
+		compress: compress,
+		maxMsg:   opts.MaxPayload + opts.MaxControlLine,
+	}, nil)
+}
+
+// wsCheckUpgrade validates the upgrade request. It returns whether the
+// messages will be compressed, or the HTTP status to fail with.
+func (s *Server) wsCheckUpgrade(r *http.Request) (bool, int, error) {
+	if r.Method != http.MethodGet {
+		return false, http.StatusMethodNotAllowed, fmt.Errorf("request method must be GET")
+	}
+	if !wsHeaderContains(r.Header, "Connection", "upgrade") {
+		return false, http.StatusBadRequest, fmt.Errorf("invalid value for header 'Connection'")
+	}
+	if !wsHeaderContains(r.Header, "Upgrade", "websocket") {
+		return false, http.StatusBadRequest, fmt.Errorf("invalid value for header 'Upgrade'")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return false, http.StatusBadRequest, fmt.Errorf("invalid value for header 'Sec-WebSocket-Version'")
+	}
+	if r.Header.Get("Sec-WebSocket-Key") == "" {
+		return false, http.StatusBadRequest, fmt.Errorf("missing header 'Sec-WebSocket-Key'")
+	}
+
+	s.mu.Lock()
+	origins := s.websocket.origins
+	compression := s.websocket.compression
+	s.mu.Unlock()
+
+	// Non browser clients do not send an Origin, so do not require it.
+	if origin := r.Header.Get("Origin"); origin != "" && len(origins) > 0 {
+		no, err := wsNormalizeOrigin(origin)
+		if err != nil {
+			return false, http.StatusForbidden, fmt.Errorf("invalid origin %q: %v", origin, err)
+		}
+		if _, ok := origins[no]; !ok {
+			return false, http.StatusForbidden, fmt.Errorf("origin %q not allowed", origin)
+		}
+	}
+
+	compress := false
+	if compression {
+		for _, ext := range r.Header["Sec-Websocket-Extensions"] {
+			for _, e := range strings.Split(ext, ",") {
+				name := strings.TrimSpace(strings.Split(e, ";")[0])
+				if strings.EqualFold(name, wsPMCExtension) {
+					compress = true
+				}
+			}
+		}
+	}
+	return compress, 0, nil
+}
+
+// wsHeaderContains returns true if one of the comma separated values
+// of the header is the given token, case insensitive.
+func wsHeaderContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAcceptKey computes the Sec-WebSocket-Accept value for the client's key.
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// wsNormalizeOrigin returns the origin as scheme://host:port, in lower
+// case and with the default port of the scheme if none is specified.
+func wsNormalizeOrigin(origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if u.Host == "" || (scheme != "http" && scheme != "https") {
+		return "", fmt.Errorf("expected http(s)://host[:port]")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port), nil
+}
+
+// wsConn frames the client protocol in websocket binary messages. Once
+// the upgrade is done it is the net.Conn of a regular client.
+type wsConn struct {
+	net.Conn
+	br       *bufio.Reader
+	compress bool
+	maxMsg   int
+
+	// Read state, only accessed from the client's readLoop.
+	rem     int // Remaining payload bytes of the current frame.
+	mask    [4]byte
+	mpos    int
+	inMsg   bool   // A fragmented message is in progress.
+	inflate bool   // The current message is compressed.
+	cbuf    []byte // Compressed message being assembled.
+	pending []byte // Inflated bytes not yet returned.
+
+	// Control frames are sent from the read side, so writes need a lock.
+	wmu  sync.Mutex
+	fw   *flate.Writer
+	wbuf bytes.Buffer
+}
+
+// Read returns the payload of the data frames sent by the client.
+func (w *wsConn) Read(p []byte) (int, error) {
+	for {
+		if len(w.pending) > 0 {
+			n := copy(p, w.pending)
+			w.pending = w.pending[n:]
+			return n, nil
+		}
+		if w.rem > 0 {
+			if len(p) > w.rem {
+				p = p[:w.rem]
+			}
+			n, err := w.br.Read(p)
+			w.unmask(p[:n])
+			w.rem -= n
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if err := w.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readFrame reads the next frame header. Control frames and compressed
+// messages are fully handled here, other payloads are returned by Read.
+func (w *wsConn) readFrame() error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(w.br, hdr[:2]); err != nil {
+		return err
+	}
+	fin := hdr[0]&wsFinalBit != 0
+	rsv1 := hdr[0]&wsRsv1Bit != 0
+	op := int(hdr[0] & 0xf)
+	if hdr[0]&wsRsv23 != 0 {
+		return w.protocolError(wsCloseStatusProtocolError, "reserved bits set")
+	}
+	if hdr[1]&wsMaskBit == 0 {
+		return w.protocolError(wsCloseStatusProtocolError, "frame not masked")
+	}
+	size := uint64(hdr[1] &^ wsMaskBit)
+	switch size {
+	case 126:
+		if _, err := io.ReadFull(w.br, hdr[:2]); err != nil {
+			return err
+		}
+		size = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err := io.ReadFull(w.br, hdr[:8]); err != nil {
+			return err
+		}
+		size = binary.BigEndian.Uint64(hdr[:8])
+	}
+	if _, err := io.ReadFull(w.br, w.mask[:]); err != nil {
+		return err
+	}
+	w.mpos = 0
+
+	switch op {
+	case wsCloseFrame, wsPingFrame, wsPongFrame:
+		if !fin || size > wsMaxControlPayloadSize {
+			return w.protocolError(wsCloseStatusProtocolError, "invalid control frame")
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(w.br, payload); err != nil {
+			return err
+		}
+		w.unmask(payload)
+		switch op {
+		case wsPingFrame:
+			w.wmu.Lock()
+			err := w.writeFrame(wsPongFrame, payload, false)
+			w.wmu.Unlock()
+			return err
+		case wsCloseFrame:
+			w.writeClose(wsCloseStatusNormal, "")
+			return io.EOF
+		}
+		return nil
+	case wsTextFrame, wsBinaryFrame:
+		if w.inMsg {
+			return w.protocolError(wsCloseStatusProtocolError, "expected continuation frame")
+		}
+		if rsv1 && !w.compress {
+			return w.protocolError(wsCloseStatusProtocolError, "compression not negotiated")
+		}
+		w.inflate = rsv1
+	case wsContinuationFrame:
+		if !w.inMsg || rsv1 {
+			return w.protocolError(wsCloseStatusProtocolError, "unexpected continuation frame")
+		}
+	default:
+		return w.protocolError(wsCloseStatusProtocolError, fmt.Sprintf("unknown opcode %d", op))
+	}
+	w.inMsg = !fin
+
+	if size > uint64(w.maxMsg) || (w.inflate && len(w.cbuf)+int(size) > w.maxMsg) {
+		return w.protocolError(wsCloseStatusMessageTooBig, "frame too big")
+	}
+	if !w.inflate {
+		w.rem = int(size)
+		return nil
+	}
+
+	// Compressed messages are inflated once complete.
+	start := len(w.cbuf)
+	w.cbuf = append(w.cbuf, make([]byte, size)...)
+	if _, err := io.ReadFull(w.br, w.cbuf[start:]); err != nil {
+		return err
+	}
+	w.unmask(w.cbuf[start:])
+	if !fin {
+		return nil
+	}
+	w.cbuf = append(w.cbuf, wsInflateTail...)
+	fr := flate.NewReader(bytes.NewReader(w.cbuf))
+	data, err := ioutil.ReadAll(io.LimitReader(fr, int64(w.maxMsg)+1))
+	fr.Close()
+	w.cbuf, w.inflate = w.cbuf[:0], false
+	if err != nil {
+		return w.protocolError(wsCloseStatusProtocolError, err.Error())
+	}
+	if len(data) > w.maxMsg {
+		return w.protocolError(wsCloseStatusMessageTooBig, "message too big")
+	}
+	w.pending = data
+	return nil
+}
+
+func (w *wsConn) unmask(b []byte) {
+	for i := range b {
+		b[i] ^= w.mask[w.mpos&3]
+		w.mpos++
+	}
+}
+
+// protocolError sends a close frame with the given status and returns
+// the error that will close the client connection.
+func (w *wsConn) protocolError(status int, reason string) error {
+	w.writeClose(status, reason)
+	return fmt.Errorf("%v: %s", ErrWebsocketProtocol, reason)
+}
+
+func (w *wsConn) writeClose(status int, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(status))
+	payload = append(payload, reason...)
+	if len(payload) > wsMaxControlPayloadSize {
+		payload = payload[:wsMaxControlPayloadSize]
+	}
+	w.wmu.Lock()
+	w.writeFrame(wsCloseFrame, payload, false)
+	w.wmu.Unlock()
+}
+
+// Write sends b as a single binary message.
+func (w *wsConn) Write(b []byte) (int, error) {
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	if err := w.writeFrame(wsBinaryFrame, b, w.compress); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeFrame writes a final, unmasked, frame.
+// Write lock should be held.
+func (w *wsConn) writeFrame(op int, payload []byte, compress bool) error {
+	if compress {
+		w.wbuf.Reset()
+		if w.fw == nil {
+			w.fw, _ = flate.NewWriter(&w.wbuf, flate.BestSpeed)
+		} else {
+			w.fw.Reset(&w.wbuf)
+		}
+		w.fw.Write(payload)
+		w.fw.Flush()
+		payload = bytes.TrimSuffix(w.wbuf.Bytes(), wsDeflateTail)
+	}
+	var hdr [10]byte
+	hdr[0] = wsFinalBit | byte(op)
+	if compress {
+		hdr[0] |= wsRsv1Bit
+	}
+	n := 2
+	switch l := len(payload); {
+	case l <= wsMaxControlPayloadSize:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	bufs := net.Buffers{hdr[:n], payload}
+	_, err := bufs.WriteTo(w.Conn)
+	return err
+}
+
+// tlsConn returns the TLS connection under the websocket, if any.
+func (w *wsConn) tlsConn() *tls.Conn {
+	tc, _ := w.Conn.(*tls.Conn)
+	return tc
+}
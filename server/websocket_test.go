@@ -0,0 +1,309 @@
+// Copyright 2018 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testWSClient struct {
+	t        *testing.T
+	conn     net.Conn
+	br       *bufio.Reader
+	compress bool
+	buf      []byte
+}
+
+func testWSOptions() *Options {
+	opts := DefaultOptions()
+	opts.Websocket.Host = "127.0.0.1"
+	opts.Websocket.Port = -1
+	return opts
+}
+
+// testWSConnect performs the upgrade and returns the response, which is
+// not a 101 if the server rejected the handshake. The given headers are
+// added to, or replace, the ones of a valid upgrade request.
+func testWSConnect(t *testing.T, s *Server, hdrs ...string) (*testWSClient, *http.Response) {
+	t.Helper()
+	addr := s.WebsocketAddr()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("Error dialing websocket: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	h := http.Header{}
+	h.Set("Host", addr.String())
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	h.Set("Sec-WebSocket-Version", "13")
+	for _, hdr := range hdrs {
+		kv := strings.SplitN(hdr, ":", 2)
+		h.Set(kv[0], strings.TrimSpace(kv[1]))
+	}
+	var req bytes.Buffer
+	req.WriteString("GET / HTTP/1.1\r\n")
+	h.Write(&req)
+	req.WriteString("\r\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		t.Fatalf("Error sending upgrade: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Error reading upgrade response: %v", err)
+	}
+	wc := &testWSClient{t: t, conn: conn, br: br}
+	wc.compress = strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), wsPMCExtension)
+	return wc, resp
+}
+
+func (wc *testWSClient) writeFrame(op int, payload []byte, compress bool) {
+	wc.t.Helper()
+	if compress {
+		var b bytes.Buffer
+		fw, _ := flate.NewWriter(&b, flate.BestSpeed)
+		fw.Write(payload)
+		fw.Flush()
+		payload = bytes.TrimSuffix(b.Bytes(), wsDeflateTail)
+	}
+	var frame []byte
+	b0 := byte(wsFinalBit | op)
+	if compress {
+		b0 |= wsRsv1Bit
+	}
+	frame = append(frame, b0)
+	switch l := len(payload); {
+	case l <= 125:
+		frame = append(frame, byte(l)|wsMaskBit)
+	default:
+		frame = append(frame, 126|wsMaskBit, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(l))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+	if _, err := wc.conn.Write(frame); err != nil {
+		wc.t.Fatalf("Error writing frame: %v", err)
+	}
+}
+
+func (wc *testWSClient) send(proto string) {
+	wc.t.Helper()
+	wc.writeFrame(wsBinaryFrame, []byte(proto), wc.compress)
+}
+
+// readFrame returns the opcode and the, inflated, payload of a frame.
+func (wc *testWSClient) readFrame() (int, []byte) {
+	wc.t.Helper()
+	var hdr [10]byte
+	if _, err := io.ReadFull(wc.br, hdr[:2]); err != nil {
+		wc.t.Fatalf("Error reading frame: %v", err)
+	}
+	if hdr[1]&wsMaskBit != 0 {
+		wc.t.Fatalf("Server frames should not be masked")
+	}
+	size := int(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		io.ReadFull(wc.br, hdr[2:4])
+		size = int(binary.BigEndian.Uint16(hdr[2:4]))
+	case 127:
+		io.ReadFull(wc.br, hdr[2:10])
+		size = int(binary.BigEndian.Uint64(hdr[2:10]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(wc.br, payload); err != nil {
+		wc.t.Fatalf("Error reading frame payload: %v", err)
+	}
+	if hdr[0]&wsRsv1Bit != 0 {
+		fr := flate.NewReader(bytes.NewReader(append(payload, wsInflateTail...)))
+		var err error
+		if payload, err = ioutil.ReadAll(fr); err != nil {
+			wc.t.Fatalf("Error inflating payload: %v", err)
+		}
+	}
+	return int(hdr[0] & 0xf), payload
+}
+
+// expect reads data frames until the protocol stream contains s.
+func (wc *testWSClient) expect(s string) {
+	wc.t.Helper()
+	for !bytes.Contains(wc.buf, []byte(s)) {
+		op, payload := wc.readFrame()
+		if op != wsBinaryFrame {
+			wc.t.Fatalf("Expected binary frame, got opcode %d", op)
+		}
+		wc.buf = append(wc.buf, payload...)
+	}
+	i := bytes.Index(wc.buf, []byte(s))
+	wc.buf = wc.buf[i+len(s):]
+}
+
+func TestWebsocketPubSub(t *testing.T) {
+	s := RunServer(testWSOptions())
+	defer s.Shutdown()
+
+	wc, resp := testWSConnect(t, s)
+	defer wc.conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v", resp.Status)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept key: %q", accept)
+	}
+	wc.expect("INFO ")
+	wc.send("CONNECT {\"verbose\":false,\"name\":\"ws\"}\r\nSUB foo 1\r\nPING\r\n")
+	wc.expect("PONG\r\n")
+
+	// Messages from a regular client are delivered over the websocket.
+	nc := createClientConnWithName(t, "pub", s)
+	defer nc.Close()
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+	wc.expect("MSG foo 1 5\r\nhello\r\n")
+
+	// Pings from the websocket layer get a pong.
+	wc.writeFrame(wsPingFrame, []byte("ping"), false)
+	if op, payload := wc.readFrame(); op != wsPongFrame || string(payload) != "ping" {
+		t.Fatalf("Expected pong, got %d %q", op, payload)
+	}
+
+	// The connection is a regular client.
+	cz, _ := s.Connz(&ConnzOptions{})
+	found := false
+	for _, ci := range cz.Conns {
+		if ci.Name == "ws" {
+			found = true
+			if ci.IP != "127.0.0.1" || ci.Port == 0 {
+				t.Fatalf("Unexpected address for websocket client: %s:%d", ci.IP, ci.Port)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("Websocket client not found in connz")
+	}
+
+	// A close frame closes the client.
+	wc.writeFrame(wsCloseFrame, []byte{0x03, 0xe8}, false)
+	if op, _ := wc.readFrame(); op != wsCloseFrame {
+		t.Fatalf("Expected close frame, got %d", op)
+	}
+	checkClientsCount(t, s, 1)
+}
+
+func TestWebsocketHandshakeErrors(t *testing.T) {
+	opts := testWSOptions()
+	opts.Websocket.AllowedOrigins = []string{"https://dashboard.example.com"}
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	for _, test := range []struct {
+		name   string
+		hdrs   []string
+		status int
+	}{
+		{"allowed origin", []string{"Origin: https://Dashboard.example.com:443"}, http.StatusSwitchingProtocols},
+		{"no origin", nil, http.StatusSwitchingProtocols},
+		{"other origin", []string{"Origin: https://evil.example.com"}, http.StatusForbidden},
+		{"other scheme", []string{"Origin: http://dashboard.example.com"}, http.StatusForbidden},
+		{"bad version", []string{"Sec-WebSocket-Version: 12"}, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wc, resp := testWSConnect(t, s, test.hdrs...)
+			defer wc.conn.Close()
+			if resp.StatusCode != test.status {
+				t.Fatalf("Expected status %v, got %v", test.status, resp.Status)
+			}
+		})
+	}
+
+	// Clients must mask their frames.
+	wc, _ := testWSConnect(t, s)
+	defer wc.conn.Close()
+	wc.expect("INFO ")
+	wc.conn.Write([]byte{wsFinalBit | wsBinaryFrame, 4, 'P', 'I', 'N', 'G'})
+	op, payload := wc.readFrame()
+	if op != wsCloseFrame || binary.BigEndian.Uint16(payload) != wsCloseStatusProtocolError {
+		t.Fatalf("Expected close with protocol error, got %d %q", op, payload)
+	}
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	opts := testWSOptions()
+	opts.Websocket.Compression = true
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	wc, resp := testWSConnect(t, s, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits")
+	defer wc.conn.Close()
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != wsPMCResponse {
+		t.Fatalf("Unexpected extensions: %q", ext)
+	}
+	wc.expect("INFO ")
+	payload := strings.Repeat("A", 1000)
+	wc.send(fmt.Sprintf("CONNECT {\"verbose\":false}\r\nSUB foo 1\r\nPUB foo %d\r\n%s\r\n", len(payload), payload))
+	wc.expect(fmt.Sprintf("MSG foo 1 %d\r\n%s\r\n", len(payload), payload))
+
+	// Without the option, the extension is not negotiated.
+	s.Shutdown()
+	s = RunServer(testWSOptions())
+	defer s.Shutdown()
+	wc2, resp := testWSConnect(t, s, "Sec-WebSocket-Extensions: permessage-deflate")
+	defer wc2.conn.Close()
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" {
+		t.Fatalf("Unexpected extensions: %q", ext)
+	}
+}
+
+func TestWebsocketConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		websocket {
+			listen: "127.0.0.1:-1"
+			allowed_origins: ["https://dashboard.example.com", "http://localhost:8080"]
+			compression: true
+			handshake_timeout: 5
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	ws := opts.Websocket
+	if ws.Host != "127.0.0.1" || ws.Port != -1 {
+		t.Fatalf("Unexpected listen: %s:%d", ws.Host, ws.Port)
+	}
+	if len(ws.AllowedOrigins) != 2 || ws.AllowedOrigins[1] != "http://localhost:8080" {
+		t.Fatalf("Unexpected allowed origins: %v", ws.AllowedOrigins)
+	}
+	if !ws.Compression || ws.HandshakeTimeout != 5*time.Second {
+		t.Fatalf("Unexpected options: %+v", ws)
+	}
+}